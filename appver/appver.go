@@ -1,11 +1,17 @@
 package appver
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -58,3 +64,119 @@ func ExtractAppVersion(root string) (string, error) {
 	}
 	return found, nil
 }
+
+// FromFile reads the string variable name declared at the top level of the Go
+// file path. It also returns the file's package name, which decides the symbol
+// -X has to set; a constant is an error, as -X cannot set one.
+func FromFile(path, name string) (version, pkg string, err error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return "", "", err
+	}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || (gen.Tok != token.VAR && gen.Tok != token.CONST) {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, ident := range vs.Names {
+				if ident.Name != name {
+					continue
+				}
+				if gen.Tok == token.CONST {
+					return "", "", fmt.Errorf("%s in %s is a constant, which -X cannot set; declare it with var", name, path)
+				}
+				if i >= len(vs.Values) {
+					continue
+				}
+				lit, ok := vs.Values[i].(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					return "", "", fmt.Errorf("%s in %s is not a string literal", name, path)
+				}
+				version, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					return "", "", err
+				}
+				return version, f.Name.Name, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("no variable %s in %s", name, path)
+}
+
+// Manifests are the non-Go files a version is read from when the Go source
+// declares none, in the order they are tried
+var Manifests = []string{"VERSION", "package.json", "Cargo.toml", "pyproject.toml"}
+
+// FindManifest returns the version of the first manifest found in dirs, and
+// the manifest's path
+func FindManifest(dirs ...string) (version, path string) {
+	for _, dir := range dirs {
+		for _, name := range Manifests {
+			p := filepath.Join(dir, name)
+			if v, err := FromManifest(p); err == nil {
+				return v, p
+			}
+		}
+	}
+	return "", ""
+}
+
+// FromManifest reads the version of a package.json, a Cargo.toml or
+// pyproject.toml ([package], [project] or [tool.poetry] version), or any
+// other file as plain text whose first line is the version, like VERSION
+func FromManifest(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var version string
+	switch {
+	case strings.HasSuffix(path, ".json"):
+		var pkg struct {
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(b, &pkg); err != nil {
+			return "", fmt.Errorf("invalid %s: %v", path, err)
+		}
+		version = pkg.Version
+	case strings.HasSuffix(path, ".toml"):
+		version = tomlVersion(string(b))
+	default:
+		version, _, _ = strings.Cut(string(b), "\n")
+		version = strings.TrimSpace(version)
+	}
+	if version == "" {
+		return "", fmt.Errorf("no version in %s", path)
+	}
+	return version, nil
+}
+
+// tomlVersion returns the version key of the [package], [project] or
+// [tool.poetry] table; only plain basic or literal strings are understood
+func tomlVersion(content string) string {
+	table := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			table = strings.TrimSpace(strings.Trim(line, "[]"))
+			continue
+		}
+		if table != "package" && table != "project" && table != "tool.poetry" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "version" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+				return value[1 : end+1]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"pbuild/fsutil"
+)
+
+// Formats lists the accepted archive formats; auto picks zip for windows and tar.gz elsewhere
+var Formats = []string{"auto", "tar.gz", "zip"}
+
+// File is one entry of an archive, read from Path or taken from Data
+type File struct {
+	Name string // slash-separated path inside the archive
+	Path string
+	Data []byte
+	Mode fs.FileMode
+}
+
+// FormatFor resolves auto to the customary format for goos
+func FormatFor(format, goos string) string {
+	if format != "auto" {
+		return format
+	}
+	if goos == "windows" {
+		return "zip"
+	}
+	return "tar.gz"
+}
+
+// Ext returns the file extension of a resolved format
+func Ext(format string) string {
+	return "." + format
+}
+
+// content returns the entry's bytes
+func (f File) content() ([]byte, error) {
+	if f.Path == "" {
+		return f.Data, nil
+	}
+	return os.ReadFile(f.Path)
+}
+
+// level is the compression level of both formats; a fixed level keeps the
+// compressed bytes stable for a given Go release
+const level = flate.DefaultCompression
+
+// Write creates the archive of a goos target at dst in the given resolved
+// format. Archives of the same inputs are byte-identical: entries are sorted by
+// name, their directories get entries of their own, and all get mtime (in whole
+// seconds), uid and gid 0, and 0755 or 0644 permissions. Windows zips carry
+// MS-DOS attributes instead of Unix permissions.
+func Write(dst, format, goos string, files []File, mtime time.Time) error {
+	files, err := normalize(files)
+	if err != nil {
+		return err
+	}
+	mtime = mtime.UTC().Truncate(time.Second)
+
+	tmp := fsutil.TempPath(dst)
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = render(out, format, files, mtime, goos == "windows")
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// normalize sorts the entries by their cleaned, slash-separated names, adds
+// the directories they are in and rejects duplicates
+func normalize(files []File) ([]File, error) {
+	var sorted []File
+	dirs := map[string]bool{}
+	for _, f := range files {
+		f.Name = path.Clean(strings.ReplaceAll(f.Name, `\`, "/"))
+		if f.Mode&0o111 != 0 {
+			f.Mode = 0o755
+		} else {
+			f.Mode = 0o644
+		}
+		sorted = append(sorted, f)
+		for dir := path.Dir(f.Name); dir != "." && dir != "/" && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+			sorted = append(sorted, File{Name: dir + "/", Mode: fs.ModeDir | 0o755})
+		}
+	}
+	slices.SortStableFunc(sorted, func(a, b File) int { return strings.Compare(a.Name, b.Name) })
+	for i := 1; i < len(sorted); i++ {
+		if strings.TrimSuffix(sorted[i].Name, "/") == strings.TrimSuffix(sorted[i-1].Name, "/") {
+			return nil, fmt.Errorf("duplicate archive entry %s", sorted[i].Name)
+		}
+	}
+	return sorted, nil
+}
+
+// render writes the archive of the normalized entries to w
+func render(w io.Writer, format string, files []File, mtime time.Time, dos bool) error {
+	switch format {
+	case "tar.gz":
+		return writeTarGz(w, files, mtime)
+	case "zip":
+		return writeZip(w, files, mtime, dos)
+	}
+	return fmt.Errorf("unsupported archive format: %s", format)
+}
+
+func writeTarGz(w io.Writer, files []File, mtime time.Time) error {
+	// The gzip header keeps its zero name and mtime
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.Name,
+			Mode:     int64(f.Mode.Perm()),
+			ModTime:  mtime,
+			Format:   tar.FormatPAX,
+		}
+		if f.Mode.IsDir() {
+			hdr.Typeflag = tar.TypeDir
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := f.content()
+		if err != nil {
+			return err
+		}
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// MS-DOS attributes of windows zips, as Explorer writes them
+const (
+	dosDirectory = 0x10
+	dosArchive   = 0x20
+)
+
+func writeZip(w io.Writer, files []File, mtime time.Time, dos bool) error {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	for _, f := range files {
+		hdr := &zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: mtime}
+		switch {
+		case dos && f.Mode.IsDir():
+			hdr.Method, hdr.ExternalAttrs = zip.Store, dosDirectory
+		case dos:
+			// Created on FAT: the zero creator version leaves out Unix permissions
+			hdr.ExternalAttrs = dosArchive
+		default:
+			hdr.SetMode(f.Mode)
+			if f.Mode.IsDir() {
+				hdr.Method = zip.Store
+			}
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if f.Mode.IsDir() {
+			continue
+		}
+		data, err := f.content()
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,110 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+// writeTwice writes the archive of files twice, the second time from the
+// entries in reverse order with a source file touched in between, and returns
+// both archives
+func writeTwice(t *testing.T, format, goos string) ([]byte, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "app")
+	if err := os.WriteFile(bin, []byte("binary"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := []File{
+		{Name: "app", Path: bin, Mode: 0o755},
+		{Name: "doc/README.md", Data: []byte("# app\n"), Mode: 0o600},
+		{Name: `doc\man\app.1`, Data: []byte(".TH APP 1\n")},
+	}
+
+	var out [2][]byte
+	for i := range out {
+		dst := filepath.Join(dir, "app"+Ext(FormatFor(format, goos)))
+		if err := Write(dst, FormatFor(format, goos), goos, files, epoch.Add(300*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = data
+		slices.Reverse(files)
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(bin, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out[0], out[1]
+}
+
+func TestWriteDeterministic(t *testing.T) {
+	for _, tc := range []struct{ format, goos string }{
+		{"tar.gz", "linux"},
+		{"zip", "darwin"},
+		{"auto", "windows"},
+	} {
+		t.Run(tc.format+"/"+tc.goos, func(t *testing.T) {
+			first, second := writeTwice(t, tc.format, tc.goos)
+			if !bytes.Equal(first, second) {
+				t.Error("two archives of the same files differ")
+			}
+		})
+	}
+}
+
+func TestWriteTarEntries(t *testing.T) {
+	data, _ := writeTwice(t, "tar.gz", "linux")
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gz.ModTime.IsZero() || gz.Name != "" {
+		t.Errorf("gzip header carries name %q and mtime %v", gz.Name, gz.ModTime)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if !hdr.ModTime.Equal(epoch) || hdr.Uid != 0 || hdr.Gid != 0 {
+			t.Errorf("%s: mtime %v, owner %d:%d", hdr.Name, hdr.ModTime, hdr.Uid, hdr.Gid)
+		}
+		if want := map[string]int64{"app": 0o755, "doc/README.md": 0o644}[hdr.Name]; want != 0 && hdr.Mode != want {
+			t.Errorf("%s: mode %o, want %o", hdr.Name, hdr.Mode, want)
+		}
+	}
+	want := []string{"app", "doc/", "doc/README.md", "doc/man/", "doc/man/app.1"}
+	if !slices.Equal(names, want) {
+		t.Errorf("entries %q, want %q", names, want)
+	}
+}
+
+func TestWriteDuplicate(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "app.zip")
+	files := []File{{Name: "app", Data: []byte("a")}, {Name: "./app", Data: []byte("b")}}
+	if err := Write(dst, "zip", "linux", files, epoch); err == nil {
+		t.Fatal("duplicate entries were archived")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("failed archive left at %s", dst)
+	}
+}
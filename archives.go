@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"pbuild/archive"
+	"pbuild/config"
+	"pbuild/gitmeta"
+	"pbuild/targets"
+)
+
+const defaultArchiveName = "{{.Project}}_{{.Version}}_{{.OS}}_{{.Arch}}"
+
+// archiveData is the template context for archive names and templated files
+type archiveData struct {
+	Project string
+	Version string
+	Target  string
+	OS      string
+	Arch    string
+	Binary  string // file name of the artifact inside the archive
+	Date    time.Time
+}
+
+// archiveFile is a validated extra file of the archive plan
+type archiveFile struct {
+	config.ArchiveFile
+	src  string
+	tmpl *template.Template
+}
+
+// archivePlan is the validated archive configuration of a run
+type archivePlan struct {
+	format string
+	name   *template.Template
+	dir    *template.Template // nil without archive.dir
+	files  []archiveFile
+	date   time.Time
+	// generated holds completions and man pages produced by the host binary
+	generated []archive.File
+}
+
+// newArchivePlan validates the archive settings up front; it returns nil when archives are off
+func newArchivePlan(p *project) (*archivePlan, error) {
+	format := p.settings.ArchiveFormat
+	if format == "" {
+		return nil, nil
+	}
+	if !slices.Contains(archive.Formats, format) {
+		return nil, fmt.Errorf("unsupported archive format: %s (expected %s)", format, strings.Join(archive.Formats, ", "))
+	}
+
+	nameTmpl := p.cfg.Archive.Name
+	if nameTmpl == "" {
+		nameTmpl = defaultArchiveName
+	}
+	name, err := template.New("archive name").Option("missingkey=error").Parse(nameTmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive name template: %v", err)
+	}
+
+	date, err := archiveDate(p)
+	if err != nil {
+		return nil, err
+	}
+	plan := &archivePlan{format: format, name: name, date: date}
+	if d := p.cfg.Archive.Dir; d != "" {
+		if plan.dir, err = template.New("archive dir").Option("missingkey=error").Parse(d); err != nil {
+			return nil, fmt.Errorf("invalid archive dir template: %v", err)
+		}
+	}
+	for i, f := range p.cfg.Archive.Files {
+		if f.Src == "" {
+			return nil, fmt.Errorf("archive.files[%d]: src is required", i)
+		}
+		af := archiveFile{ArchiveFile: f, src: filepath.Join(p.workDir, filepath.FromSlash(f.Src))}
+		if af.Dst == "" {
+			af.Dst = path.Base(filepath.ToSlash(f.Src))
+		}
+		// Zip and tar paths are slash-separated whatever the config was written on
+		af.Dst = strings.ReplaceAll(af.Dst, `\`, "/")
+		if path.IsAbs(af.Dst) || strings.HasPrefix(path.Clean(af.Dst), "..") {
+			return nil, fmt.Errorf("archive.files[%d]: dst %q must stay inside the archive", i, af.Dst)
+		}
+		data, err := os.ReadFile(af.src)
+		if err != nil {
+			return nil, fmt.Errorf("archive.files[%d]: %v", i, err)
+		}
+		if f.Template {
+			if af.tmpl, err = template.New(f.Src).Option("missingkey=error").Parse(string(data)); err != nil {
+				return nil, fmt.Errorf("archive.files[%d]: invalid template: %v", i, err)
+			}
+		}
+		plan.files = append(plan.files, af)
+	}
+	return plan, nil
+}
+
+// archiveDate is the mtime of every archive entry and the .Date of the
+// templates: SOURCE_DATE_EPOCH when set, otherwise the commit time of HEAD, so
+// that rebuilding a commit yields identical archives
+func archiveDate(p *project) (time.Time, error) {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q (expected seconds since 1970)", epoch)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	if t, err := gitmeta.CommitTime(p.gitRoot); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Now().UTC(), nil
+}
+
+// data returns the template context for a target
+func (a *archivePlan) data(p *project, t targets.Target, binary string) archiveData {
+	return archiveData{
+		Project: p.name, Version: p.version, Target: t.String(),
+		OS: t.OS, Arch: t.Arch, Binary: binary, Date: a.date,
+	}
+}
+
+// fileName renders the archive file name for a target
+func (a *archivePlan) fileName(p *project, t targets.Target, binary string) (string, error) {
+	var b strings.Builder
+	if err := a.name.Execute(&b, a.data(p, t, binary)); err != nil {
+		return "", fmt.Errorf("failed to render archive name: %v", err)
+	}
+	name := b.String()
+	if flagCover && name != "" && !strings.Contains(name, coverSuffix) {
+		name += coverSuffix
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("archive name %q for %s is not a plain file name", name, t)
+	}
+	return name + archive.Ext(archive.FormatFor(a.format, t.OS)), nil
+}
+
+// crlf converts LF line endings to CRLF, leaving existing CRLFs alone
+func crlf(data []byte) []byte {
+	return bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+// applies reports whether an extra file belongs in the target's archive
+func (f archiveFile) applies(goos string) bool {
+	if len(f.OS) > 0 && !slices.Contains(f.OS, goos) {
+		return false
+	}
+	return !slices.Contains(f.ExcludeOS, goos)
+}
+
+// build writes the target's archive into versionDir and returns its file name
+func (a *archivePlan) build(p *project, t targets.Target, binPath, versionDir string) (string, error) {
+	binary := filepath.Base(binPath)
+	name, err := a.fileName(p, t, binary)
+	if err != nil {
+		return "", err
+	}
+	data := a.data(p, t, binary)
+
+	files := []archive.File{{Name: binary, Path: binPath, Mode: 0o755}}
+	for _, f := range a.files {
+		if !f.applies(t.OS) {
+			continue
+		}
+		entry := archive.File{Name: f.Dst, Path: f.src, Mode: 0o644}
+		if f.tmpl != nil {
+			var b bytes.Buffer
+			if err := f.tmpl.Execute(&b, data); err != nil {
+				return "", fmt.Errorf("failed to render %s: %v", f.Src, err)
+			}
+			entry.Path, entry.Data = "", b.Bytes()
+		}
+		if f.CRLF && t.OS == "windows" {
+			if entry.Path != "" {
+				if entry.Data, err = os.ReadFile(entry.Path); err != nil {
+					return "", err
+				}
+				entry.Path = ""
+			}
+			entry.Data = crlf(entry.Data)
+		}
+		files = append(files, entry)
+	}
+	for _, g := range a.generated {
+		// Windows has no man pages to install them to
+		if t.OS == "windows" && strings.HasPrefix(g.Name, "man/") {
+			continue
+		}
+		files = append(files, g)
+	}
+
+	if a.dir != nil {
+		var b strings.Builder
+		if err := a.dir.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("failed to render archive dir: %v", err)
+		}
+		dir := b.String()
+		if dir == "" || strings.ContainsAny(dir, `/\`) || dir == "." || dir == ".." {
+			return "", fmt.Errorf("archive dir %q for %s is not a plain directory name", dir, t)
+		}
+		for i := range files {
+			files[i].Name = dir + "/" + files[i].Name
+		}
+	}
+
+	format := archive.FormatFor(a.format, t.OS)
+	if err := archive.Write(filepath.Join(versionDir, name), format, t.OS, files, a.date); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"pbuild/fsutil"
+	"pbuild/pool"
+)
+
+// artifactPool returns the pool of --pool in the output directory, or nil without it
+func artifactPool(p *project) *pool.Pool {
+	if !flagPool {
+		return nil
+	}
+	return pool.New(outputDir(p.workDir))
+}
+
+// printPoolSummary reports the binaries the pool already held
+func printPoolSummary(rows []summaryRow) {
+	if !flagPool {
+		return
+	}
+	built, reused, saved := 0, 0, int64(0)
+	for _, r := range rows {
+		if !r.Success {
+			continue
+		}
+		built++
+		if r.Deduplicated {
+			reused++
+			saved += r.Size
+		}
+	}
+	if reused > 0 {
+		fmt.Printf("Pool: %d of %d binaries identical to earlier builds, %s not stored again\n\n", reused, built, fsutil.HumanSizeBytes(saved))
+	}
+}
+
+// prunePool removes pooled binaries no version directory links to any more,
+// e.g. after nightlies were pruned
+func prunePool(outDir string) {
+	removed, freed, err := pool.New(outDir).Prune(outDir)
+	if err != nil {
+		fmt.Printf("Warning: Failed to prune %s: %v\n", pool.Dir, err)
+		return
+	}
+	if removed > 0 {
+		fmt.Printf("Pruned %d unused binaries from %s (%s)\n", removed, pool.Dir, fsutil.HumanSizeBytes(freed))
+	}
+}
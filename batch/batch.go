@@ -0,0 +1,183 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"pbuild/fsutil"
+)
+
+// File is a repos.yaml batch definition
+type File struct {
+	// OutputRoot collects every repository's artifacts under <root>/<name>;
+	// empty keeps each repository's own output directory
+	OutputRoot string `yaml:"output_root"`
+	// Workspace holds clones of git entries
+	Workspace string `yaml:"workspace"`
+	// Args are pbuild flags applied to every entry
+	Args  []string `yaml:"args"`
+	Repos []Repo   `yaml:"repos"`
+}
+
+// Repo is one batch entry: a local directory or a git repository
+type Repo struct {
+	Name string   `yaml:"name"`
+	Path string   `yaml:"path"`
+	Git  string   `yaml:"git"`
+	Ref  string   `yaml:"ref"`
+	Args []string `yaml:"args"`
+}
+
+// Load reads a batch file, resolving relative paths against its directory and
+// deriving missing entry names
+func Load(file string) (*File, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	if len(f.Repos) == 0 {
+		return nil, fmt.Errorf("%s lists no repos", file)
+	}
+
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Dir(absFile)
+	abs := func(p string) string {
+		if p == "" || filepath.IsAbs(p) || strings.HasPrefix(p, "~") {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+	f.OutputRoot = abs(f.OutputRoot)
+	f.Workspace = abs(f.Workspace)
+
+	seen := make(map[string]bool)
+	for i := range f.Repos {
+		r := &f.Repos[i]
+		switch {
+		case r.Path != "" && r.Git != "":
+			return nil, fmt.Errorf("repos[%d]: set either path or git, not both", i)
+		case r.Path == "" && r.Git == "":
+			return nil, fmt.Errorf("repos[%d]: path or git is required", i)
+		}
+		r.Path = abs(r.Path)
+		if r.Name == "" {
+			if r.Git != "" {
+				r.Name = strings.TrimSuffix(path.Base(strings.TrimRight(r.Git, "/")), ".git")
+			} else {
+				r.Name = filepath.Base(r.Path)
+			}
+		}
+		if r.Name == "" || r.Name == "." || strings.ContainsAny(r.Name, `/\`) || strings.Contains(r.Name, "..") {
+			return nil, fmt.Errorf("repos[%d]: invalid name %q", i, r.Name)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("repos[%d]: duplicate name %q", i, r.Name)
+		}
+		seen[r.Name] = true
+		for _, a := range append(append([]string{}, f.Args...), r.Args...) {
+			if !strings.HasPrefix(a, "-") {
+				return nil, fmt.Errorf("repos[%d]: unexpected argument %q: use --flag=value form", i, a)
+			}
+		}
+	}
+	return &f, nil
+}
+
+// Result is the outcome of building one batch entry
+type Result struct {
+	Name         string   `json:"name"`
+	Source       string   `json:"source"`
+	Version      string   `json:"version,omitempty"`
+	Success      bool     `json:"success"`
+	Error        string   `json:"error,omitempty"`
+	OutputDir    string   `json:"output_dir,omitempty"`
+	SuccessCount int      `json:"success_count"`
+	FailCount    int      `json:"fail_count"`
+	SkipCount    int      `json:"skip_count,omitempty"`
+	Duration     string   `json:"duration"`
+	Artifacts    []string `json:"artifacts,omitempty"`
+}
+
+// Report is the consolidated outcome of a batch run
+type Report struct {
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	Results  []Result  `json:"results"`
+}
+
+// Failed returns how many entries did not build cleanly
+func (r Report) Failed() int {
+	n := 0
+	for _, res := range r.Results {
+		if !res.Success {
+			n++
+		}
+	}
+	return n
+}
+
+const markdownTemplate = `# Batch build
+
+Started {{.Started.Format "2006-01-02 15:04:05 MST"}}, took {{.Duration}}. **{{len .Results}}** repositories, **{{.Failed}}** failed.
+
+| Repository | Version | Targets | Duration | Status |
+|------------|---------|---------|----------|--------|
+{{- range .Results}}
+| {{.Name}} | {{if .Version}}{{.Version}}{{else}}n/a{{end}} | {{.SuccessCount}} ok, {{.FailCount}} failed{{if .SkipCount}}, {{.SkipCount}} skipped{{end}} | {{.Duration}} | {{if .Success}}success{{else}}failed{{end}} |
+{{- end}}
+{{- if .Failed}}
+
+## Failures
+{{range .Results}}{{if not .Success}}
+### {{.Name}}
+
+Source: ` + "`{{.Source}}`" + `
+
+` + "```" + `
+{{.Error}}
+` + "```" + `
+{{end}}{{end}}
+{{- end}}
+`
+
+var markdown = template.Must(template.New("batch").Parse(markdownTemplate))
+
+// WriteReport writes batch-report.json and batch-report.md into dir and returns their paths
+func WriteReport(dir string, report Report) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	jsonPath := filepath.Join(dir, "batch-report.json")
+	if err := fsutil.WriteFileAtomic(jsonPath, data, 0644); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	if err := markdown.Execute(&b, report); err != nil {
+		return nil, fmt.Errorf("failed to render batch report: %v", err)
+	}
+	mdPath := filepath.Join(dir, "batch-report.md")
+	if err := fsutil.WriteFileAtomic(mdPath, []byte(b.String()), 0644); err != nil {
+		return nil, err
+	}
+	return []string{jsonPath, mdPath}, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/batch"
+	"pbuild/buildmeta"
+	"pbuild/cache"
+	"pbuild/fsutil"
+	"pbuild/gitmeta"
+	"pbuild/ui"
+)
+
+var flagBatchReportDir string
+
+// runIDEnv passes a batch entry's build the ID recorded in its build-metadata.json
+const runIDEnv = "PBUILD_RUN_ID"
+
+// newBatchCmd returns the `pbuild batch` subcommand
+func newBatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "batch REPOS_FILE",
+		Short: "Build a list of repositories or directories and write a consolidated report",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBatch(args[0])
+		},
+	}
+	cmd.Flags().StringVar(&flagBatchReportDir, "report-dir", "", "where to write batch-report.{json,md} (default: output_root, else the directory of REPOS_FILE)")
+	return cmd
+}
+
+// batchOutputDir returns the output directory an entry's build writes to,
+// honoring an --output-dir in its own args
+func batchOutputDir(f *batch.File, r batch.Repo, dir string, args []string) string {
+	workDir := dir
+	if root, err := fsutil.FindModuleRoot(dir); err == nil {
+		workDir = root
+	}
+	out := filepath.Join(workDir, "builds")
+	if f.OutputRoot != "" {
+		out = filepath.Join(f.OutputRoot, r.Name)
+	}
+	for _, a := range args {
+		if v, ok := strings.CutPrefix(a, "--output-dir="); ok {
+			out = v
+			if !filepath.IsAbs(out) {
+				out = filepath.Join(workDir, out)
+			}
+		}
+	}
+	return out
+}
+
+// runMetadata returns the metadata of the build in outDir that recorded runID,
+// and its version directory
+func runMetadata(outDir, runID string) (*buildmeta.BuildMetadata, string) {
+	entries, _ := os.ReadDir(outDir)
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == "latest" {
+			continue
+		}
+		dir := filepath.Join(outDir, e.Name())
+		if m, err := buildmeta.Read(dir); err == nil && m.RunID == runID {
+			return m, dir
+		}
+	}
+	return nil, ""
+}
+
+// newRunID returns a random ID for one build of a batch
+func newRunID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// runBatch builds every entry of the batch file in order with its own config
+func runBatch(file string) error {
+	f, err := batch.Load(file)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the pbuild binary: %v", err)
+	}
+	workspace := f.Workspace
+	if workspace == "" {
+		dir, err := cache.Dir()
+		if err != nil {
+			return fmt.Errorf("set workspace in %s: %v", file, err)
+		}
+		workspace = filepath.Join(dir, cache.Workspace)
+	}
+	if workspace, err = fsutil.ExpandHome(workspace); err != nil {
+		return err
+	}
+
+	// Ctrl-C reaches the running pbuild directly; stop scheduling further entries
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report := batch.Report{Started: time.Now()}
+	for _, r := range f.Repos {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("==> %s\n", r.Name)
+		start := time.Now()
+		res := batch.Result{Name: r.Name, Source: r.Path}
+
+		dir := r.Path
+		if r.Git != "" {
+			res.Source = r.Git
+			if r.Ref != "" {
+				res.Source += "@" + r.Ref
+			}
+			dir = filepath.Join(workspace, "batch", r.Name)
+			if err := gitmeta.Checkout(ctx, r.Git, r.Ref, dir, os.Stdout); err != nil {
+				res.Error = err.Error()
+				res.Duration = time.Since(start).String()
+				report.Results = append(report.Results, res)
+				fmt.Printf("  FAILED\n  %v\n\n", err)
+				continue
+			}
+		}
+		if dir, err = fsutil.ExpandHome(dir); err != nil {
+			return err
+		}
+
+		args := append(append([]string{}, f.Args...), r.Args...)
+		outDir := batchOutputDir(f, r, dir, args)
+		runID := newRunID()
+		cmd := exec.Command(exe, append(append([]string{"--output-dir=" + outDir}, args...), dir)...)
+		cmd.Env = append(os.Environ(), runIDEnv+"="+runID)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		runErr := cmd.Run()
+
+		res.Duration = time.Since(start).String()
+		if m, versionDir := runMetadata(outDir, runID); m != nil {
+			res.Version = m.Version
+			res.OutputDir = versionDir
+			res.SuccessCount, res.FailCount, res.SkipCount = m.SuccessCount, m.FailCount, m.SkipCount
+			res.Artifacts = m.Artifacts
+		}
+		switch {
+		case runErr != nil:
+			res.Error = runErr.Error()
+		case res.Version == "":
+			res.Error = "no build metadata was written to " + outDir
+		case res.FailCount > 0:
+			res.Error = fmt.Sprintf("%d targets failed", res.FailCount)
+		default:
+			res.Success = true
+		}
+		report.Results = append(report.Results, res)
+		fmt.Println()
+	}
+	report.Duration = time.Since(report.Started).String()
+
+	renderBatchSummary(report)
+
+	reportDir := flagBatchReportDir
+	if reportDir == "" {
+		reportDir = f.OutputRoot
+	}
+	if reportDir == "" {
+		if reportDir, err = filepath.Abs(filepath.Dir(file)); err != nil {
+			return err
+		}
+	}
+	if reportDir, err = fsutil.ExpandHome(reportDir); err != nil {
+		return err
+	}
+	paths, err := batch.WriteReport(reportDir, report)
+	if err != nil {
+		return fmt.Errorf("failed to write batch report: %v", err)
+	}
+	fmt.Printf("\nBatch report written to: %s\n", strings.Join(paths, ", "))
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("batch interrupted after %d of %d repositories", len(report.Results), len(f.Repos))
+	}
+	if n := report.Failed(); n > 0 {
+		return fmt.Errorf("%d of %d repositories failed", n, len(report.Results))
+	}
+	return nil
+}
+
+// renderBatchSummary prints one row per batch entry
+func renderBatchSummary(report batch.Report) {
+	greenTick := ui.OK()
+	redX := ui.Fail()
+	tbl := newGridTable(os.Stdout)
+	tbl.Header([]string{"Repository", "Version", "Targets", "Duration", "Status"})
+	data := make([][]any, 0, len(report.Results))
+	for _, r := range report.Results {
+		version := r.Version
+		if version == "" {
+			version = "n/a"
+		}
+		status := greenTick
+		if !r.Success {
+			status = redX
+		}
+		targets := fmt.Sprintf("%d ok, %d failed", r.SuccessCount, r.FailCount)
+		if r.SkipCount > 0 {
+			targets += fmt.Sprintf(", %d skipped", r.SkipCount)
+		}
+		data = append(data, []any{r.Name, version, targets, r.Duration, status})
+	}
+	_ = tbl.Bulk(data)
+	_ = tbl.Render()
+}
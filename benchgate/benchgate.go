@@ -0,0 +1,237 @@
+package benchgate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"pbuild/sandbox"
+)
+
+// ResultsFile is the go test -bench output kept in the version directory;
+// benchstat reads it as is
+const ResultsFile = "bench.txt"
+
+// ComparisonFile is the comparison with the baseline, next to ResultsFile
+const ComparisonFile = "bench-compare.txt"
+
+// Alpha is the significance level below which a change counts, as in benchstat
+const Alpha = 0.05
+
+// Options selects the benchmarks to run and how
+type Options struct {
+	// Packages are package patterns, default ./...
+	Packages []string
+	// Bench is the -bench regexp, default .
+	Bench string
+	// Count is how many times each benchmark runs
+	Count int
+	// Benchtime is the -benchtime, e.g. 1s or 100x; empty keeps go test's
+	Benchtime string
+	// Tags and Env are those of the build, so the benchmarks measure its code
+	Tags []string
+	Env  []string
+	// Sandbox restricts go test; nil runs it unrestricted
+	Sandbox *sandbox.Policy
+}
+
+// Run runs the benchmarks in workDir and returns go test's output
+func Run(ctx context.Context, workDir string, opts Options) ([]byte, error) {
+	bench := opts.Bench
+	if bench == "" {
+		bench = "."
+	}
+	args := []string{"test", "-run", "^$", "-bench", bench, "-count", strconv.Itoa(max(opts.Count, 1))}
+	if opts.Benchtime != "" {
+		args = append(args, "-benchtime", opts.Benchtime)
+	}
+	if len(opts.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(opts.Tags, ","))
+	}
+	pkgs := opts.Packages
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	cmd := sandbox.Command(ctx, opts.Sandbox, "go", append(args, pkgs...)...)
+	cmd.Dir = workDir
+	cmd.Env = opts.Env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("go test -bench failed: %v\n%s", err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// Key is one measured value of a benchmark: its package, name without the
+// GOMAXPROCS suffix (e.g. Encode/small) and unit (e.g. ns/op)
+type Key struct {
+	Pkg  string `json:"pkg"`
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+}
+
+// Results are the samples of each benchmark value
+type Results map[Key][]float64
+
+var procsSuffix = regexp.MustCompile(`-\d+$`)
+
+// Parse reads go test -bench output; lines that are not results are skipped
+func Parse(r io.Reader) (Results, error) {
+	res := make(Results)
+	pkg := ""
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if p, ok := strings.CutPrefix(line, "pkg: "); ok {
+			pkg = strings.TrimSpace(p)
+			continue
+		}
+		fields := strings.Fields(line)
+		// BenchmarkName-8  1000  123 ns/op  16 B/op ...
+		if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		name := procsSuffix.ReplaceAllString(strings.TrimPrefix(fields[0], "Benchmark"), "")
+		for i := 2; i < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			k := Key{Pkg: pkg, Name: name, Unit: fields[i+1]}
+			res[k] = append(res[k], v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Delta is how one benchmark value changed from the baseline
+type Delta struct {
+	Key
+	// Old and New are the medians of the samples
+	Old float64 `json:"old"`
+	New float64 `json:"new"`
+	// Change is the change of the median in percent, positive when the value grew
+	Change float64 `json:"change"`
+	// P is the Mann-Whitney U test p-value; the change is noise above Alpha
+	P    float64 `json:"p"`
+	OldN int     `json:"old_n"`
+	NewN int     `json:"new_n"`
+	// Regression is a significant change for the worse above the threshold
+	Regression bool `json:"regression,omitempty"`
+}
+
+// HigherIsBetter reports whether a unit measures throughput, like MB/s,
+// rather than a cost, like ns/op or allocs/op
+func HigherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+// Compare compares every benchmark value measured in both runs. A change
+// for the worse of more than threshold percent that is significant is a
+// regression; a zero baseline has no percentage and never is.
+func Compare(old, new Results, threshold float64) []Delta {
+	var deltas []Delta
+	for k, newSamples := range new {
+		oldSamples, ok := old[k]
+		if !ok {
+			continue
+		}
+		d := Delta{Key: k, Old: median(oldSamples), New: median(newSamples), OldN: len(oldSamples), NewN: len(newSamples)}
+		d.P = mannWhitneyU(oldSamples, newSamples)
+		if d.Old != 0 {
+			d.Change = (d.New - d.Old) / math.Abs(d.Old) * 100
+		}
+		worse := d.Change
+		if HigherIsBetter(k.Unit) {
+			worse = -worse
+		}
+		d.Regression = d.P < Alpha && worse > threshold
+		deltas = append(deltas, d)
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		a, b := deltas[i].Key, deltas[j].Key
+		if a.Pkg != b.Pkg {
+			return a.Pkg < b.Pkg
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Unit < b.Unit
+	})
+	return deltas
+}
+
+// Format writes the deltas as a benchstat-like table per package, with
+// regressions marked
+func Format(deltas []Delta) string {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	pkg := ""
+	for i, d := range deltas {
+		if i == 0 || d.Pkg != pkg {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			pkg = d.Pkg
+			fmt.Fprintf(w, "pkg: %s\nname\tunit\tbaseline\tcurrent\tdelta\t\n", pkg)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Name, d.Unit, formatValue(d.Old), formatValue(d.New), formatChange(d), mark(d))
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// formatChange is the change with its p-value and sample counts, or ~ for
+// noise, as benchstat shows it
+func formatChange(d Delta) string {
+	stats := fmt.Sprintf("(p=%.3f n=%d+%d)", d.P, d.OldN, d.NewN)
+	if d.P >= Alpha || d.Old == 0 {
+		return "~ " + stats
+	}
+	return fmt.Sprintf("%+.2f%% %s", d.Change, stats)
+}
+
+func mark(d Delta) string {
+	if d.Regression {
+		return "REGRESSION"
+	}
+	return ""
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+func median(samples []float64) float64 {
+	s := slices.Sorted(slices.Values(samples))
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// Report is what build-metadata.json records of the gate
+type Report struct {
+	// Baseline is the file compared against; empty without one
+	Baseline  string  `json:"baseline,omitempty"`
+	Threshold float64 `json:"threshold"`
+	// Compared is the number of benchmark values measured in both runs
+	Compared    int     `json:"compared"`
+	Regressions []Delta `json:"regressions,omitempty"`
+}
@@ -0,0 +1,113 @@
+package benchgate
+
+import (
+	"math"
+	"sort"
+)
+
+// exactLimit is the largest sample size the exact U distribution is
+// computed for; larger samples use the normal approximation
+const exactLimit = 50
+
+// mannWhitneyU returns the two-sided p-value of the Mann-Whitney U test that
+// a and b come from the same distribution. Without ties small samples get
+// the exact distribution, otherwise the normal approximation with the tie
+// correction is used.
+func mannWhitneyU(a, b []float64) float64 {
+	n1, n2 := len(a), len(b)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	type sample struct {
+		v     float64
+		first bool
+	}
+	all := make([]sample, 0, n1+n2)
+	for _, v := range a {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range b {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Rank sum of a, with tied values sharing their mean rank
+	rankSum, tieTerm := 0.0, 0.0
+	ties := false
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieTerm += t*t*t - t
+		}
+		i = j
+	}
+	u := rankSum - float64(n1*(n1+1))/2
+
+	if !ties && n1 <= exactLimit && n2 <= exactLimit {
+		return exactP(n1, n2, int(math.Round(u)))
+	}
+
+	n := float64(n1 + n2)
+	mean := float64(n1*n2) / 2
+	variance := float64(n1*n2) / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		// Every value is the same
+		return 1
+	}
+	// Continuity correction towards the mean
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		return 1
+	}
+	return math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// exactP is the two-sided p-value of U = u for samples of n1 and n2 values
+// without ties, from the number of rank orders giving each U
+func exactP(n1, n2, u int) float64 {
+	// counts[j][k] is the number of orders of i values of a and j of b with U = k,
+	// built up one value of a at a time
+	counts := make([][]float64, n2+1)
+	for j := range counts {
+		counts[j] = make([]float64, 1)
+		counts[j][0] = 1
+	}
+	for i := 1; i <= n1; i++ {
+		next := make([][]float64, n2+1)
+		next[0] = []float64{1}
+		for j := 1; j <= n2; j++ {
+			next[j] = make([]float64, i*j+1)
+			// The largest value is either from a (adding j to U) or from b
+			for k, c := range counts[j] {
+				next[j][k+j] += c
+			}
+			for k, c := range next[j-1] {
+				next[j][k] += c
+			}
+		}
+		counts = next
+	}
+	dist := counts[n2]
+	total, below, above := 0.0, 0.0, 0.0
+	for k, c := range dist {
+		total += c
+		if k <= u {
+			below += c
+		}
+		if k >= u {
+			above += c
+		}
+	}
+	return math.Min(1, 2*math.Min(below, above)/total)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"pbuild/benchgate"
+	"pbuild/buildmeta"
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/targets"
+)
+
+// benchRun is the outcome of the benchmark gate, kept until the version
+// directory exists
+type benchRun struct {
+	output     []byte
+	comparison string
+	report     *benchgate.Report
+}
+
+// runBenchGate runs the configured benchmarks before building, as
+// --bench-gate asks, and compares them with the baseline. Regressions are a
+// warning, or fail the run with error; without a baseline the results only
+// become the next run's. Single-target jobs leave it to the release run.
+func runBenchGate(p *project, outDir string) (*benchRun, error) {
+	if flagBenchGate == "off" || fragmentTarget != "" {
+		return nil, nil
+	}
+	bc := p.cfg.Bench
+	if bc.Count == 0 {
+		bc.Count = 6
+	}
+	if bc.Threshold == 0 {
+		bc.Threshold = 5
+	}
+	config := newBuildConfig(p)
+	host := targets.Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	tags, err := gobuild.ResolveTags(config.Strategy, config.Tags, host)
+	if err != nil {
+		return nil, err
+	}
+	opts := benchgate.Options{
+		Packages:  bc.Packages,
+		Bench:     bc.Pattern,
+		Count:     bc.Count,
+		Benchtime: bc.Benchtime,
+		Tags:      tags,
+		Env:       append(os.Environ(), gobuild.TargetEnv(p.workDir, host, config)...),
+		Sandbox:   p.sandbox,
+	}
+	fmt.Printf("Running benchmarks (%d runs each)\n", bc.Count)
+	out, err := benchgate.Run(context.Background(), p.workDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	current, err := benchgate.Parse(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+	if len(current) == 0 {
+		fmt.Println("Warning: No benchmarks matched the bench settings")
+	}
+
+	run := &benchRun{output: out, report: &benchgate.Report{Threshold: bc.Threshold}}
+	baseline, label := benchBaseline(p, outDir)
+	if baseline == "" {
+		fmt.Println("No benchmark baseline yet; these results are the next run's")
+		return run, nil
+	}
+	data, err := os.ReadFile(baseline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the benchmark baseline: %v", err)
+	}
+	old, err := benchgate.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the benchmark baseline %s: %v", baseline, err)
+	}
+	deltas := benchgate.Compare(old, current, bc.Threshold)
+	run.report.Baseline = label
+	run.report.Compared = len(deltas)
+	for _, d := range deltas {
+		if d.Regression {
+			run.report.Regressions = append(run.report.Regressions, d)
+		}
+	}
+	run.comparison = fmt.Sprintf("baseline: %s\nthreshold: %g%%\n\n%s", label, bc.Threshold, benchgate.Format(deltas))
+
+	if len(run.report.Regressions) == 0 {
+		fmt.Printf("Benchmarks: no regression above %g%% against %s (%d values compared)\n", bc.Threshold, label, len(deltas))
+		if flagVerbose {
+			fmt.Print(indent(benchgate.Format(deltas)))
+		}
+		return run, nil
+	}
+	var names []string
+	for _, d := range run.report.Regressions {
+		names = append(names, fmt.Sprintf("%s %s %+.2f%%", d.Name, d.Unit, d.Change))
+	}
+	msg := fmt.Sprintf("%d benchmark regressions above %g%% against %s:\n  %s", len(names), bc.Threshold, label, strings.Join(names, "\n  "))
+	if flagBenchGate == "error" {
+		fmt.Print(indent(benchgate.Format(deltas)))
+		return nil, fmt.Errorf("%s", msg)
+	}
+	fmt.Printf("Warning: %s\n", msg)
+	return run, nil
+}
+
+// benchBaseline returns the results file the gate compares with and how to
+// name it: bench.baseline, or the bench.txt of the newest other build in
+// outDir
+func benchBaseline(p *project, outDir string) (string, string) {
+	if b := p.cfg.Bench.Baseline; b != "" {
+		if !filepath.IsAbs(b) {
+			b = filepath.Join(p.workDir, b)
+		}
+		return b, p.cfg.Bench.Baseline
+	}
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return "", ""
+	}
+	var newest *buildmeta.BuildMetadata
+	var newestDir string
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == "latest" || e.Name() == p.version {
+			continue
+		}
+		dir := filepath.Join(outDir, e.Name())
+		if _, err := os.Stat(filepath.Join(dir, benchgate.ResultsFile)); err != nil {
+			continue
+		}
+		meta, err := buildmeta.Read(dir)
+		if err != nil {
+			continue
+		}
+		if newest == nil || meta.BuildTime.After(newest.BuildTime) {
+			newest, newestDir = meta, dir
+		}
+	}
+	if newest == nil {
+		return "", ""
+	}
+	return filepath.Join(newestDir, benchgate.ResultsFile), newest.Version
+}
+
+// writeBenchResults stores the benchmark output and its comparison in the
+// version directory
+func writeBenchResults(versionDir string, run *benchRun) {
+	if run == nil {
+		return
+	}
+	path := filepath.Join(versionDir, benchgate.ResultsFile)
+	if err := fsutil.WriteFileAtomic(path, run.output, 0o644); err != nil {
+		fmt.Printf("Warning: Failed to write %s: %v\n", benchgate.ResultsFile, err)
+		return
+	}
+	fmt.Printf("Benchmark results written to: %s\n", path)
+	if run.comparison == "" {
+		return
+	}
+	path = filepath.Join(versionDir, benchgate.ComparisonFile)
+	if err := fsutil.WriteFileAtomic(path, []byte(run.comparison), 0o644); err != nil {
+		fmt.Printf("Warning: Failed to write %s: %v\n", benchgate.ComparisonFile, err)
+		return
+	}
+	fmt.Printf("Benchmark comparison written to: %s\n", path)
+}
+
+// indent prefixes every line of s with two spaces
+func indent(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	return "  " + strings.Join(lines, "\n  ") + "\n"
+}
+
+// metadata is the gate's record for build-metadata.json; nil when it did not run
+func (r *benchRun) metadata() *benchgate.Report {
+	if r == nil {
+		return nil
+	}
+	return r.report
+}
@@ -0,0 +1,153 @@
+package bindiff
+
+import (
+	"bytes"
+	"compress/gzip"
+	"debug/buildinfo"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"pbuild/binfmt"
+	"pbuild/metadiff"
+)
+
+// Binary is what a built binary says about what went into it
+type Binary struct {
+	// Size is the size of the binary, uncompressed
+	Size int64
+	// GoVersion is the toolchain that built it; empty without Go build info
+	GoVersion string
+	// Deps maps the modules linked in to their versions, path@version for a
+	// replaced module
+	Deps map[string]string
+	// Settings are the build settings (-ldflags, -tags, CGO_ENABLED, ...)
+	// without the vcs.* ones, which change with every commit
+	Settings map[string]string
+	// Sections maps section names to their size in the file
+	Sections map[string]int64
+}
+
+// Read loads the binary at path, decompressing a .zst or .gz artifact first
+func Read(path string) (*Binary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var in io.Reader = f
+	switch {
+	case strings.HasSuffix(path, ".zst"):
+		dec, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		in = dec
+	case strings.HasSuffix(path, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		in = gz
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	r := bytes.NewReader(data)
+	b := &Binary{Size: int64(len(data)), Deps: make(map[string]string), Settings: make(map[string]string)}
+	// A binary without build info (stripped of it, or not Go) still has sections
+	if bi, err := buildinfo.Read(r); err == nil {
+		b.GoVersion = bi.GoVersion
+		for _, m := range bi.Deps {
+			version := m.Version
+			if m.Replace != nil {
+				version = m.Replace.Path + "@" + m.Replace.Version
+			}
+			b.Deps[m.Path] = version
+		}
+		for _, s := range bi.Settings {
+			if !strings.HasPrefix(s.Key, "vcs.") {
+				b.Settings[s.Key] = s.Value
+			}
+		}
+	}
+	if b.Sections, err = binfmt.Sections(r); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return b, nil
+}
+
+// SectionChange is a section whose size differs; a zero side means absent
+type SectionChange struct {
+	Name string
+	Old  int64
+	New  int64
+}
+
+// Diff is what changed between two builds of the same target's binary
+type Diff struct {
+	OldSize  int64
+	NewSize  int64
+	OldGo    string
+	NewGo    string
+	Settings []metadiff.Change
+	Deps     []metadiff.DepChange
+	Sections []SectionChange
+}
+
+// Compare diffs two binaries of one target
+func Compare(old, new *Binary) Diff {
+	d := Diff{OldSize: old.Size, NewSize: new.Size, OldGo: old.GoVersion, NewGo: new.GoVersion}
+	for _, k := range keys(old.Settings, new.Settings) {
+		if old.Settings[k] != new.Settings[k] {
+			d.Settings = append(d.Settings, metadiff.Change{Name: k, Old: old.Settings[k], New: new.Settings[k]})
+		}
+	}
+	for _, path := range keys(old.Deps, new.Deps) {
+		if o, n := old.Deps[path], new.Deps[path]; o != n {
+			d.Deps = append(d.Deps, metadiff.DepChange{Path: path, Old: o, New: n})
+		}
+	}
+	for _, name := range keys(old.Sections, new.Sections) {
+		if o, n := old.Sections[name], new.Sections[name]; o != n {
+			d.Sections = append(d.Sections, SectionChange{Name: name, Old: o, New: n})
+		}
+	}
+	// The sections that grew or shrank the most come first
+	sort.SliceStable(d.Sections, func(i, j int) bool {
+		return abs(d.Sections[i].New-d.Sections[i].Old) > abs(d.Sections[j].New-d.Sections[j].Old)
+	})
+	return d
+}
+
+// keys returns the keys of both maps, sorted
+func keys[V any](a, b map[string]V) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, m := range []map[string]V{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				out = append(out, k)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,217 @@
+package binfmt
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"pbuild/targets"
+)
+
+// Header is what a binary's file header says about the platform it runs on
+type Header struct {
+	// Format is ELF, PE, Mach-O or WebAssembly
+	Format string
+	// OS is the GOOS named by the header, which only ELF does for freebsd,
+	// netbsd and openbsd (as the OS/ABI); empty otherwise
+	OS string
+	// Archs are the GOARCH values of the code, two for a universal Mach-O
+	Archs []string
+}
+
+// String returns e.g. "ELF freebsd/amd64" or "Mach-O amd64+arm64"
+func (h *Header) String() string {
+	arch := strings.Join(h.Archs, "+")
+	if h.OS != "" {
+		return h.Format + " " + h.OS + "/" + arch
+	}
+	return h.Format + " " + arch
+}
+
+// Read parses the header of file; it returns nil for formats it does not
+// know, like archives, XCOFF and Plan 9 a.out
+func Read(file string) (*Header, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, nil
+	}
+	switch {
+	case string(magic) == elf.ELFMAG:
+		return readELF(f)
+	case bytes.HasPrefix(magic, []byte("MZ")):
+		return readPE(f)
+	case string(magic) == "\x00asm":
+		return &Header{Format: "WebAssembly", Archs: []string{"wasm"}}, nil
+	}
+	switch binary.BigEndian.Uint32(magic) {
+	case macho.Magic32, macho.Magic64, 0xcefaedfe, 0xcffaedfe:
+		return readMachO(f)
+	case macho.MagicFat:
+		return readFat(f)
+	}
+	return nil, nil
+}
+
+func readELF(r io.ReaderAt) (*Header, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ELF file: %v", err)
+	}
+	h := &Header{Format: "ELF", Archs: []string{elfArch(f)}}
+	switch f.OSABI {
+	case elf.ELFOSABI_FREEBSD:
+		h.OS = "freebsd"
+	case elf.ELFOSABI_NETBSD:
+		h.OS = "netbsd"
+	case elf.ELFOSABI_OPENBSD:
+		h.OS = "openbsd"
+	}
+	return h, nil
+}
+
+// elfArch maps the machine, class and byte order to a GOARCH
+func elfArch(f *elf.File) string {
+	le := f.Data == elf.ELFDATA2LSB
+	is64 := f.Class == elf.ELFCLASS64
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "amd64"
+	case elf.EM_386:
+		return "386"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_LOONGARCH:
+		return "loong64"
+	case elf.EM_RISCV:
+		if is64 {
+			return "riscv64"
+		}
+	case elf.EM_S390:
+		return "s390x"
+	case elf.EM_PPC64:
+		if le {
+			return "ppc64le"
+		}
+		return "ppc64"
+	case elf.EM_MIPS:
+		arch := "mips"
+		if is64 {
+			arch = "mips64"
+		}
+		if le {
+			arch += "le"
+		}
+		return arch
+	}
+	return f.Machine.String()
+}
+
+func readPE(r io.ReaderAt) (*Header, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PE file: %v", err)
+	}
+	arch := fmt.Sprintf("machine 0x%x", f.Machine)
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		arch = "amd64"
+	case pe.IMAGE_FILE_MACHINE_I386:
+		arch = "386"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		arch = "arm64"
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		arch = "arm"
+	}
+	return &Header{Format: "PE", Archs: []string{arch}}, nil
+}
+
+func readMachO(r io.ReaderAt) (*Header, error) {
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Mach-O file: %v", err)
+	}
+	return &Header{Format: "Mach-O", Archs: []string{machoArch(f.Cpu)}}, nil
+}
+
+func readFat(r io.ReaderAt) (*Header, error) {
+	f, err := macho.NewFatFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid universal Mach-O file: %v", err)
+	}
+	h := &Header{Format: "Mach-O"}
+	for _, a := range f.Arches {
+		h.Archs = append(h.Archs, machoArch(a.Cpu))
+	}
+	slices.Sort(h.Archs)
+	return h, nil
+}
+
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "amd64"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.Cpu386:
+		return "386"
+	case macho.CpuArm:
+		return "arm"
+	}
+	return cpu.String()
+}
+
+// Expected returns the header a binary for t should have, or nil when the
+// target's format is not checked (aix, plan9)
+func Expected(t targets.Target) *Header {
+	h := &Header{Archs: []string{t.Arch}}
+	switch t.OS {
+	case "windows":
+		h.Format = "PE"
+	case "darwin", "ios":
+		h.Format = "Mach-O"
+		if t.Arch == targets.DarwinUniversal {
+			h.Archs = []string{"amd64", "arm64"}
+		}
+	case "js", "wasip1":
+		h.Format = "WebAssembly"
+	case "aix", "plan9":
+		return nil
+	case "freebsd", "netbsd", "openbsd":
+		h.Format, h.OS = "ELF", t.OS
+	default:
+		h.Format = "ELF"
+	}
+	return h
+}
+
+// Check verifies that the header of file matches the target; formats Read does
+// not know, and targets Expected does not check, pass
+func Check(file string, t targets.Target) (*Header, error) {
+	want := Expected(t)
+	if want == nil {
+		return nil, nil
+	}
+	got, err := Read(file)
+	if err != nil || got == nil {
+		return got, err
+	}
+	if got.Format != want.Format || got.OS != want.OS || !slices.Equal(got.Archs, want.Archs) {
+		return got, fmt.Errorf("binary header says %s, expected %s for %s", got, want, t)
+	}
+	return got, nil
+}
@@ -0,0 +1,82 @@
+package binfmt
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Sections returns the size in the file of each section of the ELF, PE or
+// Mach-O binary in r, e.g. ".text" or "__TEXT,__text"; sections without file
+// data, like .bss, are left out. The sections of a universal Mach-O are
+// prefixed with their arch, e.g. "arm64 __TEXT,__text". It returns nil for
+// other formats.
+func Sections(r io.ReaderAt) (map[string]int64, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, nil
+	}
+	switch {
+	case string(magic) == elf.ELFMAG:
+		f, err := elf.NewFile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ELF file: %v", err)
+		}
+		sizes := make(map[string]int64)
+		for _, s := range f.Sections {
+			if s.Name != "" && s.Type != elf.SHT_NOBITS && s.Type != elf.SHT_NULL {
+				sizes[s.Name] += int64(s.FileSize)
+			}
+		}
+		return sizes, nil
+	case bytes.HasPrefix(magic, []byte("MZ")):
+		f, err := pe.NewFile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PE file: %v", err)
+		}
+		sizes := make(map[string]int64)
+		for _, s := range f.Sections {
+			if s.Size > 0 {
+				sizes[s.Name] += int64(s.Size)
+			}
+		}
+		return sizes, nil
+	}
+	switch binary.BigEndian.Uint32(magic) {
+	case macho.Magic32, macho.Magic64, 0xcefaedfe, 0xcffaedfe:
+		f, err := macho.NewFile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Mach-O file: %v", err)
+		}
+		sizes := make(map[string]int64)
+		machoSections(f, "", sizes)
+		return sizes, nil
+	case macho.MagicFat:
+		f, err := macho.NewFatFile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid universal Mach-O file: %v", err)
+		}
+		sizes := make(map[string]int64)
+		for _, a := range f.Arches {
+			machoSections(a.File, machoArch(a.Cpu)+" ", sizes)
+		}
+		return sizes, nil
+	}
+	return nil, nil
+}
+
+// machoSections adds the file-backed sections of f to sizes
+func machoSections(f *macho.File, prefix string, sizes map[string]int64) {
+	for _, s := range f.Sections {
+		// S_ZEROFILL, S_GB_ZEROFILL and S_THREAD_LOCAL_ZEROFILL have no file data
+		switch s.Flags & 0xff {
+		case 0x1, 0xc, 0x12:
+			continue
+		}
+		sizes[prefix+s.Seg+","+s.Name] += int64(s.Size)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/targets"
+)
+
+// logDir holds per-target build logs inside the version directory
+const logDir = "logs"
+
+// logName returns a target's log path relative to the version directory
+func logName(t targets.Target) string {
+	return logDir + "/" + strings.ReplaceAll(t.String(), "/", "-") + ".log"
+}
+
+// writeBuildLog saves the full output of a failed build to logs/<target>.log
+// and returns its relative path, or "" when it could not be written
+func writeBuildLog(versionDir string, t targets.Target, err error) string {
+	data := []byte(redactor.String(err.Error()) + "\n")
+	var buildErr *gobuild.BuildError
+	if errors.As(err, &buildErr) {
+		data = buildErr.Log()
+	}
+	return writeLog(versionDir, t, data)
+}
+
+// writeLog saves data to logs/<target>.log and returns its relative path, or
+// "" when it could not be written
+func writeLog(versionDir string, t targets.Target, data []byte) string {
+	name := logName(t)
+	if err := os.MkdirAll(filepath.Join(versionDir, logDir), 0o755); err != nil {
+		return ""
+	}
+	if err := fsutil.WriteFileAtomic(filepath.Join(versionDir, filepath.FromSlash(name)), data, 0o644); err != nil {
+		return ""
+	}
+	return name
+}
+
+// diagnose classifies a failed build, returning nil when it is not a
+// recognized toolchain problem
+func diagnose(err error) *gobuild.Diagnosis {
+	var buildErr *gobuild.BuildError
+	if !errors.As(err, &buildErr) {
+		return nil
+	}
+	return gobuild.Diagnose(buildErr)
+}
@@ -0,0 +1,260 @@
+package buildmeta
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pbuild/benchgate"
+	"pbuild/buildenv"
+	"pbuild/deps"
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/memlimit"
+	"pbuild/targets"
+)
+
+// FileName is the metadata file written into every version directory
+const FileName = "build-metadata.json"
+
+// SidecarSuffix is appended to an artifact's name for its provenance sidecar
+const SidecarSuffix = ".meta.json"
+
+// TargetResult records the outcome of building one target
+type TargetResult struct {
+	Target   string    `json:"target"`
+	File     string    `json:"file"`
+	Size     int64     `json:"size,omitempty"`
+	SHA256   string    `json:"sha256,omitempty"`
+	SHA512   string    `json:"sha512,omitempty"`
+	Archive  string    `json:"archive,omitempty"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	Success  bool      `json:"success"`
+	Skipped  bool      `json:"skipped,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Log      string    `json:"log,omitempty"` // full build output of a failed build (of every build with --very-verbose), relative to the version directory
+	// ErrorKind and Hint are set for recognized toolchain failures, e.g.
+	// missing-c-compiler; ErrorKind is policy-violation for content policy failures
+	ErrorKind string `json:"error_kind,omitempty"`
+	Hint      string `json:"hint,omitempty"`
+	// Signatures lists the detached signatures of the target's checksum files
+	Signatures []string `json:"signatures,omitempty"`
+	// Published lists the URLs the target's files were uploaded to
+	Published []string `json:"published,omitempty"`
+	// Cache is the Go build cache use of the target's build, with --cache-stats
+	Cache *gobuild.CacheStats `json:"cache,omitempty"`
+	// Linkage is "static" or the loader and libraries of a dynamic ELF binary
+	Linkage string `json:"linkage,omitempty"`
+	// Loader and Libraries are the PT_INTERP loader and DT_NEEDED libraries
+	// of a dynamic ELF binary
+	Loader    string   `json:"loader,omitempty"`
+	Libraries []string `json:"libraries,omitempty"`
+	// Deduplicated is set when --pool already held an identical binary
+	Deduplicated bool `json:"deduplicated,omitempty"`
+	// Tests lists the test binaries of --test-binaries, relative to the
+	// version directory
+	Tests []string `json:"tests,omitempty"`
+}
+
+// BuildMetadata holds build information
+type BuildMetadata struct {
+	ProjectName   string                 `json:"project_name"`
+	Version       string                 `json:"version"`
+	Channel       string                 `json:"channel,omitempty"`
+	BuildTime     time.Time              `json:"build_time"`
+	BuildDuration string                 `json:"build_duration"`
+	GoVersion     string                 `json:"go_version"`
+	BuildHost     string                 `json:"build_host,omitempty"`
+	BuildUser     string                 `json:"build_user,omitempty"`
+	BuildOS       string                 `json:"build_os"`
+	BuildArch     string                 `json:"build_arch"`
+	Targets       []targets.Target       `json:"targets"`
+	BuildConfig   gobuild.BuildConfig    `json:"build_config"`
+	Flags         map[string]interface{} `json:"flags"`
+	Artifacts     []string               `json:"artifacts"`
+	Results       []TargetResult         `json:"results"`
+	SuccessCount  int                    `json:"success_count"`
+	FailCount     int                    `json:"fail_count"`
+	SkipCount     int                    `json:"skip_count,omitempty"`
+	Interrupted   bool                   `json:"interrupted,omitempty"`
+	Cache         *CacheReport           `json:"cache,omitempty"`
+	Distribution  *Distribution          `json:"distribution,omitempty"`
+	Environment   *buildenv.Snapshot     `json:"environment,omitempty"`
+	// ModVerify is the go mod verify result of --mod-verify
+	ModVerify *deps.Verification `json:"mod_verify,omitempty"`
+	// Bench is the benchmark comparison of --bench-gate
+	Bench *benchgate.Report `json:"bench,omitempty"`
+	// Memory is the parallelism the memory gate of --parallel auto and
+	// --max-memory allowed
+	Memory *memlimit.Report `json:"memory,omitempty"`
+	// RunID is $PBUILD_RUN_ID of the run, set by pbuild batch to find the
+	// metadata of each build it started
+	RunID string `json:"run_id,omitempty"`
+	// Yanked is set once pbuild yank withdrew the release
+	Yanked *Yank `json:"yanked,omitempty"`
+}
+
+// Yank records when and why a release was withdrawn
+type Yank struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Distribution records the peer-to-peer copies of the version directory
+type Distribution struct {
+	// Torrent is the .torrent file, relative to the version directory
+	Torrent  string `json:"torrent,omitempty"`
+	InfoHash string `json:"info_hash,omitempty"`
+	Magnet   string `json:"magnet,omitempty"`
+	// IPFSCID is the CIDv1 the version directory is pinned as
+	IPFSCID string `json:"ipfs_cid,omitempty"`
+}
+
+// CacheReport summarizes the Go build cache use of a run
+type CacheReport struct {
+	Dir    string `json:"dir"`
+	Hits   int    `json:"hits"`
+	Misses int    `json:"misses"`
+	// Size is the cache size after the run, Growth how much the run added
+	Size   int64 `json:"size"`
+	Growth int64 `json:"growth"`
+}
+
+// ArtifactMeta is the provenance of a single artifact, written next to it so a
+// copied file can still be traced back to its build
+type ArtifactMeta struct {
+	ProjectName string              `json:"project_name"`
+	Version     string              `json:"version"`
+	Target      string              `json:"target"`
+	File        string              `json:"file"`
+	Size        int64               `json:"size,omitempty"`
+	SHA256      string              `json:"sha256,omitempty"`
+	SHA512      string              `json:"sha512,omitempty"`
+	BuildTime   time.Time           `json:"build_time"`
+	GoVersion   string              `json:"go_version"`
+	BuildHost   string              `json:"build_host,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	BuildConfig gobuild.BuildConfig `json:"build_config"`
+}
+
+// WriteSidecar writes meta to <artifactPath>.meta.json
+func WriteSidecar(artifactPath string, meta ArtifactMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(artifactPath+SidecarSuffix, data, 0644)
+}
+
+// Write writes build metadata to a JSON file in versionDir
+func Write(versionDir string, metadata BuildMetadata) error {
+	return writeFile(filepath.Join(versionDir, FileName), metadata)
+}
+
+// WriteFragment writes the metadata of a single-target run to its fragment file in versionDir
+func WriteFragment(versionDir, target string, metadata BuildMetadata) error {
+	return writeFile(filepath.Join(versionDir, FragmentName(target)), metadata)
+}
+
+func writeFile(metadataPath string, metadata BuildMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(metadataPath, data, 0644)
+}
+
+// Read loads build metadata from a metadata file or a version directory containing one
+func Read(path string) (*BuildMetadata, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, FileName)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var metadata BuildMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &metadata, nil
+}
+
+// FragmentName is the partial metadata `pbuild build-one` writes for a single
+// target, e.g. build-metadata.linux-arm64.json
+func FragmentName(target string) string {
+	return "build-metadata." + strings.ReplaceAll(target, "/", "-") + ".json"
+}
+
+// FragmentPattern matches the fragment files in a version directory
+const FragmentPattern = "build-metadata.*.json"
+
+// Merge combines the fragments of single-target runs into the metadata of the
+// whole build. All fragments must be of the same project and version, and each
+// target may only appear once.
+func Merge(fragments []*BuildMetadata) (BuildMetadata, error) {
+	if len(fragments) == 0 {
+		return BuildMetadata{}, fmt.Errorf("no metadata fragments to merge")
+	}
+	first := fragments[0]
+	merged := *first
+	merged.Targets, merged.Artifacts, merged.Results = nil, nil, nil
+	merged.SuccessCount, merged.FailCount, merged.SkipCount = 0, 0, 0
+	merged.Cache = nil
+
+	var started, finished time.Time
+	seen := make(map[targets.Target]bool)
+	for _, f := range fragments {
+		if f.ProjectName != first.ProjectName || f.Version != first.Version {
+			return BuildMetadata{}, fmt.Errorf("cannot merge %s %s with %s %s", first.ProjectName, first.Version, f.ProjectName, f.Version)
+		}
+		for _, t := range f.Targets {
+			if seen[t] {
+				return BuildMetadata{}, fmt.Errorf("%s appears in more than one fragment", t)
+			}
+			seen[t] = true
+			merged.Targets = append(merged.Targets, t)
+		}
+		merged.Artifacts = append(merged.Artifacts, f.Artifacts...)
+		merged.Results = append(merged.Results, f.Results...)
+		merged.SuccessCount += f.SuccessCount
+		merged.FailCount += f.FailCount
+		merged.SkipCount += f.SkipCount
+		merged.Interrupted = merged.Interrupted || f.Interrupted
+		// Each job has its own cache; only the counts add up
+		if f.Cache != nil {
+			if merged.Cache == nil {
+				merged.Cache = &CacheReport{}
+			}
+			merged.Cache.Hits += f.Cache.Hits
+			merged.Cache.Misses += f.Cache.Misses
+		}
+
+		// The merged build spans from the earliest start to the last finish
+		end := f.BuildTime
+		start := end
+		if d, err := time.ParseDuration(f.BuildDuration); err == nil {
+			start = end.Add(-d)
+		}
+		if started.IsZero() || start.Before(started) {
+			started = start
+		}
+		if end.After(finished) {
+			finished = end
+		}
+		if f.GoVersion != merged.GoVersion {
+			merged.GoVersion = "mixed"
+		}
+		if f.BuildHost != merged.BuildHost {
+			merged.BuildHost = "multiple"
+		}
+	}
+	merged.BuildTime = finished
+	merged.BuildDuration = finished.Sub(started).String()
+	return merged, nil
+}
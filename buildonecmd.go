@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/buildplan"
+	"pbuild/targets"
+)
+
+var flagBuildOnePlan string
+
+// fragmentTarget is the single target a `pbuild build-one` run builds; the run
+// writes its metadata fragment instead of build-metadata.json and leaves the
+// rest of the version directory alone. pbuild merge-meta combines only the
+// metadata: the latest pointer, update manifest, combined checksum file and
+// signatures of a full run are not written for a matrix
+var fragmentTarget string
+
+// newBuildOneCmd returns the `pbuild build-one` subcommand
+func newBuildOneCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "build-one TARGET [TARGET_DIR]",
+		Short: "Build a single target as one job of a CI matrix",
+		Long: "Builds exactly one GOOS/GOARCH target, with the flags, version and pbuild.yaml\n" +
+			"settings of --plan when given, and writes build-metadata.<goos>-<goarch>.json\n" +
+			"next to the artifact. Combine the fragments of all jobs with pbuild merge-meta.",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := targets.Parse(args[0])
+			if err != nil {
+				return err
+			}
+			if flagBuildOnePlan != "" {
+				plan, err := buildplan.Read(flagBuildOnePlan)
+				if err != nil {
+					return err
+				}
+				if !planHasTarget(plan, t) {
+					return fmt.Errorf("%s is not a target of %s", t, flagBuildOnePlan)
+				}
+				if err := applyPlan(cmd, plan); err != nil {
+					return err
+				}
+				replaying = plan
+			}
+			flagTargets, flagAll, flagTargetGroup, flagUniversal = t.String(), false, "", false
+			fragmentTarget = t.String()
+			return run(targetArg(args[1:]))
+		},
+	}
+	cmd.Flags().StringVar(&flagBuildOnePlan, "plan", "", "buildplan.json (or version directory) to take flags, version and config from")
+	cmd.Flags().StringVar(&flagOutDir, "output-dir", "builds", "directory for build artifacts")
+	cmd.Flags().StringVar(&flagStagingDir, "staging-dir", "", "build in this local directory and copy the artifact to --output-dir at the end, verified, e.g. for a network share")
+	cmd.Flags().BoolVar(&flagPublish, "publish", false, "upload the artifact to the publish: destinations in pbuild.yaml")
+	return cmd
+}
+
+// planHasTarget reports whether plan builds t
+func planHasTarget(plan *buildplan.Plan, t targets.Target) bool {
+	for _, pt := range plan.Targets {
+		if pt.Target == t.String() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package buildplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pbuild/config"
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+)
+
+// FileName is the plan written into every version directory
+const FileName = "buildplan.json"
+
+// Schema is the plan format version; Read rejects newer plans
+const Schema = 1
+
+// Plan is everything a run was resolved from, so it can be replayed later
+// without consulting defaults, the environment or pbuild.yaml again
+type Plan struct {
+	Schema        int       `json:"schema"`
+	PBuildVersion string    `json:"pbuild_version"`
+	Created       time.Time `json:"created"`
+	Project       string    `json:"project"`
+	Version       string    `json:"version"`
+	Channel       string    `json:"channel,omitempty"`
+	// Commit is the short HEAD commit the plan was made at
+	Commit string `json:"commit,omitempty"`
+	// Flags holds every command line flag with its effective value
+	Flags map[string][]string `json:"flags"`
+	// Config is pbuild.yaml as it was loaded
+	Config      *config.Config      `json:"config"`
+	BuildConfig gobuild.BuildConfig `json:"build_config"`
+	Targets     []Target            `json:"targets"`
+}
+
+// Target is the resolved build of one target
+type Target struct {
+	Target string   `json:"target"`
+	Output string   `json:"output"`
+	Env    []string `json:"env"`
+	Tags   []string `json:"tags,omitempty"`
+	// Slices are the per-architecture builds a universal darwin binary is merged from
+	Slices []Target `json:"slices,omitempty"`
+}
+
+// Write writes plan to versionDir/buildplan.json
+func Write(versionDir string, plan Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(filepath.Join(versionDir, FileName), data, 0644)
+}
+
+// Read loads a plan from a file or a version directory containing one
+func Read(path string) (*Plan, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, FileName)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if plan.Schema > Schema {
+		return nil, fmt.Errorf("%s uses plan schema %d; this pbuild reads up to %d", path, plan.Schema, Schema)
+	}
+	if plan.Config == nil {
+		plan.Config = &config.Config{}
+	}
+	return &plan, nil
+}
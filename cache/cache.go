@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Directories pbuild keeps below its cache directory
+const (
+	Workspace = "workspace" // clones of pbuild batch and pbuild serve repositories
+	Builds    = "builds"    // artifacts of pbuild serve webhook builds
+	Tools     = "tools"     // pinned tools from the tools: section of pbuild.yaml
+	GoCaches  = "gocache"   // a Go build cache per target (--cache-strategy per-target)
+	Probes    = "probes"    // what pbuild found out about the installed toolchains
+)
+
+// Managed lists the cache directories pbuild manages
+var Managed = []string{Workspace, Builds, Tools, GoCaches, Probes}
+
+// Dir is pbuild's cache directory, <user cache dir>/pbuild
+func Dir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "pbuild"), nil
+}
+
+// GoCache returns the Go build cache directory reported by go env GOCACHE
+func GoCache(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "go", "env", "GOCACHE").Output()
+	if err != nil {
+		return "", fmt.Errorf("go env GOCACHE failed: %v", err)
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" || dir == "off" {
+		return "", fmt.Errorf("the Go build cache is off")
+	}
+	return dir, nil
+}
+
+// Usage is the disk usage of a directory tree
+type Usage struct {
+	Size  int64
+	Files int
+	// Modified is the newest modification time in the tree
+	Modified time.Time
+}
+
+// Measure walks dir and sums its files; a missing dir has zero usage
+func Measure(dir string) (Usage, error) {
+	var u Usage
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed while walking
+		}
+		if info.ModTime().After(u.Modified) {
+			u.Modified = info.ModTime()
+		}
+		if info.Mode().IsRegular() {
+			u.Size += info.Size()
+			u.Files++
+		}
+		return nil
+	})
+	return u, err
+}
+
+// Entry is one top-level item of a managed directory, e.g. one clone
+type Entry struct {
+	Path string
+	Usage
+}
+
+// Entries lists the items of a managed directory, least recently modified first
+func Entries(dir string) ([]Entry, error) {
+	items, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, item := range items {
+		path := filepath.Join(dir, item.Name())
+		u, err := Measure(path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{Path: path, Usage: u})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Modified.Before(entries[j].Modified) })
+	return entries, nil
+}
+
+// Stale returns the entries not modified within maxAge of now
+func Stale(entries []Entry, maxAge time.Duration, now time.Time) []Entry {
+	var stale []Entry
+	for _, e := range entries {
+		if now.Sub(e.Modified) > maxAge {
+			stale = append(stale, e)
+		}
+	}
+	return stale
+}
+
+// GCResult is what GC removed, or would remove
+type GCResult struct {
+	Before  int64
+	Removed int
+	Freed   int64
+}
+
+// GC removes the least recently used entries of the Go build caches below
+// root until together they take at most maxSize. Only cache entries (the
+// <hash>-a and <hash>-d files of the two-digit directories) are removed, so
+// every cache stays valid: a build that needs a removed entry compiles the
+// package again. With dry nothing is removed.
+func GC(root string, maxSize int64, dry bool) (GCResult, error) {
+	var res GCResult
+	type entry struct {
+		path     string
+		size     int64
+		modified time.Time
+	}
+	var entries []entry
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed while walking
+		}
+		res.Before += info.Size()
+		name := d.Name()
+		if len(filepath.Base(filepath.Dir(path))) == 2 && (strings.HasSuffix(name, "-a") || strings.HasSuffix(name, "-d")) {
+			entries = append(entries, entry{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	// The go command refreshes the modification time of the entries it uses
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modified.Before(entries[j].modified) })
+	size := res.Before
+	for _, e := range entries {
+		if size <= maxSize {
+			break
+		}
+		if !dry {
+			if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+				return res, err
+			}
+		}
+		size -= e.size
+		res.Removed++
+		res.Freed += e.size
+	}
+	return res, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/cache"
+	"pbuild/fsutil"
+)
+
+var (
+	flagTrimOlder time.Duration
+	flagTrimDry   bool
+	flagGCMaxSize string
+)
+
+// newCacheCmd returns the `pbuild cache` subcommand
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Show and trim the Go build caches and pbuild's cache directories",
+	}
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Show the size of the Go build cache and pbuild's cache directories",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheStatus()
+		},
+	}
+	trim := &cobra.Command{
+		Use:   "trim",
+		Short: "Remove pbuild workspace clones, webhook builds and tools not used recently",
+		Long: "Removes the entries of pbuild's cache directories (batch and webhook clones,\n" +
+			"webhook build output, pinned tools) not used within --older-than. The Go build\n" +
+			"cache trims itself; go clean -cache empties it.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheTrim()
+		},
+	}
+	trim.Flags().DurationVar(&flagTrimOlder, "older-than", 30*24*time.Hour, "remove entries not modified for this long")
+	trim.Flags().BoolVar(&flagTrimDry, "dry-run", false, "only list what would be removed")
+	gc := &cobra.Command{
+		Use:   "gc",
+		Short: "Bound the size of the per-target Go build caches",
+		Long: "Removes the least recently used entries of the Go build caches of\n" +
+			"--cache-strategy per-target until together they take at most --max-size.\n" +
+			"The caches stay valid; a removed entry is compiled again when a build needs it.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheGC()
+		},
+	}
+	gc.Flags().StringVar(&flagGCMaxSize, "max-size", "10GiB", "size the per-target caches may take together")
+	gc.Flags().BoolVar(&flagTrimDry, "dry-run", false, "only report what would be removed")
+	cmd.AddCommand(status, trim, gc)
+	return cmd
+}
+
+// runCacheStatus prints the usage of every cache directory
+func runCacheStatus() error {
+	if dir, err := cache.GoCache(context.Background()); err != nil {
+		fmt.Printf("Go build cache: %v\n", err)
+	} else if u, err := cache.Measure(dir); err != nil {
+		fmt.Printf("Go build cache: %s: %v\n", dir, err)
+	} else {
+		fmt.Printf("Go build cache: %s\n  %s in %d files\n", dir, fsutil.HumanSizeBytes(u.Size), u.Files)
+	}
+
+	root, err := cache.Dir()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, name := range cache.Managed {
+		dir := filepath.Join(root, name)
+		entries, err := cache.Entries(dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("pbuild %s: %s\n", name, dir)
+		if len(entries) == 0 {
+			fmt.Println("  empty")
+			continue
+		}
+		var total int64
+		for _, e := range entries {
+			total += e.Size
+		}
+		fmt.Printf("  %s in %d entries, oldest unused for %s\n",
+			fsutil.HumanSizeBytes(total), len(entries), now.Sub(entries[0].Modified).Round(time.Hour))
+	}
+	return nil
+}
+
+// runCacheTrim removes the stale entries of pbuild's cache directories
+func runCacheTrim() error {
+	root, err := cache.Dir()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var freed int64
+	removed := 0
+	for _, name := range cache.Managed {
+		entries, err := cache.Entries(filepath.Join(root, name))
+		if err != nil {
+			return err
+		}
+		for _, e := range cache.Stale(entries, flagTrimOlder, now) {
+			if flagTrimDry {
+				fmt.Printf("Would remove %s (%s, unused for %s)\n", e.Path, fsutil.HumanSizeBytes(e.Size), now.Sub(e.Modified).Round(time.Hour))
+			} else {
+				if err := os.RemoveAll(e.Path); err != nil {
+					return err
+				}
+				fmt.Printf("Removed %s (%s)\n", e.Path, fsutil.HumanSizeBytes(e.Size))
+			}
+			freed += e.Size
+			removed++
+		}
+	}
+	switch {
+	case removed == 0:
+		fmt.Printf("Nothing unused for more than %s\n", flagTrimOlder)
+	case flagTrimDry:
+		fmt.Printf("Would free %s in %d entries\n", fsutil.HumanSizeBytes(freed), removed)
+	default:
+		fmt.Printf("Freed %s in %d entries\n", fsutil.HumanSizeBytes(freed), removed)
+	}
+	return nil
+}
+
+// runCacheGC shrinks the per-target Go build caches to --max-size
+func runCacheGC() error {
+	maxSize, err := fsutil.ParseSize(flagGCMaxSize)
+	if err != nil {
+		return fmt.Errorf("invalid --max-size: %v", err)
+	}
+	root, err := cache.Dir()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, cache.GoCaches)
+	res, err := cache.GC(dir, maxSize, flagTrimDry)
+	if err != nil {
+		return err
+	}
+	switch {
+	case res.Removed == 0:
+		fmt.Printf("Per-target Go build caches: %s in %s, within %s\n", fsutil.HumanSizeBytes(res.Before), dir, fsutil.HumanSizeBytes(maxSize))
+	case flagTrimDry:
+		fmt.Printf("Would free %s in %d cache entries: %s -> %s\n", fsutil.HumanSizeBytes(res.Freed), res.Removed,
+			fsutil.HumanSizeBytes(res.Before), fsutil.HumanSizeBytes(res.Before-res.Freed))
+	default:
+		fmt.Printf("Freed %s in %d cache entries: %s -> %s\n", fsutil.HumanSizeBytes(res.Freed), res.Removed,
+			fsutil.HumanSizeBytes(res.Before), fsutil.HumanSizeBytes(res.Before-res.Freed))
+	}
+	return nil
+}
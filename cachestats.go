@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"pbuild/buildmeta"
+	"pbuild/cache"
+	"pbuild/fsutil"
+)
+
+// cacheProbe measures the Go build cache around a run for --cache-stats
+type cacheProbe struct {
+	dir    string
+	before int64
+}
+
+// startCacheProbe records the cache size before the builds; nil when
+// --cache-stats is off or the cache cannot be found
+func startCacheProbe() *cacheProbe {
+	if !flagCacheStats {
+		return nil
+	}
+	// The per-target caches are measured together
+	dir := goCacheRoot()
+	if dir == "" {
+		var err error
+		if dir, err = cache.GoCache(context.Background()); err != nil {
+			fmt.Printf("Warning: No cache statistics: %v\n", err)
+			return nil
+		}
+	}
+	u, err := cache.Measure(dir)
+	if err != nil {
+		fmt.Printf("Warning: No cache statistics: %v\n", err)
+		return nil
+	}
+	return &cacheProbe{dir: dir, before: u.Size}
+}
+
+// report sums the targets' hits and misses, measures the cache again and
+// prints a one-line summary
+func (c *cacheProbe) report(rows []summaryRow) *buildmeta.CacheReport {
+	if c == nil {
+		return nil
+	}
+	r := &buildmeta.CacheReport{Dir: c.dir}
+	for _, row := range rows {
+		if row.Cache != nil {
+			r.Hits += row.Cache.Hits
+			r.Misses += row.Cache.Misses
+		}
+	}
+	if u, err := cache.Measure(c.dir); err == nil {
+		r.Size, r.Growth = u.Size, u.Size-c.before
+	}
+	rate := 0.0
+	if n := r.Hits + r.Misses; n > 0 {
+		rate = 100 * float64(r.Hits) / float64(n)
+	}
+	growth := "+" + fsutil.HumanSizeBytes(r.Growth)
+	if r.Growth < 0 {
+		growth = "-" + fsutil.HumanSizeBytes(-r.Growth)
+	}
+	fmt.Printf("Go build cache: %d hits, %d misses (%.0f%% hit rate), %s (%s)\n\n",
+		r.Hits, r.Misses, rate, fsutil.HumanSizeBytes(r.Size), growth)
+	return r
+}
+
+// goCacheRoot is the directory of the per-target Go build caches with
+// --cache-strategy per-target, empty for the shared cache
+func goCacheRoot() string {
+	if flagCacheStrategy != "per-target" {
+		return ""
+	}
+	root, err := cache.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(root, cache.GoCaches)
+}
@@ -0,0 +1,169 @@
+package channel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pbuild/buildmeta"
+	"pbuild/fsutil"
+)
+
+// Release channels. stable is the default and keeps pbuild's original layout.
+const (
+	Stable  = "stable"
+	Beta    = "beta"
+	Nightly = "nightly"
+)
+
+// Names lists the supported channels
+var Names = []string{Stable, Beta, Nightly}
+
+// ManifestName is the update manifest written into every channel's directory
+const ManifestName = "update-manifest.json"
+
+// Validate rejects unknown channel names
+func Validate(name string) error {
+	if slices.Contains(Names, name) {
+		return nil
+	}
+	return fmt.Errorf("unknown channel %q (expected %s)", name, strings.Join(Names, ", "))
+}
+
+// Prerelease reports whether builds of the channel are prereleases
+func Prerelease(name string) bool {
+	return name != Stable
+}
+
+// Dir is the channel's directory below the output directory: "" for stable,
+// the channel name otherwise
+func Dir(name string) string {
+	if name == Stable {
+		return ""
+	}
+	return name
+}
+
+// Suffix returns the prerelease suffix for a base version: -beta.N, numbered
+// after the beta builds of base already in dir, or -nightly.YYYYMMDD
+func Suffix(name, base, dir string, now time.Time) string {
+	switch name {
+	case Beta:
+		return fmt.Sprintf("-beta.%d", lastNumber(dir, base+"-beta.")+1)
+	case Nightly:
+		return "-nightly." + now.UTC().Format("20060102")
+	default:
+		return ""
+	}
+}
+
+// lastNumber finds the highest N among the version directories named
+// <prefix>N or <prefix>N-<revision> in dir
+func lastNumber(dir, prefix string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	last := 0
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || !e.IsDir() {
+			continue
+		}
+		num, _, _ := strings.Cut(rest, "-")
+		if n, err := strconv.Atoi(num); err == nil && n > last {
+			last = n
+		}
+	}
+	return last
+}
+
+// Prune removes the oldest version directories of a channel in dir, keeping
+// the newest keep and, when maxAge is set, none older than maxAge. The newest
+// build always stays. Only directories whose build-metadata.json names the
+// channel are touched; the removed directory names are returned.
+func Prune(dir, name string, keep int, maxAge time.Duration, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type build struct {
+		dir  string
+		time time.Time
+	}
+	var builds []build
+	for _, e := range entries {
+		// latest is a symlink, or a copy on Windows
+		if !e.IsDir() || e.Name() == "latest" {
+			continue
+		}
+		meta, err := buildmeta.Read(filepath.Join(dir, e.Name()))
+		if err != nil || meta.Channel != name {
+			continue
+		}
+		builds = append(builds, build{e.Name(), meta.BuildTime})
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].time.After(builds[j].time) })
+
+	var removed []string
+	for i, b := range builds {
+		if i == 0 || (i < keep && (maxAge <= 0 || now.Sub(b.time) <= maxAge)) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, b.dir)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.dir)
+	}
+	return removed, nil
+}
+
+// Asset is one downloadable file of a release
+type Asset struct {
+	Target string `json:"target"`
+	File   string `json:"file"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Archive is the target's release archive, next to File
+	Archive string `json:"archive,omitempty"`
+}
+
+// Manifest points self-updaters at the newest release of a channel
+type Manifest struct {
+	Project    string    `json:"project"`
+	Channel    string    `json:"channel"`
+	Prerelease bool      `json:"prerelease"`
+	Version    string    `json:"version"`
+	BuildTime  time.Time `json:"build_time"`
+	// Path is the version directory relative to the manifest; asset files are inside it
+	Path   string  `json:"path"`
+	Assets []Asset `json:"assets"`
+}
+
+// WriteManifest writes m to dir/update-manifest.json
+func WriteManifest(dir string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(filepath.Join(dir, ManifestName), data, 0644)
+}
+
+// ReadManifest reads dir/update-manifest.json
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", ManifestName, err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"pbuild/buildmeta"
+	"pbuild/channel"
+	"pbuild/publish"
+)
+
+// defaultKeepNightlies is the number of nightly builds kept without a retention policy
+const defaultKeepNightlies = 7
+
+// writeUpdateManifest points the channel's update-manifest.json in outDir at
+// the successful artifacts of this run, in the local layout
+func writeUpdateManifest(p *project, outDir string, meta buildmeta.BuildMetadata) error {
+	m := channel.Manifest{
+		Project:    meta.ProjectName,
+		Channel:    p.channel,
+		Prerelease: channel.Prerelease(p.channel),
+		Version:    meta.Version,
+		BuildTime:  meta.BuildTime,
+		Path:       meta.Version,
+	}
+	for _, r := range meta.Results {
+		if !r.Success {
+			continue
+		}
+		m.Assets = append(m.Assets, channel.Asset{Target: r.Target, File: r.File, Size: r.Size, SHA256: r.SHA256, Archive: r.Archive})
+	}
+	return channel.WriteManifest(outDir, m)
+}
+
+// publishUpdateManifest uploads the channel's update manifest next to the
+// project's version directories, e.g. beta/myapp/update-manifest.json. Each
+// destination gets a copy whose path leads from the manifest to the assets as
+// its path template lays them out.
+func publishUpdateManifest(ctx context.Context, p *project, dests []*publish.Destination, outDir string) error {
+	m, err := channel.ReadManifest(outDir)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "pbuild-manifest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	data := pathData(p, channel.ManifestName)
+	data.Version = ""
+	for _, d := range dests {
+		if m.Path, err = remoteAssetDir(p, d, *m); err != nil {
+			return err
+		}
+		if err := channel.WriteManifest(tmpDir, *m); err != nil {
+			return err
+		}
+		url, err := d.Publish(ctx, filepath.Join(tmpDir, channel.ManifestName), data)
+		if err != nil {
+			return err
+		}
+		if flagVerbose {
+			fmt.Printf("Published %s\n", url)
+		}
+	}
+	return nil
+}
+
+// remoteAssetDir renders d's path template for the manifest and its first
+// asset and returns the asset's directory relative to the manifest's
+func remoteAssetDir(p *project, d *publish.Destination, m channel.Manifest) (string, error) {
+	if len(m.Assets) == 0 {
+		return m.Path, nil
+	}
+	data := pathData(p, channel.ManifestName)
+	data.Version = ""
+	manifest, err := d.RemotePath(data)
+	if err != nil {
+		return "", err
+	}
+	data.Version, data.File = m.Version, m.Assets[0].File
+	asset, err := d.RemotePath(data)
+	if err != nil {
+		return "", err
+	}
+	if path.Base(asset) != data.File {
+		return "", fmt.Errorf("publish path %s of %s does not end in the file name, so %s cannot point at it", asset, data.File, channel.ManifestName)
+	}
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(manifest)), filepath.FromSlash(path.Dir(asset)))
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// pruneNightlies applies the retention policy to the nightly channel's directory
+func pruneNightlies(p *project, outDir string) {
+	keep := p.settings.NightlyKeep
+	removed, err := channel.Prune(outDir, channel.Nightly, keep, p.cfg.Nightly.MaxAge, time.Now())
+	for _, name := range removed {
+		fmt.Printf("Pruned nightly build: %s\n", name)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to prune old nightlies: %v\n", err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/bindiff"
+	"pbuild/buildmeta"
+	"pbuild/fsutil"
+	"pbuild/ui"
+)
+
+var (
+	flagCompareSections bool
+	flagCompareTargets  string
+)
+
+// newCompareArtifactsCmd returns the `pbuild compare-artifacts` subcommand
+func newCompareArtifactsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compare-artifacts VERSION_A [VERSION_B]",
+		Short: "Compare the binaries two builds produced for each target: sizes, dependencies and sections",
+		Long: "Reads the binary of every target built in both builds and shows how its size\n" +
+			"changed, with the Go version, build settings and linked module versions that\n" +
+			"differ; --sections adds the size change of every section. Each argument is a\n" +
+			"version of the module in the current directory, a version directory or a\n" +
+			"build-metadata.json file. VERSION_B defaults to <output-dir>/latest.",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				args = append(args, "latest")
+			}
+			oldDir, err := buildDirOf(args[0])
+			if err != nil {
+				return err
+			}
+			newDir, err := buildDirOf(args[1])
+			if err != nil {
+				return err
+			}
+			return runCompareArtifacts(oldDir, newDir)
+		},
+	}
+	cmd.Flags().StringVar(&flagOutDir, "output-dir", "builds", "directory for build artifacts")
+	cmd.Flags().BoolVar(&flagCompareSections, "sections", false, "also compare the size of each section")
+	cmd.Flags().StringVar(&flagCompareTargets, "targets", "", "only compare these targets (comma-separated)")
+	return cmd
+}
+
+// buildDirOf returns the version directory an argument names: a path to one
+// or to its metadata file, or a version (or latest) of the current module
+func buildDirOf(arg string) (string, error) {
+	if _, err := os.Stat(arg); err == nil {
+		return versionDirOf(arg), nil
+	}
+	workDir, err := fsutil.FindModuleRoot(".")
+	if err != nil {
+		return "", fmt.Errorf("%s is not a version directory and there is no module to look it up in: %v", arg, err)
+	}
+	outDir := outputDir(workDir)
+	if arg == "latest" {
+		return filepath.Join(outDir, "latest"), nil
+	}
+	dir, err := findRelease(outDir, arg)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, arg), nil
+}
+
+// runCompareArtifacts compares the binaries of the targets two builds share
+func runCompareArtifacts(oldDir, newDir string) error {
+	oldMeta, err := buildmeta.Read(oldDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", oldDir, err)
+	}
+	newMeta, err := buildmeta.Read(newDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", newDir, err)
+	}
+	only := splitList(flagCompareTargets)
+	wanted := func(r buildmeta.TargetResult) bool {
+		return r.Success && r.File != "" && (len(only) == 0 || slices.Contains(only, r.Target))
+	}
+	oldFiles := make(map[string]string)
+	for _, r := range oldMeta.Results {
+		if wanted(r) {
+			oldFiles[r.Target] = r.File
+		}
+	}
+
+	fmt.Printf("Comparing the binaries of %s (%s) to %s (%s)\n\n",
+		oldMeta.Version, oldMeta.BuildTime.Format("2006-01-02 15:04"), newMeta.Version, newMeta.BuildTime.Format("2006-01-02 15:04"))
+
+	type compared struct {
+		target string
+		diff   bindiff.Diff
+	}
+	var results []compared
+	var missing []string
+	for _, r := range newMeta.Results {
+		if !wanted(r) {
+			continue
+		}
+		oldFile, ok := oldFiles[r.Target]
+		if !ok {
+			missing = append(missing, r.Target)
+			continue
+		}
+		delete(oldFiles, r.Target)
+		oldBin, err := bindiff.Read(filepath.Join(oldDir, oldFile))
+		if err != nil {
+			fmt.Printf("Warning: skipping %s: %v\n", r.Target, err)
+			continue
+		}
+		newBin, err := bindiff.Read(filepath.Join(newDir, r.File))
+		if err != nil {
+			fmt.Printf("Warning: skipping %s: %v\n", r.Target, err)
+			continue
+		}
+		results = append(results, compared{r.Target, bindiff.Compare(oldBin, newBin)})
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no target was built successfully in both %s and %s", oldMeta.Version, newMeta.Version)
+	}
+
+	tbl := newGridTable(os.Stdout)
+	tbl.Header([]string{"Target", "Previous", "Current", "Delta"})
+	data := make([][]any, 0, len(results))
+	for _, c := range results {
+		data = append(data, []any{c.target, fsutil.HumanSizeBytes(c.diff.OldSize), fsutil.HumanSizeBytes(c.diff.NewSize), sizeDelta(c.diff.OldSize, c.diff.NewSize)})
+	}
+	_ = tbl.Bulk(data)
+	_ = tbl.Render()
+	for _, t := range missing {
+		fmt.Printf("Not compared: %s was not built in %s\n", t, oldMeta.Version)
+	}
+	for _, t := range slices.Sorted(maps.Keys(oldFiles)) {
+		fmt.Printf("Not compared: %s was not built in %s\n", t, newMeta.Version)
+	}
+
+	for _, c := range results {
+		printBinaryDiff(c.target, c.diff)
+	}
+	return nil
+}
+
+// printBinaryDiff prints what changed in one target's binary
+func printBinaryDiff(target string, d bindiff.Diff) {
+	fmt.Printf("\n%s: %s\n", target, sizeDelta(d.OldSize, d.NewSize))
+	if d.OldGo == d.NewGo && len(d.Settings) == 0 && len(d.Deps) == 0 && (!flagCompareSections || len(d.Sections) == 0) {
+		fmt.Println("  same Go version, build settings and dependencies")
+		return
+	}
+	if d.OldGo != d.NewGo {
+		fmt.Printf("  Go version: %s -> %s\n", orUnset(d.OldGo), orUnset(d.NewGo))
+	}
+	if len(d.Settings) > 0 {
+		width := 0
+		for _, c := range d.Settings {
+			width = max(width, len(c.Name))
+		}
+		fmt.Println("  Build settings")
+		for _, c := range d.Settings {
+			fmt.Printf("    %-*s  %s -> %s\n", width, c.Name, orUnset(c.Old), orUnset(c.New))
+		}
+	}
+	if len(d.Deps) > 0 {
+		fmt.Println("  Dependencies")
+		for _, c := range d.Deps {
+			switch {
+			case c.Old == "":
+				fmt.Printf("    %s %s %s\n", ui.Green("+"), c.Path, c.New)
+			case c.New == "":
+				fmt.Printf("    %s %s %s\n", ui.Red("-"), c.Path, c.Old)
+			default:
+				fmt.Printf("    ~ %s %s -> %s\n", c.Path, c.Old, c.New)
+			}
+		}
+	}
+	if flagCompareSections && len(d.Sections) > 0 {
+		width := 0
+		for _, s := range d.Sections {
+			width = max(width, len(s.Name))
+		}
+		fmt.Println("  Sections")
+		for _, s := range d.Sections {
+			fmt.Printf("    %-*s  %s -> %s  %s\n", width, s.Name, fsutil.HumanSizeBytes(s.Old), fsutil.HumanSizeBytes(s.New), sizeDelta(s.Old, s.New))
+		}
+	}
+}
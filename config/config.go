@@ -0,0 +1,398 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FileName is the project configuration file looked up in the module root
+const FileName = "pbuild.yaml"
+
+// Config holds the settings read from pbuild.yaml
+type Config struct {
+	// TargetGroups adds or overrides named target groups, as lists of GOOS/GOARCH
+	TargetGroups map[string][]string `yaml:"target_groups"`
+
+	// Tags are extra build tags, using the same syntax as --tags
+	// ("tag", "-tag" or a quoted "!tag" to remove, "tag@<build constraint>" for
+	// per-target tags)
+	Tags []string `yaml:"tags"`
+
+	// Targets are built when no --all, --target-group or --targets is given,
+	// as GOOS/GOARCH or target group names; default the host platform
+	Targets []string `yaml:"targets"`
+
+	// Compress is the default of --compress: zstd, gzip or none
+	Compress string `yaml:"compress" values:"compress"`
+
+	// Sign is the default of --sign: minisign, signify, gpg or cosign
+	Sign string `yaml:"sign" values:"sign"`
+
+	// Notify lists notification targets (slack:<url>, webhook:<url>) fired after every run
+	Notify []string `yaml:"notify"`
+
+	// Skip lists rules that exclude targets before scheduling
+	Skip []SkipRule `yaml:"skip"`
+
+	// Archive configures per-target release archives
+	Archive Archive `yaml:"archive"`
+
+	// Generate produces shell completions and man pages by running the host build
+	Generate Generate `yaml:"generate"`
+
+	// Licenses configures the THIRD_PARTY_NOTICES bundle written with --licenses
+	Licenses Licenses `yaml:"licenses"`
+
+	// Concurrency caps how many workers may run a stage at once, e.g. {compress: 2}
+	Concurrency map[string]int `yaml:"concurrency"`
+
+	// Plugins are external commands run as extra pipeline stages
+	Plugins []Plugin `yaml:"plugins"`
+
+	// Publish lists destinations artifacts are uploaded to with --publish
+	Publish []Publish `yaml:"publish"`
+
+	// Distribute configures the .torrent and IPFS pin made with --torrent and --ipfs
+	Distribute Distribute `yaml:"distribute"`
+
+	// Credentials names secrets for signing and publishing and where to read them
+	Credentials map[string]Credential `yaml:"credentials"`
+
+	// Nightly is the retention policy of --nightly builds
+	Nightly Retention `yaml:"nightly"`
+
+	// Redact masks secrets before they reach build-metadata.json, logs and reports
+	Redact Redact `yaml:"redact"`
+
+	// PathCheck configures the scan of built binaries for host paths
+	PathCheck PathCheck `yaml:"path_check"`
+
+	// Policy lists content rules every built binary must satisfy
+	Policy []PolicyRule `yaml:"policy"`
+
+	// Gitignore controls how the output directory is kept out of git
+	Gitignore Gitignore `yaml:"gitignore"`
+
+	// VersionSource pins the variable appVersion is read from, instead of
+	// searching the module for it
+	VersionSource VersionSource `yaml:"version_source"`
+
+	// VersionPkg is the directory of the package from pbuild gen version-pkg,
+	// relative to the module root; default internal/buildinfo
+	VersionPkg string `yaml:"version_pkg"`
+
+	// Tools are commands pinned as package@version, installed with go install
+	// and put first on PATH for go generate and plugins
+	Tools []string `yaml:"tools"`
+
+	// GoGenerate runs go generate ./... once before every build, like --generate
+	GoGenerate bool `yaml:"go_generate"`
+
+	// InstallDir is where `pbuild install` places the host binary
+	InstallDir string `yaml:"install_dir"`
+
+	// InstallScripts configures the install.sh and install.ps1 written with --install-scripts
+	InstallScripts InstallScripts `yaml:"install_scripts"`
+
+	// Bench configures the benchmarks --bench-gate runs before building
+	Bench Bench `yaml:"bench"`
+
+	// secrets are the values of !secret entries
+	secrets []string
+	// warnings are the problems that do not stop the config from loading
+	warnings []string
+	// file is the config file read, files all files merged, bases first
+	file  string
+	files []string
+	// sources maps the dotted keys set in the files to where they were set
+	sources map[string]string
+}
+
+// Secrets returns the values !secret entries resolved to, so they can be
+// masked like credentials
+func (c *Config) Secrets() []string {
+	return c.secrets
+}
+
+// Warnings returns the problems found in the config that did not stop it
+// from loading, such as unknown keys
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+// Source returns where the dotted key (e.g. archive.format) was set: the config
+// file relative to the project's, with the variables and secrets its value came
+// from; empty when no file sets it
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// InstallScripts configures the generated install scripts
+type InstallScripts struct {
+	// URL is the download URL template of a binary with the publish path fields,
+	// e.g. https://dl.example.com/{{.Project}}/{{.Version}}/{{.File}}; default:
+	// its URL at the first --publish destination
+	URL string `yaml:"url"`
+}
+
+// VersionSource names the Go variable holding the version
+type VersionSource struct {
+	// File is relative to the module root, e.g. internal/version/version.go;
+	// a non-Go file is read like VERSION, package.json, Cargo.toml or pyproject.toml
+	File string `yaml:"file"`
+	// Var is the name of the variable, default appVersion; only for a Go file
+	Var string `yaml:"var"`
+}
+
+// Credential lists the sources of a secret, tried after the PBUILD_<NAME>
+// environment variable in this order: Env, File, Keychain
+type Credential struct {
+	// Env is an environment variable holding the value
+	Env string `yaml:"env"`
+	// File holds the value; ~/ and ${VAR} are expanded
+	File string `yaml:"file"`
+	// Keychain is the service of an entry in the macOS Keychain, the Windows
+	// Credential Manager or the Secret Service; Account defaults to the credential's name
+	Keychain string `yaml:"keychain"`
+	Account  string `yaml:"account"`
+}
+
+// Retention bounds how many builds of a channel are kept
+type Retention struct {
+	// Keep is the number of newest builds kept, default 7
+	Keep int `yaml:"keep"`
+	// MaxAge also removes older builds, e.g. 336h; the newest build always stays
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Redact adds to the built-in secret patterns
+type Redact struct {
+	// Patterns are regular expressions; the first capture group is masked, or
+	// the whole match when there is none
+	Patterns []string `yaml:"patterns"`
+	// Env names environment variables whose values are masked wherever they appear
+	Env []string `yaml:"env"`
+}
+
+// PathCheck configures which host paths may appear in built binaries
+type PathCheck struct {
+	// Allow lists leaked paths that are expected, e.g. from prebuilt vendored
+	// objects: path.Match patterns, or prefixes ending in "/"
+	Allow []string `yaml:"allow"`
+}
+
+// PolicyRule is a content check on built binaries; a violation fails the target
+type PolicyRule struct {
+	// Targets are GOOS/GOARCH patterns with * wildcards; empty means every target
+	Targets []string `yaml:"targets"`
+	// MaxSize is the largest allowed binary before compression, e.g. 20MiB
+	MaxSize string `yaml:"max_size"`
+	// Forbidden are strings that may not appear in the binary, e.g. DEBUG or
+	// internal hostnames; symbol names are strings in the binary too
+	Forbidden []string `yaml:"forbidden"`
+	// RequireVersion demands that the version being built is embedded
+	RequireVersion bool   `yaml:"require_version"`
+	Reason         string `yaml:"reason"`
+}
+
+// Gitignore configures the output directory entry pbuild adds to .gitignore
+type Gitignore struct {
+	// Update adds the entry when git does not ignore the output directory yet (default true)
+	Update *bool `yaml:"update"`
+	// Create writes a .gitignore when the module root has none
+	Create bool `yaml:"create"`
+}
+
+// SkipRule excludes matching targets from a run. All set conditions must hold;
+// a rule without Targets or When applies to every target.
+type SkipRule struct {
+	// Targets are GOOS/GOARCH patterns with * wildcards, e.g. windows/arm64 or */386
+	Targets []string `yaml:"targets"`
+	// When is a build constraint over the target, e.g. "freebsd || openbsd || netbsd"
+	When string `yaml:"when"`
+	// Tags must all be in the target's resolved tag set
+	Tags []string `yaml:"tags"`
+	// BuildMode restricts the rule to one -buildmode
+	BuildMode string `yaml:"buildmode" enum:"auto,pie,exe,c-archive,c-shared"`
+	Reason    string `yaml:"reason"`
+}
+
+// Archive configures the release archive built for every target
+type Archive struct {
+	// Format is auto (zip for windows, tar.gz elsewhere), tar.gz or zip; set to enable archives
+	Format string `yaml:"format" enum:"auto,tar.gz,zip"`
+	// Name is a template for the archive name without extension,
+	// default "{{.Project}}_{{.Version}}_{{.OS}}_{{.Arch}}"
+	Name string `yaml:"name"`
+	// Dir is a template for a directory wrapping every entry, e.g.
+	// "{{.Project}}-{{.Version}}"; empty puts the entries at the top level
+	Dir string `yaml:"dir"`
+	// Files are extra files bundled next to the binary
+	Files []ArchiveFile `yaml:"files"`
+}
+
+// ArchiveFile is an extra file placed into archives
+type ArchiveFile struct {
+	// Src is the file to include, relative to the module root
+	Src string `yaml:"src"`
+	// Dst is its path inside the archive, default the base name of Src
+	Dst string `yaml:"dst"`
+	// Template renders Src with text/template ({{.Version}}, {{.OS}}, ...) first
+	Template bool `yaml:"template"`
+	// OS limits the file to these GOOS values; ExcludeOS drops it for them
+	OS        []string `yaml:"os"`
+	ExcludeOS []string `yaml:"exclude_os"`
+	// CRLF converts the file's line endings to CRLF in windows archives
+	CRLF bool `yaml:"crlf"`
+}
+
+// Generate lists the assets produced by running the built host binary,
+// which works for cobra-based CLIs out of the box
+type Generate struct {
+	// Completions are shells passed to `<binary> completion <shell>`: bash, zsh, fish, powershell
+	Completions []string `yaml:"completions" enum:"bash,zsh,fish,powershell"`
+	// Man is the command that prints a roff man page, e.g. [man]
+	Man []string `yaml:"man"`
+}
+
+// Distribute configures peer-to-peer distribution of the version directory
+type Distribute struct {
+	Torrent Torrent `yaml:"torrent"`
+	IPFS    IPFS    `yaml:"ipfs"`
+}
+
+// Torrent configures the .torrent written with --torrent
+type Torrent struct {
+	// WebSeeds are URL templates of the directory version directories are
+	// published below, e.g. https://dl.example.com/{{.ChannelDir}}{{.Project}}/;
+	// clients append the version and the file path
+	WebSeeds []string `yaml:"web_seeds"`
+	// Trackers are announce URLs; without them clients rely on DHT and web seeds
+	Trackers []string `yaml:"trackers"`
+}
+
+// IPFS configures the pin made with --ipfs
+type IPFS struct {
+	// API is the Kubo RPC address, default http://127.0.0.1:5001
+	API string `yaml:"api"`
+}
+
+// Licenses configures dependency license collection
+type Licenses struct {
+	// Forbidden are SPDX ids with * wildcards (e.g. GPL-*, AGPL-3.0, unknown) that fail the run
+	Forbidden []string `yaml:"forbidden"`
+}
+
+// Bench selects the benchmarks of the release gate and what counts as a regression
+type Bench struct {
+	// Gate is the default of --bench-gate: warn, error or off
+	Gate string `yaml:"gate"`
+	// Packages are the package patterns to benchmark, default ./...
+	Packages []string `yaml:"packages"`
+	// Pattern is the -bench regexp of the benchmarks to run, default .
+	Pattern string `yaml:"pattern"`
+	// Count is how many times each benchmark runs, default 6; the comparison
+	// needs at least 4 samples on each side to find a significant change
+	Count int `yaml:"count"`
+	// Benchtime is the -benchtime of each run, e.g. 500ms or 1000x
+	Benchtime string `yaml:"benchtime"`
+	// Threshold is the slowdown in percent a significant change must exceed
+	// to be a regression, default 5
+	Threshold float64 `yaml:"threshold"`
+	// Baseline is a go test -bench output file to compare against, relative to
+	// the project; default: the bench.txt of the newest earlier build
+	Baseline string `yaml:"baseline"`
+}
+
+// Plugin is an exec-based pipeline stage. The command receives the stage
+// context as JSON on stdin; a non-zero exit fails the target unless Optional.
+type Plugin struct {
+	// Name identifies the stage, e.g. for --concurrency
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	// After is the stage the plugin runs after, default publish (the last built-in stage)
+	After string `yaml:"after"`
+	// Targets are GOOS/GOARCH patterns with * wildcards; empty means every target
+	Targets  []string      `yaml:"targets"`
+	Optional bool          `yaml:"optional"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// Publish is an upload destination. String values may reference environment
+// variables as ${VAR} and credentials as ${cred:NAME}, which keeps secrets out
+// of the file.
+type Publish struct {
+	// Type is http (also artifactory or webdav): a PUT per file below URL,
+	// gcs or azure
+	Type string `yaml:"type" enum:"http,artifactory,webdav,gcs,azure"`
+	// URL is the base URL for http, or an endpoint override for azure
+	URL string `yaml:"url"`
+	// Bucket is the gcs bucket; Account and Container the azure storage account and container
+	Bucket    string `yaml:"bucket"`
+	Account   string `yaml:"account"`
+	Container string `yaml:"container"`
+	// Path is a template for the remote path, default "{{.ChannelDir}}{{.Project}}/{{.Version}}/{{.File}}"
+	Path string `yaml:"path"`
+	// Channels limits the destination to these release channels; empty means all
+	Channels []string `yaml:"channels"`
+	// Username and Password select basic auth; Token a bearer token
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	Token    string            `yaml:"token"`
+	Headers  map[string]string `yaml:"headers"`
+	// MkCol creates parent collections first, as plain WebDAV servers require
+	MkCol bool `yaml:"mkcol"`
+}
+
+// Load reads the config file at path. When path is empty, pbuild.yaml in dir
+// is used and a missing file yields an empty config. The files named by
+// extends are merged in, ${VAR} references and !secret values resolved, and
+// the result is checked against the schema; unknown keys only show up in
+// Warnings.
+func Load(dir, path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = filepath.Join(dir, FileName)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return &Config{}, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	t, problems, unknown := parse(path, b)
+	switch len(problems) {
+	case 0:
+	case 1:
+		return nil, fmt.Errorf("invalid config: %s", problems[0])
+	default:
+		return nil, fmt.Errorf("invalid config, %d problems:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+	var cfg Config
+	if t.root != nil {
+		if err := t.root.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	}
+	for n := range t.secrets {
+		cfg.secrets = append(cfg.secrets, n.Value)
+	}
+	for _, u := range unknown {
+		cfg.warnings = append(cfg.warnings, u+" (ignored; unknown keys will be an error in a later release)")
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Credentials)) {
+		if ref := bareVar.FindString(cfg.Credentials[name].File); ref != "" {
+			cfg.warnings = append(cfg.warnings, fmt.Sprintf("credentials.%s.file: %s is not expanded, only ${%s} is", name, ref, ref[1:]))
+		}
+	}
+	cfg.file, cfg.files, cfg.sources = path, t.files, t.sources(filepath.Dir(path))
+	return &cfg, nil
+}
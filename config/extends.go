@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"pbuild/fsutil"
+)
+
+// ExtendsKey names the base configs a config file builds on
+const ExtendsKey = "extends"
+
+// tree is a config file with the files it extends merged in
+type tree struct {
+	// root is the merged top-level mapping, nil for an empty config
+	root *yaml.Node
+	// origin is the file each node was read from, for error locations
+	origin map[*yaml.Node]string
+	// files are the files read, bases before the files extending them
+	files []string
+	// secrets maps the nodes !secret entries resolved to onto their names
+	secrets map[*yaml.Node]string
+	// env maps the nodes ${VAR} references were resolved in onto the variables
+	env map[*yaml.Node][]string
+}
+
+// readTree parses the config in data, read from file, and merges the files
+// it extends below it, in order: a mapping is merged key by key, any other
+// value, lists included, replaces the base's, and an empty value (key: ~)
+// resets the key to its default
+func readTree(file string, data []byte) (*tree, []string) {
+	t := &tree{origin: make(map[*yaml.Node]string), secrets: make(map[*yaml.Node]string), env: make(map[*yaml.Node][]string)}
+	root, problems := t.read(file, data, nil)
+	t.root = root
+	return t, problems
+}
+
+// read parses one file of the chain; chain holds the files extending it
+func (t *tree) read(file string, data []byte, chain []string) (*yaml.Node, []string) {
+	if abs, err := filepath.Abs(file); err == nil && slices.Contains(chain, abs) {
+		return nil, []string{fmt.Sprintf("%s: %s cycle: %s", file, ExtendsKey, strings.Join(append(chain, abs), " -> "))}
+	} else if err == nil {
+		chain = append(chain, abs)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, []string{fmt.Sprintf("%s: %v", file, err)}
+	}
+	if len(doc.Content) == 0 {
+		t.files = append(t.files, file)
+		return nil, nil
+	}
+	root := doc.Content[0]
+	t.mark(root, file)
+	if root.Kind != yaml.MappingNode {
+		t.files = append(t.files, file)
+		return root, nil
+	}
+
+	var bases []*yaml.Node
+	var problems []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != ExtendsKey {
+			continue
+		}
+		value := root.Content[i+1]
+		switch value.Kind {
+		case yaml.ScalarNode:
+			bases = []*yaml.Node{value}
+		case yaml.SequenceNode:
+			bases = value.Content
+		default:
+			problems = append(problems, fmt.Sprintf("%s:%d:%d: %s: expected a file or a list of files", file, value.Line, value.Column, ExtendsKey))
+		}
+		root.Content = slices.Delete(root.Content, i, i+2)
+		break
+	}
+
+	var merged *yaml.Node
+	for _, b := range bases {
+		path := expandPath(b.Value, filepath.Dir(file))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s:%d:%d: %s: %v", file, b.Line, b.Column, ExtendsKey, err))
+			continue
+		}
+		base, baseProblems := t.read(path, data, chain)
+		problems = append(problems, baseProblems...)
+		merged = t.merge(merged, base)
+	}
+	t.files = append(t.files, file)
+	return t.merge(merged, root), problems
+}
+
+// mark records file as the origin of n and everything below it
+func (t *tree) mark(n *yaml.Node, file string) {
+	t.origin[n] = file
+	for _, c := range n.Content {
+		t.mark(c, file)
+	}
+}
+
+// merge returns over laid on top of base
+func (t *tree) merge(base, over *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || over.Kind != yaml.MappingNode {
+		if over == nil {
+			return base
+		}
+		return over
+	}
+	merged := *over
+	merged.Content = nil
+	t.origin[&merged] = t.origin[over]
+	overridden := make(map[string]bool)
+	for i := 0; i+1 < len(base.Content); i += 2 {
+		key, value := base.Content[i], base.Content[i+1]
+		if j := mappingIndex(over, key.Value); j >= 0 {
+			overridden[key.Value] = true
+			if over.Content[j+1].Kind == yaml.MappingNode {
+				value = t.merge(value, over.Content[j+1])
+			} else {
+				value = over.Content[j+1]
+			}
+			key = over.Content[j]
+		}
+		merged.Content = append(merged.Content, key, value)
+	}
+	for i := 0; i+1 < len(over.Content); i += 2 {
+		if !overridden[over.Content[i].Value] {
+			merged.Content = append(merged.Content, over.Content[i], over.Content[i+1])
+		}
+	}
+	return &merged
+}
+
+// mappingIndex returns the index of key in the mapping n, or -1
+func mappingIndex(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// expandPath resolves a leading ~/ (~\ on Windows) and makes path relative
+// to dir absolute
+func expandPath(path, dir string) string {
+	if expanded, err := fsutil.ExpandHome(path); err == nil && expanded != path {
+		return expanded
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
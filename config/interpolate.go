@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"pbuild/fsutil"
+)
+
+// SecretsFileName is the secrets file looked up next to the config file
+const SecretsFileName = "pbuild.secrets.yaml"
+
+// SecretTag marks a value read from the environment or the secrets file,
+// e.g. token: !secret artifactory_token
+const SecretTag = "!secret"
+
+// envRef matches ${VAR} and ${VAR:-default}, and $${VAR}, which stands for a
+// literal ${VAR}; ${cred:NAME} is left to the settings that resolve
+// credentials when they are used
+var envRef = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// bareVar matches a $VAR reference, which is taken literally
+var bareVar = regexp.MustCompile(`\$[A-Za-z_][A-Za-z0-9_]*`)
+
+// EnvName is the environment variable holding a secret or credential, e.g.
+// PBUILD_SIGN_PASSWORD for sign_password
+func EnvName(name string) string {
+	return "PBUILD_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// interpolate resolves ${VAR} references and !secret values in the scalars
+// of t, whose top file is file, and returns every reference that could not
+// be resolved. Unset variables without a default stay as they are, so that
+// publish: settings can still name variables that only uploading runs set.
+// Plugin commands are left alone: their shell expands them when they run.
+func interpolate(file string, t *tree) []string {
+	i := &interpolator{file: file, tree: t, skip: pluginCommands(t.root)}
+	i.walk(t.root)
+	return i.problems
+}
+
+// pluginCommands returns the command: values of the plugins: list in the
+// top-level mapping root, which interpolation skips
+func pluginCommands(root *yaml.Node) map[*yaml.Node]bool {
+	skip := map[*yaml.Node]bool{}
+	plugins := mappingValue(root, "plugins")
+	if plugins == nil || plugins.Kind != yaml.SequenceNode {
+		return skip
+	}
+	for _, plugin := range plugins.Content {
+		if command := mappingValue(plugin, "command"); command != nil {
+			skip[command] = true
+		}
+	}
+	return skip
+}
+
+// mappingValue returns the value of key in the mapping n, or nil
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// interpolator carries the state of one interpolate call
+type interpolator struct {
+	file     string
+	tree     *tree
+	problems []string
+	// store is the parsed secrets file, read on the first !secret
+	store map[string]string
+	// storeFile is where the secrets file was looked for
+	storeFile string
+	// skip are the nodes left as written
+	skip map[*yaml.Node]bool
+}
+
+func (i *interpolator) walk(n *yaml.Node) {
+	if i.skip[n] {
+		return
+	}
+	for _, c := range n.Content {
+		i.walk(c)
+	}
+	if n.Kind != yaml.ScalarNode {
+		return
+	}
+	if n.Tag == SecretTag {
+		value, err := i.secret(n.Value)
+		if err != nil {
+			i.problems = append(i.problems, fmt.Sprintf("%s:%d:%d: %s %s: %v", i.tree.origin[n], n.Line, n.Column, SecretTag, n.Value, err))
+			return
+		}
+		i.tree.secrets[n] = n.Value
+		n.Tag, n.Value = "!!str", value
+		return
+	}
+	if !strings.Contains(n.Value, "${") {
+		return
+	}
+	value := envRef.ReplaceAllStringFunc(n.Value, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		m := envRef.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(m[1]); ok && v != "" {
+			i.tree.env[n] = append(i.tree.env[n], m[1])
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		return ref
+	})
+	if value != n.Value {
+		n.Value = value
+		// A plain scalar is typed by its new value, e.g. keep: ${KEEP} as an integer
+		if n.Style == 0 {
+			n.Tag = ""
+		}
+	}
+}
+
+// secret resolves a !secret NAME from the PBUILD_<NAME> environment variable
+// or the secrets file
+func (i *interpolator) secret(name string) (string, error) {
+	if v := os.Getenv(EnvName(name)); v != "" {
+		return v, nil
+	}
+	if i.store == nil {
+		if err := i.loadStore(); err != nil {
+			return "", err
+		}
+	}
+	if v, ok := i.store[name]; ok && v != "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("not set (set %s or add %s to %s)", EnvName(name), name, i.storeFile)
+}
+
+// loadStore reads the secrets file: $PBUILD_SECRETS_FILE, or
+// pbuild.secrets.yaml next to the project's config file (not the bases it
+// extends), holding name: value pairs
+func (i *interpolator) loadStore() error {
+	i.store = map[string]string{}
+	i.storeFile = os.Getenv("PBUILD_SECRETS_FILE")
+	if i.storeFile == "" {
+		i.storeFile = filepath.Join(filepath.Dir(i.file), SecretsFileName)
+	} else if expanded, err := fsutil.ExpandHome(i.storeFile); err == nil {
+		i.storeFile = expanded
+	}
+	data, err := os.ReadFile(i.storeFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the secrets file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &i.store); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", i.storeFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package config
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// source is this package's config.go; the doc comments of its fields become
+// the descriptions of the schema
+//
+//go:embed config.go
+var source []byte
+
+// SchemaURL is where the published schema of pbuild.yaml lives
+const SchemaURL = "https://raw.githubusercontent.com/earentir/pbuild/main/pbuild.schema.json"
+
+// Values holds the allowed values of the fields tagged values:"NAME", whose
+// lists live with the code that implements them, e.g. Values["sign"]
+var Values = map[string][]string{}
+
+// durationPattern matches the strings time.ParseDuration accepts
+const durationPattern = `^[-+]?(\d+(\.\d*)?|\.\d+)(ns|us|µs|ms|s|m|h)((\d+(\.\d*)?|\.\d+)(ns|us|µs|ms|s|m|h))*$`
+
+// Schema is a JSON Schema (draft 2020-12) node, limited to what the config
+// structs need
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+}
+
+// JSONSchema returns the schema of pbuild.yaml, generated from Config
+func JSONSchema() *Schema {
+	docs := fieldDocs()
+	s := schemaOf(reflect.TypeOf(Config{}), docs)
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+	s.ID = SchemaURL
+	s.Title = FileName
+	s.Description = "pbuild project configuration"
+	// extends is merged away before the rest is checked
+	file := &Schema{Type: "string"}
+	s.Properties[ExtendsKey] = &Schema{
+		Description: "Extends lists base configs, relative to this file, merged below it in order",
+		AnyOf:       []*Schema{file, {Type: "array", Items: file}},
+	}
+	return s
+}
+
+// MarshalSchema renders the schema as indented JSON
+func MarshalSchema() ([]byte, error) {
+	b, err := json.MarshalIndent(JSONSchema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// schemaOf describes t; docs maps "Type.Field" to the field's doc comment
+func schemaOf(t reflect.Type, docs map[string]string) *Schema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return &Schema{Type: "string", Pattern: durationPattern}
+	case t.Kind() == reflect.Struct:
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema), AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if key == "" || key == "-" {
+				continue
+			}
+			prop := schemaOf(f.Type, docs)
+			prop.Description = docs[t.Name()+"."+f.Name]
+			enum := strings.Split(f.Tag.Get("enum"), ",")
+			if name := f.Tag.Get("values"); name != "" {
+				enum = Values[name]
+			}
+			if len(enum) > 0 && enum[0] != "" {
+				target := prop
+				if prop.Type == "array" {
+					target = prop.Items
+				}
+				target.Enum = enum
+			}
+			s.Properties[key] = prop
+		}
+		return s
+	case t.Kind() == reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), docs)}
+	case t.Kind() == reflect.Slice:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), docs)}
+	case t.Kind() == reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &Schema{Type: "integer"}
+	}
+	return &Schema{Type: "string"}
+}
+
+// fieldDocs collects the doc comments of the struct fields in config.go,
+// joined into one line each
+func fieldDocs() map[string]string {
+	docs := make(map[string]string)
+	f, err := parser.ParseFile(token.NewFileSet(), "config.go", source, parser.ParseComments)
+	if err != nil {
+		return docs
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		st, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return false
+		}
+		for _, field := range st.Fields.List {
+			if field.Doc == nil {
+				continue
+			}
+			for _, name := range field.Names {
+				docs[spec.Name.Name+"."+name.Name] = strings.Join(strings.Fields(field.Doc.Text()), " ")
+			}
+		}
+		return false
+	})
+	return docs
+}
+
+// Validate checks the YAML in data, read from file, against the schema, after
+// merging the files it extends and resolving ${VAR} and !secret, and returns
+// every problem as "file:line:column: key: message", unknown keys included
+func Validate(file string, data []byte) []string {
+	_, problems, unknown := parse(file, data)
+	return append(problems, unknown...)
+}
+
+// parse reads the config in data into a merged YAML tree, interpolates it
+// and checks it against the schema. Unknown keys are returned apart from the
+// other problems: Load only warns about them for now, so that configs with
+// typos or removed keys keep loading for a release.
+func parse(file string, data []byte) (*tree, []string, []string) {
+	t, problems := readTree(file, data)
+	if t.root == nil {
+		return t, problems, nil
+	}
+	problems = append(problems, interpolate(file, t)...)
+	v := &validator{file: file, origin: t.origin, problems: problems}
+	v.check(t.root, JSONSchema(), "")
+	return t, v.problems, v.unknown
+}
+
+// validator walks a YAML tree along the schema
+type validator struct {
+	file string
+	// origin is the file each node comes from, when it extends others
+	origin   map[*yaml.Node]string
+	problems []string
+	// unknown are the keys the schema does not know
+	unknown []string
+}
+
+func (v *validator) report(n *yaml.Node, key, format string, args ...any) {
+	v.problems = append(v.problems, v.locate(n, key, fmt.Sprintf(format, args...)))
+}
+
+// locate prefixes msg with the file, line and column of n and with key
+func (v *validator) locate(n *yaml.Node, key, msg string) string {
+	if key != "" {
+		msg = key + ": " + msg
+	}
+	file := v.file
+	if f, ok := v.origin[n]; ok {
+		file = f
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", file, n.Line, n.Column, msg)
+}
+
+// check validates n, found at key, against s
+func (v *validator) check(n *yaml.Node, s *Schema, key string) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	// An empty value leaves the field at its default
+	if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null" {
+		return
+	}
+	switch s.Type {
+	case "object":
+		if n.Kind != yaml.MappingNode {
+			v.report(n, key, "expected a mapping, got %s", kind(n))
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, value := n.Content[i], n.Content[i+1]
+			if k.Value == "<<" {
+				v.check(value, s, key)
+				continue
+			}
+			path := k.Value
+			if key != "" {
+				path = key + "." + k.Value
+			}
+			if prop, ok := s.Properties[k.Value]; ok {
+				v.check(value, prop, path)
+				continue
+			}
+			switch extra := s.AdditionalProperties.(type) {
+			case *Schema:
+				v.check(value, extra, path)
+			default:
+				msg := fmt.Sprintf("unknown key %q", k.Value)
+				if near := closest(k.Value, s.Properties); near != "" {
+					msg += fmt.Sprintf(" (did you mean %s?)", near)
+				}
+				v.unknown = append(v.unknown, v.locate(k, key, msg))
+			}
+		}
+	case "array":
+		if n.Kind != yaml.SequenceNode {
+			v.report(n, key, "expected a list, got %s", kind(n))
+			return
+		}
+		for i, item := range n.Content {
+			v.check(item, s.Items, fmt.Sprintf("%s[%d]", key, i))
+		}
+	default:
+		if n.Kind != yaml.ScalarNode {
+			v.report(n, key, "expected a %s, got %s", s.Type, kind(n))
+			return
+		}
+		switch {
+		case s.Type == "boolean" && n.ShortTag() != "!!bool":
+			v.report(n, key, "expected true or false, got %q", n.Value)
+		case s.Type == "integer" && n.ShortTag() != "!!int":
+			v.report(n, key, "expected an integer, got %q", n.Value)
+		case s.Pattern == durationPattern:
+			if _, err := time.ParseDuration(n.Value); err != nil {
+				v.report(n, key, "expected a duration such as 336h or 90m, got %q", n.Value)
+			}
+		case len(s.Enum) > 0 && !slices.Contains(s.Enum, n.Value):
+			v.report(n, key, "%q is not one of %s", n.Value, strings.Join(s.Enum, ", "))
+		}
+	}
+}
+
+// kind names the YAML node kind for messages
+func kind(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", n.Value)
+}
+
+// closest returns the known key within two edits of key, if any
+func closest(key string, props map[string]*Schema) string {
+	best, bestDist := "", 3
+	for name := range props {
+		if d := editDistance(key, name); d < bestDist || d == bestDist && name < best {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance of a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"pbuild/redact"
+)
+
+// Show renders the config file at path with the files it extends merged in,
+// marking each key that comes from a base with the file it was read from.
+// ${VAR} references and !secret values are kept as written.
+func Show(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	t, problems := readTree(path, data)
+	switch len(problems) {
+	case 0:
+	case 1:
+		return nil, fmt.Errorf("invalid config: %s", problems[0])
+	default:
+		return nil, fmt.Errorf("invalid config, %d problems:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+
+	var b strings.Builder
+	base := filepath.Dir(path)
+	fmt.Fprintf(&b, "# %s, merged from %s\n", relPath(base, path), strings.Join(relPaths(base, t.files), ", "))
+	if t.root == nil {
+		return []byte(b.String()), nil
+	}
+	t.annotate(t.root, path, base)
+	if err := encode(&b, t.root); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// Effective renders every setting of cfg, as loaded and then adjusted by the
+// caller, with the layer it came from: sources names the keys the caller set
+// (e.g. "compress": "--compress"); any other key is marked with the file that
+// set it, and the variable of a ${VAR} reference ($VAR) or !secret NAME when
+// its value came from there, or else is a default. Secret values are masked.
+func Effective(cfg *Config, sources map[string]string) ([]byte, error) {
+	var root yaml.Node
+	if err := root.Encode(cfg); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	if cfg.file == "" {
+		b.WriteString("# Effective configuration: defaults and flags, no " + FileName + "\n")
+	} else {
+		names := relPaths(filepath.Dir(cfg.file), cfg.files)
+		fmt.Fprintf(&b, "# Effective configuration: defaults, %s, environment and flags\n", strings.Join(names, ", "))
+	}
+	cfg.annotate(&root, "", sources)
+	if err := encode(&b, &root); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// annotate marks the settings below the mapping n, found at key, with their sources
+func (c *Config) annotate(n *yaml.Node, key string, sources map[string]string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, value := n.Content[i], n.Content[i+1]
+		path := k.Value
+		if key != "" {
+			path = key + "." + k.Value
+		}
+		if value.Kind == yaml.MappingNode && len(value.Content) > 0 {
+			c.annotate(value, path, sources)
+			continue
+		}
+		source, ok := sources[path]
+		if !ok {
+			source, ok = c.sources[path]
+		}
+		if !ok {
+			source = "default"
+		}
+		comment(k, value, source)
+		c.mask(value)
+	}
+}
+
+// mask replaces the secret values in n
+func (c *Config) mask(n *yaml.Node) {
+	for _, child := range n.Content {
+		c.mask(child)
+	}
+	if n.Kind == yaml.ScalarNode && n.Value != "" && slices.Contains(c.secrets, n.Value) {
+		n.Tag, n.Style, n.Value = "", 0, redact.Mask
+	}
+}
+
+// sources maps the dotted key of every value set in the tree onto the file it
+// was read from, relative to base, followed by the variables and secrets its
+// value came from
+func (t *tree) sources(base string) map[string]string {
+	s := make(map[string]string)
+	if t.root != nil {
+		t.collect(t.root, "", base, s)
+	}
+	return s
+}
+
+func (t *tree) collect(n *yaml.Node, key, base string, s map[string]string) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, value := n.Content[i], n.Content[i+1]
+		path := k.Value
+		if key != "" {
+			path = key + "." + k.Value
+		}
+		if value.Kind == yaml.MappingNode {
+			t.collect(value, path, base, s)
+			continue
+		}
+		parts := []string{relPath(base, t.origin[k])}
+		t.inputs(value, &parts)
+		s[path] = strings.Join(slices.Compact(parts), ", ")
+	}
+}
+
+// inputs adds the variables and secrets the values below n came from to parts
+func (t *tree) inputs(n *yaml.Node, parts *[]string) {
+	for _, name := range t.env[n] {
+		*parts = append(*parts, "$"+name)
+	}
+	if name, ok := t.secrets[n]; ok {
+		*parts = append(*parts, SecretTag+" "+name)
+	}
+	for _, c := range n.Content {
+		t.inputs(c, parts)
+	}
+}
+
+// annotate marks the keys of the mapping n that do not come from file with
+// the file they were read from
+func (t *tree) annotate(n *yaml.Node, file, base string) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if from := t.origin[key]; from != file {
+			comment(key, value, "from "+relPath(base, from))
+			continue
+		}
+		t.annotate(value, file, base)
+	}
+}
+
+// comment adds text to the line comment of the key: value pair. The encoder
+// moves a comment on the key of a flow value ([a, b], {} or a scalar) past
+// it, so those carry it themselves.
+func comment(key, value *yaml.Node, text string) {
+	n := key
+	if len(value.Content) == 0 || value.Style&yaml.FlowStyle != 0 {
+		n = value
+	}
+	if n.LineComment == "" {
+		n.LineComment = "# " + text
+	} else {
+		n.LineComment += " (" + text + ")"
+	}
+}
+
+// encode writes n as YAML indented by two spaces
+func encode(b *strings.Builder, n *yaml.Node) error {
+	enc := yaml.NewEncoder(b)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// relPath returns path relative to base where that is shorter
+func relPath(base, path string) string {
+	if rel, err := filepath.Rel(base, path); err == nil && len(rel) < len(path) {
+		return rel
+	}
+	return path
+}
+
+// relPaths applies relPath to every path
+func relPaths(base string, paths []string) []string {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		out[i] = relPath(base, p)
+	}
+	return out
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"pbuild/config"
+	"pbuild/fsutil"
+	"pbuild/versionpkg"
+)
+
+var (
+	flagSchemaOutput   string
+	flagConfigResolved bool
+)
+
+// newConfigCmd returns the `pbuild config` subcommand
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Print the JSON Schema of " + config.FileName + ", validate a config or show it merged",
+	}
+	schema := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of " + config.FileName,
+		Long: "Prints the JSON Schema (draft 2020-12) of " + config.FileName + ", generated from the\n" +
+			"config structs of this pbuild, for editors to complete and check the file.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := config.MarshalSchema()
+			if err != nil {
+				return err
+			}
+			if flagSchemaOutput == "" {
+				_, err := cmd.OutOrStdout().Write(data)
+				return err
+			}
+			if err := fsutil.WriteFileAtomic(flagSchemaOutput, data, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Schema written to: %s\n", flagSchemaOutput)
+			return nil
+		},
+	}
+	schema.Flags().StringVarP(&flagSchemaOutput, "output", "o", "", "write the schema to this file instead of stdout")
+	validate := &cobra.Command{
+		Use:   "validate [FILE]",
+		Short: "Check a config file against the schema",
+		Long: "Checks FILE (default: " + config.FileName + " in the module of the current directory,\n" +
+			"or --config) for unknown keys, wrong types and invalid values, and reports each\n" +
+			"problem with its line and column. Every build runs the same check.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfigValidate(args)
+		},
+	}
+	show := &cobra.Command{
+		Use:   "show [FILE] [-- BUILD FLAGS]",
+		Short: "Print a config file merged, or the settings a build would use",
+		Long: "Prints FILE (default: " + config.FileName + " in the module of the current directory,\n" +
+			"or --config) with the base configs named by extends: merged in, each key\n" +
+			"taken from a base marked with its file.\n\n" +
+			"With --resolved, prints every setting a build given BUILD FLAGS would use,\n" +
+			"marked with the layer that set it: a default, a config file, an environment\n" +
+			"variable or secret, or a flag. Secrets are masked.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !flagConfigResolved && cmd.ArgsLenAtDash() >= 0 {
+				return fmt.Errorf("build flags need --resolved")
+			}
+			args, err := buildFlagArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			if len(args) > 1 {
+				return fmt.Errorf("accepts at most 1 file, received %d", len(args))
+			}
+			file, err := configFile(args)
+			if err != nil {
+				return err
+			}
+			var data []byte
+			if flagConfigResolved {
+				data, err = effectiveConfig(cmd.Root(), file, len(args) == 1 || flagConfig != "")
+			} else {
+				data, err = config.Show(file)
+			}
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+	show.Flags().BoolVar(&flagConfigResolved, "resolved", false, "print the effective settings of a build, with defaults, environment and flags")
+	cmd.AddCommand(schema, validate, show)
+	return cmd
+}
+
+// configFile returns the named config file, --config or the module's pbuild.yaml
+func configFile(args []string) (string, error) {
+	file := flagConfig
+	if len(args) == 1 {
+		file = args[0]
+	}
+	if file != "" {
+		return file, nil
+	}
+	dir, err := filepath.Abs(".")
+	if err != nil {
+		return "", err
+	}
+	if root, err := fsutil.FindModuleRoot(dir); err == nil {
+		dir = root
+	}
+	return filepath.Join(dir, config.FileName), nil
+}
+
+// runConfigValidate validates the named config file or the module's pbuild.yaml
+func runConfigValidate(args []string) error {
+	file, err := configFile(args)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	problems := config.Validate(file, data)
+	if len(problems) == 0 {
+		fmt.Printf("%s is valid\n", file)
+		return nil
+	}
+	return fmt.Errorf("%d problems:\n  %s", len(problems), strings.Join(problems, "\n  "))
+}
+
+// configFlags are the build flags that set config keys, shown with those keys
+var configFlags = []string{"all", "target-group", "targets", "darwin-universal", "tags", "compress", "sign",
+	"notify", "archive", "concurrency", "generate", "keep-nightlies", "no-gitignore-update", "create-gitignore"}
+
+// effectiveConfig renders the settings a build with the parsed flags would
+// use: the config file, read like a build reads it (explicit: a missing file
+// is an error), overridden by the flags and completed with pbuild's defaults,
+// followed by the other build flags
+func effectiveConfig(root *cobra.Command, file string, explicit bool) ([]byte, error) {
+	path := ""
+	if explicit {
+		path = file
+	}
+	cfg, err := loadConfig(filepath.Dir(file), path)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := root.Flags()
+	// source joins where a key was set in the files with the given flags
+	source := func(key string, names ...string) string {
+		var parts []string
+		if s := cfg.Source(key); s != "" {
+			parts = append(parts, s)
+		}
+		for _, name := range names {
+			if flags.Changed(name) {
+				parts = append(parts, "--"+name)
+			}
+		}
+		return strings.Join(parts, ", ")
+	}
+	sources := make(map[string]string)
+	explicitTargets := flagAll || flagTargetGroup != "" || flagTargets != ""
+	if explicitTargets || flagUniversal {
+		matrix, err := resolveMatrix(cfg)
+		if err != nil {
+			return nil, err
+		}
+		// The flags replace the targets of the files, --darwin-universal adds to them
+		key := "targets"
+		if explicitTargets {
+			key = ""
+		}
+		sources["targets"] = source(key, "all", "target-group", "targets", "darwin-universal")
+		cfg.Targets = nil
+		for _, t := range matrix {
+			cfg.Targets = append(cfg.Targets, t.String())
+		}
+	} else if len(cfg.Targets) == 0 {
+		cfg.Targets = []string{runtime.GOOS + "/" + runtime.GOARCH}
+	}
+	// The flags apply exactly as in a run
+	settings := resolveSettings(cfg)
+	cfg.Tags, cfg.Notify, cfg.GoGenerate = settings.Tags, settings.Notify, settings.Generate
+	cfg.Compress, cfg.Sign, cfg.Bench.Gate, cfg.Archive.Format = settings.Compress, settings.Sign, settings.BenchGate, settings.ArchiveFormat
+	cfg.Nightly.Keep, cfg.Gitignore.Update, cfg.Gitignore.Create = settings.NightlyKeep, &settings.GitignoreUpdate, settings.GitignoreCreate
+	// --tags and --notify add to the files' lists, the other flags replace their values
+	for key, name := range map[string]string{"tags": "tags", "notify": "notify"} {
+		if flags.Changed(name) {
+			sources[key] = source(key, name)
+		}
+	}
+	for key, name := range map[string]string{
+		"compress": "compress", "sign": "sign", "bench.gate": "bench-gate", "archive.format": "archive",
+		"go_generate": "generate", "nightly.keep": "keep-nightlies",
+		"gitignore.update": "no-gitignore-update", "gitignore.create": "create-gitignore",
+	} {
+		if flags.Changed(name) {
+			sources[key] = "--" + name
+		}
+	}
+	limits, errs := parseConcurrency(cfg)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(limits) > 0 {
+		cfg.Concurrency = limits
+	}
+	for _, entry := range splitList(flagConcurrency) {
+		stage, _, _ := strings.Cut(entry, "=")
+		sources["concurrency."+strings.TrimSpace(stage)] = "--concurrency"
+	}
+	if cfg.Archive.Name == "" {
+		cfg.Archive.Name = defaultArchiveName
+	}
+	if cfg.VersionPkg == "" {
+		cfg.VersionPkg = versionpkg.DefaultDir
+	}
+	if cfg.VersionSource.Var == "" {
+		cfg.VersionSource.Var = "appVersion"
+	}
+	redactor.Value(cfg)
+	data, err := config.Effective(cfg, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	// The other build flags follow as a document of their own
+	var doc yaml.Node
+	doc.Kind = yaml.MappingNode
+	flags.VisitAll(func(f *pflag.Flag) {
+		if slices.Contains(configFlags, f.Name) || f.Name == "help" || f.Name == "version" {
+			return
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: f.Name, LineComment: "# default"}
+		if f.Changed {
+			key.LineComment = "# --" + f.Name
+		}
+		value := &yaml.Node{Kind: yaml.ScalarNode, Value: redactor.String(f.Value.String())}
+		if value.Value == "" {
+			value.Tag = "!!str"
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			value = &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+			for _, item := range sv.GetSlice() {
+				value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: redactor.String(item)})
+			}
+		}
+		doc.Content = append(doc.Content, key, value)
+	})
+	var b strings.Builder
+	b.WriteString("---\n# Build flags\n")
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return append(data, b.String()...), nil
+}
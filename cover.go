@@ -0,0 +1,22 @@
+package main
+
+import "fmt"
+
+// coverSuffix marks the artifacts and the version of --cover builds, e.g.
+// myapp-cover-arm64-linux in builds/1.2.0-abc1234-cover
+const coverSuffix = "-cover"
+
+// artifactBase is the name the artifacts of the run are derived from
+func artifactBase(p *project) string {
+	if flagCover {
+		return p.name + coverSuffix
+	}
+	return p.name
+}
+
+// printCoverHint tells how to collect coverage from the instrumented binaries
+func printCoverHint() {
+	fmt.Println("Coverage-instrumented binaries write their counters to $GOCOVERDIR on exit:")
+	fmt.Println("  mkdir -p covdata && GOCOVERDIR=covdata ./<name>-cover ...")
+	fmt.Println("  go tool covdata percent -i=covdata")
+}
@@ -0,0 +1,169 @@
+package creds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"pbuild/config"
+	"pbuild/fsutil"
+)
+
+// ErrNotFound means no source of a credential holds a value
+var ErrNotFound = errors.New("credential not found")
+
+// Well-known credentials read by pbuild itself
+const (
+	SignKey      = "sign_key"      // signing key: minisign/signify key text, gpg key id, cosign key
+	SignPassword = "sign_password" // passphrase of an encrypted signing key
+)
+
+// EnvName is the environment variable that overrides a credential, e.g.
+// PBUILD_SIGN_PASSWORD for sign_password
+func EnvName(name string) string {
+	return config.EnvName(name)
+}
+
+// Store resolves credentials from the credentials: section of pbuild.yaml.
+// For each name the first source holding a value wins:
+//
+//  1. the PBUILD_<NAME> environment variable
+//  2. the configured env variable
+//  3. the configured file
+//  4. the configured OS keychain entry
+//
+// An unset variable, a missing file or a missing keychain entry falls through
+// to the next source; any other failure is an error.
+type Store struct {
+	cfg map[string]config.Credential
+	// OnResolve, when set, receives every value handed out, e.g. to redact it
+	OnResolve func(value string)
+}
+
+// New returns a store for the configured credentials
+func New(cfg map[string]config.Credential) *Store {
+	return &Store{cfg: cfg}
+}
+
+// Get returns the value of a credential and the source it came from
+func (s *Store) Get(name string) (value, source string, err error) {
+	value, source, err = s.get(name)
+	if err == nil && s.OnResolve != nil {
+		s.OnResolve(value)
+	}
+	return value, source, err
+}
+
+func (s *Store) get(name string) (string, string, error) {
+	if v := os.Getenv(EnvName(name)); v != "" {
+		return v, "$" + EnvName(name), nil
+	}
+	c, configured := s.cfg[name]
+	if !configured {
+		return "", "", fmt.Errorf("%w: %s (set %s or add it under credentials: in %s)", ErrNotFound, name, EnvName(name), config.FileName)
+	}
+	if c.Env != "" {
+		if v := os.Getenv(c.Env); v != "" {
+			return v, "$" + c.Env, nil
+		}
+	}
+	if c.File != "" {
+		file := expandHome(c.File)
+		data, err := os.ReadFile(file)
+		if err == nil {
+			return strings.TrimRight(string(data), "\r\n"), file, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", "", fmt.Errorf("credential %s: %v", name, err)
+		}
+	}
+	if c.Keychain != "" {
+		account := c.Account
+		if account == "" {
+			account = name
+		}
+		v, err := keychainGet(c.Keychain, account)
+		if err == nil {
+			return v, fmt.Sprintf("%s %s/%s", keychainName, c.Keychain, account), nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", "", fmt.Errorf("credential %s: %v", name, err)
+		}
+	}
+	return "", "", fmt.Errorf("%w: %s (tried %s)", ErrNotFound, name, strings.Join(sources(name, c), ", "))
+}
+
+// Lookup is Get without the source, for callers that only need the value
+func (s *Store) Lookup(name string) (string, error) {
+	v, _, err := s.Get(name)
+	return v, err
+}
+
+// Optional returns a credential's value, or "" when no source holds one
+func (s *Store) Optional(name string) (string, error) {
+	v, err := s.Lookup(name)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	return v, err
+}
+
+// varRef matches ${VAR} and ${cred:NAME}; any other $ is literal, e.g. in a
+// password
+var varRef = regexp.MustCompile(`\$\{(cred:[^}]+|[A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Expand replaces ${VAR} with environment variables and ${cred:NAME} with
+// the credential NAME, in a single pass so that values containing "$" stay
+// intact
+func (s *Store) Expand(str string) (string, error) {
+	var firstErr error
+	out := varRef.ReplaceAllStringFunc(str, func(ref string) string {
+		key := ref[2 : len(ref)-1]
+		name, ok := strings.CutPrefix(key, "cred:")
+		if !ok {
+			return os.Getenv(key)
+		}
+		v, err := s.Lookup(name)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return v
+	})
+	return out, firstErr
+}
+
+// sources lists where a credential is looked up, for error messages
+func sources(name string, c config.Credential) []string {
+	list := []string{"$" + EnvName(name)}
+	if c.Env != "" {
+		list = append(list, "$"+c.Env)
+	}
+	if c.File != "" {
+		list = append(list, c.File)
+	}
+	if c.Keychain != "" {
+		account := c.Account
+		if account == "" {
+			account = name
+		}
+		list = append(list, fmt.Sprintf("%s %s/%s", keychainName, c.Keychain, account))
+	}
+	return list
+}
+
+// expandHome resolves a leading ~/ (~\ on Windows) and ${VAR} environment
+// variables in a file path
+func expandHome(path string) string {
+	path = varRef.ReplaceAllStringFunc(path, func(ref string) string {
+		if key := ref[2 : len(ref)-1]; !strings.HasPrefix(key, "cred:") {
+			return os.Getenv(key)
+		}
+		return ref
+	})
+	if expanded, err := fsutil.ExpandHome(path); err == nil {
+		return expanded
+	}
+	return path
+}
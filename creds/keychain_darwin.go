@@ -0,0 +1,31 @@
+//go:build darwin
+
+package creds
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const keychainName = "keychain"
+
+// keychainGet reads a generic password from the macOS Keychain, as stored by
+// security add-generic-password -s <service> -a <account> -w
+func keychainGet(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 {
+		// errSecItemNotFound
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("security find-generic-password failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
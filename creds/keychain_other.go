@@ -0,0 +1,34 @@
+//go:build !darwin && !windows
+
+package creds
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const keychainName = "secret service"
+
+// keychainGet reads a secret from the freedesktop Secret Service (GNOME
+// Keyring, KWallet), as stored by secret-tool store service <service> account <account>
+func keychainGet(service, account string) (string, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return "", fmt.Errorf("secret-tool not found in PATH (install libsecret-tools)")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && stderr.Len() == 0 {
+		// secret-tool exits 1 without output when nothing matches
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("secret-tool lookup failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
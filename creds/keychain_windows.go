@@ -0,0 +1,64 @@
+//go:build windows
+
+package creds
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+const keychainName = "credential manager"
+
+var (
+	advapi32     = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead = advapi32.NewProc("CredReadW")
+	procCredFree = advapi32.NewProc("CredFree")
+)
+
+// credential mirrors CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+const (
+	credTypeGeneric = 1
+	errorNotFound   = syscall.Errno(1168)
+)
+
+// keychainGet reads a generic credential from the Windows Credential Manager.
+// The target name is "<service>:<account>", as written by
+// cmdkey /generic:<service>:<account> /user:<account> /pass and go-keyring.
+func keychainGet(service, account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if errors.Is(err, errorNotFound) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	// cmdkey stores UTF-16, other tools raw bytes
+	if len(blob)%2 == 0 && len(blob) > 0 && blob[1] == 0 {
+		u := unsafe.Slice((*uint16)(unsafe.Pointer(cred.CredentialBlob)), len(blob)/2)
+		return syscall.UTF16ToString(u), nil
+	}
+	return string(blob), nil
+}
@@ -0,0 +1,140 @@
+package delta
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"pbuild/fsutil"
+)
+
+// Method names the patch format: the new file compressed with the old one as
+// a raw zstd dictionary, like `zstd --patch-from`
+const Method = "zstd-patch"
+
+// Extension is appended to artifact names for their patch files
+const Extension = ".zpatch"
+
+// dictID 0 keeps the dictionary ID out of the frame header, as zstd --patch-from does
+const dictID = 0
+
+// windowFor returns the smallest zstd window that spans both files, so matches
+// can reach anywhere in the old binary
+func windowFor(sizes ...int) int {
+	n := zstd.MinWindowSize
+	for _, s := range sizes {
+		for n < s && n < zstd.MaxWindowSize {
+			n <<= 1
+		}
+	}
+	return n
+}
+
+// Create writes a patch that turns oldPath into newPath
+func Create(oldPath, newPath, patchPath string) error {
+	oldData, err := os.ReadFile(oldPath)
+	if err != nil {
+		return err
+	}
+	newData, err := os.ReadFile(newPath)
+	if err != nil {
+		return err
+	}
+	enc, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.SpeedBestCompression),
+		zstd.WithWindowSize(windowFor(len(oldData), len(newData))),
+		zstd.WithEncoderDictRaw(dictID, oldData),
+		zstd.WithEncoderConcurrency(1),
+	)
+	if err != nil {
+		return err
+	}
+	defer enc.Close()
+	return fsutil.WriteFileAtomic(patchPath, enc.EncodeAll(newData, nil), 0644)
+}
+
+// Apply reconstructs the new file from oldPath and a patch
+func Apply(oldPath, patchPath string) ([]byte, error) {
+	oldData, err := os.ReadFile(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := os.ReadFile(patchPath)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil,
+		zstd.WithDecoderDictRaw(dictID, oldData),
+		zstd.WithDecoderMaxWindow(uint64(zstd.MaxWindowSize)),
+		zstd.WithDecoderMaxMemory(1<<32),
+		zstd.WithDecoderConcurrency(1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	out, err := dec.DecodeAll(patch, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s: %v", patchPath, err)
+	}
+	return out, nil
+}
+
+// Verify applies the patch and checks the result against the expected SHA256
+func Verify(oldPath, patchPath, wantSHA256 string) error {
+	out, err := Apply(oldPath, patchPath)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(out)
+	if got := hex.EncodeToString(sum[:]); got != wantSHA256 {
+		return fmt.Errorf("patch %s reproduces %s, want %s", patchPath, got, wantSHA256)
+	}
+	return nil
+}
+
+// ManifestName is the manifest written next to the patches
+const ManifestName = "manifest.json"
+
+// Patch describes one artifact patch and the digests on either side of it
+type Patch struct {
+	Target       string `json:"target"`
+	File         string `json:"file"`
+	Patch        string `json:"patch"`
+	SourceSHA256 string `json:"source_sha256"`
+	SourceSize   int64  `json:"source_size"`
+	TargetSHA256 string `json:"target_sha256"`
+	TargetSize   int64  `json:"target_size"`
+	PatchSHA256  string `json:"patch_sha256"`
+	PatchSize    int64  `json:"patch_size"`
+}
+
+// Manifest lists the patches from one release to another
+type Manifest struct {
+	ProjectName string    `json:"project_name"`
+	FromVersion string    `json:"from_version"`
+	ToVersion   string    `json:"to_version"`
+	Method      string    `json:"method"`
+	Created     time.Time `json:"created"`
+	Patches     []Patch   `json:"patches"`
+}
+
+// Dir is the directory inside the new version directory holding patches from fromVersion
+func Dir(versionDir, fromVersion string) string {
+	return filepath.Join(versionDir, "delta-from-"+fromVersion)
+}
+
+// WriteManifest writes m to dir/manifest.json
+func WriteManifest(dir string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(filepath.Join(dir, ManifestName), data, 0644)
+}
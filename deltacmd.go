@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/buildmeta"
+	"pbuild/delta"
+	"pbuild/fsutil"
+	"pbuild/lock"
+)
+
+var flagDeltaTo string
+
+// newDeltaCmd returns the `pbuild delta` subcommand
+func newDeltaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delta OLD_VERSION [TARGET_DIR]",
+		Short: "Generate binary patches from an earlier release to the current one",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDelta(args[0], targetArg(args[1:]))
+		},
+	}
+	cmd.Flags().StringVar(&flagOutDir, "output-dir", "builds", "directory for build artifacts")
+	cmd.Flags().StringVar(&flagDeltaTo, "to", "", "version to patch to (default: the current version)")
+	return cmd
+}
+
+// compressedArtifact reports whether a file was written by --compress; patches
+// between compressed streams gain almost nothing
+func compressedArtifact(name string) bool {
+	for _, method := range []string{"gzip", "zstd"} {
+		if strings.HasSuffix(name, compressionExt(method)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runDelta writes patches and a manifest for every target built in both versions
+func runDelta(fromVersion, targetDir string) error {
+	p, err := resolveProject(targetDir)
+	if err != nil {
+		return err
+	}
+	toVersion := flagDeltaTo
+	if toVersion == "" {
+		toVersion = p.version
+	}
+	if toVersion == fromVersion {
+		return fmt.Errorf("nothing to diff: both versions are %s", fromVersion)
+	}
+
+	outDir := outputDir(p.workDir)
+	fromDir := filepath.Join(outDir, fromVersion)
+	toDir := filepath.Join(outDir, toVersion)
+	oldMeta, err := buildmeta.Read(fromDir)
+	if err != nil {
+		return fmt.Errorf("failed to read release %s: %v", fromVersion, err)
+	}
+	newMeta, err := buildmeta.Read(toDir)
+	if err != nil {
+		return fmt.Errorf("failed to read release %s: %v", toVersion, err)
+	}
+
+	// Keep a build from rewriting the version directory while patches are written
+	l, err := lock.Acquire(toDir+".lock", 0)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	oldFiles := make(map[string]string)
+	for _, r := range oldMeta.Results {
+		if r.Success {
+			oldFiles[r.Target] = r.File
+		}
+	}
+
+	dir := delta.Dir(toDir, fromVersion)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	manifest := delta.Manifest{
+		ProjectName: newMeta.ProjectName,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Method:      delta.Method,
+		Created:     time.Now(),
+	}
+	var failed int
+	for _, r := range newMeta.Results {
+		if !r.Success {
+			continue
+		}
+		oldFile, ok := oldFiles[r.Target]
+		if !ok {
+			fmt.Printf("Skipping %s: not built in %s\n", r.Target, fromVersion)
+			continue
+		}
+		if compressedArtifact(r.File) || compressedArtifact(oldFile) {
+			fmt.Printf("Skipping %s: compressed artifacts are not diffed\n", r.Target)
+			continue
+		}
+
+		fmt.Printf("Diffing %s: %s/%s -> %s/%s\n", r.Target, fromVersion, oldFile, toVersion, r.File)
+		patch, err := createPatch(filepath.Join(fromDir, oldFile), filepath.Join(toDir, r.File), dir)
+		if err != nil {
+			fmt.Printf("  FAILED\n  %v\n\n", err)
+			failed++
+			continue
+		}
+		patch.Target = r.Target
+		manifest.Patches = append(manifest.Patches, patch)
+		fmt.Printf("  %s (%s of %s)\n\n", patch.Patch, fsutil.HumanSizeBytes(patch.PatchSize), fsutil.HumanSizeBytes(patch.TargetSize))
+	}
+
+	if err := delta.WriteManifest(dir, manifest); err != nil {
+		return fmt.Errorf("failed to write patch manifest: %v", err)
+	}
+	fmt.Printf("Patch manifest written to: %s\n", filepath.Join(dir, delta.ManifestName))
+	if failed > 0 {
+		return fmt.Errorf("%d patches failed", failed)
+	}
+	if len(manifest.Patches) == 0 {
+		return errors.New("no targets were built in both versions")
+	}
+	return nil
+}
+
+// createPatch diffs one artifact pair into dir and verifies the patch round-trips
+func createPatch(oldPath, newPath, dir string) (delta.Patch, error) {
+	sourceSum, _, err := generateChecksums(oldPath)
+	if err != nil {
+		return delta.Patch{}, err
+	}
+	targetSum, _, err := generateChecksums(newPath)
+	if err != nil {
+		return delta.Patch{}, err
+	}
+
+	name := filepath.Base(newPath)
+	patchPath := filepath.Join(dir, name+delta.Extension)
+	if err := delta.Create(oldPath, newPath, patchPath); err != nil {
+		return delta.Patch{}, err
+	}
+	if err := delta.Verify(oldPath, patchPath, targetSum); err != nil {
+		_ = os.Remove(patchPath)
+		return delta.Patch{}, err
+	}
+
+	patchSum, _, err := generateChecksums(patchPath)
+	if err != nil {
+		return delta.Patch{}, err
+	}
+	patch := delta.Patch{
+		File:         name,
+		Patch:        filepath.Base(patchPath),
+		SourceSHA256: sourceSum,
+		TargetSHA256: targetSum,
+		PatchSHA256:  patchSum,
+	}
+	patch.SourceSize, _ = fsutil.FileSize(oldPath)
+	patch.TargetSize, _ = fsutil.FileSize(newPath)
+	patch.PatchSize, _ = fsutil.FileSize(patchPath)
+	return patch, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"pbuild/deps"
+	"pbuild/gobuild"
+	"pbuild/targets"
+)
+
+// writeDeps records the module graph and the modules each successfully built
+// target links in as deps.json in versionDir
+func writeDeps(p *project, rows []summaryRow, versionDir string) {
+	cfg := newBuildConfig(p)
+	var platforms []deps.Platform
+	for _, r := range rows {
+		if !r.Success {
+			continue
+		}
+		t, err := targets.Parse(r.Target)
+		if err != nil {
+			continue
+		}
+		tags, err := gobuild.ResolveTags(cfg.Strategy, cfg.Tags, t)
+		if err != nil {
+			fmt.Printf("Warning: Failed to write %s: %v\n", deps.FileName, err)
+			return
+		}
+		arch := t.Arch
+		if t.OS == "darwin" && arch == targets.DarwinUniversal {
+			// Both slices of a universal binary build the same packages
+			arch = "arm64"
+		}
+		env := []string{"GOOS=" + t.OS, "GOARCH=" + arch}
+		if cfg.Strategy != gobuild.FlexibleCGO {
+			env = append(env, "CGO_ENABLED=0")
+		}
+		platforms = append(platforms, deps.Platform{Target: t.String(), Env: env, Tags: tags})
+	}
+
+	snap, err := deps.Collect(context.Background(), p.workDir, platforms)
+	if err == nil {
+		err = deps.Write(versionDir, snap)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to write %s: %v\n", deps.FileName, err)
+		return
+	}
+	fmt.Printf("Dependency snapshot written to: %s\n", filepath.Join(versionDir, deps.FileName))
+}
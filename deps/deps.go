@@ -0,0 +1,340 @@
+package deps
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pbuild/fsutil"
+)
+
+// FileName is the dependency snapshot written next to build-metadata.json
+const FileName = "deps.json"
+
+// Snapshot is the module graph of a build
+type Snapshot struct {
+	Main      string   `json:"main"`
+	GoVersion string   `json:"go_version"`
+	Modules   []Module `json:"modules"`
+	Graph     []Edge   `json:"graph"`
+}
+
+// Module is one module of the build list with its go.sum hashes
+type Module struct {
+	Path     string  `json:"path"`
+	Version  string  `json:"version"`
+	Sum      string  `json:"sum,omitempty"`
+	GoModSum string  `json:"go_mod_sum,omitempty"`
+	Indirect bool    `json:"indirect,omitempty"`
+	Replace  *Module `json:"replace,omitempty"`
+	// Targets lists the targets that compile a package of the module in;
+	// modules that are only part of the graph have none
+	Targets []string `json:"targets,omitempty"`
+}
+
+// Edge is a requirement from one module@version to another
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Platform is the environment a target's package graph is listed in
+type Platform struct {
+	Target string
+	Env    []string // GOOS, GOARCH, CGO_ENABLED, ...
+	Tags   []string
+}
+
+// goCmd runs a go command in workDir and returns its stdout
+func goCmd(ctx context.Context, workDir string, env []string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = workDir
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go %s failed: %v\n%s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// listModule mirrors the fields of `go list -m -json` used here
+type listModule struct {
+	Path     string
+	Version  string
+	Time     *time.Time
+	Sum      string
+	GoModSum string
+	Main     bool
+	Indirect bool
+	Replace  *listModule
+	Update   *listModule // with -u
+}
+
+func (m *listModule) module() *Module {
+	if m == nil {
+		return nil
+	}
+	return &Module{Path: m.Path, Version: m.Version, Sum: m.Sum, GoModSum: m.GoModSum, Replace: m.Replace.module()}
+}
+
+// Collect snapshots the module graph of the main module in workDir and marks
+// which modules each platform links into the binary
+func Collect(ctx context.Context, workDir string, platforms []Platform) (*Snapshot, error) {
+	if _, err := os.Stat(filepath.Join(workDir, "go.mod")); err != nil {
+		return nil, fmt.Errorf("no go.mod in %s", workDir)
+	}
+
+	out, err := goCmd(ctx, workDir, nil, "list", "-m", "-json", "all")
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{GoVersion: runtime.Version(), Modules: []Module{}}
+	index := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var m listModule
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse go list output: %v", err)
+		}
+		if m.Main {
+			snap.Main = m.Path
+			continue
+		}
+		mod := m.module()
+		mod.Indirect = m.Indirect
+		index[m.Path] = len(snap.Modules)
+		snap.Modules = append(snap.Modules, *mod)
+	}
+
+	out, err = goCmd(ctx, workDir, nil, "mod", "graph")
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if from, to, ok := strings.Cut(sc.Text(), " "); ok {
+			snap.Graph = append(snap.Graph, Edge{From: from, To: to})
+		}
+	}
+
+	linked, err := listLinked(ctx, workDir, platforms)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range platforms {
+		for path := range linked[i] {
+			if j, ok := index[path]; ok {
+				snap.Modules[j].Targets = append(snap.Modules[j].Targets, p.Target)
+			}
+		}
+	}
+	for i := range snap.Modules {
+		sort.Strings(snap.Modules[i].Targets)
+	}
+	return snap, nil
+}
+
+// listLinked returns, per platform, the module paths of all packages linked into the main package
+func listLinked(ctx context.Context, workDir string, platforms []Platform) ([]map[string]bool, error) {
+	linked := make([]map[string]bool, len(platforms))
+	errs := make([]error, len(platforms))
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, p := range platforms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			args := []string{"list", "-deps", "-f", "{{with .Module}}{{if not .Main}}{{.Path}}{{end}}{{end}}"}
+			if len(p.Tags) > 0 {
+				args = append(args, "-tags", strings.Join(p.Tags, ","))
+			}
+			out, err := goCmd(ctx, workDir, p.Env, append(args, ".")...)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %v", p.Target, err)
+				return
+			}
+			linked[i] = make(map[string]bool)
+			for _, line := range strings.Fields(string(out)) {
+				linked[i][line] = true
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return linked, nil
+}
+
+// Write writes the snapshot to versionDir/deps.json
+func Write(versionDir string, snap *Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(filepath.Join(versionDir, FileName), data, 0644)
+}
+
+// Read loads the snapshot from a version directory
+func Read(versionDir string) (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(versionDir, FileName))
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", FileName, err)
+	}
+	return &snap, nil
+}
+
+// Verification is the outcome of go mod verify
+type Verification struct {
+	Verified bool `json:"verified"`
+	// Problems are go mod verify's findings, e.g. "example.com/m v1.2.0: dir has been modified (...)"
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Verify runs go mod verify in workDir, which checks the downloaded modules
+// against go.sum; the error is for a check that could not run at all
+func Verify(ctx context.Context, workDir string) (*Verification, error) {
+	cmd := exec.CommandContext(ctx, "go", "mod", "verify")
+	cmd.Dir = workDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		return &Verification{Verified: true}, nil
+	} else if _, ok := err.(*exec.ExitError); !ok {
+		return nil, fmt.Errorf("go mod verify failed: %v", err)
+	}
+	v := &Verification{}
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		// Findings name module and version; the go command's own errors start with "go:"
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "go:") {
+			v.Problems = append(v.Problems, line)
+		}
+	}
+	if len(v.Problems) == 0 {
+		return nil, fmt.Errorf("go mod verify failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return v, nil
+}
+
+// OutdatedFile is the report --deps-outdated writes into the version directory
+const OutdatedFile = "deps-outdated.md"
+
+// Dependency is a direct requirement of the main module
+type Dependency struct {
+	Path    string
+	Version string
+	Time    time.Time
+	// Latest is the newest version when it is newer than Version
+	Latest     string
+	LatestTime time.Time
+}
+
+// Outdated lists the direct requirements of the main module in workDir with
+// the newest versions the module proxy knows (go list -u -m all). Modules
+// replaced by a directory have no versions to compare and are left out.
+func Outdated(ctx context.Context, workDir string) (main string, list []Dependency, err error) {
+	if _, err := os.Stat(filepath.Join(workDir, "go.mod")); err != nil {
+		return "", nil, fmt.Errorf("no go.mod in %s", workDir)
+	}
+	out, err := goCmd(ctx, workDir, nil, "list", "-u", "-m", "-json", "all")
+	if err != nil {
+		return "", nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var m listModule
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", nil, fmt.Errorf("failed to parse go list output: %v", err)
+		}
+		switch {
+		case m.Main:
+			main = m.Path
+			continue
+		case m.Indirect, m.Replace != nil && m.Replace.Version == "":
+			continue
+		}
+		d := Dependency{Path: m.Path, Version: m.Version}
+		if m.Time != nil {
+			d.Time = *m.Time
+		}
+		if m.Update != nil {
+			d.Latest = m.Update.Version
+			if m.Update.Time != nil {
+				d.LatestTime = *m.Update.Time
+			}
+		}
+		list = append(list, d)
+	}
+	return main, list, nil
+}
+
+// OutdatedReport renders the outdated dependencies of list as Markdown
+func OutdatedReport(main string, list []Dependency, checked time.Time) []byte {
+	var outdated []Dependency
+	for _, d := range list {
+		if d.Latest != "" {
+			outdated = append(outdated, d)
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Outdated dependencies of %s\n\n", main)
+	fmt.Fprintf(&b, "%d of %d direct dependencies have newer versions (checked %s).\n", len(outdated), len(list), checked.UTC().Format("2006-01-02"))
+	if len(outdated) == 0 {
+		return []byte(b.String())
+	}
+	b.WriteString("\n| Module | Version | Released | Latest | Released | Behind |\n|--------|---------|----------|--------|----------|--------|\n")
+	for _, d := range outdated {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", d.Path, d.Version, day(d.Time), d.Latest, day(d.LatestTime), Behind(d))
+	}
+	return []byte(b.String())
+}
+
+// Behind is how much older Version is than Latest, e.g. "14 months"; empty
+// when the proxy did not report both release times
+func Behind(d Dependency) string {
+	if d.Time.IsZero() || d.LatestTime.IsZero() || !d.LatestTime.After(d.Time) {
+		return ""
+	}
+	days := int(d.LatestTime.Sub(d.Time).Hours() / 24)
+	switch {
+	case days >= 60:
+		return fmt.Sprintf("%d months", days/30)
+	case days == 1:
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// day formats a release time, or "" when it is unknown
+func day(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/deps"
+	"pbuild/fsutil"
+)
+
+// newDepsCmd returns the `pbuild deps` subcommand
+func newDepsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deps",
+		Short: "Inspect the module's dependencies",
+	}
+	outdated := &cobra.Command{
+		Use:   "outdated [TARGET_DIR]",
+		Short: "List direct dependencies with newer versions available",
+		Long: "Asks the module proxy for the newest version of every direct dependency\n" +
+			"(go list -u -m all) and lists those with a newer one. A build with\n" +
+			"--deps-outdated writes the same list into the version directory as " + deps.OutdatedFile + ".",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDepsOutdated(targetArg(args))
+		},
+	}
+	cmd.AddCommand(outdated)
+	return cmd
+}
+
+// runDepsOutdated prints the outdated direct dependencies of the module in targetDir
+func runDepsOutdated(targetDir string) error {
+	abs, err := filepath.Abs(targetDir)
+	if err != nil {
+		return err
+	}
+	root, err := fsutil.FindModuleRoot(abs)
+	if err != nil {
+		return fmt.Errorf("no go.mod found in or above %s", abs)
+	}
+	main, list, err := deps.Outdated(context.Background(), root)
+	if err != nil {
+		return err
+	}
+
+	width, count := 0, 0
+	for _, d := range list {
+		if d.Latest != "" {
+			width = max(width, len(d.Path))
+			count++
+		}
+	}
+	for _, d := range list {
+		if d.Latest == "" {
+			continue
+		}
+		line := fmt.Sprintf("  %-*s  %s -> %s", width, d.Path, d.Version, d.Latest)
+		if behind := deps.Behind(d); behind != "" {
+			line += fmt.Sprintf("  (%s behind)", behind)
+		}
+		fmt.Println(line)
+	}
+	if count > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%d of %d direct dependencies of %s have newer versions\n", count, len(list), main)
+	return nil
+}
+
+// writeOutdated writes the outdated dependency report of --deps-outdated into versionDir
+func writeOutdated(p *project, versionDir string) {
+	if !flagDepsOutdated {
+		return
+	}
+	main, list, err := deps.Outdated(context.Background(), p.workDir)
+	if err == nil {
+		err = fsutil.WriteFileAtomic(filepath.Join(versionDir, deps.OutdatedFile), deps.OutdatedReport(main, list, time.Now()), 0644)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to write %s: %v\n", deps.OutdatedFile, err)
+		return
+	}
+	fmt.Printf("Outdated dependency report written to: %s\n", filepath.Join(versionDir, deps.OutdatedFile))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/buildmeta"
+	"pbuild/deps"
+	"pbuild/fsutil"
+	"pbuild/metadiff"
+	"pbuild/ui"
+)
+
+// newDiffMetaCmd returns the `pbuild diff-meta` subcommand
+func newDiffMetaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff-meta PREV_VERSION_DIR [VERSION_DIR]",
+		Short: "Show what changed between two builds: settings, Go version, dependencies and sizes",
+		Long: "Compares the build-metadata.json (and deps.json, when present) of two version\n" +
+			"directories. VERSION_DIR defaults to <output-dir>/latest of the module in the\n" +
+			"current directory. Either argument may also be a build-metadata.json file.",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			newDir := ""
+			if len(args) == 2 {
+				newDir = args[1]
+			} else {
+				workDir, err := fsutil.FindModuleRoot(".")
+				if err != nil {
+					return err
+				}
+				newDir = filepath.Join(outputDir(workDir), "latest")
+			}
+			return runDiffMeta(args[0], newDir)
+		},
+	}
+	cmd.Flags().StringVar(&flagOutDir, "output-dir", "builds", "directory for build artifacts")
+	return cmd
+}
+
+// versionDirOf returns the directory holding a metadata file or version directory
+func versionDirOf(path string) string {
+	if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+		return filepath.Dir(path)
+	}
+	return path
+}
+
+// runDiffMeta prints the differences between two builds
+func runDiffMeta(oldPath, newPath string) error {
+	oldMeta, err := buildmeta.Read(oldPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", oldPath, err)
+	}
+	newMeta, err := buildmeta.Read(newPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", newPath, err)
+	}
+	// Builds from before deps.json simply skip the dependency section
+	oldDeps, _ := deps.Read(versionDirOf(oldPath))
+	newDeps, _ := deps.Read(versionDirOf(newPath))
+
+	d := metadiff.Compare(oldMeta, newMeta, oldDeps, newDeps)
+	fmt.Printf("Changes from %s (%s) to %s (%s)\n",
+		d.OldVersion, oldMeta.BuildTime.Format("2006-01-02 15:04"), d.NewVersion, newMeta.BuildTime.Format("2006-01-02 15:04"))
+
+	printChanges("Build environment", d.Build)
+	printChanges("Build configuration", d.Config)
+	printChanges("Flags", d.Flags)
+
+	fmt.Println("\nDependencies")
+	switch {
+	case !d.DepsCompared:
+		fmt.Printf("  not compared: %s missing from one of the builds\n", deps.FileName)
+	case len(d.Deps) == 0:
+		fmt.Println("  no changes")
+	}
+	for _, c := range d.Deps {
+		switch {
+		case c.Old == "":
+			fmt.Printf("  %s %s %s\n", ui.Green("+"), c.Path, c.New)
+		case c.New == "":
+			fmt.Printf("  %s %s %s\n", ui.Red("-"), c.Path, c.Old)
+		case c.SumChanged:
+			fmt.Printf("  %s %s %s (go.sum hash changed)\n", ui.Red("!"), c.Path, c.New)
+		default:
+			fmt.Printf("  ~ %s %s -> %s\n", c.Path, c.Old, c.New)
+		}
+	}
+
+	fmt.Println("\nTargets")
+	renderTargetChanges(d.Targets)
+	return nil
+}
+
+// printChanges prints one section of old -> new settings
+func printChanges(title string, changes []metadiff.Change) {
+	fmt.Printf("\n%s\n", title)
+	if len(changes) == 0 {
+		fmt.Println("  no changes")
+		return
+	}
+	width := 0
+	for _, c := range changes {
+		width = max(width, len(c.Name))
+	}
+	for _, c := range changes {
+		fmt.Printf("  %-*s  %s -> %s\n", width, c.Name, orUnset(c.Old), orUnset(c.New))
+	}
+}
+
+func orUnset(s string) string {
+	if s == "" {
+		return "(unset)"
+	}
+	return s
+}
+
+// renderTargetChanges prints sizes and status per target with the size delta
+func renderTargetChanges(changes []metadiff.TargetChange) {
+	tbl := newGridTable(os.Stdout)
+	tbl.Header([]string{"Target", "Previous", "Current", "Delta", "Status"})
+	data := make([][]any, 0, len(changes))
+	for _, c := range changes {
+		size := func(n int64, status string) string {
+			if status != "ok" {
+				return "-"
+			}
+			return fsutil.HumanSizeBytes(n)
+		}
+		delta := "-"
+		if c.OldStatus == "ok" && c.NewStatus == "ok" {
+			delta = sizeDelta(c.OldSize, c.NewSize)
+		}
+		status := orNone(c.NewStatus)
+		if c.OldStatus != c.NewStatus {
+			status = orNone(c.OldStatus) + " -> " + orNone(c.NewStatus)
+		}
+		data = append(data, []any{c.Target, size(c.OldSize, c.OldStatus), size(c.NewSize, c.NewStatus), delta, status})
+	}
+	_ = tbl.Bulk(data)
+	_ = tbl.Render()
+}
+
+// sizeDelta renders a size change in bytes and percent, e.g. "+4096 B (+2.0%)"
+func sizeDelta(old, new int64) string {
+	delta := fmt.Sprintf("%+d B", new-old)
+	if old > 0 {
+		delta += fmt.Sprintf(" (%+.1f%%)", float64(new-old)*100/float64(old))
+	}
+	return delta
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "absent"
+	}
+	return s
+}
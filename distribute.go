@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"pbuild/buildmeta"
+	"pbuild/config"
+	"pbuild/fsutil"
+	"pbuild/ipfs"
+	"pbuild/torrent"
+)
+
+// distribute writes the .torrent and pins the version directory to IPFS as
+// requested by --torrent and --ipfs; failures are warnings, like the other
+// run-level files
+func distribute(ctx context.Context, p *project, rows []summaryRow, versionDir string) *buildmeta.Distribution {
+	if !flagTorrent && !flagIPFS {
+		return nil
+	}
+	files := distributedFiles(rows, versionDir)
+	if len(files) == 0 {
+		fmt.Println("Warning: Nothing to distribute, no target was built")
+		return nil
+	}
+	dist := &buildmeta.Distribution{}
+	name := filepath.Base(versionDir)
+
+	if flagTorrent {
+		if err := writeTorrent(p, versionDir, name, files, dist); err != nil {
+			fmt.Printf("Warning: Failed to create the torrent: %v\n", err)
+		}
+	}
+	if flagIPFS {
+		api := p.cfg.Distribute.IPFS.API
+		if api == "" {
+			api = ipfs.DefaultAPI
+		}
+		fmt.Printf("Pinning %d files to IPFS through %s...\n", len(files), api)
+		cid, err := ipfs.Add(ctx, api, versionDir, name, files)
+		if err != nil {
+			fmt.Printf("Warning: Failed to pin to IPFS: %v\n", err)
+		} else {
+			dist.IPFSCID = cid
+			fmt.Printf("Pinned as ipfs://%s\n", cid)
+		}
+	}
+	fmt.Println()
+	if *dist == (buildmeta.Distribution{}) {
+		return nil
+	}
+	return dist
+}
+
+// writeTorrent creates <project>-<version>.torrent in the version directory
+func writeTorrent(p *project, versionDir, name string, files []string, dist *buildmeta.Distribution) error {
+	cfg := p.cfg.Distribute.Torrent
+	var seeds []string
+	for i, s := range cfg.WebSeeds {
+		seed, err := expandWebSeed(p, s)
+		if err != nil {
+			return fmt.Errorf("distribute.torrent.web_seeds[%d] in %s: %v", i, config.FileName, err)
+		}
+		seeds = append(seeds, seed)
+	}
+	t, err := torrent.Create(versionDir, name, files, torrent.Options{
+		Trackers:  cfg.Trackers,
+		WebSeeds:  seeds,
+		CreatedBy: "pbuild " + appVersion,
+	})
+	if err != nil {
+		return err
+	}
+	file := p.name + "-" + name + ".torrent"
+	if err := fsutil.WriteFileAtomic(filepath.Join(versionDir, file), t.Data, 0644); err != nil {
+		return err
+	}
+	dist.Torrent, dist.InfoHash, dist.Magnet = file, t.InfoHash, t.Magnet()
+	fmt.Printf("Torrent of %d files written to: %s (info hash %s)\n", len(files), filepath.Join(versionDir, file), t.InfoHash)
+	return nil
+}
+
+// expandWebSeed fills in a web seed template with the publish path data
+func expandWebSeed(p *project, s string) (string, error) {
+	tmpl, err := template.New("web seed").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, pathData(p, "")); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// distributedFiles lists what --publish uploads from the version directory,
+// relative to it, except the metadata and reports written after distribution
+func distributedFiles(rows []summaryRow, versionDir string) []string {
+	var candidates []string
+	for _, r := range rows {
+		if !r.Success {
+			continue
+		}
+		candidates = append(candidates, r.File, r.File+".hash", r.File+buildmeta.SidecarSuffix)
+		if r.Archive != "" {
+			candidates = append(candidates, r.Archive, r.Archive+".hash")
+		}
+		candidates = append(candidates, r.Signatures...)
+	}
+	for _, name := range runFiles(versionDir) {
+		if name == buildmeta.FileName || strings.HasPrefix(name, "build-report.") || strings.HasSuffix(name, ".torrent") {
+			continue
+		}
+		candidates = append(candidates, name)
+	}
+	var files []string
+	seen := map[string]bool{}
+	for _, name := range candidates {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if _, err := os.Stat(filepath.Join(versionDir, filepath.FromSlash(name))); err == nil {
+			files = append(files, name)
+		}
+	}
+	return files
+}
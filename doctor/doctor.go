@@ -0,0 +1,167 @@
+package doctor
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"pbuild/targets"
+	"pbuild/toolprobe"
+)
+
+// probeTimeout bounds each `<tool> --version` probe
+const probeTimeout = 5 * time.Second
+
+// Check is the outcome of one environment check
+type Check struct {
+	Name   string
+	OK     bool
+	Detail string
+	// Hint is the remediation printed when the check fails
+	Hint string
+	// Required checks make doctor exit non-zero when they fail
+	Required bool
+}
+
+// tool describes an external program pbuild features depend on
+type tool struct {
+	name     string
+	binaries []string
+	args     []string
+	hint     string
+	required bool
+}
+
+var tools = []tool{
+	{name: "go", binaries: []string{"go"}, args: []string{"version"}, required: true,
+		hint: "install Go from https://go.dev/dl and make sure it is in PATH"},
+	{name: "git", binaries: []string{"git"}, args: []string{"--version"},
+		hint: "install git; without it versions fall back to \"unknown\" and dirty detection is disabled"},
+	{name: "upx", binaries: []string{"upx"}, args: []string{"--version"},
+		hint: "install upx (https://upx.github.io) to pack binaries"},
+	{name: "gpg", binaries: []string{"gpg", "gpg2"}, args: []string{"--version"},
+		hint: "install GnuPG to sign checksum files"},
+	{name: "cosign", binaries: []string{"cosign"}, args: []string{"version"},
+		hint: "install cosign (https://docs.sigstore.dev) for keyless signing"},
+	{name: "minisign", binaries: []string{"minisign"}, args: []string{"-v"},
+		hint: "install minisign for lightweight signatures"},
+	{name: "zig", binaries: []string{"zig"}, args: []string{"version"},
+		hint: "install zig (https://ziglang.org) to use `zig cc` as a CGO cross compiler for linux, windows and darwin"},
+	{name: "docker", binaries: []string{"docker", "podman"}, args: []string{"--version"},
+		hint: "install docker or podman for container-based cross builds"},
+	{name: "qemu", binaries: []string{"qemu-aarch64-static", "qemu-aarch64", "qemu-riscv64-static", "qemu-riscv64"}, args: []string{"--version"},
+		hint: "install qemu-user-static to run foreign-architecture binaries (smoke tests)"},
+}
+
+// crossCompilers lists conventional CGO C compilers per target
+var crossCompilers = map[targets.Target][]string{
+	{OS: "linux", Arch: "amd64"}:    {"x86_64-linux-gnu-gcc"},
+	{OS: "linux", Arch: "arm64"}:    {"aarch64-linux-gnu-gcc"},
+	{OS: "linux", Arch: "arm"}:      {"arm-linux-gnueabihf-gcc"},
+	{OS: "linux", Arch: "riscv64"}:  {"riscv64-linux-gnu-gcc"},
+	{OS: "linux", Arch: "ppc64le"}:  {"powerpc64le-linux-gnu-gcc"},
+	{OS: "linux", Arch: "s390x"}:    {"s390x-linux-gnu-gcc"},
+	{OS: "linux", Arch: "386"}:      {"i686-linux-gnu-gcc"},
+	{OS: "windows", Arch: "amd64"}:  {"x86_64-w64-mingw32-gcc"},
+	{OS: "windows", Arch: "386"}:    {"i686-w64-mingw32-gcc"},
+	{OS: "windows", Arch: "arm64"}:  {"aarch64-w64-mingw32-clang"},
+	{OS: "darwin", Arch: "amd64"}:   {"o64-clang"},
+	{OS: "darwin", Arch: "arm64"}:   {"oa64-clang"},
+	{OS: "android", Arch: "arm64"}:  {"aarch64-linux-android21-clang"},
+	{OS: "android", Arch: "amd64"}:  {"x86_64-linux-android21-clang"},
+	{OS: "freebsd", Arch: "amd64"}:  {"x86_64-unknown-freebsd-clang"},
+	{OS: "linux", Arch: "mips64le"}: {"mips64el-linux-gnuabi64-gcc"},
+	{OS: "linux", Arch: "loong64"}:  {"loongarch64-linux-gnu-gcc"},
+}
+
+// zigTargets are the operating systems `zig cc` can cross compile C for
+var zigTargets = map[string]bool{"linux": true, "windows": true, "darwin": true}
+
+// probe finds the first available binary and returns its path and first line of version output
+func probe(binaries []string, args []string) (string, string, bool) {
+	for _, bin := range binaries {
+		path, err := exec.LookPath(bin)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		out, _ := exec.CommandContext(ctx, path, args...).CombinedOutput()
+		cancel()
+		line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		return path, strings.TrimSpace(line), true
+	}
+	return "", "", false
+}
+
+// lookup finds the first available binary without running it
+func lookup(binaries []string) (string, bool) {
+	for _, bin := range binaries {
+		if path, err := exec.LookPath(bin); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Tools checks the external programs used by pbuild features
+func Tools() []Check {
+	checks := make([]Check, 0, len(tools))
+	for _, t := range tools {
+		path, version, ok := probe(t.binaries, t.args)
+		c := Check{Name: t.name, OK: ok, Required: t.required}
+		if ok {
+			c.Detail = version
+			if c.Detail == "" {
+				c.Detail = path
+			}
+		} else {
+			c.Detail = "not found"
+			c.Hint = t.hint
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// Targets reports per-target readiness. Without CGO every target the Go toolchain
+// supports is ready; with CGO each target needs a matching C cross compiler.
+// The toolchain's targets and zig come from pr.
+func Targets(ctx context.Context, pr *toolprobe.Prober, matrix []targets.Target, cgo bool) []Check {
+	tc, _ := pr.Toolchain(ctx) // nil without a go command, reported by Tools
+	_, haveZig := pr.Zig(ctx)
+	hostPath, haveHostCC := lookup([]string{"cc", "gcc", "clang"})
+
+	checks := make([]Check, 0, len(matrix))
+	for _, t := range matrix {
+		c := Check{Name: t.String()}
+		switch {
+		case !targets.Supported(t) || (tc != nil && !tc.Supports(t)):
+			c.Detail = "not supported by the Go toolchain"
+			c.Hint = "remove it from the matrix (see `go tool dist list`)"
+		case !cgo:
+			c.OK = true
+			c.Detail = "pure Go, no C toolchain needed"
+		case t.OS == runtime.GOOS && t.Arch == runtime.GOARCH && haveHostCC:
+			c.OK = true
+			c.Detail = "host C compiler " + hostPath
+		default:
+			if path, ok := lookup(crossCompilers[t]); ok {
+				c.OK = true
+				c.Detail = "CC=" + path
+			} else if haveZig && zigTargets[t.OS] {
+				c.OK = true
+				c.Detail = "zig cc available"
+			} else {
+				c.Detail = "no C cross compiler found"
+				c.Hint = "use --strategy purego, install zig, or install " + strings.Join(append(crossCompilers[t], "a cross gcc/clang"), " / ")
+			}
+		}
+		if c.OK && tc != nil && tc.Broken(t) {
+			c.Detail += "; a broken port in " + tc.Version
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
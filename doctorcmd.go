@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/doctor"
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/ui"
+)
+
+var flagDoctorGroup string
+
+// newDoctorCmd returns the `pbuild doctor` subcommand
+func newDoctorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor [TARGET_DIR]",
+		Short: "Check the local toolchain and per-target cross-compilation readiness",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDoctor(targetArg(args))
+		},
+	}
+	cmd.Flags().StringVar(&flagDoctorGroup, "target-group", "default", "target groups to check (comma-separated)")
+	return cmd
+}
+
+// printChecks renders checks as a table followed by remediation hints
+func printChecks(title string, checks []doctor.Check) (failedRequired bool) {
+	tbl := newGridTable(os.Stdout)
+	tbl.Header([]string{title, "Status", "Detail"})
+	var hints []doctor.Check
+	for _, c := range checks {
+		status := ui.OK()
+		if !c.OK {
+			status = ui.Fail()
+			hints = append(hints, c)
+			if c.Required {
+				failedRequired = true
+			}
+		}
+		_ = tbl.Append([]any{c.Name, status, c.Detail})
+	}
+	_ = tbl.Render()
+
+	if len(hints) > 0 {
+		fmt.Println()
+		for _, c := range hints {
+			fmt.Printf("  %s: %s\n", c.Name, c.Hint)
+		}
+	}
+	fmt.Println()
+	return failedRequired
+}
+
+func runDoctor(targetDir string) error {
+	workDir := targetDir
+	if modRoot, err := fsutil.FindModuleRoot(targetDir); err == nil {
+		workDir = modRoot
+	}
+	cfg, err := loadConfig(workDir, flagConfig)
+	if err != nil {
+		return err
+	}
+
+	failed := printChecks("Tool", doctor.Tools())
+
+	// doctor looks afresh and refreshes the probe cache for the runs after it
+	probes := newToolProber(workDir)
+	probes.Refresh = true
+	defer saveProbes(probes)
+
+	strategy := getBuildStrategy(flagStrategy, getBuildMode(flagBuildMode))
+	for _, name := range splitList(flagDoctorGroup) {
+		group, err := resolveGroup(cfg, name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Target readiness for group %q (strategy %s)\n\n", name, strategy)
+		printChecks("Target", doctor.Targets(context.Background(), probes, group, strategy == gobuild.FlexibleCGO))
+	}
+
+	if failed {
+		return errors.New("required tools are missing")
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"pbuild/embedcheck"
+)
+
+// checkEmbeddedFiles warns about //go:embed files that do not match the
+// commit, so a release does not ship uncommitted or stale assets
+func checkEmbeddedFiles(p *project) {
+	findings, err := embedcheck.Check(context.Background(), p.workDir, p.gitRoot)
+	if err != nil {
+		// Outside git there is nothing to compare with
+		if flagVerbose {
+			fmt.Printf("Embedded files not checked: %v\n", err)
+		}
+		return
+	}
+	for _, f := range findings {
+		fmt.Printf("Warning: embedded file %s (%s) %s\n", f.File, f.Package, f.Reason)
+	}
+	if len(findings) > 0 {
+		fmt.Println()
+	}
+}
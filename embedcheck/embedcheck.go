@@ -0,0 +1,92 @@
+package embedcheck
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pbuild/gitmeta"
+)
+
+// Finding is a //go:embed file that may not match the commit being built
+type Finding struct {
+	Package string
+	// File is relative to the repository root, with forward slashes
+	File   string
+	Reason string
+}
+
+// embedTemplate prints dir, import path and file for every embedded file of
+// the main module's packages
+const embedTemplate = `{{if and .Module .Module.Main}}{{range .EmbedFiles}}{{$.Dir}}{{"\t"}}{{$.ImportPath}}{{"\t"}}{{.}}{{"\n"}}{{end}}{{end}}`
+
+// Check lists the files the module's packages embed and reports those that
+// differ from HEAD: changed or untracked files, and files git ignores (build
+// output such as a frontend bundle) that were written before the last commit
+// and may be stale
+func Check(ctx context.Context, workDir, gitRoot string) ([]Finding, error) {
+	cmd := exec.CommandContext(ctx, "go", "list", "-deps", "-f", embedTemplate, ".")
+	cmd.Dir = workDir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil, nil
+	}
+
+	changes, err := gitmeta.Changes(gitRoot)
+	if err != nil {
+		return nil, err
+	}
+	tracked, err := gitmeta.TrackedFiles(gitRoot)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := gitmeta.CommitTime(gitRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		path := filepath.Join(fields[0], filepath.FromSlash(fields[2]))
+		rel, err := filepath.Rel(gitRoot, path)
+		if err != nil {
+			continue
+		}
+		f := Finding{Package: fields[1], File: filepath.ToSlash(rel)}
+		if _, changed := changes[f.File]; changed {
+			f.Reason = "changed since the last commit"
+			if !tracked[f.File] {
+				f.Reason = "not committed"
+			}
+			findings = append(findings, f)
+			continue
+		}
+		if tracked[f.File] {
+			continue
+		}
+		// Ignored files are build output; only their age tells
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if fi.ModTime().Before(committed) {
+			f.Reason = fmt.Sprintf("not in git and written %s, before the last commit (%s); it may be stale",
+				fi.ModTime().Format("2006-01-02 15:04"), committed.Format("2006-01-02 15:04"))
+			findings = append(findings, f)
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].File < findings[j].File })
+	return findings, nil
+}
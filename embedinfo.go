@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"pbuild/gitmeta"
+	"pbuild/pipeline"
+	"pbuild/selfinfo"
+)
+
+// infoEmbedder links the build info into each binary as --embed-info asks:
+// "var" sets main.pbuildInfo, an importpath.name sets that variable instead,
+// and "section" also writes it into a section of ELF binaries
+type infoEmbedder struct {
+	p        *project
+	variable string
+	section  bool
+	commit   string
+	warnOnce sync.Once
+}
+
+// newInfoEmbedder validates --embed-info; it returns nil when the flag is unset
+func newInfoEmbedder(p *project) (*infoEmbedder, error) {
+	e := &infoEmbedder{p: p, variable: selfinfo.DefaultVar}
+	switch flagEmbedInfo {
+	case "":
+		return nil, nil
+	case "var":
+	case "section":
+		e.section = true
+	default:
+		if !selfinfo.ValidVar(flagEmbedInfo) {
+			return nil, fmt.Errorf("invalid --embed-info %q (expected var, section or an importpath.name variable)", flagEmbedInfo)
+		}
+		e.variable = flagEmbedInfo
+	}
+	e.commit, _ = gitmeta.ResolveHEAD(p.gitRoot)
+	return e, nil
+}
+
+// info describes the artifact's build
+func (e *infoEmbedder) info(a *pipeline.Artifact) selfinfo.Info {
+	return selfinfo.Info{
+		Project:   e.p.name,
+		Version:   e.p.version,
+		Commit:    e.commit,
+		BuildTime: time.Now().UTC().Truncate(time.Second),
+		Target:    a.Target.String(),
+		PBuild:    appVersion,
+	}
+}
+
+// finish adds the section to the compiled binary and warns once when the info
+// cannot be found in it, i.e. the program does not declare the variable
+func (e *infoEmbedder) finish(a *pipeline.Artifact, info selfinfo.Info) error {
+	if e.section && selfinfo.IsELF(a.Temp) {
+		if err := selfinfo.AddSection(a.Temp, selfinfo.Section, selfinfo.Marshal(info)); err != nil {
+			return fmt.Errorf("failed to add %s section: %v", selfinfo.Section, err)
+		}
+	}
+	if _, err := selfinfo.Extract(a.Temp); errors.Is(err, selfinfo.ErrNotFound) {
+		e.warnOnce.Do(func() {
+			i := strings.LastIndex(e.variable, ".")
+			fmt.Printf("  WARNING: %s is not linked into %s, so it has no embedded build info; declare `var %s string` in package %s and use it\n",
+				e.variable, a.Target.String(), e.variable[i+1:], e.variable[:i])
+		})
+	}
+	return nil
+}
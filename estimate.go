@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"pbuild/buildmeta"
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/targets"
+)
+
+// outputEstimate is the predicted size of a run's version directory
+type outputEstimate struct {
+	total  int64
+	source string // the build the sizes come from
+}
+
+// previousBuild returns the newest build in outDir with metadata
+func previousBuild(outDir string) (*buildmeta.BuildMetadata, string) {
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return nil, ""
+	}
+	var newest *buildmeta.BuildMetadata
+	var newestDir string
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == "latest" {
+			continue
+		}
+		dir := filepath.Join(outDir, e.Name())
+		meta, err := buildmeta.Read(dir)
+		if err != nil || meta.SuccessCount == 0 {
+			continue
+		}
+		if newest == nil || meta.BuildTime.After(newest.BuildTime) {
+			newest, newestDir = meta, dir
+		}
+	}
+	return newest, newestDir
+}
+
+// estimateOutput predicts the artifacts (and archives) of the targets from the
+// previous build in outDir. Targets that build has no size for get the
+// average; without any previous build, a host build is measured when probe
+// is set, and the estimate is nil otherwise.
+func estimateOutput(p *project, outDir string, matrix []targets.Target, archiving, probe bool) (*outputEstimate, error) {
+	sizes := make(map[string]int64)
+	archiveSizes := make(map[string]int64)
+	var est outputEstimate
+
+	if prev, dir := previousBuild(outDir); prev != nil {
+		est.source = prev.Version
+		for _, r := range prev.Results {
+			if !r.Success || r.Size == 0 {
+				continue
+			}
+			sizes[r.Target] = r.Size
+			if r.Archive != "" {
+				if sz, err := fsutil.FileSize(filepath.Join(dir, r.Archive)); err == nil {
+					archiveSizes[r.Target] = sz
+				}
+			}
+		}
+	}
+	if len(sizes) == 0 {
+		if !probe {
+			return nil, nil
+		}
+		size, err := hostBuildSize(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to estimate the output size: %v", err)
+		}
+		host := targets.Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+		sizes[host.String()] = size
+		est.source = "a host build"
+	}
+
+	var sum int64
+	for _, size := range sizes {
+		sum += size
+	}
+	average := sum / int64(len(sizes))
+
+	for _, t := range matrix {
+		size, known := sizes[t.String()]
+		if !known {
+			size = average
+			// A universal binary holds two architectures
+			if t.Arch == targets.DarwinUniversal {
+				size *= 2
+			}
+		}
+		est.total += size
+		if archiving {
+			if sz, ok := archiveSizes[t.String()]; ok {
+				est.total += sz
+			} else {
+				est.total += size
+			}
+		}
+	}
+	return &est, nil
+}
+
+// hostBuildSize builds the host target into a temp file and returns its size
+func hostBuildSize(p *project) (int64, error) {
+	tmp, err := os.MkdirTemp("", "pbuild-estimate-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+	bin := filepath.Join(tmp, "estimate")
+	host := targets.Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if err := gobuild.BuildWithConfig(context.Background(), p.workDir, host, bin, newBuildConfig(p)); err != nil {
+		return 0, err
+	}
+	return fsutil.FileSize(bin)
+}
+
+// checkOutputSize prints the estimated output and enforces --max-output-size
+func checkOutputSize(p *project, outDir string, matrix []targets.Target, archiving bool) error {
+	var limit int64
+	if flagMaxOutput != "" {
+		var err error
+		if limit, err = fsutil.ParseSize(flagMaxOutput); err != nil {
+			return fmt.Errorf("invalid --max-output-size: %v", err)
+		}
+	}
+	est, err := estimateOutput(p, outDir, matrix, archiving, limit > 0)
+	if err != nil || est == nil {
+		return err
+	}
+	fmt.Printf("Estimated output: %s for %d targets (sizes from %s)\n", fsutil.HumanSizeBytes(est.total), len(matrix), est.source)
+	if limit > 0 && est.total > limit {
+		return fmt.Errorf("estimated output of %s exceeds --max-output-size %s; build fewer targets or raise the limit",
+			fsutil.HumanSizeBytes(est.total), fsutil.HumanSizeBytes(limit))
+	}
+	return nil
+}
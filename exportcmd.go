@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/fsutil"
+)
+
+var (
+	flagExportOutput string
+	flagExportForce  bool
+)
+
+// exportFormat is a task runner `pbuild export` writes a file for
+type exportFormat struct {
+	name     string
+	file     string
+	template string
+}
+
+var exportFormats = []exportFormat{
+	{name: "make", file: "Makefile", template: makefileTemplate},
+	{name: "task", file: "Taskfile.yml", template: taskfileTemplate},
+}
+
+// exportTask is a target of the generated file
+type exportTask struct {
+	Name    string
+	Desc    string
+	Command string
+}
+
+// newExportCmd returns the `pbuild export` subcommand
+func newExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write a Makefile or Taskfile wrapping the common pbuild invocations",
+		Long: "Writes build, release, clean, verify, install and doctor targets that run\n" +
+			"pbuild with the project's targets and the release flags its pbuild.yaml calls\n" +
+			"for, so a team runs `make release` or `task release` instead of remembering\n" +
+			"flags. The flags are variables that can be overridden per call.",
+	}
+	for _, f := range exportFormats {
+		f := f
+		sub := &cobra.Command{
+			Use:   f.name + " [TARGET_DIR]",
+			Short: "Write a " + f.file + " into the module root",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return runExport(f, targetArg(args))
+			},
+		}
+		cmd.AddCommand(sub)
+	}
+	cmd.PersistentFlags().StringVarP(&flagExportOutput, "output", "o", "", "file to write, - for stdout (default: Makefile or Taskfile.yml in the module root)")
+	cmd.PersistentFlags().BoolVar(&flagExportForce, "force", false, "overwrite an existing file")
+	cmd.PersistentFlags().BoolVar(&flagAll, "all", false, "build for all predefined targets")
+	cmd.PersistentFlags().StringVar(&flagTargetGroup, "target-group", "", "build named target groups (comma-separated)")
+	cmd.PersistentFlags().StringVar(&flagTargets, "targets", "", "build explicit targets as GOOS/GOARCH (comma-separated)")
+	cmd.PersistentFlags().BoolVar(&flagUniversal, "darwin-universal", false, "also build a universal darwin binary (amd64 + arm64)")
+	cmd.PersistentFlags().StringVar(&flagOutDir, "output-dir", "builds", "directory for build artifacts")
+	return cmd
+}
+
+// runExport renders the task runner file for the project in targetDir
+func runExport(f exportFormat, targetDir string) error {
+	p, err := resolveProject(targetDir)
+	if err != nil {
+		return err
+	}
+	// Fail now on target flags a build would reject
+	if _, err := resolveMatrix(p.cfg); err != nil {
+		return err
+	}
+
+	data := struct {
+		Project      string
+		BuildFlags   string
+		ReleaseFlags string
+		OutputDir    string
+		Tasks        []exportTask
+	}{
+		Project:      p.name,
+		BuildFlags:   strings.Join(exportBuildFlags(), " "),
+		ReleaseFlags: strings.Join(exportReleaseFlags(p), " "),
+		OutputDir:    flagOutDir,
+	}
+	doctor := "PBUILD doctor"
+	if flagTargetGroup != "" {
+		doctor += " --target-group " + flagTargetGroup
+	}
+	data.Tasks = []exportTask{
+		{"build", "Cross-compile the target matrix", "PBUILD PBUILD_FLAGS --output-dir OUTPUT_DIR"},
+		{"release", "Build a release with checksums, tag check and the configured extras", "PBUILD PBUILD_FLAGS RELEASE_FLAGS --output-dir OUTPUT_DIR"},
+		{"clean", "Remove the build output", "rm -rf OUTPUT_DIR"},
+		{"verify", "Check the digests and signatures of the latest build", "PBUILD verify-release OUTPUT_DIR/latest"},
+		{"install", "Build the host binary and install it", "PBUILD install"},
+		{"doctor", "Check the toolchain and cross-compilation readiness", doctor},
+	}
+
+	tmpl, err := template.New(f.file).Funcs(template.FuncMap{"vars": exportVars(f.name)}).Parse(f.template)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return err
+	}
+
+	if flagExportOutput == "-" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+	path := flagExportOutput
+	if path == "" {
+		path = filepath.Join(p.workDir, f.file)
+	}
+	if _, err := os.Stat(path); err == nil && !flagExportForce {
+		return fmt.Errorf("%s already exists; use --force to overwrite it or --output to write elsewhere", path)
+	}
+	if err := fsutil.WriteFileAtomic(path, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s with targets:", path)
+	for _, t := range data.Tasks {
+		fmt.Printf(" %s", t.Name)
+	}
+	fmt.Println()
+	return nil
+}
+
+// exportBuildFlags are the target selection flags given to pbuild export
+func exportBuildFlags() []string {
+	var flags []string
+	if flagAll {
+		flags = append(flags, "--all")
+	}
+	if flagTargetGroup != "" {
+		flags = append(flags, "--target-group "+flagTargetGroup)
+	}
+	if flagTargets != "" {
+		flags = append(flags, "--targets "+flagTargets)
+	}
+	if flagUniversal {
+		flags = append(flags, "--darwin-universal")
+	}
+	if flagConfig != "" {
+		flags = append(flags, "--config "+flagConfig)
+	}
+	return flags
+}
+
+// exportReleaseFlags turns on what the project's pbuild.yaml configures for releases
+func exportReleaseFlags(p *project) []string {
+	flags := []string{"--tag-check error", "--report md"}
+	if len(p.cfg.Licenses.Forbidden) > 0 {
+		flags = append(flags, "--licenses")
+	}
+	if p.cfg.InstallScripts.URL != "" || len(p.cfg.Publish) > 0 {
+		flags = append(flags, "--install-scripts")
+	}
+	if len(p.cfg.Distribute.Torrent.WebSeeds) > 0 || len(p.cfg.Distribute.Torrent.Trackers) > 0 {
+		flags = append(flags, "--torrent")
+	}
+	if len(p.cfg.Publish) > 0 {
+		flags = append(flags, "--publish")
+	}
+	return flags
+}
+
+// exportVars replaces the variable names in a task's command with the
+// format's references, $(NAME) for make and {{.NAME}} for task
+func exportVars(format string) func(string) string {
+	names := []string{"PBUILD_FLAGS", "RELEASE_FLAGS", "OUTPUT_DIR", "PBUILD"}
+	return func(command string) string {
+		var pairs []string
+		for _, n := range names {
+			ref := "$(" + n + ")"
+			if format == "task" {
+				ref = "{{." + n + "}}"
+			}
+			pairs = append(pairs, n, ref)
+		}
+		return strings.NewReplacer(pairs...).Replace(command)
+	}
+}
+
+const makefileTemplate = `# Generated by pbuild export make for {{.Project}}; override the variables per
+# call, e.g. make build PBUILD_FLAGS="--targets linux/amd64"
+PBUILD ?= pbuild
+PBUILD_FLAGS ?= {{.BuildFlags}}
+RELEASE_FLAGS ?= {{.ReleaseFlags}}
+OUTPUT_DIR ?= {{.OutputDir}}
+
+.PHONY:{{range .Tasks}} {{.Name}}{{end}} help
+{{range .Tasks}}
+## {{.Name}}: {{.Desc}}
+{{.Name}}:
+	{{vars .Command}}
+{{end}}
+## help: List the targets
+help:
+	@sed -n 's/^## //p' $(MAKEFILE_LIST)
+`
+
+const taskfileTemplate = `# Generated by pbuild export task for {{.Project}}; override the variables per
+# call, e.g. task build PBUILD_FLAGS="--targets linux/amd64"
+version: '3'
+
+vars:
+  PBUILD: pbuild
+  PBUILD_FLAGS: '{{.BuildFlags}}'
+  RELEASE_FLAGS: '{{.ReleaseFlags}}'
+  OUTPUT_DIR: '{{.OutputDir}}'
+
+tasks:
+  default:
+    cmds:
+      - task: build
+{{range .Tasks}}
+  {{.Name}}:
+    desc: {{.Desc}}
+    cmds:
+      - '{{vars .Command}}'
+{{end}}`
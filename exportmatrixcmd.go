@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"pbuild/targets"
+)
+
+var (
+	flagMatrixFormat string
+	flagMatrixJob    string
+	flagMatrixIndent bool
+)
+
+// matrixEntry is one CI job of the exported matrix
+type matrixEntry struct {
+	Target string `json:"target"`
+	GOOS   string `json:"goos"`
+	GOARCH string `json:"goarch"`
+	Output string `json:"output"`
+	// Args are the pbuild arguments of the job: its target and the build flags
+	// given to export-matrix
+	Args string `json:"args"`
+	// targetArgs are the job's target selection alone
+	targetArgs string
+}
+
+// gitlabJob is a job expanded by GitLab's parallel:matrix
+type gitlabJob struct {
+	Parallel struct {
+		Matrix []map[string]string `yaml:"matrix"`
+	} `yaml:"parallel"`
+	Script []string `yaml:"script"`
+}
+
+// newExportMatrixCmd returns the `pbuild export-matrix` subcommand
+func newExportMatrixCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export-matrix [TARGET_DIR]",
+		Short: "Print the target matrix as a GitHub Actions or GitLab CI matrix",
+		Long: "Resolves the targets exactly like a build (--all, --target-group, --targets,\n" +
+			"pbuild.yaml groups and skip rules) and prints one CI job per target: a JSON\n" +
+			"object for a GitHub Actions strategy.matrix, or a GitLab CI job using\n" +
+			"parallel:matrix, e.g. for a dynamic child pipeline. Build flags given to\n" +
+			"export-matrix are passed on to every job.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runExportMatrix(targetArg(args), matrixFlags(cmd))
+		},
+	}
+	cmd.Flags().StringVar(&flagMatrixFormat, "format", "github", "matrix format: github, gitlab")
+	cmd.Flags().StringVar(&flagMatrixJob, "job", "build", "gitlab: name of the generated job")
+	cmd.Flags().BoolVar(&flagMatrixIndent, "indent", false, "github: indent the JSON (default: one line, for $GITHUB_OUTPUT)")
+	cmd.Flags().BoolVar(&flagAll, "all", false, "build for all predefined targets")
+	cmd.Flags().StringVar(&flagTargetGroup, "target-group", "", "build named target groups (comma-separated)")
+	cmd.Flags().StringVar(&flagTargets, "targets", "", "build explicit targets as GOOS/GOARCH (comma-separated)")
+	cmd.Flags().BoolVar(&flagUniversal, "darwin-universal", false, "also build a universal darwin binary (amd64 + arm64)")
+	return cmd
+}
+
+// matrixFlags are the build flags given to export-matrix, quoted for the jobs'
+// pbuild command line
+func matrixFlags(cmd *cobra.Command) []string {
+	own := map[string]bool{"format": true, "job": true, "indent": true, "all": true, "targets": true, "target-group": true, "darwin-universal": true}
+	var flags []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if own[f.Name] {
+			return
+		}
+		values := []string{f.Value.String()}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			values = sv.GetSlice()
+		}
+		for _, v := range values {
+			flags = append(flags, quoteArg("--"+f.Name+"="+v))
+		}
+	})
+	return flags
+}
+
+// quoteArg single-quotes s for POSIX sh when it holds anything but plain characters
+func quoteArg(s string) string {
+	if strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=/.,:@+%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// jobTargetArgs selects t for a job; a universal binary is merged from the
+// darwin slices, which its job builds with --darwin-universal
+func jobTargetArgs(t targets.Target) string {
+	if t.OS == "darwin" && t.Arch == targets.DarwinUniversal {
+		return "--targets darwin/amd64,darwin/arm64 --darwin-universal"
+	}
+	return "--targets " + t.String()
+}
+
+// runExportMatrix prints the project's buildable targets as a CI matrix
+func runExportMatrix(targetDir string, flags []string) error {
+	p, err := resolveProject(targetDir)
+	if err != nil {
+		return err
+	}
+	matrix, err := resolveMatrix(p.cfg)
+	if err != nil {
+		return err
+	}
+	names, err := targets.OutputNames(artifactBase(p), matrix)
+	if err != nil {
+		return err
+	}
+	var entries []matrixEntry
+	for _, t := range matrix {
+		// Skipped targets would only produce empty jobs
+		reason, err := skipReason(p.cfg.Skip, t, newBuildConfig(p))
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			continue
+		}
+		selection := jobTargetArgs(t)
+		args := strings.Join(append([]string{selection}, flags...), " ")
+		entries = append(entries, matrixEntry{Target: t.String(), GOOS: t.OS, GOARCH: t.Arch, Output: names[t], Args: args, targetArgs: selection})
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("every target is skipped; nothing to export")
+	}
+
+	switch flagMatrixFormat {
+	case "github":
+		matrix := map[string][]matrixEntry{"include": entries}
+		var data []byte
+		if flagMatrixIndent {
+			data, err = json.MarshalIndent(matrix, "", "  ")
+		} else {
+			data, err = json.Marshal(matrix)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	case "gitlab":
+		var job gitlabJob
+		for _, e := range entries {
+			job.Parallel.Matrix = append(job.Parallel.Matrix, map[string]string{
+				"PBUILD_TARGET": e.Target, "GOOS": e.GOOS, "GOARCH": e.GOARCH, "PBUILD_OUTPUT": e.Output, "PBUILD_TARGET_ARGS": e.targetArgs,
+			})
+		}
+		// The variable holds no quotes, so the shell's word splitting is enough
+		job.Script = []string{strings.Join(append([]string{"pbuild $PBUILD_TARGET_ARGS"}, flags...), " ")}
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(map[string]gitlabJob{flagMatrixJob: job}); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("unsupported matrix format: %s (expected github, gitlab)", flagMatrixFormat)
+	}
+}
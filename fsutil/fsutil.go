@@ -3,9 +3,13 @@ package fsutil
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -54,7 +58,8 @@ func FindGitRoot(start string) (string, error) {
 		return "", err
 	}
 	for {
-		if fi, err := os.Stat(filepath.Join(dir, ".git")); err == nil && fi.IsDir() {
+		// .git is a file in linked worktrees and submodules
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
 			return dir, nil
 		}
 		parent := filepath.Dir(dir)
@@ -65,6 +70,124 @@ func FindGitRoot(start string) (string, error) {
 	}
 }
 
+// ExpandHome replaces a leading ~ with the user's home directory, which is
+// %USERPROFILE% on Windows
+func ExpandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// maxDirPath is how long a directory path may be in the Win32 API without
+// the \\?\ prefix: MAX_PATH less room for an 8.3 file name
+const maxDirPath = 260 - 12
+
+// LongPath returns an absolute path in the \\?\ form on Windows when it is
+// too long for MAX_PATH, for the external tools it is handed to; Go's own file
+// functions do this themselves. Other paths, and paths elsewhere, are
+// returned as they are.
+func LongPath(path string) string {
+	if runtime.GOOS != "windows" || len(path) < maxDirPath || !filepath.IsAbs(path) || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	// The prefix also turns off the resolving of . and .. and of / separators
+	path = filepath.Clean(path)
+	if share, ok := strings.CutPrefix(path, `\\`); ok {
+		return `\\?\UNC\` + share
+	}
+	return `\\?\` + path
+}
+
+// TempPath returns the in-progress name used while writing path
+func TempPath(path string) string {
+	return path + ".tmp"
+}
+
+// WriteFileAtomic writes data to a temp file next to path and renames it into place,
+// so readers never observe a partially written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := TempPath(path)
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// CopyFile copies src to dst atomically, preserving the file mode
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := TempPath(dst)
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// CopyDir recursively copies the regular files and directories of src into dst
+func CopyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return CopyFile(path, target)
+	})
+}
+
+// ReplaceSymlink points link at target, replacing any existing link atomically
+func ReplaceSymlink(target, link string) error {
+	tmp := TempPath(link)
+	_ = os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
 func HumanSizeBytes(b int64) string {
 	const unit = 1024
 	if b < unit {
@@ -78,3 +201,30 @@ func HumanSizeBytes(b int64) string {
 	prefixes := []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
 	return fmt.Sprintf("%.1f %s", val/math.Pow(unit, float64(exp)), prefixes[exp-1])
 }
+
+// ParseSize reads a size such as 512M, 1.5GiB or 2048: plain numbers are
+// bytes, K, M, G and T (with or without B or iB) are powers of 1024
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+	unit := strings.TrimLeft(num, "0123456789.")
+	num = strings.TrimSpace(strings.TrimSuffix(num, unit))
+	exp := 0
+	switch strings.ToUpper(strings.TrimSpace(unit)) {
+	case "", "B":
+	case "K", "KB", "KIB":
+		exp = 1
+	case "M", "MB", "MIB":
+		exp = 2
+	case "G", "GB", "GIB":
+		exp = 3
+	case "T", "TB", "TIB":
+		exp = 4
+	default:
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+	val, err := strconv.ParseFloat(num, 64)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(val * math.Pow(1024, float64(exp))), nil
+}
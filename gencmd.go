@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/config"
+	"pbuild/fsutil"
+	"pbuild/versionpkg"
+)
+
+var (
+	flagGenDir   string
+	flagGenForce bool
+)
+
+// newGenCmd returns the `pbuild gen` subcommand
+func newGenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gen",
+		Short: "Generate code in the target project",
+	}
+	versionPkg := &cobra.Command{
+		Use:   "version-pkg [TARGET_DIR]",
+		Short: "Generate a buildinfo package whose Version, Commit and Date every build stamps",
+		Long: "Writes internal/buildinfo/buildinfo.go with Version, Commit and Date variables,\n" +
+			"Get and String helpers and an HTTP handler serving them as JSON. Builds find\n" +
+			"the package and add the -X flags for it to the default ldflags.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGenVersionPkg(targetArg(args))
+		},
+	}
+	versionPkg.Flags().StringVar(&flagGenDir, "dir", "", "package directory relative to the module root (default: version_pkg in pbuild.yaml, or "+versionpkg.DefaultDir+")")
+	versionPkg.Flags().BoolVar(&flagGenForce, "force", false, "overwrite an existing "+versionpkg.FileName)
+	cmd.AddCommand(versionPkg)
+	return cmd
+}
+
+// runGenVersionPkg writes the buildinfo package into the module of targetDir
+func runGenVersionPkg(targetDir string) error {
+	abs, err := filepath.Abs(targetDir)
+	if err != nil {
+		return err
+	}
+	root, err := fsutil.FindModuleRoot(abs)
+	if err != nil {
+		return fmt.Errorf("no go.mod found in or above %s", abs)
+	}
+	cfg, err := loadConfig(root, flagConfig)
+	if err != nil {
+		return err
+	}
+	dir := flagGenDir
+	if dir == "" {
+		dir = cfg.VersionPkg
+	}
+	if dir == "" {
+		dir = versionpkg.DefaultDir
+	}
+	dir = filepath.ToSlash(dir)
+
+	file, err := versionpkg.Write(root, dir, flagGenForce)
+	if err != nil {
+		return err
+	}
+	importPath, err := versionpkg.Find(root, dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", file)
+	fmt.Printf("Builds now stamp %s.{%s}\n", importPath, strings.Join(versionpkg.Vars, ","))
+	if dir != versionpkg.DefaultDir && cfg.VersionPkg != dir {
+		fmt.Printf("Add `version_pkg: %s` to %s so builds find the package\n", dir, config.FileName)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"pbuild/archive"
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/targets"
+)
+
+// completionFiles maps a shell to the conventional completion file name
+var completionFiles = map[string]string{
+	"bash":       "%s.bash",
+	"zsh":        "_%s",
+	"fish":       "%s.fish",
+	"powershell": "%s.ps1",
+}
+
+// generateTimeout bounds each run of the built binary
+const generateTimeout = 30 * time.Second
+
+// generateAssets builds the host binary and runs it to produce the shell
+// completions and man page configured under generate: in pbuild.yaml. Files
+// are written to completions/ and man/ in versionDir and returned as archive
+// entries so every target's archive carries them.
+func generateAssets(p *project, versionDir string) ([]archive.File, error) {
+	gen := p.cfg.Generate
+	if len(gen.Completions) == 0 && len(gen.Man) == 0 {
+		return nil, nil
+	}
+	for _, shell := range gen.Completions {
+		if _, ok := completionFiles[shell]; !ok {
+			return nil, fmt.Errorf("unsupported completion shell: %s (expected bash, zsh, fish or powershell)", shell)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "pbuild-generate-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	host := targets.Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	binPath := filepath.Join(tmpDir, targets.OutputName(p.name, host))
+	fmt.Printf("Generating completions and man pages with a %s build\n", host)
+	if err := gobuild.BuildWithConfig(context.Background(), p.workDir, host, binPath, newBuildConfig(p)); err != nil {
+		return nil, fmt.Errorf("failed to build the host binary for generation: %v", err)
+	}
+
+	// Run every command before writing anything, so a binary that is not a
+	// cobra CLI leaves no partial set behind
+	type output struct {
+		dir, name string
+		data      []byte
+	}
+	var outputs []output
+	run := func(dir, name string, args []string) bool {
+		out, err := runGenerator(binPath, args)
+		if err != nil {
+			fmt.Printf("Warning: %s %s: %v (is it a cobra CLI?); skipping its completions and man page\n\n", p.name, strings.Join(args, " "), err)
+			return false
+		}
+		outputs = append(outputs, output{dir, name, out})
+		return true
+	}
+	for _, shell := range gen.Completions {
+		if !run("completions", fmt.Sprintf(completionFiles[shell], p.name), []string{"completion", shell}) {
+			return nil, nil
+		}
+	}
+	if len(gen.Man) > 0 && !run("man", p.name+".1", gen.Man) {
+		return nil, nil
+	}
+
+	var files []archive.File
+	for _, o := range outputs {
+		rel := filepath.ToSlash(filepath.Join(o.dir, o.name))
+		if err := os.MkdirAll(filepath.Join(versionDir, o.dir), 0o755); err != nil {
+			return nil, err
+		}
+		if err := fsutil.WriteFileAtomic(filepath.Join(versionDir, o.dir, o.name), o.data, 0o644); err != nil {
+			return nil, err
+		}
+		files = append(files, archive.File{Name: rel, Data: o.data, Mode: 0o644})
+		fmt.Printf("  %s\n", rel)
+	}
+	fmt.Println()
+	return files, nil
+}
+
+// runGenerator runs the built binary and returns its stdout
+func runGenerator(binPath string, args []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), generateTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("no output")
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"pbuild/fsutil"
+)
+
+// checkAndUpdateGitignore makes sure git ignores the output directory. It adds
+// <output-dir>/ to the module's .gitignore unless git already ignores it, e.g.
+// through .git/info/exclude or the global core.excludesFile.
+func checkAndUpdateGitignore(p *project, outDir string) error {
+	if !p.settings.GitignoreUpdate {
+		return nil
+	}
+	rel, err := filepath.Rel(p.workDir, outDir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// Outside the module there is nothing to ignore
+		return nil
+	}
+	entry := filepath.ToSlash(rel) + "/"
+
+	if source, ignored := gitIgnoredBy(p.workDir, entry); ignored {
+		if source != "" && source != ".gitignore" {
+			fmt.Printf("%s directory already ignored by %s\n", entry, source)
+		} else {
+			fmt.Printf("%s directory already in .gitignore file\n", entry)
+		}
+		return nil
+	}
+
+	gitignorePath := filepath.Join(p.workDir, ".gitignore")
+	content, err := os.ReadFile(gitignorePath)
+	if errors.Is(err, os.ErrNotExist) {
+		if !p.settings.GitignoreCreate {
+			fmt.Printf("No .gitignore file found - skipping %s directory check\n", entry)
+			return nil
+		}
+		if err := fsutil.WriteFileAtomic(gitignorePath, []byte(entry+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to create .gitignore file: %v", err)
+		}
+		fmt.Printf("Created .gitignore file with %s\n", entry)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read .gitignore file: %v", err)
+	}
+
+	// Without git, fall back to looking for the entry itself
+	for _, line := range strings.Split(string(content), "\n") {
+		switch strings.TrimSpace(line) {
+		case entry, "/" + entry, strings.TrimSuffix(entry, "/"), "/" + strings.TrimSuffix(entry, "/"):
+			fmt.Printf("%s directory already in .gitignore file\n", entry)
+			return nil
+		}
+	}
+
+	newContent := string(content)
+	if !strings.HasSuffix(newContent, "\n") && len(newContent) > 0 {
+		newContent += "\n"
+	}
+	newContent += entry + "\n"
+	if err := os.WriteFile(gitignorePath, []byte(newContent), 0644); err != nil {
+		return fmt.Errorf("failed to update .gitignore file: %v", err)
+	}
+	fmt.Printf("Added %s to .gitignore file\n", entry)
+	return nil
+}
+
+// gitIgnoredBy asks git whether path (relative to dir) is ignored and by which
+// exclude file. ignored is false when git is unavailable or dir is not in a repository.
+func gitIgnoredBy(dir, path string) (source string, ignored bool) {
+	cmd := exec.Command("git", "check-ignore", "-v", "--no-index", path)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+	// <source>:<line>:<pattern>\t<path>; a matching negation means not ignored
+	info, _, _ := strings.Cut(string(bytes.TrimSpace(out)), "\t")
+	parts := strings.SplitN(info, ":", 3)
+	if len(parts) == 3 && strings.HasPrefix(parts[2], "!") {
+		return "", false
+	}
+	source = parts[0]
+	if home, err := os.UserHomeDir(); err == nil && strings.HasPrefix(source, home+string(filepath.Separator)) {
+		source = "~" + source[len(home):]
+	}
+	return source, true
+}
@@ -1,16 +1,54 @@
 package gitmeta
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"pbuild/runner"
 )
 
+// Runner runs the git commands; nil runs the git in PATH
+var Runner runner.Runner
+
+// gitOutput runs git with args in dir and returns its standard output
+func gitOutput(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	return runner.Output(ctx, Runner, &runner.Cmd{Name: "git", Args: args, Dir: dir})
+}
+
+// gitDirs returns the git directory of repoRoot and the common directory
+// holding its refs; they differ in linked worktrees, whose .git is a file
+func gitDirs(repoRoot string) (gitDir, commonDir string) {
+	gitDir = filepath.Join(repoRoot, ".git")
+	b, err := os.ReadFile(gitDir)
+	if err != nil {
+		return gitDir, gitDir
+	}
+	if dir, ok := strings.CutPrefix(strings.TrimSpace(string(b)), "gitdir:"); ok {
+		gitDir = strings.TrimSpace(dir)
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(repoRoot, gitDir)
+		}
+	}
+	commonDir = gitDir
+	if c, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(c))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	return gitDir, commonDir
+}
+
 func ResolveHEAD(repoRoot string) (string, error) {
-	gitDir := filepath.Join(repoRoot, ".git")
-	b, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	headDir, gitDir := gitDirs(repoRoot)
+	b, err := os.ReadFile(filepath.Join(headDir, "HEAD"))
 	if err != nil {
 		return "", err
 	}
@@ -50,33 +88,82 @@ func ResolveHEAD(repoRoot string) (string, error) {
 	return rev, nil
 }
 
-func HeuristicDirty(repoRoot string) (bool, error) {
+// Changes returns the paths (relative to repoRoot, directories ending in "/")
+// that git status reports as changed or untracked, with their two-letter status
+func Changes(repoRoot string) (map[string]string, error) {
+	output, err := gitOutput(context.Background(), repoRoot, "status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]string)
+	entries := strings.Split(string(output), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		status, p := entry[:2], entry[3:]
+		if status[0] == 'R' || status[0] == 'C' {
+			i++ // the source path of a rename or copy follows
+		}
+		changes[p] = status
+	}
+	return changes, nil
+}
+
+// CommitTime returns the committer time of HEAD
+func CommitTime(repoRoot string) (time.Time, error) {
+	out, err := gitOutput(context.Background(), repoRoot, "log", "-1", "--format=%ct")
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unexpected git log output %q", out)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// TrackedFiles returns the paths in the git index, relative to repoRoot
+func TrackedFiles(repoRoot string) (map[string]bool, error) {
+	out, err := gitOutput(context.Background(), repoRoot, "ls-files", "-z")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool)
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p != "" {
+			files[p] = true
+		}
+	}
+	return files, nil
+}
+
+// HeuristicDirty reports whether the work tree has local changes or is behind
+// its remote. Changed paths for which ignored returns true (paths are relative
+// to repoRoot, directories end in "/") do not count; ignored may be nil.
+func HeuristicDirty(repoRoot string, ignored func(path string, isDir bool) bool) (bool, error) {
 	// Check if there are local changes (uncommitted files)
-	cmd := exec.Command("git", "status", "--porcelain")
-	cmd.Dir = repoRoot
-	output, err := cmd.Output()
+	changes, err := Changes(repoRoot)
 	if err != nil {
 		return false, nil
 	}
 
 	// If there are local changes, repo is dirty
-	if len(strings.TrimSpace(string(output))) > 0 {
-		return true, nil
+	for p := range changes {
+		if ignored == nil || !ignored(strings.TrimSuffix(p, "/"), strings.HasSuffix(p, "/")) {
+			return true, nil
+		}
 	}
 
 	// Check if local repo is behind remote
-	cmd = exec.Command("git", "fetch", "--dry-run")
-	cmd.Dir = repoRoot
-	err = cmd.Run()
-	if err != nil {
+	if _, err := gitOutput(context.Background(), repoRoot, "fetch", "--dry-run"); err != nil {
 		// If remote is not accessible, consider it clean
 		return false, nil
 	}
 
 	// Check if local branch is behind remote
-	cmd = exec.Command("git", "status", "-uno")
-	cmd.Dir = repoRoot
-	output, err = cmd.Output()
+	output, err := gitOutput(context.Background(), repoRoot, "status", "-uno")
 	if err != nil {
 		return false, nil
 	}
@@ -84,3 +171,80 @@ func HeuristicDirty(repoRoot string) (bool, error) {
 	// If output contains "behind", repo is dirty (not in sync with remote)
 	return strings.Contains(string(output), "behind"), nil
 }
+
+// Checkout fetches ref (a branch, tag or full ref; empty for the remote HEAD)
+// from url into dir, initializing the repository on first use, and leaves a
+// clean, detached work tree. Git's output goes to log.
+func Checkout(ctx context.Context, url, ref, dir string, log io.Writer) error {
+	git := func(args ...string) error {
+		fmt.Fprintf(log, "$ git %s\n", strings.Join(args, " "))
+		// Never block on a credential prompt
+		cmd := &runner.Cmd{Name: "git", Args: append([]string{"-C", dir}, args...), Env: append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), Stdout: log, Stderr: log}
+		if err := runner.Or(Runner).Run(ctx, cmd); err != nil {
+			return fmt.Errorf("git %s failed: %v", strings.Join(args, " "), err)
+		}
+		return nil
+	}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// -- keeps a URL or ref starting with - from being read as an option
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := git("init", "--quiet"); err != nil {
+			return err
+		}
+		if err := git("remote", "add", "--", "origin", url); err != nil {
+			return err
+		}
+	} else if err := git("remote", "set-url", "--", "origin", url); err != nil {
+		return err
+	}
+	if err := git("fetch", "--quiet", "--force", "--tags", "--", "origin", ref); err != nil {
+		return err
+	}
+	if err := git("checkout", "--quiet", "--force", "--detach", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	return git("clean", "-fdxq")
+}
+
+// TagsAt returns the tags pointing at HEAD
+func TagsAt(repoRoot string) ([]string, error) {
+	out, err := gitOutput(context.Background(), repoRoot, "tag", "--points-at", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("git tag failed: %v", err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// AddWorktree checks out ref (a tag, branch or commit) of the repository at
+// repoRoot into dir as a detached linked worktree, with its submodules, and
+// returns the full commit hash
+func AddWorktree(ctx context.Context, repoRoot, ref, dir string) (string, error) {
+	out, err := gitOutput(ctx, repoRoot, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("%s is not a commit, branch or tag of %s", ref, repoRoot)
+	}
+	commit := strings.TrimSpace(string(out))
+	if _, err := gitOutput(ctx, repoRoot, "worktree", "add", "--detach", "--quiet", dir, commit); err != nil {
+		return "", fmt.Errorf("git worktree add failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".gitmodules")); err == nil {
+		if _, err := gitOutput(ctx, dir, "submodule", "update", "--init", "--recursive", "--quiet"); err != nil {
+			return "", fmt.Errorf("git submodule update failed: %v", err)
+		}
+	}
+	return commit, nil
+}
+
+// RemoveWorktree deletes a worktree created by AddWorktree
+func RemoveWorktree(repoRoot, dir string) error {
+	if _, err := gitOutput(context.Background(), repoRoot, "worktree", "remove", "--force", "--force", dir); err != nil {
+		return fmt.Errorf("git worktree remove failed: %v", err)
+	}
+	return nil
+}
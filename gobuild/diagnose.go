@@ -0,0 +1,92 @@
+package gobuild
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// Diagnosis classifies a failed build and suggests a fix
+type Diagnosis struct {
+	Kind    string // short identifier, e.g. missing-c-compiler
+	Summary string
+	Hint    string
+}
+
+// diagnosers are tried in order; the first whose pattern matches the output wins
+var diagnosers = []struct {
+	kind    string
+	pattern *regexp.Regexp
+	explain func(m []string, e *BuildError) (summary, hint string)
+}{
+	{
+		"unsupported-target",
+		regexp.MustCompile(`unsupported GOOS/GOARCH pair (\S+)`),
+		func(m []string, e *BuildError) (string, string) {
+			return "Go does not support " + m[1],
+				"see `go tool dist list` for valid pairs; remove it from --targets or add a skip rule to pbuild.yaml"
+		},
+	},
+	{
+		"unsupported-buildmode",
+		regexp.MustCompile(`-buildmode=(\S+) not supported on (\S+?)\.?\s`),
+		func(m []string, e *BuildError) (string, string) {
+			return fmt.Sprintf("-buildmode=%s is not available on %s", m[1], m[2]),
+				fmt.Sprintf("use --buildmode exe for this target, or skip it with a rule `buildmode: %s` in pbuild.yaml", m[1])
+		},
+	},
+	{
+		"missing-c-compiler",
+		regexp.MustCompile(`C compiler "([^"]+)" not found|exec: "([^"]*(?:gcc|clang|cc))": executable file not found`),
+		func(m []string, e *BuildError) (string, string) {
+			cc := m[1]
+			if cc == "" {
+				cc = m[2]
+			}
+			return fmt.Sprintf("the C compiler %s is not installed", cc),
+				fmt.Sprintf("install a C cross compiler for %s and point CC at it, or build without cgo using --strategy purego", e.Target)
+		},
+	},
+	{
+		"cgo-required",
+		regexp.MustCompile(`requires external \(cgo\) linking|cgo is not enabled|C source files not allowed when not using cgo|requires cgo`),
+		func(m []string, e *BuildError) (string, string) {
+			return "this build needs cgo, but it is disabled",
+				fmt.Sprintf("use --strategy flexible and provide a C compiler for %s via CC", e.Target)
+		},
+	},
+	{
+		"no-go-files",
+		regexp.MustCompile(`build constraints exclude all Go files in (\S+)`),
+		func(m []string, e *BuildError) (string, string) {
+			summary := fmt.Sprintf("no Go file in %s builds for %s", m[1], e.Target)
+			if slices.Contains(e.Env, "CGO_ENABLED=0") {
+				return summary, "files that import \"C\" need cgo: use --strategy flexible with a C compiler for the target, otherwise check //go:build lines and --tags"
+			}
+			return summary, "cgo is off by default when cross-compiling; set CGO_ENABLED=1 and CC to a cross compiler, otherwise check //go:build lines and --tags"
+		},
+	},
+	{
+		"invalid-ldflags",
+		regexp.MustCompile(`-X flag requires argument of the form importpath\.name=value|invalid value "[^"]*" for flag -ldflags|flag provided but not defined: (-\S+)`),
+		func(m []string, e *BuildError) (string, string) {
+			if m[1] != "" {
+				return "the linker does not know the flag " + m[1], "check the flags passed with --ldflags against `go tool link -help`"
+			}
+			return "--ldflags could not be parsed",
+				"quote values containing spaces inside --ldflags and write -X as importpath.name=value"
+		},
+	},
+}
+
+// Diagnose classifies a build failure by its output; it returns nil for
+// failures it does not recognize, such as compile errors in the project
+func Diagnose(e *BuildError) *Diagnosis {
+	for _, d := range diagnosers {
+		if m := d.pattern.FindStringSubmatch(string(e.Output) + "\n"); m != nil {
+			summary, hint := d.explain(m, e)
+			return &Diagnosis{Kind: d.kind, Summary: summary, Hint: hint}
+		}
+	}
+	return nil
+}
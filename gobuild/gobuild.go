@@ -3,11 +3,15 @@ package gobuild
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
+	"pbuild/runner"
+	"pbuild/sandbox"
 	"pbuild/targets"
 )
 
@@ -37,6 +41,20 @@ func ParseStrategy(s string) BuildTagStrategy {
 	}
 }
 
+// String returns the strategy name as accepted by ParseStrategy
+func (s BuildTagStrategy) String() string {
+	switch s {
+	case FlexibleCGO:
+		return "flexible"
+	case NoCGOEver:
+		return "purego"
+	case TraditionalCGO:
+		return "traditional"
+	default:
+		return "unknown"
+	}
+}
+
 // getBuildTags returns the appropriate build tags for the strategy
 func getBuildTags(strategy BuildTagStrategy) string {
 	switch strategy {
@@ -51,144 +69,346 @@ func getBuildTags(strategy BuildTagStrategy) string {
 	}
 }
 
+// cpuLevelEnv returns the CPU feature level environment variable for an architecture, or "" if none applies
+func cpuLevelEnv(arch string, config BuildConfig) string {
+	switch arch {
+	case "amd64":
+		return "GOAMD64=" + config.AMD64Level
+	case "arm64":
+		return "GOARM64=" + config.ARM64Level
+	case "arm":
+		return "GOARM=" + config.ARMLevel
+	case "386":
+		return "GO386=" + config.X86Level
+	case "mips", "mipsle":
+		return "GOMIPS=" + config.MIPSLevel
+	case "mips64", "mips64le":
+		return "GOMIPS64=" + config.MIPS64Level
+	case "ppc64", "ppc64le":
+		return "GOPPC64=" + config.PPC64Level
+	case "riscv64":
+		return "GORISCV64=" + config.RISCVLevel
+	}
+	// loong64, s390x and wasm have no tunable CPU level
+	return ""
+}
+
 // BuildConfig holds all build configuration options
 type BuildConfig struct {
-	Strategy   BuildTagStrategy
-	AMD64Level string
-	ARM64Level string
-	ARMLevel   string
-	MIPSLevel  string
-	PPC64Level string
-	RISCVLevel string
-	BuildMode  string
-	Tags       string
-	LDFlags    string
-	BuildFlags string
-	Verbose    bool
-	CleanCache bool
+	Strategy    BuildTagStrategy
+	AMD64Level  string
+	ARM64Level  string
+	ARMLevel    string
+	MIPSLevel   string
+	MIPS64Level string
+	X86Level    string
+	PPC64Level  string
+	RISCVLevel  string
+	BuildMode   string
+	Tags        string
+	LDFlags     string
+	BuildFlags  string
+	Verbose     bool
+	CleanCache  bool
+	// GCFlags and ASMFlags are passed as one -gcflags or -asmflags each, in
+	// order, e.g. "all=-N -l"; a package pattern before = limits a value to
+	// the packages it matches and the last matching value wins
+	GCFlags  []string
+	ASMFlags []string
+	// Cover builds with -cover: the binaries write coverage counters of the
+	// main module's packages to $GOCOVERDIR when they exit (Go 1.20+)
+	Cover bool
+	// Procs caps GOMAXPROCS and the go command's -p for each build; 0 leaves them alone
+	Procs int
+	// GoCacheRoot gives every target a Go build cache of its own below it,
+	// <root>/<os>-<arch>; empty builds with the shared GOCACHE
+	GoCacheRoot string `json:"-"`
+	// Sandbox restricts the go commands of a build; nil runs them unrestricted
+	Sandbox *sandbox.Policy `json:"-"`
+	// Transcript runs go build with -x and receives the log of each build,
+	// failed or not: command, environment and every toolchain command run
+	Transcript io.Writer `json:"-"`
+	// Runner runs the go commands of a build; nil runs the go tool in PATH
+	Runner runner.Runner `json:"-"`
+	// Env replaces the variables TargetEnv resolves for the targets it holds,
+	// e.g. with those of a replayed build plan; GOCACHE is still set
+	Env map[targets.Target][]string `json:"-"`
+}
+
+// Trimpath reports whether the build strips host paths with -trimpath, which
+// is the default unless BuildFlags replaces it
+func (c BuildConfig) Trimpath() bool {
+	if c.BuildFlags == "" {
+		return true
+	}
+	for _, f := range strings.Fields(c.BuildFlags) {
+		switch f {
+		case "-trimpath", "--trimpath", "-trimpath=true", "--trimpath=true":
+			return true
+		}
+	}
+	return false
 }
 
 func Build(ctx context.Context, workDir string, t targets.Target, outputPath, ldflags string) error {
 	config := BuildConfig{
-		Strategy:   NoCGOEver, // Changed default to purego
-		AMD64Level: "v2",
-		ARM64Level: "v8.0",
-		ARMLevel:   "7",
-		MIPSLevel:  "hardfloat",
-		PPC64Level: "power8",
-		RISCVLevel: "rva20u64",
-		BuildMode:  "exe",
-		LDFlags:    ldflags,
-		BuildFlags: "-trimpath",
-		CleanCache: true,
+		Strategy:    NoCGOEver, // Changed default to purego
+		AMD64Level:  "v2",
+		ARM64Level:  "v8.0",
+		ARMLevel:    "7",
+		MIPSLevel:   "hardfloat",
+		MIPS64Level: "hardfloat",
+		X86Level:    "sse2",
+		PPC64Level:  "power8",
+		RISCVLevel:  "rva20u64",
+		BuildMode:   "exe",
+		LDFlags:     ldflags,
+		BuildFlags:  "-trimpath",
+		CleanCache:  true,
 	}
 	return BuildWithConfig(ctx, workDir, t, outputPath, config)
 }
 
+// TargetEnv returns the variables a build of t sets on top of the inherited environment
+func TargetEnv(workDir string, t targets.Target, config BuildConfig) []string {
+	env, planned := config.Env[t]
+	if planned {
+		env = slices.Clone(env)
+	} else {
+		env = resolveEnv(workDir, t, config)
+	}
+	if config.GoCacheRoot != "" {
+		env = append(env, "GOCACHE="+filepath.Join(config.GoCacheRoot, t.OS+"-"+t.Arch))
+	}
+	return env
+}
+
+// resolveEnv returns the target variables the build config calls for
+func resolveEnv(workDir string, t targets.Target, config BuildConfig) []string {
+	env := []string{
+		"GOOS=" + t.OS,
+		"GOARCH=" + t.Arch,
+	}
+
+	// Handle CGO based on strategy
+	if config.Strategy != FlexibleCGO {
+		env = append(env, "CGO_ENABLED=0")
+	}
+
+	// Add CPU feature support based on architecture
+	if levelEnv := cpuLevelEnv(t.Arch, config); levelEnv != "" {
+		env = append(env, levelEnv)
+	}
+
+	if config.Procs > 0 {
+		env = append(env, "GOMAXPROCS="+strconv.Itoa(config.Procs))
+	}
+
+	// If no go.mod in workDir, force GOPATH mode so plain packages still build.
+	if _, err := os.Stat(filepath.Join(workDir, "go.mod")); err != nil {
+		env = append(env, "GO111MODULE=off")
+	}
+	return env
+}
+
 func BuildWithConfig(ctx context.Context, workDir string, t targets.Target, outputPath string, config BuildConfig) error {
 	// Clean cache if requested
 	if config.CleanCache {
-		cleanCmd := exec.CommandContext(ctx, "go", "clean", "-cache")
-		cleanCmd.Dir = workDir
-		cleanCmd.Run() // Ignore errors, cache cleaning is best effort
+		clean := &runner.Cmd{Name: "go", Args: []string{"clean", "-cache"}, Dir: workDir, Env: append(os.Environ(), TargetEnv(workDir, t, config)...), Sandbox: config.Sandbox}
+		_ = runner.Or(config.Runner).Run(ctx, clean) // Ignore errors, cache cleaning is best effort
+	}
+
+	buildArgs, err := goBuildArgs(t, outputPath, config)
+	if err != nil {
+		return err
+	}
+	return runGo(ctx, workDir, t, outputPath, buildArgs, config)
+}
+
+// runGo runs the go command with args for t, writing to outputPath
+func runGo(ctx context.Context, workDir string, t targets.Target, outputPath string, buildArgs []string, config BuildConfig) error {
+	if config.Transcript != nil {
+		buildArgs = append([]string{buildArgs[0], "-x"}, buildArgs[1:]...)
+	}
+
+	env := TargetEnv(workDir, t, config)
+	cmd := &runner.Cmd{
+		Name:    "go",
+		Args:    buildArgs,
+		Dir:     workDir,
+		Env:     append(os.Environ(), env...),
+		Sandbox: config.Sandbox.With(filepath.Dir(outputPath)),
 	}
 
+	// Show command if verbose
+	if config.Verbose {
+		fmt.Printf("  Command: go %s\n", Redact(strings.Join(buildArgs, " ")))
+		fmt.Printf("  Environment: %s\n", strings.Join(env, " "))
+	}
+
+	out, err := runner.CombinedOutput(ctx, config.Runner, cmd)
+	if config.Transcript != nil {
+		_, _ = config.Transcript.Write(buildLog(t, workDir, env, buildArgs, out, err))
+	}
+	if err != nil {
+		return &BuildError{Target: t, Dir: workDir, Args: buildArgs, Env: env, Output: out, Err: err}
+	}
+	return nil
+}
+
+// goBuildArgs returns the arguments of the go build for t
+func goBuildArgs(t targets.Target, outputPath string, config BuildConfig) ([]string, error) {
 	// Build command arguments
 	buildArgs := []string{"build"}
 
 	// Add build flags
 	if config.BuildFlags != "" {
-		buildArgs = append(buildArgs, config.BuildFlags)
+		buildArgs = append(buildArgs, strings.Fields(config.BuildFlags)...)
 	} else {
 		buildArgs = append(buildArgs, "-trimpath")
 	}
 
+	// Limit parallel compiles
+	if config.Procs > 0 {
+		buildArgs = append(buildArgs, "-p", strconv.Itoa(config.Procs))
+	}
+
 	// Add build mode
 	buildArgs = append(buildArgs, "-buildmode="+config.BuildMode)
 
 	// Add build tags
-	var allTags []string
-	if strategyTags := getBuildTags(config.Strategy); strategyTags != "" {
-		allTags = append(allTags, strategyTags)
-	}
-	if config.Tags != "" {
-		allTags = append(allTags, config.Tags)
+	allTags, err := ResolveTags(config.Strategy, config.Tags, t)
+	if err != nil {
+		return nil, err
 	}
 	if len(allTags) > 0 {
 		buildArgs = append(buildArgs, "-tags", strings.Join(allTags, ","))
 	}
 
+	if config.Cover {
+		buildArgs = append(buildArgs, "-cover")
+	}
+
+	// Add compiler and assembler flags
+	for _, f := range config.GCFlags {
+		buildArgs = append(buildArgs, "-gcflags", f)
+	}
+	for _, f := range config.ASMFlags {
+		buildArgs = append(buildArgs, "-asmflags", f)
+	}
+
 	// Add ldflags
 	buildArgs = append(buildArgs, "-ldflags", config.LDFlags, "-o", outputPath, ".")
+	return buildArgs, nil
+}
 
-	cmd := exec.CommandContext(ctx, "go", buildArgs...)
-	cmd.Dir = workDir
+// CacheStats counts the packages of a build that come from the Go build cache
+// (hits) and those that are compiled (misses)
+type CacheStats struct {
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+}
 
-	env := append(os.Environ(),
-		"GOOS="+t.OS,
-		"GOARCH="+t.Arch,
-	)
+// ProbeCache predicts the cache hits and misses of a build: go build -n lists
+// the packages that would be compiled, go list -deps all packages of the build
+func ProbeCache(ctx context.Context, workDir string, t targets.Target, config BuildConfig) (CacheStats, error) {
+	var stats CacheStats
+	buildArgs, err := goBuildArgs(t, os.DevNull, config)
+	if err != nil {
+		return stats, err
+	}
+	env := append(os.Environ(), TargetEnv(workDir, t, config)...)
 
-	// Handle CGO based on strategy
-	if config.Strategy != FlexibleCGO {
-		env = append(env, "CGO_ENABLED=0")
+	listArgs := []string{"list", "-deps"}
+	if tags, _ := ResolveTags(config.Strategy, config.Tags, t); len(tags) > 0 {
+		listArgs = append(listArgs, "-tags", strings.Join(tags, ","))
+	}
+	list := &runner.Cmd{Name: "go", Args: append(listArgs, "."), Dir: workDir, Env: env, Sandbox: config.Sandbox}
+	out, err := runner.Output(ctx, config.Runner, list)
+	if err != nil {
+		return stats, fmt.Errorf("go list failed: %v", err)
+	}
+	total := 0
+	for _, pkg := range strings.Fields(string(out)) {
+		// unsafe is built into the compiler
+		if pkg != "unsafe" {
+			total++
+		}
 	}
 
-	// Add CPU feature support based on architecture
-	switch t.Arch {
-	case "amd64":
-		env = append(env, "GOAMD64="+config.AMD64Level)
-	case "arm64":
-		env = append(env, "GOARM64="+config.ARM64Level)
-	case "arm":
-		env = append(env, "GOARM="+config.ARMLevel)
-	case "mips", "mipsle":
-		env = append(env, "GOMIPS="+config.MIPSLevel)
-	case "ppc64", "ppc64le":
-		env = append(env, "GOPPC64="+config.PPC64Level)
-	case "riscv64":
-		env = append(env, "GORISCV64="+config.RISCVLevel)
+	// A cleaned cache compiles everything
+	if config.CleanCache {
+		stats.Misses = total
+		return stats, nil
+	}
+	dry := &runner.Cmd{Name: "go", Args: append([]string{"build", "-n"}, buildArgs[1:]...), Dir: workDir, Env: env, Sandbox: config.Sandbox}
+	out, err = runner.CombinedOutput(ctx, config.Runner, dry)
+	if err != nil {
+		return stats, fmt.Errorf("go build -n failed: %v", err)
+	}
+	compiled := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.TrimSuffix(filepath.Base(fields[0]), ".exe") != "compile" {
+			continue
+		}
+		for i := 1; i+1 < len(fields); i++ {
+			if fields[i] == "-p" {
+				compiled[fields[i+1]] = true
+			}
+		}
 	}
+	stats.Misses = len(compiled)
+	stats.Hits = max(total-stats.Misses, 0)
+	return stats, nil
+}
 
-	// If no go.mod in workDir, force GOPATH mode so plain packages still build.
-	if _, err := os.Stat(filepath.Join(workDir, "go.mod")); err != nil {
-		env = append(env, "GO111MODULE=off")
+// Redact masks secrets in commands and output before they are printed or
+// logged; pbuild sets it from the redact: section of pbuild.yaml
+var Redact = func(s string) string { return s }
+
+// BuildError is a failed go build with its full combined output
+type BuildError struct {
+	Target targets.Target
+	Dir    string
+	Args   []string
+	Env    []string // variables set on top of the inherited environment
+	Output []byte
+	Err    error
+}
+
+func (e *BuildError) Error() string {
+	command := "go build"
+	if len(e.Args) > 1 && e.Args[0] == "test" {
+		command = "go test -c"
 	}
+	return Redact(fmt.Sprintf("%s failed for %s/%s in %s: %v\n%s", command, e.Target.OS, e.Target.Arch, e.Dir, e.Err, string(e.Output)))
+}
 
-	cmd.Env = env
+func (e *BuildError) Unwrap() error { return e.Err }
 
-	// Show command if verbose
-	if config.Verbose {
-		fmt.Printf("  Command: go %s\n", strings.Join(buildArgs, " "))
-		fmt.Printf("  Environment: GOOS=%s GOARCH=%s", t.OS, t.Arch)
-
-		// Show architecture-specific environment variables
-		switch t.Arch {
-		case "amd64":
-			fmt.Printf(" GOAMD64=%s", config.AMD64Level)
-		case "arm64":
-			fmt.Printf(" GOARM64=%s", config.ARM64Level)
-		case "arm":
-			fmt.Printf(" GOARM=%s", config.ARMLevel)
-		case "mips", "mipsle":
-			fmt.Printf(" GOMIPS=%s", config.MIPSLevel)
-		case "ppc64", "ppc64le":
-			fmt.Printf(" GOPPC64=%s", config.PPC64Level)
-		case "riscv64":
-			fmt.Printf(" GORISCV64=%s", config.RISCVLevel)
-		}
+// Log renders the command, environment and output for a log file
+func (e *BuildError) Log() []byte {
+	return buildLog(e.Target, e.Dir, e.Env, e.Args, e.Output, e.Err)
+}
 
-		if config.Strategy != FlexibleCGO {
-			fmt.Printf(" CGO_ENABLED=0")
+// buildLog renders a go build for a log file; err is nil for a build that succeeded
+func buildLog(t targets.Target, dir string, env, buildArgs []string, out []byte, err error) []byte {
+	args := make([]string, len(buildArgs))
+	for i, a := range buildArgs {
+		if strings.ContainsAny(a, " \t\"'") {
+			a = strconv.Quote(a)
 		}
-		fmt.Println()
+		args[i] = a
 	}
-
-	out, err := cmd.CombinedOutput()
+	var b strings.Builder
+	fmt.Fprintf(&b, "# target: %s\n# dir: %s\n# env: %s\n# command: go %s\n", t, dir, strings.Join(env, " "), strings.Join(args, " "))
 	if err != nil {
-		return fmt.Errorf("go build failed for %s/%s in %s: %v\n%s", t.OS, t.Arch, workDir, err, string(out))
+		fmt.Fprintf(&b, "# error: %v\n", err)
 	}
-	return nil
+	b.WriteString("\n")
+	b.Write(out)
+	return []byte(Redact(b.String()))
 }
 
 // Legacy function for backward compatibility
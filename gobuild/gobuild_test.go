@@ -0,0 +1,134 @@
+package gobuild
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"pbuild/runner"
+	"pbuild/targets"
+)
+
+var linuxAMD64 = targets.Target{OS: "linux", Arch: "amd64"}
+
+// fakeRunner records the commands of a build without running them and fails
+// them with err
+func fakeRunner(err error) *runner.Recorder {
+	return &runner.Recorder{Next: runner.Func(func(ctx context.Context, cmd *runner.Cmd) error { return err })}
+}
+
+func testConfig() BuildConfig {
+	return BuildConfig{Strategy: NoCGOEver, AMD64Level: "v2", BuildMode: "exe", LDFlags: "-s -w"}
+}
+
+func TestGoBuildArgs(t *testing.T) {
+	config := testConfig()
+	config.Tags = "extra"
+	config.Procs = 2
+	config.GCFlags = []string{"all=-N -l"}
+	args, err := goBuildArgs(linuxAMD64, "out/app", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"build", "-trimpath", "-p", "2", "-buildmode=exe", "-tags", "purego,netgo,osusergo,extra",
+		"-gcflags", "all=-N -l", "-ldflags", "-s -w", "-o", "out/app", "."}
+	if !slices.Equal(args, want) {
+		t.Errorf("goBuildArgs = %q, want %q", args, want)
+	}
+}
+
+func TestGoBuildArgsBuildFlags(t *testing.T) {
+	config := testConfig()
+	config.BuildFlags = "-race -v"
+	config.Cover = true
+	args, err := goBuildArgs(linuxAMD64, "app", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(args, "-trimpath") {
+		t.Errorf("custom build flags kept -trimpath: %q", args)
+	}
+	for _, f := range []string{"-race", "-v", "-cover"} {
+		if !slices.Contains(args, f) {
+			t.Errorf("args %q lack %s", args, f)
+		}
+	}
+}
+
+func TestBuildWithConfig(t *testing.T) {
+	rec := fakeRunner(nil)
+	config := testConfig()
+	config.Runner = rec
+	config.CleanCache = true
+	config.GoCacheRoot = "/cache"
+	dir := t.TempDir()
+	if err := BuildWithConfig(context.Background(), dir, linuxAMD64, "app", config); err != nil {
+		t.Fatal(err)
+	}
+	cmds := rec.Commands()
+	if len(cmds) != 2 {
+		t.Fatalf("ran %d commands, want go clean and go build", len(cmds))
+	}
+	if got := cmds[0].String(); got != "go clean -cache" {
+		t.Errorf("first command = %s, want go clean -cache", got)
+	}
+	build := cmds[1]
+	if build.Name != "go" || build.Args[0] != "build" || build.Dir != dir {
+		t.Errorf("build command = %s in %s", build.String(), build.Dir)
+	}
+	for _, v := range []string{"GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0", "GOAMD64=v2", "GOCACHE=/cache/linux-amd64"} {
+		if !slices.Contains(build.Env, v) {
+			t.Errorf("build environment lacks %s", v)
+		}
+	}
+}
+
+func TestBuildWithConfigPlannedEnv(t *testing.T) {
+	rec := fakeRunner(nil)
+	config := testConfig()
+	config.Runner = rec
+	config.Env = map[targets.Target][]string{linuxAMD64: {"GOOS=linux", "GOARCH=amd64", "GOAMD64=v3"}}
+	if err := BuildWithConfig(context.Background(), t.TempDir(), linuxAMD64, "app", config); err != nil {
+		t.Fatal(err)
+	}
+	env := rec.Commands()[0].Env
+	if !slices.Contains(env, "GOAMD64=v3") || slices.Contains(env, "GOAMD64=v2") {
+		t.Error("build did not use the planned environment")
+	}
+}
+
+func TestBuildWithConfigError(t *testing.T) {
+	failed := errors.New("exit status 1")
+	config := testConfig()
+	config.Runner = fakeRunner(failed)
+	err := BuildWithConfig(context.Background(), t.TempDir(), linuxAMD64, "app", config)
+	var buildErr *BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("error = %v, want a *BuildError", err)
+	}
+	if buildErr.Target != linuxAMD64 || !errors.Is(buildErr.Err, failed) {
+		t.Errorf("BuildError = %+v", buildErr)
+	}
+}
+
+func TestBuildTest(t *testing.T) {
+	rec := fakeRunner(nil)
+	config := testConfig()
+	config.Runner = rec
+	config.BuildMode = "c-shared"
+	if err := BuildTest(context.Background(), t.TempDir(), linuxAMD64, "example.com/app/pkg", "pkg.test", config); err != nil {
+		t.Fatal(err)
+	}
+	args := rec.Commands()[0].Args
+	if !slices.Equal(args[:2], []string{"test", "-c"}) {
+		t.Errorf("BuildTest ran go %s, want go test -c", strings.Join(args, " "))
+	}
+	if args[len(args)-1] != "example.com/app/pkg" || !slices.Contains(args, "pkg.test") {
+		t.Errorf("BuildTest args %q lack the package or output", args)
+	}
+	if !slices.Contains(args, "-buildmode=exe") {
+		t.Errorf("c-shared test binary not built as exe: %q", args)
+	}
+}
@@ -0,0 +1,111 @@
+package gobuild
+
+import (
+	"fmt"
+	"go/build/constraint"
+	"regexp"
+	"strings"
+
+	"pbuild/targets"
+)
+
+// tagName matches a single build tag as accepted by go build -tags
+var tagName = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// tagEntry is one item of a tag list: a tag to add, a tag to remove ("!tag",
+// or "-tag", which YAML leaves alone when unquoted), or a tag added only when a build constraint matches the target ("tag@linux && !arm64")
+type tagEntry struct {
+	name   string
+	remove bool
+	when   constraint.Expr
+}
+
+// parseTags parses a comma-separated tag list
+func parseTags(s string) ([]tagEntry, error) {
+	var entries []tagEntry
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var e tagEntry
+		name, expr, conditional := strings.Cut(item, "@")
+		name = strings.TrimSpace(name)
+		if strings.HasPrefix(name, "!") || strings.HasPrefix(name, "-") {
+			if conditional {
+				return nil, fmt.Errorf("invalid tag %q: a removal cannot carry a condition", item)
+			}
+			e.remove = true
+			name = strings.TrimSpace(name[1:])
+		}
+		if !tagName.MatchString(name) {
+			return nil, fmt.Errorf("invalid build tag %q", name)
+		}
+		e.name = name
+
+		if conditional {
+			x, err := constraint.Parse("//go:build " + expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid condition for tag %q: %v", name, err)
+			}
+			e.when = x
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ValidateTags reports syntax errors in a --tags value before any build starts
+func ValidateTags(s string) error {
+	_, err := parseTags(s)
+	return err
+}
+
+// ResolveTags merges the strategy tags with the user tag list for a target,
+// applying removals and conditions and dropping duplicates
+func ResolveTags(strategy BuildTagStrategy, userTags string, t targets.Target) ([]string, error) {
+	entries, err := parseTags(userTags)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			tags = append(tags, name)
+		}
+	}
+	remove := func(name string) {
+		if !seen[name] {
+			return
+		}
+		delete(seen, name)
+		for i, tag := range tags {
+			if tag == name {
+				tags = append(tags[:i], tags[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for _, name := range strings.Split(getBuildTags(strategy), ",") {
+		if name != "" {
+			add(name)
+		}
+	}
+
+	cgo := strategy == FlexibleCGO
+	ok := func(tag string) bool { return t.Satisfies(tag, cgo) }
+	for _, e := range entries {
+		switch {
+		case e.remove:
+			remove(e.name)
+		case e.when == nil || e.when.Eval(ok):
+			add(e.name)
+		}
+	}
+	return tags, nil
+}
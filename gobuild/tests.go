@@ -0,0 +1,62 @@
+package gobuild
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"pbuild/runner"
+	"pbuild/targets"
+)
+
+// TestPackage is a package with tests, as go list reports it for a target
+type TestPackage struct {
+	ImportPath string
+	Dir        string
+}
+
+// TestPackages lists the packages matching pattern that have test files
+// for t, under the tags of the build
+func TestPackages(ctx context.Context, workDir string, t targets.Target, pattern string, config BuildConfig) ([]TestPackage, error) {
+	args := []string{"list", "-f", "{{if or .TestGoFiles .XTestGoFiles}}{{.ImportPath}}\t{{.Dir}}{{end}}"}
+	if tags, err := ResolveTags(config.Strategy, config.Tags, t); err != nil {
+		return nil, err
+	} else if len(tags) > 0 {
+		args = append(args, "-tags", strings.Join(tags, ","))
+	}
+	cmd := &runner.Cmd{
+		Name:    "go",
+		Args:    append(args, strings.Fields(pattern)...),
+		Dir:     workDir,
+		Env:     append(os.Environ(), TargetEnv(workDir, t, config)...),
+		Sandbox: config.Sandbox,
+	}
+	out, err := runner.Output(ctx, config.Runner, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("go list %s failed: %v", pattern, err)
+	}
+	var pkgs []TestPackage
+	for _, line := range strings.Split(string(out), "\n") {
+		if path, dir, ok := strings.Cut(line, "\t"); ok {
+			pkgs = append(pkgs, TestPackage{ImportPath: path, Dir: dir})
+		}
+	}
+	return pkgs, nil
+}
+
+// BuildTest compiles the tests of pkg for t into the test binary at
+// outputPath (go test -c), with the flags of the main build; c-archive and
+// c-shared builds get an executable test binary
+func BuildTest(ctx context.Context, workDir string, t targets.Target, pkg, outputPath string, config BuildConfig) error {
+	if config.BuildMode == "c-archive" || config.BuildMode == "c-shared" {
+		config.BuildMode = "exe"
+	}
+	buildArgs, err := goBuildArgs(t, outputPath, config)
+	if err != nil {
+		return err
+	}
+	// go build ... -o OUT . becomes go test -c ... -o OUT PKG
+	buildArgs[len(buildArgs)-1] = pkg
+	return runGo(ctx, workDir, t, outputPath, append([]string{"test", "-c"}, buildArgs[1:]...), config)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pbuild/gitmeta"
+	"pbuild/sandbox"
+)
+
+// runGoGenerate runs go generate ./... in the module once before the matrix.
+// Files it changes, creates or deletes that are not committed are reported,
+// and a version pbuild derived is marked dirty, since the binaries no longer
+// match the commit.
+func runGoGenerate(p *project) error {
+	before, gitErr := generatedState(p.gitRoot)
+
+	fmt.Println("Running go generate ./...")
+	// Generators write into the module, which stays read-only for go build
+	cmd := sandbox.Command(context.Background(), p.sandbox.With(p.workDir), "go", "generate", "./...")
+	cmd.Dir = p.workDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("go generate failed: %v\n%s", err, out)
+	}
+	if flagVerbose && len(out) > 0 {
+		fmt.Print(string(out))
+	}
+	// Outside a git repository there is no commit to compare with
+	if gitErr != nil {
+		return nil
+	}
+	after, err := generatedState(p.gitRoot)
+	if err != nil {
+		return nil
+	}
+
+	ignored := projectIgnore(p.workDir, p.gitRoot)
+	var changed []string
+	for path, state := range after {
+		if before[path] == state || ignored.Match(strings.TrimSuffix(path, "/"), strings.HasSuffix(path, "/")) {
+			continue
+		}
+		changed = append(changed, path)
+	}
+	// An untracked file generation removed is in neither git status any more
+	for path := range before {
+		if _, ok := after[path]; !ok && !ignored.Match(strings.TrimSuffix(path, "/"), strings.HasSuffix(path, "/")) {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+	fmt.Printf("Warning: go generate changed files that are not committed: %s\n", strings.Join(changed, ", "))
+	if flagSetVersion == "" && !strings.HasSuffix(p.version, "-dirty") {
+		p.version += "-dirty"
+	}
+	fmt.Println()
+	return nil
+}
+
+// generatedState returns every changed or untracked path of the work tree
+// with its git status and content digest, so that further edits to a file
+// that was already modified are noticed too
+func generatedState(gitRoot string) (map[string]string, error) {
+	changes, err := gitmeta.Changes(gitRoot)
+	if err != nil {
+		return nil, err
+	}
+	for path, status := range changes {
+		if strings.HasSuffix(path, "/") {
+			continue
+		}
+		if data, err := os.ReadFile(filepath.Join(gitRoot, filepath.FromSlash(path))); err == nil {
+			sum := sha256.Sum256(data)
+			changes[path] = status + " " + hex.EncodeToString(sum[:])
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+
+	"pbuild/binfmt"
+	"pbuild/pipeline"
+)
+
+// headerStage fails binaries whose ELF, PE or Mach-O header names another
+// platform than the target, e.g. after an output name collision or a
+// misconfigured toolchain
+type headerStage struct{}
+
+func (headerStage) Name() string  { return "header" }
+func (headerStage) Enabled() bool { return true }
+
+func (headerStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	h, err := binfmt.Check(a.Temp, a.Target)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		stageLog(a, "Header check skipped")
+		return nil
+	}
+	stageLog(a, "Header: %s", h)
+	return nil
+}
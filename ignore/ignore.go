@@ -0,0 +1,182 @@
+package ignore
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is pbuild's own ignore file, read next to .gitignore. It lists files
+// that never make a build dirty or change its inputs, even when git tracks them.
+const FileName = ".pbuildignore"
+
+// rule is one gitignore pattern
+type rule struct {
+	base     string   // slash-separated directory the pattern is relative to, "" for the root
+	segments []string // pattern split at "/"
+	anchored bool     // matches from base only (leading or inner slash)
+	dirOnly  bool     // trailing slash
+	negate   bool     // leading "!"
+}
+
+// Matcher matches slash-separated paths against gitignore-style rules
+type Matcher struct {
+	rules []rule
+}
+
+// Load reads root/.gitignore and root/.pbuildignore, then the .gitignore of
+// every directory below root that is not ignored, like git. Missing files are
+// skipped.
+func Load(root string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, name := range []string{".gitignore", FileName} {
+		if err := m.AddFile(filepath.Join(root, name), ""); err != nil {
+			return nil, err
+		}
+	}
+	// Directories are visited before their contents, so a nested file's
+	// patterns come after, and win over, those of its parents
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		if d.Name() == ".git" || m.Match(rel, true) {
+			return filepath.SkipDir
+		}
+		return m.AddFile(filepath.Join(p, ".gitignore"), rel)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddFile adds the patterns of an ignore file whose directory is base relative
+// to the matcher's root. A missing file is not an error.
+func (m *Matcher) AddFile(file, base string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		m.Add(sc.Text(), base)
+	}
+	return sc.Err()
+}
+
+// Add adds one pattern line in gitignore syntax, relative to base
+func (m *Matcher) Add(line, base string) {
+	line = strings.TrimRight(line, "\r")
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	r := rule{base: strings.Trim(filepath.ToSlash(base), "/")}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return
+	}
+	r.segments = strings.Split(line, "/")
+	m.rules = append(m.rules, r)
+}
+
+// Match reports whether the slash-separated path, relative to the root, is
+// ignored. isDir tells whether the path itself is a directory; a path inside
+// an ignored directory is ignored as well.
+func (m *Matcher) Match(p string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	p = strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/")
+	if p == "" {
+		return false
+	}
+	parts := strings.Split(p, "/")
+	// Like git, a file cannot be re-included once a parent directory is excluded
+	for i := 1; i < len(parts); i++ {
+		if m.match(parts[:i], true) {
+			return true
+		}
+	}
+	return m.match(parts, isDir)
+}
+
+// match applies the rules to one path; the last matching rule decides
+func (m *Matcher) match(parts []string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel := parts
+		if r.base != "" {
+			baseParts := strings.Split(r.base, "/")
+			if len(parts) <= len(baseParts) || strings.Join(parts[:len(baseParts)], "/") != r.base {
+				continue
+			}
+			rel = parts[len(baseParts):]
+		}
+		var ok bool
+		if r.anchored {
+			ok = matchSegments(r.segments, rel)
+		} else {
+			// An unanchored pattern is a single segment matching at any depth
+			ok = matchSegments(r.segments, rel[len(rel)-1:])
+		}
+		if ok {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// stands for any number of segments
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], parts[0]); err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
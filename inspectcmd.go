@@ -0,0 +1,82 @@
+package main
+
+import (
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/selfinfo"
+)
+
+var flagInspectSelf bool
+
+// newInspectCmd returns the `pbuild inspect` subcommand
+func newInspectCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect BINARY",
+		Short: "Show the build info embedded in a binary pbuild produced",
+		Long: "Prints the version JSON linked in with --embed-info and the Go toolchain's own\n" +
+			"build info. --self prints only the embedded JSON, for scripts.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runInspect(args[0])
+		},
+	}
+	cmd.Flags().BoolVar(&flagInspectSelf, "self", false, "print only the embedded version JSON")
+	return cmd
+}
+
+// runInspect prints what a binary says about its build
+func runInspect(path string) error {
+	info, err := selfinfo.Extract(path)
+	switch {
+	case errors.Is(err, selfinfo.ErrNotFound):
+		if flagInspectSelf {
+			return fmt.Errorf("%s has no embedded pbuild info (build it with --embed-info)", path)
+		}
+	case err != nil:
+		return err
+	}
+	if flagInspectSelf {
+		fmt.Println(string(selfinfo.Marshal(*info)))
+		return nil
+	}
+
+	fmt.Println(path)
+	if info != nil {
+		fmt.Println("\nEmbedded build info")
+		fmt.Printf("  Project:        %s\n", info.Project)
+		fmt.Printf("  Version:        %s\n", info.Version)
+		if info.Commit != "" {
+			fmt.Printf("  Commit:         %s\n", info.Commit)
+		}
+		fmt.Printf("  Build time:     %s\n", info.BuildTime.Format(time.RFC3339))
+		fmt.Printf("  Target:         %s\n", info.Target)
+		fmt.Printf("  pbuild version: %s\n", info.PBuild)
+	} else {
+		fmt.Println("\nNo embedded build info (build with --embed-info)")
+	}
+
+	bi, err := buildinfo.ReadFile(path)
+	if err != nil {
+		if _, statErr := os.Stat(path); statErr != nil {
+			return statErr
+		}
+		fmt.Printf("\nNo Go build info: %v\n", err)
+		return nil
+	}
+	fmt.Println("\nGo build info")
+	fmt.Printf("  Go version:     %s\n", bi.GoVersion)
+	fmt.Printf("  Module:         %s %s\n", bi.Main.Path, bi.Main.Version)
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "GOOS", "GOARCH", "CGO_ENABLED", "-tags", "-trimpath", "vcs.revision", "vcs.time", "vcs.modified":
+			fmt.Printf("  %-15s %s\n", s.Key+":", s.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/config"
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/targets"
+)
+
+var flagBinDir string
+
+// newInstallCmd returns the `pbuild install` subcommand
+func newInstallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install [TARGET_DIR]",
+		Short: "Build the host binary with version injection and install it",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return install(targetArg(args))
+		},
+	}
+	cmd.Flags().StringVar(&flagBinDir, "bin-dir", "", "install directory (default: install_dir from pbuild.yaml, $GOBIN, then ~/.local/bin, %USERPROFILE%\\go\\bin on Windows)")
+	return cmd
+}
+
+// installDir resolves where the host binary is installed
+func installDir(workDir string, cfg *config.Config) (string, error) {
+	dir := flagBinDir
+	if dir == "" && cfg.InstallDir != "" {
+		dir = cfg.InstallDir
+		if !filepath.IsAbs(dir) && !strings.HasPrefix(dir, "~") {
+			dir = filepath.Join(workDir, dir)
+		}
+	}
+	if dir == "" {
+		dir = os.Getenv("GOBIN")
+	}
+	if dir == "" {
+		// On Windows where go install puts binaries without GOBIN, which Go's installer adds to PATH
+		dir = "~/.local/bin"
+		if runtime.GOOS == "windows" {
+			dir = `~\go\bin`
+		}
+	}
+	return fsutil.ExpandHome(dir)
+}
+
+// install builds the host target and atomically replaces the installed binary
+func install(targetDir string) error {
+	p, err := resolveProject(targetDir)
+	if err != nil {
+		return err
+	}
+	dir, err := installDir(p.workDir, p.cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	host := targets.Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	name := p.name
+	if host.OS == "windows" {
+		name += ".exe"
+	}
+	dst := filepath.Join(dir, name)
+	tmp := fsutil.TempPath(dst)
+
+	fmt.Printf("Installing %s %s for %s -> %s\n", p.name, p.version, host, dst)
+	if err := gobuild.BuildWithConfig(context.Background(), p.workDir, host, tmp, newBuildConfig(p)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = os.Chmod(tmp, 0o755)
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	fmt.Println("  SUCCESS")
+
+	// Point out when the binary will not be found by the shell
+	inPath := false
+	for _, d := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.Clean(d) == filepath.Clean(dir) {
+			inPath = true
+			break
+		}
+	}
+	if !inPath {
+		fmt.Printf("Note: %s is not in your PATH\n", dir)
+	}
+	return nil
+}
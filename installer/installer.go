@@ -0,0 +1,351 @@
+package installer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"pbuild/fsutil"
+	"pbuild/targets"
+)
+
+// Install scripts written into the version directory
+const (
+	ShellName      = "install.sh"
+	PowerShellName = "install.ps1"
+)
+
+// Asset is a downloadable binary of one target
+type Asset struct {
+	Target targets.Target
+	URL    string
+	SHA256 string
+	// Compression is "gzip" or "zstd" for compressed binaries
+	Compression string
+}
+
+// Release is what the scripts install
+type Release struct {
+	Project string
+	Version string
+	// Binary is the installed file name, without .exe
+	Binary string
+	Assets []Asset
+}
+
+// Installable reports whether an install script can run on the target's OS
+func Installable(t targets.Target) bool {
+	switch t.OS {
+	case "js", "wasip1", "android", "ios", "plan9":
+		return false
+	}
+	return true
+}
+
+// Write renders install.sh for the Unix assets and install.ps1 for the
+// Windows ones into dir and returns the names of the scripts written
+func Write(dir string, r Release) ([]string, error) {
+	var unix, windows []Asset
+	for _, a := range r.Assets {
+		if !Installable(a.Target) {
+			continue
+		}
+		if a.Target.OS == "windows" {
+			windows = append(windows, a)
+		} else {
+			unix = append(unix, a)
+		}
+	}
+	var written []string
+	if len(unix) > 0 {
+		if err := render(filepath.Join(dir, ShellName), shellTemplate, r, shellCases(unix), 0755); err != nil {
+			return written, err
+		}
+		written = append(written, ShellName)
+	}
+	if len(windows) > 0 {
+		if err := render(filepath.Join(dir, PowerShellName), powerShellTemplate, r, windowsCases(windows), 0644); err != nil {
+			return written, err
+		}
+		written = append(written, PowerShellName)
+	}
+	return written, nil
+}
+
+// scriptCase is an asset with the platform patterns that select it
+type scriptCase struct {
+	Asset
+	Patterns []string
+}
+
+// shellCases matches assets by os/arch; universal darwin binaries serve both
+// architectures that have no binary of their own
+func shellCases(assets []Asset) []scriptCase {
+	own := map[string]bool{}
+	for _, a := range assets {
+		own[a.Target.String()] = true
+	}
+	var cases []scriptCase
+	for _, a := range assets {
+		c := scriptCase{Asset: a, Patterns: []string{a.Target.String()}}
+		if a.Target.OS == "darwin" && a.Target.Arch == targets.DarwinUniversal {
+			c.Patterns = nil
+			for _, arch := range []string{"amd64", "arm64"} {
+				if !own["darwin/"+arch] {
+					c.Patterns = append(c.Patterns, "darwin/"+arch)
+				}
+			}
+			if len(c.Patterns) == 0 {
+				continue
+			}
+		}
+		cases = append(cases, c)
+	}
+	sort.Slice(cases, func(i, j int) bool { return cases[i].Patterns[0] < cases[j].Patterns[0] })
+	return cases
+}
+
+// windowsCases matches assets by architecture
+func windowsCases(assets []Asset) []scriptCase {
+	var cases []scriptCase
+	for _, a := range assets {
+		cases = append(cases, scriptCase{Asset: a, Patterns: []string{a.Target.Arch}})
+	}
+	sort.Slice(cases, func(i, j int) bool { return cases[i].Patterns[0] < cases[j].Patterns[0] })
+	return cases
+}
+
+func render(path, text string, r Release, cases []scriptCase, perm os.FileMode) error {
+	tmpl, err := template.New(filepath.Base(path)).Funcs(template.FuncMap{
+		"sh":   shellQuote,
+		"ps":   powerShellQuote,
+		"join": strings.Join,
+	}).Parse(text)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	data := struct {
+		Release
+		Cases []scriptCase
+	}{r, cases}
+	if err := tmpl.Execute(&b, data); err != nil {
+		return fmt.Errorf("failed to render %s: %v", filepath.Base(path), err)
+	}
+	return fsutil.WriteFileAtomic(path, b.Bytes(), perm)
+}
+
+// shellQuote single-quotes s for POSIX sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// powerShellQuote single-quotes s for PowerShell
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+const shellTemplate = `#!/bin/sh
+# Installs {{.Project}} {{.Version}}; generated by pbuild.
+#
+#   curl -fsSL <release-url>/install.sh | sh
+#
+# INSTALL_DIR overrides the directory, by default /usr/local/bin when it is
+# writable and ~/.local/bin otherwise.
+set -eu
+
+NAME={{sh .Binary}}
+VERSION={{sh .Version}}
+
+fail() {
+	echo "install.sh: $*" >&2
+	exit 1
+}
+
+detect_os() {
+	case "$(uname -s)" in
+	Linux) echo linux ;;
+	Darwin) echo darwin ;;
+	FreeBSD) echo freebsd ;;
+	OpenBSD) echo openbsd ;;
+	NetBSD) echo netbsd ;;
+	DragonFly) echo dragonfly ;;
+	SunOS) if [ "$(uname -o 2>/dev/null)" = illumos ]; then echo illumos; else echo solaris; fi ;;
+	AIX) echo aix ;;
+	*) fail "unsupported operating system $(uname -s)" ;;
+	esac
+}
+
+detect_arch() {
+	case "$1" in
+	aix) echo ppc64; return ;;
+	solaris | illumos) isainfo -k; return ;;
+	darwin)
+		# An x86_64 shell under Rosetta still prefers the native binary
+		if [ "$(sysctl -n hw.optional.arm64 2>/dev/null)" = 1 ]; then echo arm64; return; fi
+		;;
+	esac
+	case "$(uname -m)" in
+	x86_64 | amd64) echo amd64 ;;
+	i386 | i486 | i586 | i686 | i86pc) echo 386 ;;
+	aarch64 | arm64 | evbarm) echo arm64 ;;
+	arm*) echo arm ;;
+	riscv64) echo riscv64 ;;
+	ppc64le) echo ppc64le ;;
+	ppc64) echo ppc64 ;;
+	s390x) echo s390x ;;
+	loongarch64) echo loong64 ;;
+	mips64el) echo mips64le ;;
+	mips64) echo mips64 ;;
+	mipsel) echo mipsle ;;
+	mips) echo mips ;;
+	*) uname -m ;;
+	esac
+}
+
+download() {
+	if command -v curl >/dev/null 2>&1; then
+		curl -fsSL -o "$2" "$1"
+	elif command -v wget >/dev/null 2>&1; then
+		wget -q -O "$2" "$1"
+	else
+		fail "curl or wget is required"
+	fi
+}
+
+sha256() {
+	if command -v sha256sum >/dev/null 2>&1; then
+		sha256sum "$1" | cut -d ' ' -f 1
+	elif command -v shasum >/dev/null 2>&1; then
+		shasum -a 256 "$1" | cut -d ' ' -f 1
+	elif command -v sha256 >/dev/null 2>&1; then
+		sha256 -q "$1"
+	elif command -v openssl >/dev/null 2>&1; then
+		openssl dgst -sha256 "$1" | sed 's/^.*= //'
+	else
+		fail "sha256sum, shasum, sha256 or openssl is required"
+	fi
+}
+
+OS=$(detect_os)
+ARCH=$(detect_arch "$OS")
+case "$OS/$ARCH" in
+{{- range .Cases}}
+{{join .Patterns " | "}})
+	URL={{sh .URL}}
+	SHA256={{sh .SHA256}}
+	COMPRESSION={{sh .Compression}}
+	;;
+{{- end}}
+*) fail "there is no $NAME $VERSION binary for $OS/$ARCH" ;;
+esac
+
+TMP=$(mktemp -d)
+trap 'rm -rf "$TMP"' EXIT
+
+echo "Downloading $NAME $VERSION for $OS/$ARCH"
+download "$URL" "$TMP/download"
+ACTUAL=$(sha256 "$TMP/download")
+[ "$ACTUAL" = "$SHA256" ] || fail "checksum mismatch for $URL: expected $SHA256, got $ACTUAL"
+
+case "$COMPRESSION" in
+gzip) gzip -dc "$TMP/download" >"$TMP/$NAME" ;;
+zstd)
+	command -v zstd >/dev/null 2>&1 || fail "zstd is required to unpack $URL"
+	zstd -qdc "$TMP/download" >"$TMP/$NAME"
+	;;
+*) mv "$TMP/download" "$TMP/$NAME" ;;
+esac
+chmod 755 "$TMP/$NAME"
+
+if [ -z "${INSTALL_DIR:-}" ]; then
+	if [ -w /usr/local/bin ]; then
+		INSTALL_DIR=/usr/local/bin
+	else
+		INSTALL_DIR="$HOME/.local/bin"
+	fi
+fi
+mkdir -p "$INSTALL_DIR"
+mv -f "$TMP/$NAME" "$INSTALL_DIR/$NAME"
+echo "Installed $NAME $VERSION to $INSTALL_DIR/$NAME"
+case ":$PATH:" in
+*":$INSTALL_DIR:"*) ;;
+*) echo "Note: $INSTALL_DIR is not on PATH" ;;
+esac
+`
+
+const powerShellTemplate = `# Installs {{.Project}} {{.Version}}; generated by pbuild.
+#
+#   irm <release-url>/install.ps1 | iex
+#
+# $env:INSTALL_DIR overrides the directory, by default
+# %LOCALAPPDATA%\Programs\{{.Binary}}.
+$ErrorActionPreference = 'Stop'
+
+$Name = {{ps .Binary}}
+$Version = {{ps .Version}}
+$Assets = @{
+{{- range .Cases}}
+	{{ps (index .Patterns 0)}} = @{ Url = {{ps .URL}}; Sha256 = {{ps .SHA256}}; Compression = {{ps .Compression}} }
+{{- end}}
+}
+
+# A 32-bit PowerShell on 64-bit Windows reports the native architecture here
+$Machine = if ($env:PROCESSOR_ARCHITEW6432) { $env:PROCESSOR_ARCHITEW6432 } else { $env:PROCESSOR_ARCHITECTURE }
+$Arch = switch ($Machine) {
+	'AMD64' { 'amd64' }
+	'ARM64' { 'arm64' }
+	'x86' { '386' }
+	'ARM' { 'arm' }
+	default { $Machine }
+}
+$Asset = $Assets[$Arch]
+if (-not $Asset) { throw "There is no $Name $Version binary for windows/$Arch" }
+
+$Dir = if ($env:INSTALL_DIR) { $env:INSTALL_DIR } else { Join-Path $env:LOCALAPPDATA "Programs\$Name" }
+$Tmp = Join-Path ([IO.Path]::GetTempPath()) ([IO.Path]::GetRandomFileName())
+New-Item -ItemType Directory -Path $Tmp | Out-Null
+try {
+	$Download = Join-Path $Tmp 'download'
+	$Exe = Join-Path $Tmp "$Name.exe"
+	Write-Host "Downloading $Name $Version for windows/$Arch"
+	[Net.ServicePointManager]::SecurityProtocol = [Net.ServicePointManager]::SecurityProtocol -bor [Net.SecurityProtocolType]::Tls12
+	Invoke-WebRequest -UseBasicParsing -Uri $Asset.Url -OutFile $Download
+	$Actual = (Get-FileHash -Algorithm SHA256 -Path $Download).Hash.ToLower()
+	if ($Actual -ne $Asset.Sha256) { throw "Checksum mismatch for $($Asset.Url): expected $($Asset.Sha256), got $Actual" }
+
+	switch ($Asset.Compression) {
+		'gzip' {
+			$In = [IO.File]::OpenRead($Download)
+			$Out = [IO.File]::Create($Exe)
+			try {
+				$Gzip = New-Object IO.Compression.GZipStream($In, [IO.Compression.CompressionMode]::Decompress)
+				$Gzip.CopyTo($Out)
+			} finally {
+				$Out.Dispose()
+				$In.Dispose()
+			}
+		}
+		'zstd' {
+			if (-not (Get-Command zstd -ErrorAction SilentlyContinue)) { throw "zstd is required to unpack $($Asset.Url)" }
+			& zstd -q -d $Download -o $Exe
+			if ($LASTEXITCODE -ne 0) { throw "Failed to unpack $($Asset.Url)" }
+		}
+		default { Move-Item -Path $Download -Destination $Exe }
+	}
+
+	New-Item -ItemType Directory -Force -Path $Dir | Out-Null
+	Move-Item -Force -Path $Exe -Destination (Join-Path $Dir "$Name.exe")
+} finally {
+	Remove-Item -Recurse -Force -Path $Tmp
+}
+Write-Host "Installed $Name $Version to $(Join-Path $Dir "$Name.exe")"
+$UserPath = [Environment]::GetEnvironmentVariable('Path', 'User')
+if (-not (($env:Path -split ';') -contains $Dir) -and -not (($UserPath -split ';') -contains $Dir)) {
+	Write-Host "Note: $Dir is not on PATH"
+}
+`
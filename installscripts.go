@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"pbuild/config"
+	"pbuild/installer"
+	"pbuild/publish"
+	"pbuild/targets"
+)
+
+// installURLs resolves the download URLs the install scripts fetch binaries from
+type installURLs struct {
+	p    *project
+	tmpl *template.Template
+	dest *publish.Destination
+}
+
+// newInstallURLs validates the URL source of --install-scripts before building
+func newInstallURLs(p *project, dests []*publish.Destination) (*installURLs, error) {
+	if !flagInstallScripts {
+		return nil, nil
+	}
+	switch flagBuildMode {
+	case "c-archive", "c-shared":
+		return nil, fmt.Errorf("--install-scripts installs executables, not --buildmode %s libraries", flagBuildMode)
+	}
+	u := &installURLs{p: p}
+	if text := p.cfg.InstallScripts.URL; text != "" {
+		tmpl, err := template.New("install url").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid install_scripts.url in %s: %v", config.FileName, err)
+		}
+		u.tmpl = tmpl
+		return u, nil
+	}
+	if len(dests) == 0 {
+		return nil, fmt.Errorf("--install-scripts needs install_scripts.url in %s or --publish", config.FileName)
+	}
+	u.dest = dests[0]
+	return u, nil
+}
+
+// url returns where the file of target t is downloaded from
+func (u *installURLs) url(t targets.Target, file string) (string, error) {
+	data := pathData(u.p, file)
+	data.Target, data.OS, data.Arch = t.String(), t.OS, t.Arch
+	if u.dest != nil {
+		return u.dest.FileURL(data)
+	}
+	var b bytes.Buffer
+	if err := u.tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render install_scripts.url: %v", err)
+	}
+	return b.String(), nil
+}
+
+// writeInstallScripts writes install.sh and install.ps1 for the built binaries
+func writeInstallScripts(u *installURLs, rows []summaryRow, versionDir string) {
+	if u == nil {
+		return
+	}
+	release := installer.Release{Project: u.p.name, Version: u.p.version, Binary: u.p.name}
+	for _, r := range rows {
+		t, err := targets.Parse(r.Target)
+		if !r.Success || err != nil || !installer.Installable(t) {
+			continue
+		}
+		sum := r.SHA256
+		if sum == "" {
+			// --checksums=false leaves the digest to us
+			if sum, _, err = generateChecksums(filepath.Join(versionDir, r.File)); err != nil {
+				fmt.Printf("Warning: Failed to write install scripts: %v\n", err)
+				return
+			}
+		}
+		url, err := u.url(t, r.File)
+		if err != nil {
+			fmt.Printf("Warning: Failed to write install scripts: %v\n", err)
+			return
+		}
+		asset := installer.Asset{Target: t, URL: url, SHA256: sum}
+		// A binary whose compression failed is stored as is
+		for _, method := range []string{"gzip", "zstd"} {
+			if strings.HasSuffix(r.File, compressionExt(method)) {
+				asset.Compression = method
+			}
+		}
+		release.Assets = append(release.Assets, asset)
+	}
+	if len(release.Assets) == 0 {
+		fmt.Println("Warning: No install scripts written, no installable target was built")
+		return
+	}
+	written, err := installer.Write(versionDir, release)
+	for _, name := range written {
+		fmt.Printf("Install script written to: %s\n", filepath.Join(versionDir, name))
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to write install scripts: %v\n", err)
+	}
+}
@@ -0,0 +1,136 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultAPI is the RPC address of a local Kubo node
+const DefaultAPI = "http://127.0.0.1:5001"
+
+// addedEntry is a line of the /api/v0/add response
+type addedEntry struct {
+	Name string
+	Hash string
+}
+
+// Add uploads files, slash-separated paths relative to dir, as a directory
+// named name to the Kubo node at api, pins it and returns its CIDv1
+func Add(ctx context.Context, api, dir, name string, files []string) (string, error) {
+	if api == "" {
+		api = DefaultAPI
+	}
+	endpoint := strings.TrimRight(api, "/") + "/api/v0/add?" + url.Values{
+		"pin":         {"true"},
+		"cid-version": {"1"},
+		"progress":    {"false"},
+	}.Encode()
+
+	body, contentType := multipartBody(dir, name, files)
+	defer body.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("unexpected response status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e addedEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("invalid add response: %v", err)
+		}
+		if e.Name == name {
+			return e.Hash, nil
+		}
+	}
+	return "", fmt.Errorf("the node did not return the CID of %s", name)
+}
+
+// multipartBody streams the directory tree as the node expects it: every
+// directory part before the files in it
+func multipartBody(dir, name string, files []string) (io.ReadCloser, string) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeParts(mw, dir, name, files))
+	}()
+	return pr, mw.FormDataContentType()
+}
+
+func writeParts(mw *multipart.Writer, dir, name string, files []string) error {
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+	dirs := map[string]bool{}
+	addDir := func(p string) error {
+		if dirs[p] {
+			return nil
+		}
+		dirs[p] = true
+		_, err := mw.CreatePart(partHeader(p, "application/x-directory"))
+		return err
+	}
+	if err := addDir(name); err != nil {
+		return err
+	}
+	for _, f := range files {
+		full := path.Join(name, f)
+		// Parents first, outermost to innermost
+		var parents []string
+		for p := path.Dir(full); p != name; p = path.Dir(p) {
+			parents = append([]string{p}, parents...)
+		}
+		for _, p := range parents {
+			if err := addDir(p); err != nil {
+				return err
+			}
+		}
+		w, err := mw.CreatePart(partHeader(full, "application/octet-stream"))
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func partHeader(name, contentType string) textproto.MIMEHeader {
+	// Kubo query-unescapes the name, which would turn the + of a version like
+	// 1.0.0+build into a space
+	escaped := strings.ReplaceAll(url.PathEscape(name), "+", "%2B")
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, escaped))
+	h.Set("Content-Type", contentType)
+	return h
+}
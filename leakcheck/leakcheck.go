@@ -0,0 +1,128 @@
+package leakcheck
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Root is a host directory whose absolute path should not end up in a binary
+type Root struct {
+	Kind string // e.g. "the module source", "GOROOT"
+	Path string
+}
+
+// Finding is a host path found in a binary, grouped by the root it lies under
+type Finding struct {
+	Kind  string
+	Root  string
+	Paths []string // distinct leaked paths, sorted
+}
+
+// minRootLen skips roots like "/" or "C:\" that would match everywhere
+const minRootLen = 4
+
+// Scan looks for the roots' paths in the file. Each occurrence is attributed to
+// the longest root it starts with; paths matching one of the allow patterns
+// (path.Match syntax, or a prefix ending in "/") are not reported.
+func Scan(file string, roots []Root, allow []string) ([]Finding, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// Longest roots first, so GOMODCACHE wins over GOPATH and the home directory
+	sort.SliceStable(roots, func(i, j int) bool { return len(roots[i].Path) > len(roots[j].Path) })
+
+	found := make(map[string]map[string]bool) // root path -> leaked paths
+	for _, r := range roots {
+		if len(r.Path) < minRootLen {
+			continue
+		}
+		needle := []byte(r.Path)
+		for rest, off := data, 0; ; {
+			i := bytes.Index(rest, needle)
+			if i < 0 {
+				break
+			}
+			start := off + i
+			leaked := extractPath(data, start)
+			rest, off = rest[i+len(needle):], start+len(needle)
+			if owner := ownerOf(leaked, roots); owner != r.Path || allowed(leaked, allow) {
+				continue
+			}
+			if found[r.Path] == nil {
+				found[r.Path] = make(map[string]bool)
+			}
+			found[r.Path][leaked] = true
+		}
+	}
+
+	var findings []Finding
+	for _, r := range roots {
+		paths := found[r.Path]
+		if len(paths) == 0 {
+			continue
+		}
+		f := Finding{Kind: r.Kind, Root: r.Path}
+		for p := range paths {
+			f.Paths = append(f.Paths, p)
+		}
+		sort.Strings(f.Paths)
+		findings = append(findings, f)
+		delete(found, r.Path) // roots listed twice are reported once
+	}
+	return findings, nil
+}
+
+// extractPath returns the path-like run of bytes starting at i
+func extractPath(data []byte, i int) string {
+	end := i
+	for end < len(data) && end-i < 4096 {
+		c := data[end]
+		if c < 0x20 || c >= 0x7f || c == '"' || c == '\'' || c == '`' || c == '<' || c == '>' || c == '|' {
+			break
+		}
+		end++
+	}
+	return string(data[i:end])
+}
+
+// ownerOf returns the longest root the path starts with
+func ownerOf(p string, roots []Root) string {
+	for _, r := range roots {
+		if len(r.Path) >= minRootLen && strings.HasPrefix(p, r.Path) {
+			return r.Path
+		}
+	}
+	return ""
+}
+
+// allowed reports whether the path matches an allow pattern
+func allowed(p string, allow []string) bool {
+	for _, pattern := range allow {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(p, pattern) {
+			return true
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary describes the findings in one line, e.g.
+// "12 paths under the module source (/home/me/app), 1 under GOROOT (/usr/local/go)"
+func Summary(findings []Finding) string {
+	var parts []string
+	for i, f := range findings {
+		unit := "paths under"
+		if i > 0 {
+			unit = "under"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s %s (%s)", len(f.Paths), unit, f.Kind, f.Root))
+	}
+	return strings.Join(parts, ", ")
+}
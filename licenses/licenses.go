@@ -0,0 +1,172 @@
+package licenses
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pbuild/fsutil"
+)
+
+// NoticesFile is the notices bundle written into the version directory and archives
+const NoticesFile = "THIRD_PARTY_NOTICES"
+
+// Unknown is the license id of dependencies whose license could not be identified
+const Unknown = "unknown"
+
+// Module is one dependency compiled into the binary
+type Module struct {
+	Path    string
+	Version string
+	License string // SPDX identifier, or Unknown
+	File    string // license file name inside the module, empty when none was found
+	Text    string
+}
+
+// licenseFiles are the base names (before any extension) recognized as license texts
+var licenseFiles = []string{"license", "licence", "copying", "unlicense"}
+
+// signatures identify a license by phrases of its text; the first match wins,
+// so more specific licenses come before the ones they resemble
+var signatures = []struct {
+	id      string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"cc0 1.0 universal"}},
+	{"Zlib", []string{"this software is provided 'as-is'", "altered source versions must be plainly marked"}},
+}
+
+// Identify returns the SPDX id of a license text, or Unknown
+func Identify(text string) string {
+	// Collapse whitespace so line wrapping does not break phrases
+	norm := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	for _, sig := range signatures {
+		match := true
+		for _, phrase := range sig.phrases {
+			if !strings.Contains(norm, phrase) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return sig.id
+		}
+	}
+	return Unknown
+}
+
+// findLicense returns the license file in a module directory
+func findLicense(dir string) (string, string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", ""
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		base := strings.ToLower(e.Name())
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+		for _, name := range licenseFiles {
+			if base == name || strings.HasPrefix(base, name+"-") || strings.HasPrefix(base, name+"_") {
+				b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+				if err != nil {
+					continue
+				}
+				return e.Name(), string(b)
+			}
+		}
+	}
+	return "", ""
+}
+
+// Collect lists the modules of every package linked into the main package in workDir
+func Collect(ctx context.Context, workDir string) ([]Module, error) {
+	cmd := exec.CommandContext(ctx, "go", "list", "-deps",
+		"-f", "{{with .Module}}{{if not .Main}}{{.Path}}\t{{.Version}}\t{{.Dir}}{{end}}{{end}}", ".")
+	cmd.Dir = workDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list failed: %v\n%s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	seen := make(map[string]bool)
+	var modules []Module
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), "\t")
+		if len(fields) != 3 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		m := Module{Path: fields[0], Version: fields[1], License: Unknown}
+		m.File, m.Text = findLicense(fields[2])
+		if m.Text != "" {
+			m.License = Identify(m.Text)
+		}
+		modules = append(modules, m)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+	return modules, nil
+}
+
+// Forbidden returns the modules whose license matches one of the patterns
+// (SPDX ids with * wildcards, e.g. GPL-*, or "unknown")
+func Forbidden(modules []Module, patterns []string) []Module {
+	var bad []Module
+	for _, m := range modules {
+		for _, p := range patterns {
+			if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(m.License)); ok {
+				bad = append(bad, m)
+				break
+			}
+		}
+	}
+	return bad
+}
+
+// Notices renders the notices bundle: a summary followed by every license text
+func Notices(project string, modules []Module) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Third-party notices for %s\n\n", project)
+	fmt.Fprintf(&b, "This software includes the following %d Go modules:\n\n", len(modules))
+	for _, m := range modules {
+		fmt.Fprintf(&b, "  %s %s (%s)\n", m.Path, m.Version, m.License)
+	}
+	for _, m := range modules {
+		fmt.Fprintf(&b, "\n%s\n%s %s\n", strings.Repeat("=", 78), m.Path, m.Version)
+		if m.Text == "" {
+			fmt.Fprintf(&b, "License: %s (no license file found)\n", m.License)
+			continue
+		}
+		fmt.Fprintf(&b, "License: %s (%s)\n%s\n\n%s\n", m.License, m.File, strings.Repeat("-", 78), strings.TrimSpace(m.Text))
+	}
+	return b.Bytes()
+}
+
+// Write writes the notices bundle to dir/THIRD_PARTY_NOTICES
+func Write(dir, project string, modules []Module) ([]byte, error) {
+	data := Notices(project, modules)
+	return data, fsutil.WriteFileAtomic(filepath.Join(dir, NoticesFile), data, 0644)
+}
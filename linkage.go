@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/linkcheck"
+	"pbuild/pipeline"
+)
+
+// staticCheckStage fails ELF binaries that should be static but need a
+// dynamic loader or shared libraries. For flexible (cgo) builds it records
+// the linkage for the system library report even when it does not check.
+type staticCheckStage struct {
+	enabled bool
+	record  bool
+}
+
+// newStaticCheckStage resolves --static-check; auto checks CGO_ENABLED=0 executables
+func newStaticCheckStage(config gobuild.BuildConfig) (*staticCheckStage, error) {
+	s := &staticCheckStage{record: config.Strategy == gobuild.FlexibleCGO}
+	switch flagStaticCheck {
+	case "auto":
+		s.enabled = config.Strategy != gobuild.FlexibleCGO && config.BuildMode == "exe"
+	case "always":
+		s.enabled = true
+	case "never":
+	default:
+		return nil, fmt.Errorf("invalid --static-check %q (expected auto, always or never)", flagStaticCheck)
+	}
+	return s, nil
+}
+
+func (s *staticCheckStage) Name() string  { return "static" }
+func (s *staticCheckStage) Enabled() bool { return s.enabled || s.record }
+
+func (s *staticCheckStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	linkage, ok, err := linkcheck.Inspect(a.Temp)
+	if err != nil {
+		stageLog(a, "Static check failed: %v", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	a.Result.Linkage = linkage.String()
+	a.Result.Loader, a.Result.Libraries = linkage.Interpreter, linkage.Needed
+	if !s.enabled {
+		if !linkage.Static() {
+			stageLog(a, "Needs %s", strings.Join(append([]string{linkage.Interpreter}, linkage.Needed...), ", "))
+		}
+		return nil
+	}
+	if !linkcheck.CanBeStatic(a.Target.OS) {
+		stageLog(a, "Linked %s; %s binaries always use the system loader", linkage, a.Target.OS)
+		return nil
+	}
+	if !linkage.Static() {
+		return fmt.Errorf("binary should be static but is linked %s", linkage)
+	}
+	stageLog(a, "Statically linked")
+	return nil
+}
+
+// writeSystemLibraries writes the report of the shared libraries the
+// binaries of a flexible (cgo) run need
+func writeSystemLibraries(p *project, rows []summaryRow, versionDir string) {
+	if newBuildConfig(p).Strategy != gobuild.FlexibleCGO {
+		return
+	}
+	var list []linkcheck.Target
+	for _, r := range rows {
+		if !r.Success {
+			continue
+		}
+		list = append(list, linkcheck.Target{
+			Target:    r.Target,
+			Inspected: r.Linkage != "",
+			Linkage:   linkcheck.Linkage{Interpreter: r.Loader, Needed: r.Libraries},
+		})
+	}
+	if len(list) == 0 {
+		return
+	}
+	path := filepath.Join(versionDir, linkcheck.ReportFile)
+	if err := fsutil.WriteFileAtomic(path, linkcheck.Report(p.name, p.version, list), 0644); err != nil {
+		fmt.Printf("Warning: Failed to write %s: %v\n", linkcheck.ReportFile, err)
+		return
+	}
+	fmt.Printf("System library report written to: %s\n", path)
+}
@@ -0,0 +1,152 @@
+package linkcheck
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ReportFile is the report of the shared libraries flexible (cgo) builds
+// need, written into the version directory
+const ReportFile = "system-libraries.md"
+
+// Linkage describes how an ELF binary is linked
+type Linkage struct {
+	// Interpreter is the PT_INTERP dynamic loader; empty for static binaries
+	Interpreter string
+	// Needed are the DT_NEEDED shared libraries
+	Needed []string
+}
+
+// Static reports whether the binary runs without a loader or shared libraries
+func (l Linkage) Static() bool {
+	return l.Interpreter == "" && len(l.Needed) == 0
+}
+
+// String summarizes the linkage, e.g. "dynamic (loader /lib64/ld-linux-x86-64.so.2, needs libc.so.6)"
+func (l Linkage) String() string {
+	if l.Static() {
+		return "static"
+	}
+	var parts []string
+	if l.Interpreter != "" {
+		parts = append(parts, "loader "+l.Interpreter)
+	}
+	if len(l.Needed) > 0 {
+		parts = append(parts, "needs "+strings.Join(l.Needed, ", "))
+	}
+	return "dynamic (" + strings.Join(parts, ", ") + ")"
+}
+
+// Inspect reads the linkage of an ELF file; ok is false for other formats
+func Inspect(file string) (linkage Linkage, ok bool, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Linkage{}, false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != elf.ELFMAG {
+		return Linkage{}, false, nil
+	}
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		return Linkage{}, false, fmt.Errorf("invalid ELF file: %v", err)
+	}
+	defer ef.Close()
+
+	for _, prog := range ef.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return Linkage{}, false, fmt.Errorf("failed to read the interpreter: %v", err)
+		}
+		linkage.Interpreter = string(bytes.TrimRight(data, "\x00"))
+	}
+	// Binaries without a dynamic section have no imported libraries
+	if ef.Section(".dynamic") != nil {
+		if linkage.Needed, err = ef.ImportedLibraries(); err != nil {
+			return Linkage{}, false, fmt.Errorf("failed to read the dynamic section: %v", err)
+		}
+	}
+	return linkage, true, nil
+}
+
+// LibC names the C library a dynamic loader belongs to, e.g. glibc for
+// /lib64/ld-linux-x86-64.so.2, or "" when it is not a known one
+func LibC(loader string) string {
+	base := path.Base(loader)
+	switch {
+	case strings.HasPrefix(base, "ld-linux"), strings.HasPrefix(base, "ld64.so"), base == "ld.so.1" && strings.HasPrefix(loader, "/lib"):
+		return "glibc"
+	case strings.HasPrefix(base, "ld-musl"):
+		return "musl"
+	case base == "linker" || base == "linker64":
+		return "bionic"
+	}
+	return ""
+}
+
+// Target is the linkage of one target's binary for the report; Inspected is
+// false for binaries that are not ELF
+type Target struct {
+	Target    string
+	Inspected bool
+	Linkage   Linkage
+}
+
+// Report renders the shared libraries each target needs at run time, and
+// which targets need each library
+func Report(project, version string, list []Target) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# System libraries of %s %s\n\n", project, version)
+	b.WriteString("Shared libraries (DT_NEEDED) and dynamic loader (PT_INTERP) each binary of this flexible (cgo) build needs on the target system.\n\n")
+	b.WriteString("| Target | Linkage | Loader | C library | Libraries |\n|--------|---------|--------|-----------|-----------|\n")
+	users := make(map[string][]string)
+	for _, t := range list {
+		if !t.Inspected {
+			fmt.Fprintf(&b, "| %s | not ELF, not inspected | | | |\n", t.Target)
+			continue
+		}
+		linkage := "dynamic"
+		if t.Linkage.Static() {
+			linkage = "static"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", t.Target, linkage, t.Linkage.Interpreter, LibC(t.Linkage.Interpreter), strings.Join(t.Linkage.Needed, ", "))
+		for _, lib := range t.Linkage.Needed {
+			users[lib] = append(users[lib], t.Target)
+		}
+	}
+	if len(users) == 0 {
+		return []byte(b.String())
+	}
+	libs := make([]string, 0, len(users))
+	for lib := range users {
+		libs = append(libs, lib)
+	}
+	sort.Strings(libs)
+	b.WriteString("\n| Library | Needed by |\n|---------|-----------|\n")
+	for _, lib := range libs {
+		fmt.Fprintf(&b, "| %s | %s |\n", lib, strings.Join(users[lib], ", "))
+	}
+	return []byte(b.String())
+}
+
+// CanBeStatic reports whether Go links CGO_ENABLED=0 binaries for goos
+// statically; openbsd, solaris, illumos and android binaries always use the
+// system loader
+func CanBeStatic(goos string) bool {
+	switch goos {
+	case "linux", "freebsd", "netbsd", "dragonfly":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/buildmeta"
+	"pbuild/config"
+	"pbuild/creds"
+	"pbuild/gobuild"
+	"pbuild/publish"
+	"pbuild/targets"
+)
+
+// newLintCmd returns the `pbuild lint` subcommand
+func newLintCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lint [TARGET_DIR] [-- BUILD FLAGS]",
+		Short: "Check the settings of a build for problems before running it",
+		Long: "Checks what a build with BUILD FLAGS would run into without building: every\n" +
+			"invalid setting, artifact, archive and upload names that collide across\n" +
+			"targets, build modes the go tool does not support on a target, a signing\n" +
+			"method without a key and publish destinations without credentials.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			args, err := buildFlagArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			if len(args) > 1 {
+				return fmt.Errorf("accepts at most 1 directory, received %d", len(args))
+			}
+			return runLint(targetArg(args))
+		},
+	}
+}
+
+// buildFlagArgs applies the build flags given after -- and returns the
+// arguments before them, for subcommands that look at a build without
+// running it
+func buildFlagArgs(cmd *cobra.Command, args []string) ([]string, error) {
+	var flagArgs []string
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		args, flagArgs = args[:dash], args[dash:]
+	}
+	return args, cmd.Root().ParseFlags(flagArgs)
+}
+
+func runLint(targetDir string) error {
+	p, err := resolveProject(targetDir)
+	if err != nil {
+		return err
+	}
+	if err := validateSettings(p); err != nil {
+		return err
+	}
+	applyConfigDefaults(p)
+	matrix, err := resolveMatrix(p.cfg)
+	if err != nil {
+		return err
+	}
+	archives, err := newArchivePlan(p)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	problems = append(problems, lintNames(p, matrix, archives)...)
+	problems = append(problems, lintBuildMode(p, matrix)...)
+	problems = append(problems, lintSigning(p)...)
+	problems = append(problems, lintPublish(p, matrix, archives)...)
+	if len(problems) == 0 {
+		fmt.Printf("No problems found for %s %s (%d targets)\n", p.name, p.version, len(matrix))
+		return nil
+	}
+	return fmt.Errorf("%d problems:\n  %s", len(problems), strings.Join(problems, "\n  "))
+}
+
+// lintNames checks that no file of a target overwrites another's
+func lintNames(p *project, matrix []targets.Target, archives *archivePlan) []string {
+	if _, err := resolveOutputNames(p, matrix, archives); err != nil {
+		return []string{"naming: " + err.Error()}
+	}
+	return nil
+}
+
+// lintBuildMode checks the build mode against the strategy and every target
+// that is not skipped
+func lintBuildMode(p *project, matrix []targets.Target) []string {
+	bc := newBuildConfig(p)
+	var problems []string
+	if (bc.BuildMode == "c-archive" || bc.BuildMode == "c-shared") && bc.Strategy != gobuild.FlexibleCGO {
+		problems = append(problems, fmt.Sprintf("buildmode: -buildmode=%s needs cgo, which --strategy %s turns off (use --strategy flexible)", bc.BuildMode, flagStrategy))
+	}
+	var unsupported []string
+	for _, t := range matrix {
+		if t.Arch == targets.DarwinUniversal || buildModeSupported(p, bc.BuildMode, t) {
+			continue
+		}
+		if reason, err := skipReason(p.cfg.Skip, t, bc); err == nil && reason != "" {
+			continue
+		}
+		unsupported = append(unsupported, t.String())
+	}
+	saveProbes(p.probes)
+	if len(unsupported) > 0 {
+		problems = append(problems, fmt.Sprintf("buildmode: -buildmode=%s is not available on %s (skip them with a rule `buildmode: %s` under skip: in %s)",
+			bc.BuildMode, strings.Join(unsupported, ", "), bc.BuildMode, config.FileName))
+	}
+	return problems
+}
+
+// lintSigning checks that the signing method has its key
+func lintSigning(p *project) []string {
+	if flagSign == "" {
+		return nil
+	}
+	if _, err := newSigner(p); err != nil {
+		msg := err.Error()
+		if _, _, credErr := p.creds.Get(creds.SignKey); flagSignKey == "" && errors.Is(credErr, creds.ErrNotFound) {
+			msg += "; " + credErr.Error()
+		}
+		return []string{fmt.Sprintf("sign: %s", msg)}
+	}
+	return nil
+}
+
+// lintPublish checks every publish: destination, whatever channel it takes:
+// its settings and credentials resolve, an http upload authenticates, and no
+// two files of the release upload to the same path
+func lintPublish(p *project, matrix []targets.Target, archives *archivePlan) []string {
+	names, err := targets.OutputNames(artifactBase(p), matrix)
+	if err != nil {
+		return nil
+	}
+	var problems []string
+	for i, c := range p.cfg.Publish {
+		d, err := publish.New(c, p.creds.Expand)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("publish[%d]: %v", i, err))
+			continue
+		}
+		switch strings.ToLower(c.Type) {
+		case "http", "artifactory", "webdav":
+			if !hasAuth(p, c) {
+				problems = append(problems, fmt.Sprintf("publish[%d]: %s upload to %s has no password, token or Authorization header", i, c.Type, c.URL))
+			}
+		}
+
+		if err := uploadCollision(p, d, matrix, names, archives); err != nil {
+			problems = append(problems, fmt.Sprintf("publish[%d]: %v", i, err))
+		}
+	}
+	return problems
+}
+
+// uploadCollision returns the first remote path two files of the matrix
+// would both upload to
+func uploadCollision(p *project, d *publish.Destination, matrix []targets.Target, names map[targets.Target]string, archives *archivePlan) error {
+	owner := make(map[string]string)
+	for _, t := range matrix {
+		name := names[t] + compressionExt(flagCompress)
+		var r buildmeta.TargetResult
+		if archives != nil {
+			var err error
+			if r.Archive, err = archives.fileName(p, t, name); err != nil {
+				return err
+			}
+		}
+		for _, file := range targetFiles(name, r) {
+			remote, err := d.RemotePath(pathData(p, file))
+			if err != nil {
+				return err
+			}
+			if other, ok := owner[remote]; ok {
+				return fmt.Errorf("%s and %s both upload to %s", other, file, remote)
+			}
+			owner[remote] = file
+		}
+	}
+	return nil
+}
+
+// hasAuth reports whether an http destination sends credentials
+func hasAuth(p *project, c config.Publish) bool {
+	values := []string{c.Password, c.Token}
+	for k, v := range c.Headers {
+		if strings.EqualFold(k, "Authorization") {
+			values = append(values, v)
+		}
+	}
+	for _, v := range values {
+		if s, err := p.creds.Expand(v); err == nil && s != "" {
+			return true
+		}
+	}
+	return false
+}
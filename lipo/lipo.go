@@ -0,0 +1,110 @@
+package lipo
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// fatMagic is the big-endian magic number of a universal (fat) Mach-O file
+const fatMagic = 0xcafebabe
+
+// slice describes one thin Mach-O image inside a universal binary
+type slice struct {
+	path   string
+	cpu    macho.Cpu
+	subCpu uint32
+	size   int64
+	align  uint32
+}
+
+// alignFor returns the power-of-two alignment lipo uses for the given CPU
+func alignFor(cpu macho.Cpu) uint32 {
+	switch cpu {
+	case macho.CpuArm64, macho.CpuArm:
+		return 14 // 16 KiB pages
+	default:
+		return 12 // 4 KiB pages
+	}
+}
+
+// Create merges thin Mach-O binaries into a single universal binary at outPath,
+// equivalent to `lipo -create -output outPath inputs...`
+func Create(outPath string, inputs ...string) error {
+	if len(inputs) < 2 {
+		return errors.New("lipo: at least two input binaries are required")
+	}
+
+	slices := make([]slice, 0, len(inputs))
+	seen := make(map[macho.Cpu]string)
+	for _, in := range inputs {
+		f, err := macho.Open(in)
+		if err != nil {
+			return fmt.Errorf("lipo: %s is not a thin Mach-O file: %v", in, err)
+		}
+		cpu, subCpu := f.Cpu, f.SubCpu
+		f.Close()
+
+		if prev, ok := seen[cpu]; ok {
+			return fmt.Errorf("lipo: %s and %s have the same architecture (%s)", prev, in, cpu)
+		}
+		seen[cpu] = in
+
+		fi, err := os.Stat(in)
+		if err != nil {
+			return err
+		}
+		slices = append(slices, slice{path: in, cpu: cpu, subCpu: subCpu, size: fi.Size(), align: alignFor(cpu)})
+	}
+
+	// Keep slice order stable regardless of input order
+	sort.Slice(slices, func(i, j int) bool { return slices[i].cpu < slices[j].cpu })
+
+	// Fat header (8 bytes) followed by one fat_arch (20 bytes) per slice
+	offset := int64(8 + 20*len(slices))
+	offsets := make([]int64, len(slices))
+	for i, s := range slices {
+		a := int64(1) << s.align
+		offset = (offset + a - 1) &^ (a - 1)
+		offsets[i] = offset
+		offset += s.size
+	}
+	if offset > 1<<32-1 {
+		return errors.New("lipo: universal binary exceeds 4 GiB, which the fat format cannot address")
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	header := []uint32{fatMagic, uint32(len(slices))}
+	for i, s := range slices {
+		header = append(header, uint32(s.cpu), s.subCpu, uint32(offsets[i]), uint32(s.size), s.align)
+	}
+	if err := binary.Write(out, binary.BigEndian, header); err != nil {
+		return err
+	}
+
+	for i, s := range slices {
+		if _, err := out.Seek(offsets[i], io.SeekStart); err != nil {
+			return err
+		}
+		in, err := os.Open(s.path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return out.Close()
+}
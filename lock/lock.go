@@ -0,0 +1,130 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// staleAfter is how old a lock held by another host must be before it is considered abandoned
+const staleAfter = 24 * time.Hour
+
+// pollInterval is how often a waiting run retries the lock
+const pollInterval = 500 * time.Millisecond
+
+// ErrLocked is returned when another run holds the lock
+var ErrLocked = errors.New("locked by another pbuild run")
+
+// owner is the content of a lock file
+type owner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+// Lock is an advisory lock file held for the duration of a run
+type Lock struct {
+	path string
+}
+
+// Acquire creates the lock file at path, removing stale locks left by dead processes.
+// If the lock is held, it retries for up to wait before returning ErrLocked.
+func Acquire(path string, wait time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		err := create(path)
+		if err == nil {
+			return &Lock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		holder, info, readErr := read(path)
+		if readErr == nil && stale(holder) {
+			removed, err := takeOver(path, info)
+			if err != nil {
+				return nil, err
+			}
+			if removed {
+				fmt.Printf("Removed stale lock %s (pid %d on %s)\n", path, holder.PID, holder.Host)
+			}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			if readErr == nil {
+				return nil, fmt.Errorf("%w: pid %d on %s since %s (%s)", ErrLocked, holder.PID, holder.Host, holder.Started.Format(time.RFC3339), path)
+			}
+			return nil, fmt.Errorf("%w (%s)", ErrLocked, path)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Release removes the lock file
+func (l *Lock) Release() error {
+	return os.Remove(l.path)
+}
+
+func create(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	host, _ := os.Hostname()
+	return json.NewEncoder(f).Encode(owner{PID: os.Getpid(), Host: host, Started: time.Now()})
+}
+
+// takeOver moves the stale lock file described by info out of the way. Two
+// waiters may both find the same stale lock: renaming it to a name of our own
+// is atomic, and if the file we got is not the one we judged stale, another
+// waiter already replaced it with a live lock, which is put back. When a
+// third run created a lock in the meantime the live one cannot be put back;
+// it is left aside rather than removed and the lock reported as contended.
+func takeOver(path string, info os.FileInfo) (bool, error) {
+	aside := fmt.Sprintf("%s.stale-%d-%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); err != nil {
+		return false, nil
+	}
+	got, err := os.Stat(aside)
+	if err == nil && os.SameFile(info, got) {
+		_ = os.Remove(aside)
+		return true, nil
+	}
+	if err := os.Link(aside, path); err != nil {
+		return false, fmt.Errorf("%w: %s is contended, a live lock was moved to %s while replacing a stale one", ErrLocked, path, aside)
+	}
+	_ = os.Remove(aside)
+	return false, nil
+}
+
+// read returns the holder of the lock at path and the file it was read from
+func read(path string) (owner, os.FileInfo, error) {
+	var o owner
+	f, err := os.Open(path)
+	if err != nil {
+		return o, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return o, nil, err
+	}
+	err = json.NewDecoder(f).Decode(&o)
+	return o, info, err
+}
+
+// stale reports whether the lock holder is gone
+func stale(o owner) bool {
+	host, _ := os.Hostname()
+	if o.Host != host {
+		// Cannot probe a remote process, so fall back to age
+		return time.Since(o.Started) > staleAfter
+	}
+	return !processAlive(o.PID)
+}
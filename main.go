@@ -5,32 +5,58 @@ import (
 	"context"
 	"crypto/sha256"
 	"crypto/sha512"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 	"unicode/utf8"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/olekukonko/tablewriter"
-	"github.com/olekukonko/tablewriter/renderer"
-	"github.com/olekukonko/tablewriter/tw"
 	"github.com/spf13/cobra"
 
-	"pbuild/appver"
+	"pbuild/benchgate"
+	"pbuild/buildenv"
+	"pbuild/buildmeta"
+	"pbuild/buildplan"
+	"pbuild/channel"
+	"pbuild/config"
+	"pbuild/deps"
 	"pbuild/fsutil"
-	"pbuild/gitmeta"
 	"pbuild/gobuild"
+	"pbuild/licenses"
+	"pbuild/linkcheck"
+	"pbuild/lipo"
+	"pbuild/lock"
+	"pbuild/metrics"
+	"pbuild/notify"
+	"pbuild/pipeline"
+	"pbuild/pool"
+	"pbuild/priority"
+	"pbuild/redact"
+	"pbuild/report"
+	"pbuild/sandbox"
+	"pbuild/sign"
 	"pbuild/targets"
+	"pbuild/ui"
 )
 
 var appVersion = "1.1.19"
 
+// redactor masks secrets before they are printed, logged or written to
+// metadata; set once the project's config is known
+var redactor *redact.Redactor
+
 // getBuildMode returns the appropriate build mode for the target platform
 func getBuildMode(requestedMode string) string {
 	if requestedMode != "auto" {
@@ -51,6 +77,34 @@ func getBuildStrategy(requestedStrategy, buildMode string) gobuild.BuildTagStrat
 	return gobuild.ParseStrategy(requestedStrategy)
 }
 
+// buildDarwinUniversal builds darwin/amd64 and darwin/arm64 and merges them into one universal binary
+func buildDarwinUniversal(ctx context.Context, workDir, outPath string, config gobuild.BuildConfig) error {
+	var thin []string
+	for _, arch := range []string{"amd64", "arm64"} {
+		thinPath := fmt.Sprintf("%s.%s.tmp", outPath, arch)
+		defer os.Remove(thinPath)
+		if err := gobuild.BuildWithConfig(ctx, workDir, targets.Target{OS: "darwin", Arch: arch}, thinPath, config); err != nil {
+			return err
+		}
+		thin = append(thin, thinPath)
+	}
+	if err := lipo.Create(outPath, thin...); err != nil {
+		return fmt.Errorf("failed to create universal binary: %v", err)
+	}
+	return nil
+}
+
+// compressionExt returns the file extension for a compression method
+func compressionExt(method string) string {
+	switch method {
+	case "gzip":
+		return ".gz"
+	case "zstd":
+		return ".zst"
+	}
+	return ""
+}
+
 // compressFile compresses a file using the specified method
 func compressFile(inputPath, outputPath, method string) error {
 	inputFile, err := os.Open(inputPath)
@@ -94,6 +148,51 @@ func compressFile(inputPath, outputPath, method string) error {
 	return nil
 }
 
+// decompressFile writes the file compressed with the method, as an executable
+func decompressFile(inputPath, outputPath, method string) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var reader io.Reader
+	switch method {
+	case "gzip":
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	case "zstd":
+		dec, err := zstd.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer dec.Close()
+		reader = dec
+	default:
+		return fmt.Errorf("unsupported compression method: %s", method)
+	}
+
+	tmp := fsutil.TempPath(outputPath)
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, outputPath)
+}
+
 // generateChecksums generates SHA256 and SHA512 checksums for a file
 func generateChecksums(filePath string) (string, string, error) {
 	file, err := os.Open(filePath)
@@ -129,158 +228,238 @@ func writeChecksumFile(filePath string, sha256Sum, sha512Sum string) error {
 		filepath.Base(filePath), sha256Sum,
 		filepath.Base(filePath), sha512Sum)
 
-	return os.WriteFile(hashFilePath, []byte(content), 0644)
-}
-
-// checkAndUpdateGitignore checks if builds/ directory is in .gitignore and adds it if missing
-func checkAndUpdateGitignore(workDir string) error {
-	gitignorePath := filepath.Join(workDir, ".gitignore")
-
-	// Check if .gitignore file exists
-	if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
-		// .gitignore doesn't exist, inform user and do nothing
-		fmt.Println("No .gitignore file found - skipping builds/ directory check")
-		return nil
-	}
-
-	// Read existing .gitignore file
-	content, err := os.ReadFile(gitignorePath)
-	if err != nil {
-		return fmt.Errorf("failed to read .gitignore file: %v", err)
-	}
-
-	// Check if builds/ is already in the file
-	lines := strings.Split(string(content), "\n")
-	buildsEntryFound := false
-	for _, line := range lines {
-		// Trim whitespace and check for exact match
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "builds/" {
-			buildsEntryFound = true
-			break
-		}
-	}
-
-	// If builds/ is not found, add it
-	if !buildsEntryFound {
-		// Add builds/ to the end of the file
-		newContent := string(content)
-		if !strings.HasSuffix(newContent, "\n") && len(newContent) > 0 {
-			newContent += "\n"
-		}
-		newContent += "builds/\n"
-
-		if err := os.WriteFile(gitignorePath, []byte(newContent), 0644); err != nil {
-			return fmt.Errorf("failed to update .gitignore file: %v", err)
-		}
-		fmt.Println("Added builds/ to .gitignore file")
-	} else {
-		fmt.Println("builds/ directory already in .gitignore file")
-	}
-
-	return nil
-}
-
-// BuildMetadata holds build information
-type BuildMetadata struct {
-	ProjectName   string                 `json:"project_name"`
-	Version       string                 `json:"version"`
-	BuildTime     time.Time              `json:"build_time"`
-	BuildDuration string                 `json:"build_duration"`
-	GoVersion     string                 `json:"go_version"`
-	BuildHost     string                 `json:"build_host"`
-	BuildUser     string                 `json:"build_user"`
-	BuildOS       string                 `json:"build_os"`
-	BuildArch     string                 `json:"build_arch"`
-	Targets       []targets.Target       `json:"targets"`
-	BuildConfig   gobuild.BuildConfig    `json:"build_config"`
-	Flags         map[string]interface{} `json:"flags"`
-	Artifacts     []string               `json:"artifacts"`
-	SuccessCount  int                    `json:"success_count"`
-	FailCount     int                    `json:"fail_count"`
-}
-
-// writeBuildMetadata writes build metadata to a JSON file
-func writeBuildMetadata(versionDir string, metadata BuildMetadata) error {
-	metadataPath := filepath.Join(versionDir, "build-metadata.json")
-	data, err := json.MarshalIndent(metadata, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(metadataPath, data, 0644)
+	return fsutil.WriteFileAtomic(hashFilePath, []byte(content), 0644)
 }
 
 var (
-	flagAll         bool
-	flagName        string
-	flagOutDir      string
-	flagSetVersion  string
-	flagStrategy    string
-	flagAMD64Level  string
-	flagARM64Level  string
-	flagARMLevel    string
-	flagMIPSLevel   string
-	flagPPC64Level  string
-	flagRISCVLevel  string
-	flagBuildMode   string
-	flagTags        string
-	flagLDFlags     string
-	flagBuildFlags  string
-	flagVerbose     bool
-	flagSkipCleanup bool
-	flagStopOnError bool
-	flagParallel    int
-	flagCleanCache  bool
-	flagCompress    string
-	flagChecksums   bool
+	flagAll             bool
+	flagName            string
+	flagOutDir          string
+	flagStagingDir      string
+	flagSetVersion      string
+	flagChannel         string
+	flagNightly         bool
+	flagKeepNightly     int
+	flagStrategy        string
+	flagAMD64Level      string
+	flagARM64Level      string
+	flagARMLevel        string
+	flagMIPSLevel       string
+	flagMIPS64Level     string
+	flagX86Level        string
+	flagPPC64Level      string
+	flagRISCVLevel      string
+	flagBuildMode       string
+	flagTags            string
+	flagLDFlags         string
+	flagGCFlags         []string
+	flagASMFlags        []string
+	flagCover           bool
+	flagTestBinaries    string
+	flagStrip           bool
+	flagNoStrip         bool
+	flagDWARF           bool
+	flagBuildFlags      string
+	flagVerbose         bool
+	flagVeryVerbose     bool
+	flagSkipCleanup     bool
+	flagStopOnError     bool
+	flagParallel        string
+	flagMaxMemory       string
+	flagCleanCache      bool
+	flagCacheStats      bool
+	flagCacheStrategy   string
+	flagGenerate        bool
+	flagEmbedCheck      bool
+	flagCPULimit        int
+	flagLowPriority     bool
+	flagMaxOutput       string
+	flagCompress        string
+	flagChecksums       bool
+	flagUniversal       bool
+	flagTargetGroup     string
+	flagTargets         string
+	flagConfig          string
+	flagColor           string
+	flagASCII           bool
+	flagSummaryCols     string
+	flagSHADisplay      string
+	flagSummary         string
+	flagReport          string
+	flagNotify          []string
+	flagOTel            string
+	flagPushgateway     string
+	flagWait            time.Duration
+	flagLatest          bool
+	flagLatestBin       bool
+	flagHostBin         string
+	flagSidecar         bool
+	flagXattrs          bool
+	flagPool            bool
+	flagArchive         string
+	flagLicenses        bool
+	flagConcurrency     string
+	flagPublish         bool
+	flagPublishDryRun   bool
+	flagPublishPartial  bool
+	flagSign            string
+	flagSignKey         string
+	flagEmbedInfo       string
+	flagNoGitignore     bool
+	flagNewGitignore    bool
+	flagPathCheck       string
+	flagRef             string
+	flagTagCheck        string
+	flagStaticCheck     string
+	flagTorrent         bool
+	flagIPFS            bool
+	flagInstallScripts  bool
+	flagOmitHost        bool
+	flagMetadataMinimal bool
+	flagModVerify       string
+	flagBenchGate       string
+	flagDeps            bool
+	flagDepsOutdated    bool
+	flagSandbox         bool
 )
 
 func main() {
+	// A sandboxed go command starts pbuild as its helper first
+	sandbox.Enter()
 	root := &cobra.Command{
 		Use:          "pbuild [TARGET_DIR]",
 		Short:        "Cross-compile a Go project for a target matrix",
 		Args:         cobra.MaximumNArgs(1),
 		SilenceUsage: true, // do not print usage on build errors
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			mode, err := ui.ParseColorMode(flagColor)
+			if err != nil {
+				return err
+			}
+			ui.SetColorMode(mode)
+			if flagASCII {
+				ui.SetGlyphs(ui.ASCIIGlyphs)
+			}
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			target := "."
-			if len(args) == 1 {
-				target = args[0]
+			if err := checkHostBinArg(args); err != nil {
+				return err
 			}
-			return run(target)
+			return run(targetArg(args))
 		},
 	}
+	root.AddCommand(newInstallCmd(), newRunCmd(), newDoctorCmd(), newDeltaCmd(), newServeCmd(), newBatchCmd(), newDiffMetaCmd(), newVerifyReleaseCmd(), newInspectCmd(), newReplayCmd(), newExportMatrixCmd(), newExportCmd(), newGenCmd(), newWizardCmd(), newDepsCmd(), newPublishCmd(), newYankCmd(), newBuildOneCmd(), newMergeMetaCmd(), newCacheCmd(), newConfigCmd(), newLintCmd(), newCompareArtifactsCmd())
+	rootCmd = root
 	// Expose tool version via built-in --version
 	root.Version = appVersion
 	root.SetVersionTemplate("{{.Version}}\n")
 	root.Flags().BoolVar(&flagAll, "all", false, "build for all predefined targets")
-	root.Flags().StringVar(&flagName, "name", "", "override inferred project name")
+	root.Flags().StringVar(&flagTargetGroup, "target-group", "", "build named target groups (comma-separated): "+strings.Join(targets.GroupNames(), ", "))
+	root.Flags().StringVar(&flagTargets, "targets", "", "build explicit targets as GOOS/GOARCH (comma-separated)")
+	root.Flags().BoolVar(&flagUniversal, "darwin-universal", false, "also build a universal darwin binary (amd64 + arm64)")
+	root.PersistentFlags().StringVar(&flagColor, "color", "auto", "colorize output: auto, always, never (honors NO_COLOR)")
+	root.PersistentFlags().BoolVar(&flagASCII, "ascii", false, "ASCII-only output: OK/FAIL instead of check marks and +-| table lines")
+	root.PersistentFlags().StringVar(&flagConfig, "config", "", "path to config file (default: pbuild.yaml in the module root)")
+	root.PersistentFlags().StringVar(&flagName, "name", "", "override inferred project name")
 	root.Flags().StringVar(&flagOutDir, "output-dir", "builds", "directory for build artifacts")
-	root.Flags().StringVar(&flagSetVersion, "set-version", "", "override embedded version tag")
+	root.Flags().StringVar(&flagStagingDir, "staging-dir", "", "build in this local directory and copy the version directory to --output-dir at the end, verified, e.g. for a network share")
+	root.Flags().StringVar(&flagTagCheck, "tag-check", "warn", "compare the source version with the version tags of HEAD: warn, error, off")
+	root.Flags().StringVar(&flagModVerify, "mod-verify", "off", "run go mod verify before building: off, warn, error (fail on modules that do not match go.sum)")
+	root.Flags().StringVar(&flagBenchGate, "bench-gate", "", "run the benchmarks of pbuild.yaml's bench: before building and compare them with the last build's: warn, error (fail on regressions), off (default: bench.gate, else off)")
+	root.Flags().StringVar(&flagRef, "ref", "", "build this tag, branch or commit from a clean temporary worktree instead of the working tree")
+	root.PersistentFlags().StringVar(&flagSetVersion, "set-version", "", "override embedded version tag")
+	root.PersistentFlags().BoolVar(&flagNightly, "nightly", false, "nightly build: version from date and commit, nightly channel, old nightlies pruned")
+	root.Flags().IntVar(&flagKeepNightly, "keep-nightlies", 0, "nightly builds to keep with --nightly (default: nightly.keep in pbuild.yaml, or 7)")
+	root.PersistentFlags().StringVar(&flagChannel, "channel", channel.Stable, "release channel: "+strings.Join(channel.Names, ", ")+" (prerelease channels get a version suffix and their own directory)")
 
 	// Build configuration flags
-	root.Flags().StringVar(&flagStrategy, "strategy", "purego", "build strategy: flexible, purego, traditional")
-	root.Flags().StringVar(&flagAMD64Level, "amd64-level", "v2", "GOAMD64 level: v1, v2, v3, v4")
-	root.Flags().StringVar(&flagARM64Level, "arm64-level", "v8.0", "GOARM64 level: v8.0, v8.1, v8.2, v8.3, v8.4, v8.5, v8.6, v8.7, v8.8, v8.9, v9.0, v9.1, v9.2, v9.3, v9.4, v9.5")
-	root.Flags().StringVar(&flagARMLevel, "arm-level", "7", "GOARM level: 5, 6, 7")
-	root.Flags().StringVar(&flagMIPSLevel, "mips-level", "hardfloat", "GOMIPS level: hardfloat, softfloat")
-	root.Flags().StringVar(&flagPPC64Level, "ppc64-level", "power8", "GOPPC64 level: power8, power9, power10")
-	root.Flags().StringVar(&flagRISCVLevel, "riscv-level", "rva20u64", "GORISCV64 level: rva20u64, rva22u64")
-	root.Flags().StringVar(&flagBuildMode, "buildmode", "auto", "build mode: auto (exe), pie (requires CGO), exe, c-archive, c-shared")
-	root.Flags().StringVar(&flagTags, "tags", "", "additional build tags (comma-separated)")
-	root.Flags().StringVar(&flagLDFlags, "ldflags", "", "custom ldflags (default: -s -w -X main.appVersion)")
-	root.Flags().StringVar(&flagBuildFlags, "build-flags", "", "additional go build flags (default: -trimpath)")
+	root.PersistentFlags().StringVar(&flagStrategy, "strategy", "purego", "build strategy: "+strings.Join(strategies, ", "))
+	root.PersistentFlags().StringVar(&flagAMD64Level, "amd64-level", "v2", "GOAMD64 level: "+strings.Join(amd64Levels, ", "))
+	root.PersistentFlags().StringVar(&flagARM64Level, "arm64-level", "v8.0", "GOARM64 level: "+strings.Join(arm64Levels, ", "))
+	root.PersistentFlags().StringVar(&flagARMLevel, "arm-level", "7", "GOARM level: "+strings.Join(armLevels, ", "))
+	root.PersistentFlags().StringVar(&flagMIPSLevel, "mips-level", "hardfloat", "GOMIPS level: "+strings.Join(mipsLevels, ", "))
+	root.PersistentFlags().StringVar(&flagMIPS64Level, "mips64-level", "hardfloat", "GOMIPS64 level: "+strings.Join(mipsLevels, ", "))
+	root.PersistentFlags().StringVar(&flagX86Level, "386-level", "sse2", "GO386 level: "+strings.Join(x86Levels, ", "))
+	root.PersistentFlags().StringVar(&flagPPC64Level, "ppc64-level", "power8", "GOPPC64 level: "+strings.Join(ppc64Levels, ", "))
+	root.PersistentFlags().StringVar(&flagRISCVLevel, "riscv-level", "rva20u64", "GORISCV64 level: "+strings.Join(riscvLevels, ", "))
+	root.PersistentFlags().StringVar(&flagBuildMode, "buildmode", "auto", "build mode: auto (exe), pie (requires CGO), exe, c-archive, c-shared")
+	root.PersistentFlags().StringVar(&flagTags, "tags", "", "additional build tags (comma-separated; !tag or -tag removes, tag@<constraint> adds per target)")
+	root.PersistentFlags().StringVar(&flagLDFlags, "ldflags", "", "custom ldflags (default: -s -w -X main.appVersion)")
+	root.PersistentFlags().StringArrayVar(&flagGCFlags, "gcflags", nil, "go build -gcflags, optionally for a package pattern, e.g. 'all=-N -l' (repeatable)")
+	root.PersistentFlags().StringArrayVar(&flagASMFlags, "asmflags", nil, "go build -asmflags, optionally for a package pattern, e.g. 'all=-spectre=all' (repeatable)")
+	root.PersistentFlags().BoolVar(&flagCover, "cover", false, "build coverage-instrumented binaries (go build -cover) named <name>-cover..., which write counters to $GOCOVERDIR")
+	root.PersistentFlags().StringVar(&flagTestBinaries, "test-binaries", "", "also compile the tests of these packages per target (go test -c) into tests/<os>-<arch>/ (plain --test-binaries: ./...)")
+	root.PersistentFlags().Lookup("test-binaries").NoOptDefVal = "./..."
+	root.PersistentFlags().BoolVar(&flagStrip, "strip", false, "strip the symbol table and DWARF (-s -w), also with custom --ldflags (the default ldflags always strip)")
+	root.PersistentFlags().BoolVar(&flagNoStrip, "no-strip", false, "keep the symbol table and DWARF, overriding -s/-w in --ldflags")
+	root.PersistentFlags().BoolVar(&flagDWARF, "dwarf", false, "keep DWARF debug info while stripping the symbol table (-s -w=0)")
+	root.MarkFlagsMutuallyExclusive("strip", "no-strip")
+	root.PersistentFlags().StringVar(&flagBuildFlags, "build-flags", "", "additional go build flags (default: -trimpath)")
 
 	// Behavior flags
-	root.Flags().BoolVar(&flagVerbose, "verbose", false, "show actual go build commands")
+	root.PersistentFlags().BoolVar(&flagVerbose, "verbose", false, "show actual go build commands")
+	root.PersistentFlags().BoolVar(&flagVeryVerbose, "very-verbose", false, "--verbose, and build with go build -x, keeping each target's toolchain transcript in logs/<target>.log")
 	root.Flags().BoolVar(&flagSkipCleanup, "skip-cleanup", false, "skip cleaning previous build directory")
 	root.Flags().BoolVar(&flagStopOnError, "stop-on-error", false, "stop building others when one fails")
-	root.Flags().IntVar(&flagParallel, "parallel", runtime.NumCPU(), "number of parallel builds (0 = sequential)")
-	root.Flags().BoolVar(&flagCleanCache, "clean-cache", false, "clean Go build cache before building")
+	root.Flags().DurationVar(&flagWait, "wait", 0, "wait up to this long for another run on the same version directory (0 = fail immediately)")
+	root.Flags().StringVar(&flagParallel, "parallel", strconv.Itoa(runtime.NumCPU()), "number of parallel builds, or auto to fit them into the available memory (0 = sequential)")
+	root.Flags().StringVar(&flagMaxMemory, "max-memory", "", "memory the parallel builds may take together, e.g. 8GiB; caps --parallel by the measured memory of a build")
+	root.Flags().StringVar(&flagConcurrency, "concurrency", "", "cap concurrent workers per stage, e.g. compile=4,compress=2 (stages: "+strings.Join(stageNames, ", ")+")")
+	root.PersistentFlags().IntVar(&flagCPULimit, "cpu-limit", 0, "CPUs for the whole run, shared by the parallel builds via GOMAXPROCS and go build -p (0 = no limit)")
+	root.PersistentFlags().BoolVar(&flagLowPriority, "low-priority", false, "run at low CPU and I/O priority (nice/ionice, below-normal priority class on Windows)")
+	root.PersistentFlags().BoolVar(&flagCleanCache, "clean-cache", false, "clean Go build cache before building")
+	root.Flags().BoolVar(&flagGenerate, "generate", false, "run go generate ./... once before building (default: go_generate in pbuild.yaml)")
+	root.PersistentFlags().BoolVar(&flagCacheStats, "cache-stats", false, "report Go build cache hits, misses and size after the run")
+	root.PersistentFlags().StringVar(&flagCacheStrategy, "cache-strategy", "shared", "Go build cache: shared (GOCACHE), per-target (one per GOOS/GOARCH under pbuild's cache directory, kept across runs)")
 
 	// Output flags
-	root.Flags().StringVar(&flagCompress, "compress", "", "compress binaries: zstd, gzip")
+	root.Flags().BoolVar(&flagNoGitignore, "no-gitignore-update", false, "do not add the output directory to .gitignore")
+	root.Flags().BoolVar(&flagNewGitignore, "create-gitignore", false, "create .gitignore with the output directory when the module has none")
+	root.Flags().StringVar(&flagMaxOutput, "max-output-size", "", "abort before building when the estimated output exceeds this size, e.g. 2GiB")
+	root.Flags().BoolVar(&flagLatest, "latest", true, "point <output-dir>/latest at the version directory after a fully successful run")
+	root.Flags().BoolVar(&flagLatestBin, "latest-bin", false, "also copy the host binary, uncompressed, to <output-dir>/<name> after a fully successful run")
+	root.Flags().StringVar(&flagHostBin, "host-bin", "", "copy the host binary, uncompressed, to this path (--host-bin=PATH), or with plain --host-bin to <version-dir>/<name>")
+	root.Flags().Lookup("host-bin").NoOptDefVal = hostBinAuto
+	root.Flags().StringVar(&flagCompress, "compress", "", "compress binaries: zstd, gzip, none (default: compress in pbuild.yaml)")
 	root.Flags().BoolVar(&flagChecksums, "checksums", true, "generate SHA256 and SHA512 checksums")
-
+	root.Flags().StringVar(&flagArchive, "archive", "", "bundle each artifact with archive.files from pbuild.yaml: auto, tar.gz, zip, none (default: archive.format)")
+	root.Flags().BoolVar(&flagSandbox, "sandbox", false, "run go build and go generate under landlock and seccomp: source read-only, writes only to the output dir, GOCACHE and TMPDIR, no network when offline (linux)")
+	root.Flags().BoolVar(&flagDeps, "deps", false, "write "+deps.FileName+" with the module graph and the modules each built target links (always with --publish)")
+	root.Flags().BoolVar(&flagDepsOutdated, "deps-outdated", false, "write "+deps.OutdatedFile+" listing direct dependencies with newer versions (needs the module proxy)")
+	root.Flags().BoolVar(&flagLicenses, "licenses", false, "write THIRD_PARTY_NOTICES with dependency licenses and fail on licenses.forbidden")
+	root.Flags().StringVar(&flagPathCheck, "path-check", "auto", "warn about host paths in binaries: auto (when -trimpath is off), always, never")
+	root.Flags().StringVar(&flagStaticCheck, "static-check", "auto", "fail ELF binaries that are not statically linked: auto (CGO_ENABLED=0 executables), always, never")
+	root.Flags().BoolVar(&flagEmbedCheck, "embed-check", false, "warn about //go:embed files that are uncommitted, or ignored by git and older than the last commit")
+	root.Flags().StringVar(&flagEmbedInfo, "embed-info", "", "embed version JSON for pbuild inspect: var (main.pbuildInfo), section (also an ELF section) or an importpath.name variable")
+	root.Flags().BoolVar(&flagOmitHost, "omit-host", false, "leave the build host, user and host directories out of metadata, sidecars and notifications")
+	root.Flags().BoolVar(&flagMetadataMinimal, "metadata-minimal", false, "also strip absolute host paths from metadata, build plan and sidecars and build with -trimpath (implies --omit-host)")
+	root.Flags().BoolVar(&flagSidecar, "sidecar", false, "write <artifact>.meta.json with target, version, digests and build config")
+	root.Flags().BoolVar(&flagXattrs, "xattrs", false, "store provenance in user.pbuild.* extended attributes where the filesystem supports them")
+	root.Flags().BoolVar(&flagPool, "pool", false, "store each binary once in <output-dir>/"+pool.Dir+" by SHA256 and hardlink it into the version directories")
+	root.Flags().BoolVar(&flagInstallScripts, "install-scripts", false, "write install.sh and install.ps1 that download, verify and install the binary for the host (URLs from install_scripts.url or the first --publish destination)")
+	root.Flags().BoolVar(&flagTorrent, "torrent", false, "write <name>-<version>.torrent of the version directory with the distribute.torrent web seeds and trackers")
+	root.Flags().BoolVar(&flagIPFS, "ipfs", false, "pin the version directory to the IPFS node at distribute.ipfs.api and record its CID")
+	root.Flags().BoolVar(&flagPublish, "publish", false, "upload artifacts to the publish: destinations in pbuild.yaml")
+	root.Flags().BoolVar(&flagPublishPartial, "publish-partial", false, "with --publish, upload each artifact as soon as it is built, and the run files even when targets failed (default: only publish a run without failures)")
+	root.Flags().BoolVar(&flagPublishDryRun, "publish-dry-run", false, "build, then list what --publish would upload and where, and what the destinations already hold, without uploading")
+	root.Flags().StringVar(&flagSign, "sign", "", "sign the checksum files: "+strings.Join(sign.Methods, ", ")+", none (default: sign in pbuild.yaml; key and passphrase from --key and the sign_key/sign_password credentials)")
+	root.Flags().StringVar(&flagSignKey, "key", "", "signing key: minisign/signify secret key file, gpg key id, cosign key file or KMS URI")
+	root.Flags().StringVar(&flagSummary, "summary", "table", "summary layout: table, group-by-os (a table per OS with subtotals), compact (one row per OS)")
+	root.Flags().StringVar(&flagSummaryCols, "summary-columns", "", "summary table columns (comma-separated): "+strings.Join(summaryColumnOrder, ", "))
+	root.Flags().StringVar(&flagReport, "report", "", "write a build report into the version directory: md, html (comma-separated)")
+	root.Flags().StringArrayVar(&flagNotify, "notify", nil, "notify on completion: slack:<webhook-url> or webhook:<url> (repeatable)")
+	root.Flags().StringVar(&flagOTel, "otel-endpoint", "", "export the run as an OpenTelemetry trace to an OTLP/HTTP endpoint (default: $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	root.Flags().StringVar(&flagPushgateway, "pushgateway", "", "push build metrics to a Prometheus Pushgateway URL")
+	root.Flags().StringVar(&flagSHADisplay, "sha-display", "full", "SHA256 in the summary table: short, full, none")
+
+	// replay overrides the plan's flags with them and export-matrix passes them
+	// on to its jobs, so both take every build flag
+	for _, c := range root.Commands() {
+		if c.Name() == "replay" || c.Name() == "export-matrix" {
+			c.Flags().AddFlagSet(root.Flags())
+		}
+	}
 	if err := root.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -288,15 +467,9 @@ func main() {
 }
 
 // showConfigTables displays the configuration in 3 side-by-side tables
-func showConfigTables() {
+func showConfigTables(p *project) {
 	// Build Config table
-	buildTbl := tablewriter.NewTable(
-		os.Stdout,
-		tablewriter.WithRenderer(renderer.NewBlueprint(tw.Rendition{
-			Borders:  tw.BorderNone,
-			Settings: tw.Settings{Separators: tw.Separators{BetweenColumns: tw.On, BetweenRows: tw.On}},
-		})),
-	)
+	buildTbl := newGridTable(os.Stdout)
 	buildTbl.Header([]string{"Build Config", "Value"})
 	buildData := [][]any{
 		[]any{"Strategy", flagStrategy},
@@ -308,73 +481,71 @@ func showConfigTables() {
 		buildData = append(buildData, []any{"Custom Tags", flagTags})
 	}
 	if flagLDFlags != "" {
-		buildData = append(buildData, []any{"Custom LDFlags", flagLDFlags})
+		buildData = append(buildData, []any{"Custom LDFlags", redactor.String(flagLDFlags)})
+	}
+	if len(flagGCFlags) > 0 {
+		buildData = append(buildData, []any{"Custom GCFlags", redactor.String(strings.Join(flagGCFlags, "; "))})
+	}
+	if len(flagASMFlags) > 0 {
+		buildData = append(buildData, []any{"Custom ASMFlags", redactor.String(strings.Join(flagASMFlags, "; "))})
 	}
 	if flagBuildFlags != "" {
-		buildData = append(buildData, []any{"Custom Build Flags", flagBuildFlags})
+		buildData = append(buildData, []any{"Custom Build Flags", redactor.String(flagBuildFlags)})
+	}
+	if flagCover {
+		buildData = append(buildData, []any{"Coverage", "instrumented (-cover)"})
 	}
+	buildData = append(buildData, debugInfoRows()...)
 	if flagCompress != "" {
 		buildData = append(buildData, []any{"Compression", flagCompress})
 	}
+	if p.channel != channel.Stable {
+		buildData = append(buildData, []any{"Channel", p.channel})
+	}
 
 	_ = buildTbl.Bulk(buildData)
 
 	// CPU Levels table
-	cpuTbl := tablewriter.NewTable(
-		os.Stdout,
-		tablewriter.WithRenderer(renderer.NewBlueprint(tw.Rendition{
-			Borders:  tw.BorderNone,
-			Settings: tw.Settings{Separators: tw.Separators{BetweenColumns: tw.On, BetweenRows: tw.On}},
-		})),
-	)
+	cpuTbl := newGridTable(os.Stdout)
 	cpuTbl.Header([]string{"CPU Levels", "Value"})
 	cpuData := [][]any{
 		[]any{"AMD64", flagAMD64Level},
 		[]any{"ARM64", flagARM64Level},
 		[]any{"ARM", flagARMLevel},
 		[]any{"MIPS", flagMIPSLevel},
+		[]any{"MIPS64", flagMIPS64Level},
+		[]any{"386", flagX86Level},
 		[]any{"PPC64", flagPPC64Level},
 		[]any{"RISC-V", flagRISCVLevel},
 	}
 	_ = cpuTbl.Bulk(cpuData)
 
 	// Behavior table
-	behaviorTbl := tablewriter.NewTable(
-		os.Stdout,
-		tablewriter.WithRenderer(renderer.NewBlueprint(tw.Rendition{
-			Borders:  tw.BorderNone,
-			Settings: tw.Settings{Separators: tw.Separators{BetweenColumns: tw.On, BetweenRows: tw.On}},
-		})),
-	)
+	behaviorTbl := newGridTable(os.Stdout)
 	behaviorTbl.Header([]string{"Behavior", "Value"})
 	behaviorData := [][]any{
-		[]any{"Parallel Workers", fmt.Sprintf("%d", flagParallel)},
+		[]any{"Parallel Workers", parallelWorkers(p)},
 		[]any{"Clean Cache", fmt.Sprintf("%t", flagCleanCache)},
 		[]any{"Skip Cleanup", fmt.Sprintf("%t", flagSkipCleanup)},
 		[]any{"Stop on Error", fmt.Sprintf("%t", flagStopOnError)},
 		[]any{"Verbose", fmt.Sprintf("%t", flagVerbose)},
 		[]any{"Generate Checksums", fmt.Sprintf("%t", flagChecksums)},
 	}
+	behaviorData = append(behaviorData, resourceRows()...)
 	_ = behaviorTbl.Bulk(behaviorData)
 
 	// Render tables side by side using tablewriter
-	renderTablesSideBySide(buildTbl, cpuTbl, behaviorTbl)
+	renderTablesSideBySide(p, buildTbl, cpuTbl, behaviorTbl)
 }
 
 // renderTablesSideBySide renders tablewriter tables side by side
-func renderTablesSideBySide(buildTbl, cpuTbl, behaviorTbl *tablewriter.Table) {
+func renderTablesSideBySide(p *project, buildTbl, cpuTbl, behaviorTbl *tablewriter.Table) {
 	// Capture output from each table by creating new tables with buffers
 	var outputs []string
 
 	// Build Config table
 	var buildBuf strings.Builder
-	buildCapture := tablewriter.NewTable(
-		&buildBuf,
-		tablewriter.WithRenderer(renderer.NewBlueprint(tw.Rendition{
-			Borders:  tw.BorderNone,
-			Settings: tw.Settings{Separators: tw.Separators{BetweenColumns: tw.On, BetweenRows: tw.On}},
-		})),
-	)
+	buildCapture := newGridTable(&buildBuf)
 	buildCapture.Header([]string{"Build Config", "Value"})
 	buildData := [][]any{
 		[]any{"Strategy", flagStrategy},
@@ -384,33 +555,42 @@ func renderTablesSideBySide(buildTbl, cpuTbl, behaviorTbl *tablewriter.Table) {
 		buildData = append(buildData, []any{"Custom Tags", flagTags})
 	}
 	if flagLDFlags != "" {
-		buildData = append(buildData, []any{"Custom LDFlags", flagLDFlags})
+		buildData = append(buildData, []any{"Custom LDFlags", redactor.String(flagLDFlags)})
+	}
+	if len(flagGCFlags) > 0 {
+		buildData = append(buildData, []any{"Custom GCFlags", redactor.String(strings.Join(flagGCFlags, "; "))})
+	}
+	if len(flagASMFlags) > 0 {
+		buildData = append(buildData, []any{"Custom ASMFlags", redactor.String(strings.Join(flagASMFlags, "; "))})
 	}
 	if flagBuildFlags != "" {
-		buildData = append(buildData, []any{"Custom Build Flags", flagBuildFlags})
+		buildData = append(buildData, []any{"Custom Build Flags", redactor.String(flagBuildFlags)})
 	}
+	if flagCover {
+		buildData = append(buildData, []any{"Coverage", "instrumented (-cover)"})
+	}
+	buildData = append(buildData, debugInfoRows()...)
 	if flagCompress != "" {
 		buildData = append(buildData, []any{"Compression", flagCompress})
 	}
+	if p.channel != channel.Stable {
+		buildData = append(buildData, []any{"Channel", p.channel})
+	}
 	_ = buildCapture.Bulk(buildData)
 	buildCapture.Render()
 	outputs = append(outputs, buildBuf.String())
 
 	// CPU Levels table
 	var cpuBuf strings.Builder
-	cpuCapture := tablewriter.NewTable(
-		&cpuBuf,
-		tablewriter.WithRenderer(renderer.NewBlueprint(tw.Rendition{
-			Borders:  tw.BorderNone,
-			Settings: tw.Settings{Separators: tw.Separators{BetweenColumns: tw.On, BetweenRows: tw.On}},
-		})),
-	)
+	cpuCapture := newGridTable(&cpuBuf)
 	cpuCapture.Header([]string{"CPU Levels", "Value"})
 	cpuData := [][]any{
 		[]any{"AMD64", flagAMD64Level},
 		[]any{"ARM64", flagARM64Level},
 		[]any{"ARM", flagARMLevel},
 		[]any{"MIPS", flagMIPSLevel},
+		[]any{"MIPS64", flagMIPS64Level},
+		[]any{"386", flagX86Level},
 		[]any{"PPC64", flagPPC64Level},
 		[]any{"RISC-V", flagRISCVLevel},
 	}
@@ -420,22 +600,17 @@ func renderTablesSideBySide(buildTbl, cpuTbl, behaviorTbl *tablewriter.Table) {
 
 	// Behavior table
 	var behaviorBuf strings.Builder
-	behaviorCapture := tablewriter.NewTable(
-		&behaviorBuf,
-		tablewriter.WithRenderer(renderer.NewBlueprint(tw.Rendition{
-			Borders:  tw.BorderNone,
-			Settings: tw.Settings{Separators: tw.Separators{BetweenColumns: tw.On, BetweenRows: tw.On}},
-		})),
-	)
+	behaviorCapture := newGridTable(&behaviorBuf)
 	behaviorCapture.Header([]string{"Behavior", "Value"})
 	behaviorData := [][]any{
-		[]any{"Parallel Workers", fmt.Sprintf("%d", flagParallel)},
+		[]any{"Parallel Workers", parallelWorkers(p)},
 		[]any{"Clean Cache", fmt.Sprintf("%t", flagCleanCache)},
 		[]any{"Skip Cleanup", fmt.Sprintf("%t", flagSkipCleanup)},
 		[]any{"Stop on Error", fmt.Sprintf("%t", flagStopOnError)},
 		[]any{"Verbose", fmt.Sprintf("%t", flagVerbose)},
 		[]any{"Generate Checksums", fmt.Sprintf("%t", flagChecksums)},
 	}
+	behaviorData = append(behaviorData, resourceRows()...)
 	_ = behaviorCapture.Bulk(behaviorData)
 	behaviorCapture.Render()
 	outputs = append(outputs, behaviorBuf.String())
@@ -475,106 +650,560 @@ func renderTablesSideBySide(buildTbl, cpuTbl, behaviorTbl *tablewriter.Table) {
 	}
 }
 
+// updateLatest points <outDir>/latest at the version directory, copying it where symlinks are unavailable
+func updateLatest(outDir, versionDir string) error {
+	link := filepath.Join(outDir, "latest")
+	if fi, err := os.Lstat(link); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+		// A copied "latest" left by an earlier run without symlink support
+		if err := os.RemoveAll(link); err != nil {
+			return err
+		}
+	}
+	err := fsutil.ReplaceSymlink(filepath.Base(versionDir), link)
+	if err == nil || runtime.GOOS != "windows" {
+		return err
+	}
+	// Windows needs developer mode or admin rights for symlinks, so fall back to a copy
+	return fsutil.CopyDir(versionDir, link)
+}
+
+// hostBinAuto is the value of a plain --host-bin
+const hostBinAuto = "auto"
+
+// hostBinName is the canonical file name of the host binary
+func hostBinName(projectName string) string {
+	if runtime.GOOS == "windows" {
+		return projectName + ".exe"
+	}
+	return projectName
+}
+
+// checkHostBinArg catches --host-bin PATH, which the flag parser reads as a
+// plain --host-bin followed by TARGET_DIR
+func checkHostBinArg(args []string) error {
+	if flagHostBin != hostBinAuto || len(args) == 0 {
+		return nil
+	}
+	if fi, err := os.Stat(args[0]); err == nil && fi.IsDir() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a directory to build; --host-bin takes its path with =, e.g. --host-bin=%s", args[0], args[0])
+}
+
+// hostBinPath is where --host-bin places the host binary: <version-dir>/<name>
+// for a plain --host-bin, else the given path, relative to the module root like
+// --output-dir; in an existing directory the binary keeps its canonical name
+func hostBinPath(p *project, versionDir string) string {
+	if flagHostBin == hostBinAuto {
+		return filepath.Join(versionDir, hostBinName(artifactBase(p)))
+	}
+	dst := flagHostBin
+	if home, err := fsutil.ExpandHome(dst); err == nil {
+		dst = home
+	}
+	if !filepath.IsAbs(dst) {
+		root := p.workDir
+		if outputRoot != "" {
+			root = outputRoot
+		}
+		dst = filepath.Join(root, dst)
+	}
+	if fi, err := os.Stat(dst); (err == nil && fi.IsDir()) || strings.HasSuffix(flagHostBin, "/") {
+		dst = filepath.Join(dst, hostBinName(artifactBase(p)))
+	}
+	return dst
+}
+
+// copyHostBinary places the host target's binary at dst, decompressed when
+// --compress is on, for --host-bin and --latest-bin
+func copyHostBinary(versionDir, dst string, rows []summaryRow) error {
+	host := targets.Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	for _, r := range rows {
+		if r.Target != host.String() || !r.Success {
+			continue
+		}
+		src := filepath.Join(versionDir, r.File)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if ext := compressionExt(flagCompress); ext != "" && strings.HasSuffix(r.File, ext) {
+			return decompressFile(src, dst, flagCompress)
+		}
+		// The host artifact may already carry the canonical name
+		if filepath.Clean(src) == filepath.Clean(dst) {
+			return nil
+		}
+		return fsutil.CopyFile(src, dst)
+	}
+	return fmt.Errorf("no host (%s) artifact was built", host)
+}
+
+// outputDir resolves --output-dir against the module root
+func outputDir(workDir string) string {
+	if filepath.IsAbs(flagOutDir) {
+		return flagOutDir
+	}
+	if outputRoot != "" {
+		workDir = outputRoot
+	}
+	return filepath.Join(workDir, flagOutDir)
+}
+
+// targetArg returns the optional TARGET_DIR argument, defaulting to the current directory
+func targetArg(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return "."
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// resolveGroup returns a target group, preferring definitions from pbuild.yaml.
+// Names are case-insensitive, like the built-in ones.
+func resolveGroup(cfg *config.Config, name string) ([]targets.Target, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	var found []string
+	for _, n := range slices.Sorted(maps.Keys(cfg.TargetGroups)) {
+		if strings.ToLower(strings.TrimSpace(n)) == key {
+			found = append(found, n)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return targets.Group(name)
+	case 1:
+		group, err := targets.ParseList(cfg.TargetGroups[found[0]])
+		if err != nil {
+			return nil, fmt.Errorf("target group %q in %s: %v", found[0], config.FileName, err)
+		}
+		return group, nil
+	default:
+		return nil, fmt.Errorf("target groups %s in %s differ only in case", strings.Join(found, ", "), config.FileName)
+	}
+}
+
+// resolveMatrix builds the target matrix from --all, --target-group and --targets
+func resolveMatrix(cfg *config.Config) ([]targets.Target, error) {
+	var matrix []targets.Target
+	seen := make(map[targets.Target]bool)
+	add := func(ts ...targets.Target) {
+		for _, t := range ts {
+			if !seen[t] {
+				seen[t] = true
+				matrix = append(matrix, t)
+			}
+		}
+	}
+
+	if flagAll {
+		add(targets.Default()...)
+	}
+	for _, name := range splitList(flagTargetGroup) {
+		group, err := resolveGroup(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		add(group...)
+	}
+	for _, s := range splitList(flagTargets) {
+		t, err := targets.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		add(t)
+	}
+
+	// Fall back to the targets of pbuild.yaml, then the host platform
+	if len(matrix) == 0 {
+		for _, s := range cfg.Targets {
+			if !strings.Contains(s, "/") {
+				group, err := resolveGroup(cfg, s)
+				if err != nil {
+					return nil, fmt.Errorf("targets in %s: %v", config.FileName, err)
+				}
+				add(group...)
+				continue
+			}
+			t, err := targets.Parse(s)
+			if err != nil {
+				return nil, fmt.Errorf("targets in %s: %v", config.FileName, err)
+			}
+			add(t)
+		}
+	}
+	if len(matrix) == 0 {
+		add(targets.Target{OS: runtime.GOOS, Arch: runtime.GOARCH})
+	}
+	if flagUniversal {
+		add(targets.Target{OS: "darwin", Arch: targets.DarwinUniversal})
+	}
+	return matrix, nil
+}
+
+// applyConfigDefaults takes --compress, --sign and --bench-gate from
+// pbuild.yaml when they are not given; none turns the configured value off
+func applyConfigDefaults(p *project) {
+	flagCompress, flagSign, flagBenchGate = p.settings.Compress, p.settings.Sign, p.settings.BenchGate
+}
+
+// resolveOutputNames assigns artifact names and fails fast when any file a
+// target writes would collide with another target's files or pbuild's own
+func resolveOutputNames(p *project, matrix []targets.Target, archives *archivePlan) (map[targets.Target]string, error) {
+	projectName := artifactBase(p)
+	names, err := targets.OutputNames(projectName, matrix)
+	if err != nil {
+		return nil, err
+	}
+	// A plan's names stand, even for a subset of its targets
+	if replaying != nil {
+		for _, pt := range replaying.Targets {
+			if t, err := targets.Parse(pt.Target); err == nil && pt.Output != "" {
+				if _, ok := names[t]; ok {
+					names[t] = pt.Output
+				}
+			}
+		}
+	}
+
+	if flagLatest && flagLatestBin && strings.EqualFold(projectName, "latest") {
+		return nil, errors.New("--latest-bin would overwrite the latest pointer; use --name to rename the project")
+	}
+
+	owner := map[string]string{
+		strings.ToLower(buildmeta.FileName): "build metadata",
+		strings.ToLower(deps.FileName):      "dependency snapshot",
+		strings.ToLower(buildplan.FileName): "build plan",
+		logDir:                              "build logs",
+	}
+	if flagTestBinaries != "" {
+		owner[testDir] = "test binaries"
+	}
+	if flagBenchGate != "off" {
+		owner[benchgate.ResultsFile] = "benchmark results"
+		owner[benchgate.ComparisonFile] = "benchmark comparison"
+	}
+	if flagLicenses {
+		owner[strings.ToLower(licenses.NoticesFile)] = "license notices"
+	}
+	if flagDepsOutdated {
+		owner[strings.ToLower(deps.OutdatedFile)] = "outdated dependency report"
+	}
+	if newBuildConfig(p).Strategy == gobuild.FlexibleCGO {
+		owner[strings.ToLower(linkcheck.ReportFile)] = "system library report"
+	}
+	for _, format := range report.Formats {
+		owner["build-report."+format] = "build report"
+	}
+	// The host target's own file may carry the canonical name already
+	host := targets.Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	hostBin := ""
+	if flagHostBin == hostBinAuto {
+		hostBin = strings.ToLower(hostBinName(projectName))
+	}
+	for _, t := range matrix {
+		// The raw name is kept when compression fails, so reserve both
+		bases := []string{names[t]}
+		if ext := compressionExt(flagCompress); ext != "" {
+			bases = append(bases, names[t]+ext)
+		}
+		if archives != nil {
+			name, err := archives.fileName(p, t, names[t]+compressionExt(flagCompress))
+			if err != nil {
+				return nil, err
+			}
+			bases = append(bases, name)
+		}
+		var files []string
+		for _, base := range bases {
+			files = append(files, base, base+".hash", base+buildmeta.SidecarSuffix)
+		}
+		for _, file := range files {
+			key := strings.ToLower(file)
+			if other, ok := owner[key]; ok {
+				return nil, fmt.Errorf("%s would overwrite the %s file %s", t, other, file)
+			}
+			if key == hostBin && t != host {
+				return nil, fmt.Errorf("--host-bin would overwrite the %s file %s; give it a path", t, file)
+			}
+			owner[key] = t.String()
+		}
+	}
+	return names, nil
+}
+
 func run(targetDir string) error {
 	startTime := time.Now()
 
-	abs, err := filepath.Abs(targetDir)
+	if flagRef != "" {
+		dir, cleanup, err := checkoutRef(targetDir)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		targetDir = dir
+	}
+	p, err := resolveProject(targetDir)
 	if err != nil {
 		return err
 	}
-
-	// roots
-	workDir := abs
-	if modRoot, err := fsutil.FindModuleRoot(abs); err == nil {
-		workDir = modRoot
+	if err := validateSettings(p); err != nil {
+		return err
 	}
-	gitRoot := workDir
-	if gr, err := fsutil.FindGitRoot(workDir); err == nil {
-		gitRoot = gr
+	applyConfigDefaults(p)
+	if flagMetadataMinimal {
+		flagOmitHost = true
 	}
-
-	// name
-	projectName := flagName
-	if projectName == "" {
-		if m, err := fsutil.InferModulePath(workDir); err == nil && m != "" {
-			parts := strings.Split(m, "/")
-			projectName = parts[len(parts)-1]
-		} else {
-			projectName = filepath.Base(workDir)
+	if flagVeryVerbose {
+		flagVerbose = true
+	}
+	checkMinimalLDFlags(p)
+	if flagSandbox {
+		if p.sandbox, err = sandboxPolicy(p); err != nil {
+			return err
 		}
+		defer os.RemoveAll(p.sandbox.Temp)
+		printSandbox(p.sandbox)
 	}
-
-	// version
-	versionTag := flagSetVersion
-	if versionTag == "" {
-		base, _ := appver.ExtractAppVersion(workDir)
-		if base == "" {
-			base = appVersion
+	// Lowered first, so every subprocess of the run inherits it
+	if flagLowPriority {
+		if err := priority.Lower(); err != nil {
+			fmt.Printf("Warning: Failed to lower the priority: %v\n", err)
 		}
-		rev, _ := gitmeta.ResolveHEAD(gitRoot)
-		if rev == "" {
-			rev = "unknown"
+	}
+	if err := checkVersionTag(p); err != nil {
+		return err
+	}
+	modVerify, err := verifyModules(p)
+	if err != nil {
+		return err
+	}
+	if err := installTools(p); err != nil {
+		return err
+	}
+	// Generated code is part of the build, so it is in place before anything else
+	if p.settings.Generate {
+		if err := runGoGenerate(p); err != nil {
+			return err
 		}
-		dirty, _ := gitmeta.HeuristicDirty(gitRoot)
-		if dirty {
-			rev += "-dirty"
+	}
+	if flagEmbedCheck {
+		checkEmbeddedFiles(p)
+	}
+	workDir, projectName, versionTag, cfg := p.workDir, p.name, p.version, p.cfg
+
+	p.creds.OnResolve = redactor.Add
+
+	summaryCols, err := parseSummaryColumns(flagSummary, flagSummaryCols, flagSHADisplay)
+	if err != nil {
+		return err
+	}
+
+	if err := gobuild.ValidateTags(newBuildConfig(p).Tags); err != nil {
+		return err
+	}
+
+	reportFormats := splitList(flagReport)
+
+	var notifiers []notify.Notifier
+	for _, spec := range p.settings.Notify {
+		n, err := notify.Parse(spec)
+		if err != nil {
+			return err
 		}
-		versionTag = fmt.Sprintf("%s-%s", base, rev)
+		notifiers = append(notifiers, n)
 	}
 
-	// Check and update .gitignore to ensure builds/ directory is ignored
-	if err := checkAndUpdateGitignore(workDir); err != nil {
-		fmt.Printf("Warning: Failed to check/update .gitignore: %v\n", err)
+	// matrix
+	matrix, err := resolveMatrix(cfg)
+	if err != nil {
+		return err
+	}
+	archives, err := newArchivePlan(p)
+	if err != nil {
+		return err
+	}
+	dests, err := newDestinations(p)
+	if err != nil {
+		return err
+	}
+	installURLs, err := newInstallURLs(p, dests)
+	if err != nil {
+		return err
+	}
+	signer, err := newSigner(p)
+	if err != nil {
+		return err
+	}
+	preview := newReleasePlan(dests)
+	p.memory = newMemoryGate()
+	stages, err := newPipeline(p, archives, signer, dests, preview)
+	if err != nil {
+		return err
+	}
+	outNames, err := resolveOutputNames(p, matrix, archives)
+	if err != nil {
+		return err
 	}
 
 	// out dirs
-	outDir := flagOutDir
-	if !filepath.IsAbs(outDir) {
-		outDir = filepath.Join(workDir, outDir)
+	outDir := outputDir(workDir)
+
+	// Check and update .gitignore to ensure the output directory is ignored
+	if err := checkAndUpdateGitignore(p, outDir); err != nil {
+		fmt.Printf("Warning: Failed to check/update .gitignore: %v\n", err)
 	}
+	// Prerelease channels get their own directory, latest pointer and update manifest
+	outDir = filepath.Join(outDir, channel.Dir(p.channel))
 	versionDir := filepath.Join(outDir, versionTag)
-	if !flagSkipCleanup {
+
+	// Hold an advisory lock so concurrent runs cannot clean up or overwrite each other's artifacts
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return err
+	}
+	runLock, err := lock.Acquire(versionDir+".lock", flagWait)
+	if err != nil {
+		return err
+	}
+	defer runLock.Release()
+	// The run writes to local storage; the output directory only receives the finished version directory
+	finalDir := versionDir
+	if flagStagingDir != "" {
+		versionDir = filepath.Join(stagingDir(workDir), channel.Dir(p.channel), versionTag)
+	}
+
+	// Estimated from the previous build, which may be the one about to be replaced
+	var planned []targets.Target
+	for _, t := range matrix {
+		if reason, err := skipReason(cfg.Skip, t, newBuildConfig(p)); err == nil && reason == "" {
+			planned = append(planned, t)
+		}
+	}
+	if err := checkOutputSize(p, outDir, planned, archives != nil); err != nil {
+		return err
+	}
+	// Measured before the run replaces the version directory, so a failing gate leaves the release as it was
+	bench, err := runBenchGate(p, outDir)
+	if err != nil {
+		return err
+	}
+
+	// Single-target jobs may share the version directory with the other targets
+	if !flagSkipCleanup && fragmentTarget == "" {
 		_ = os.RemoveAll(versionDir)
 	}
 	if err := os.MkdirAll(versionDir, 0o755); err != nil {
 		return err
 	}
+	writeBenchResults(versionDir, bench)
 
-	// matrix
-	var matrix []targets.Target
-	if flagAll {
-		matrix = targets.Default()
-	} else {
-		matrix = []targets.Target{{OS: runtime.GOOS, Arch: runtime.GOARCH}}
+	plan := newBuildPlan(p, matrix, outNames)
+	if replaying != nil {
+		checkReplay(p, *replaying, plan)
+	}
+	// A single-target job keeps the plan of the whole matrix it came from
+	if fragmentTarget == "" {
+		redactor.Value(&plan)
+		if flagMetadataMinimal {
+			redact.Strings(&plan, hostPaths(p))
+		}
+		if err := buildplan.Write(versionDir, plan); err != nil {
+			fmt.Printf("Warning: Failed to write build plan: %v\n", err)
+		}
 	}
 
 	fmt.Printf("Building version %s\n\n", versionTag)
 
 	// Show build configuration in 3 side-by-side tables
-	showConfigTables()
+	showConfigTables(p)
 	fmt.Println()
 
+	// Completions and man pages come from a host build and go into every archive
+	generated, err := generateAssets(p, versionDir)
+	if err != nil {
+		return err
+	}
+	notices, err := writeNotices(p, versionDir)
+	if err != nil {
+		return err
+	}
+	if notices != nil {
+		generated = append(generated, *notices)
+	}
+	if archives != nil {
+		archives.generated = generated
+	}
+
 	// collect rows for summary table
-	type row struct{ file, target, size, sha256, status string }
-	var rows []row
+	var rows []summaryRow
 
 	// status glyphs
-	greenTick := "\x1b[32m✓\x1b[0m"
-	redX := "\x1b[31m✗\x1b[0m"
+	greenTick := ui.OK()
+	redX := ui.Fail()
+	skipDash := ui.Skip()
+
+	var successCount, failCount, skipCount int
+
+	// Evaluate skip rules before scheduling so skipped targets never reach a worker
+	var buildable []targets.Target
+	for _, t := range matrix {
+		reason, err := skipReason(cfg.Skip, t, newBuildConfig(p))
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			buildable = append(buildable, t)
+			continue
+		}
+		fmt.Printf("Skipping %s: %s\n", t, reason)
+		rows = append(rows, summaryRow{
+			TargetResult: buildmeta.TargetResult{
+				Target:  t.String(),
+				File:    outNames[t],
+				Skipped: true,
+				Reason:  reason,
+			},
+			status: skipDash,
+		})
+		skipCount++
+	}
+	if skipCount > 0 {
+		fmt.Println()
+	}
 
-	var successCount, failCount int
+	probe := startCacheProbe()
 
-	ctx := context.Background()
+	// Cancel running builds on Ctrl-C/SIGTERM; a second signal kills the process as usual
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			signal.Stop(sigCh)
+			fmt.Println("\nInterrupt received, waiting for running builds to stop (press Ctrl-C again to force)")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
 	// Determine number of workers
-	numWorkers := flagParallel
-	if numWorkers <= 0 {
-		numWorkers = 1 // Sequential
-	}
+	numWorkers := workerCount()
 
 	// Channel for targets
-	targetChan := make(chan targets.Target, len(matrix))
-	resultChan := make(chan row, len(matrix))
+	targetChan := make(chan targets.Target, len(buildable))
+	resultChan := make(chan summaryRow, len(buildable))
 
 	// Start workers
 	var wg sync.WaitGroup
@@ -583,139 +1212,66 @@ func run(targetDir string) error {
 		go func(workerID int) {
 			defer wg.Done()
 			for t := range targetChan {
-				outName := targets.OutputName(projectName, t)
-				outPath := filepath.Join(versionDir, outName)
+				// Drain remaining targets without building once interrupted
+				if ctx.Err() != nil {
+					continue
+				}
+				a := &pipeline.Artifact{
+					Target: t,
+					Worker: workerID,
+					Dir:    versionDir,
+					Name:   outNames[t],
+					Result: buildmeta.TargetResult{Target: t.String(), Started: time.Now()},
+				}
 
 				if flagVerbose {
-					fmt.Printf("[Worker %d] Building for: %s/%s -> %s\n", workerID, t.OS, t.Arch, outPath)
+					fmt.Printf("[Worker %d] Building for: %s/%s -> %s\n", workerID, t.OS, t.Arch, a.Path())
 				} else {
-					fmt.Printf("Building for: %s/%s -> %s\n", t.OS, t.Arch, outPath)
+					fmt.Printf("Building for: %s/%s -> %s\n", t.OS, t.Arch, a.Path())
 				}
 
-				// Build configuration
-				buildMode := getBuildMode(flagBuildMode)
-				strategy := getBuildStrategy(flagStrategy, buildMode)
-
 				// Warn if strategy was changed due to PIE requirements
-				if buildMode == "pie" && flagStrategy == "purego" {
+				if getBuildMode(flagBuildMode) == "pie" && flagStrategy == "purego" {
 					if flagVerbose {
 						fmt.Printf("[Worker %d]   WARNING: PIE mode requires CGO, switching from purego to flexible strategy\n", workerID)
 					}
 				}
 
-				config := gobuild.BuildConfig{
-					Strategy:   strategy,
-					AMD64Level: flagAMD64Level,
-					ARM64Level: flagARM64Level,
-					ARMLevel:   flagARMLevel,
-					MIPSLevel:  flagMIPSLevel,
-					PPC64Level: flagPPC64Level,
-					RISCVLevel: flagRISCVLevel,
-					BuildMode:  buildMode,
-					Tags:       flagTags,
-					LDFlags:    flagLDFlags,
-					BuildFlags: flagBuildFlags,
-					Verbose:    flagVerbose,
-					CleanCache: flagCleanCache,
-				}
-
-				// Set default ldflags if not provided
-				if config.LDFlags == "" {
-					config.LDFlags = "-s -w -X main.appVersion=" + versionTag
-				}
-
-				if err := gobuild.BuildWithConfig(ctx, workDir, t, outPath, config); err != nil {
-					if flagVerbose {
-						fmt.Printf("[Worker %d]   FAILED\n  %v\n\n", workerID, err)
-					} else {
-						fmt.Printf("  FAILED\n  %v\n\n", err)
-					}
-					resultChan <- row{
-						file:   outName,
-						target: t.OS + "/" + t.Arch,
-						size:   "n/a",
-						sha256: "n/a",
-						status: redX,
-					}
+				err := stages.Run(ctx, a)
+				a.Result.Duration = time.Since(a.Result.Started).String()
+				if err == nil {
+					resultChan <- summaryRow{TargetResult: a.Result, status: greenTick}
 					continue
 				}
 
-				_ = os.Chmod(outPath, 0o755)
-
-				// Compress if requested
-				if flagCompress != "" {
-					ext := ""
-					switch flagCompress {
-					case "gzip":
-						ext = ".gz"
-					case "zstd":
-						ext = ".zst"
-					}
-					compressedPath := outPath + ext
-					if err := compressFile(outPath, compressedPath, flagCompress); err != nil {
-						if flagVerbose {
-							fmt.Printf("[Worker %d]   Compression failed: %v\n", workerID, err)
-						}
-					} else {
-						// Remove original file after successful compression
-						os.Remove(outPath)
-						outPath = compressedPath
-						if flagVerbose {
-							fmt.Printf("[Worker %d]   Compressed to %s\n", workerID, compressedPath)
-						}
-					}
-				}
-
-				if flagVerbose {
-					fmt.Printf("[Worker %d]   SUCCESS\n\n", workerID)
-				} else {
-					fmt.Printf("  SUCCESS\n\n")
+				if a.Temp != "" {
+					_ = os.Remove(a.Temp)
 				}
-
-				sizeStr := "n/a"
-				sha256Str := "n/a"
-				if sz, err := fsutil.FileSize(outPath); err == nil {
-					sizeStr = fmt.Sprintf("%s (%d)", fsutil.HumanSizeBytes(sz), sz)
+				if ctx.Err() != nil {
+					err = errors.New("interrupted")
+				} else if a.Result.Log == "" {
+					// A --very-verbose transcript is kept when a later stage fails
+					a.Result.Log = writeBuildLog(versionDir, t, err)
 				}
-
-				// Generate checksums if requested
-				if flagChecksums {
-					sha256Sum, sha512Sum, err := generateChecksums(outPath)
-					if err != nil {
-						if flagVerbose {
-							fmt.Printf("[Worker %d]   Checksum generation failed: %v\n", workerID, err)
-						}
-					} else {
-						// Write checksum file
-						if err := writeChecksumFile(outPath, sha256Sum, sha512Sum); err != nil {
-							if flagVerbose {
-								fmt.Printf("[Worker %d]   Failed to write checksum file: %v\n", workerID, err)
-							}
-						}
-						sha256Str = sha256Sum // Show full hash
-					}
+				prefix := ""
+				if flagVerbose {
+					prefix = fmt.Sprintf("[Worker %d] ", workerID)
 				}
-
-				// Update outName if compressed
-				finalOutName := outName
-				if flagCompress != "" {
-					ext := ""
-					switch flagCompress {
-					case "gzip":
-						ext = ".gz"
-					case "zstd":
-						ext = ".zst"
-					}
-					finalOutName = outName + ext
+				// Recognized toolchain failures get a hint instead of the raw output
+				if diag := diagnose(err); diag != nil {
+					fmt.Printf("%s  FAILED: %s\n  Hint: %s\n", prefix, diag.Summary, diag.Hint)
+					a.Result.ErrorKind, a.Result.Hint = diag.Kind, diag.Hint
+				} else {
+					fmt.Printf("%s  FAILED\n  %v\n", prefix, err)
 				}
-
-				resultChan <- row{
-					file:   finalOutName,
-					target: t.OS + "/" + t.Arch,
-					size:   sizeStr,
-					sha256: sha256Str,
-					status: greenTick,
+				if a.Result.Log != "" {
+					fmt.Printf("  Full output: %s\n", filepath.Join(versionDir, a.Result.Log))
 				}
+				fmt.Println()
+				a.Result.File = a.Name
+				a.Result.Success = false
+				a.Result.Error = err.Error()
+				resultChan <- summaryRow{TargetResult: a.Result, status: redX}
 			}
 		}(i)
 	}
@@ -723,7 +1279,7 @@ func run(targetDir string) error {
 	// Send targets to workers
 	go func() {
 		defer close(targetChan)
-		for _, t := range matrix {
+		for _, t := range buildable {
 			targetChan <- t
 		}
 	}()
@@ -737,56 +1293,94 @@ func run(targetDir string) error {
 	// Collect results
 	for result := range resultChan {
 		rows = append(rows, result)
-		if result.status == redX {
+		if !result.Success {
 			failCount++
 		} else {
 			successCount++
 		}
 	}
 
-	fmt.Printf("\nArtifacts for %s, version %s\nstored in %s\n\n", projectName, versionTag, versionDir)
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		fmt.Printf("\nBuild interrupted: %d of %d targets finished before shutdown\n", successCount+failCount, len(buildable))
+	}
 
-	// render table — inner grid only, no outer frame
-	tbl := tablewriter.NewTable(
-		os.Stdout,
-		tablewriter.WithRenderer(renderer.NewBlueprint(tw.Rendition{
-			Borders:  tw.BorderNone,
-			Settings: tw.Settings{Separators: tw.Separators{BetweenColumns: tw.On, BetweenRows: tw.On}},
-		})),
-	)
-
-	tbl.Header([]string{"File", "Target", "Size", "SHA256", "Status"})
-	data := make([][]any, 0, len(rows))
-	for _, r := range rows {
-		data = append(data, []any{r.file, r.target, r.size, r.sha256, r.status})
+	// Without --publish-partial a release only goes up once every target built
+	if len(dests) > 0 && pipelineDests(dests, preview) == nil && !interrupted {
+		if failCount > 0 {
+			fmt.Printf("\nNot publishing: %d of %d targets failed (--publish-partial uploads the others)\n", failCount, successCount+failCount)
+			dests = nil
+		} else {
+			failed := publishTargets(ctx, p, dests, rows, versionDir)
+			successCount -= failed
+			failCount += failed
+		}
 	}
-	_ = tbl.Bulk(data)
-	_ = tbl.Render()
+
+	fmt.Printf("\nArtifacts for %s, version %s\nstored in %s\n\n", projectName, versionTag, versionDir)
+
+	renderSummary(rows, summaryCols, flagSHADisplay, flagSummary)
+	printFailureLogs(rows, versionDir)
 
 	// print build summary counts
-	total := successCount + failCount
+	total := successCount + failCount + skipCount
 	fmt.Println()
-	fmt.Printf("Build summary: Total: %d  Success: %d  Failed: %d\n\n", total, successCount, failCount)
+	if skipCount > 0 {
+		fmt.Printf("Build summary: Total: %d  Success: %d  Failed: %d  Skipped: %d\n\n", total, successCount, failCount, skipCount)
+	} else {
+		fmt.Printf("Build summary: Total: %d  Success: %d  Failed: %d\n\n", total, successCount, failCount)
+	}
+	printPoolSummary(rows)
+	cacheReport := probe.report(rows)
 
 	// Generate build metadata
 	buildTime := time.Now()
-	hostname, _ := os.Hostname()
-	username := os.Getenv("USER")
-	if username == "" {
-		username = os.Getenv("USERNAME") // Windows
+	var hostname, username string
+	if !flagOmitHost {
+		hostname, _ = os.Hostname()
+		username = os.Getenv("USER")
+		if username == "" {
+			username = os.Getenv("USERNAME") // Windows
+		}
+	}
+	environment, err := buildenv.Collect(context.Background(), workDir, flagOmitHost, hostPaths(p))
+	if err != nil {
+		fmt.Printf("Warning: Failed to record the build environment: %v\n", err)
+	}
+
+	if flagDeps || flagPublish {
+		writeDeps(p, rows, versionDir)
+	}
+	if fragmentTarget == "" {
+		writeOutdated(p, versionDir)
+		writeSystemLibraries(p, rows, versionDir)
+	}
+	// Install scripts and distribution cover the whole release, not a fragment
+	var distribution *buildmeta.Distribution
+	if fragmentTarget == "" && !interrupted {
+		if !flagCover {
+			writeInstallScripts(installURLs, rows, versionDir)
+		}
+		distribution = distribute(ctx, p, rows, versionDir)
 	}
 
-	// Collect artifact names
+	// Collect artifact names and per-target results
 	var artifacts []string
+	results := make([]buildmeta.TargetResult, 0, len(rows))
 	for _, r := range rows {
-		if r.status == greenTick {
-			artifacts = append(artifacts, r.file)
+		if r.Success {
+			artifacts = append(artifacts, r.File)
+		}
+		if r.Archive != "" {
+			artifacts = append(artifacts, r.Archive)
 		}
+		results = append(results, r.TargetResult)
 	}
 
-	metadata := BuildMetadata{
+	metadata := buildmeta.BuildMetadata{
 		ProjectName:   projectName,
 		Version:       versionTag,
+		Channel:       p.channel,
 		BuildTime:     buildTime,
 		BuildDuration: time.Since(startTime).String(),
 		GoVersion:     runtime.Version(),
@@ -796,55 +1390,268 @@ func run(targetDir string) error {
 		BuildArch:     runtime.GOARCH,
 		Targets:       matrix,
 		BuildConfig: gobuild.BuildConfig{
-			Strategy:   gobuild.ParseStrategy(flagStrategy),
-			AMD64Level: flagAMD64Level,
-			ARM64Level: flagARM64Level,
-			ARMLevel:   flagARMLevel,
-			MIPSLevel:  flagMIPSLevel,
-			PPC64Level: flagPPC64Level,
-			RISCVLevel: flagRISCVLevel,
-			BuildMode:  flagBuildMode, // Show the requested mode, not the resolved one
-			Tags:       flagTags,
-			LDFlags:    flagLDFlags,
-			BuildFlags: flagBuildFlags,
-			Verbose:    flagVerbose,
-			CleanCache: flagCleanCache,
+			Strategy:    gobuild.ParseStrategy(flagStrategy),
+			AMD64Level:  flagAMD64Level,
+			ARM64Level:  flagARM64Level,
+			ARMLevel:    flagARMLevel,
+			MIPSLevel:   flagMIPSLevel,
+			MIPS64Level: flagMIPS64Level,
+			X86Level:    flagX86Level,
+			PPC64Level:  flagPPC64Level,
+			RISCVLevel:  flagRISCVLevel,
+			BuildMode:   flagBuildMode, // Show the requested mode, not the resolved one
+			Tags:        flagTags,
+			LDFlags:     flagLDFlags,
+			GCFlags:     flagGCFlags,
+			ASMFlags:    flagASMFlags,
+			Cover:       flagCover,
+			BuildFlags:  flagBuildFlags,
+			Verbose:     flagVerbose,
+			CleanCache:  flagCleanCache,
+			Procs:       buildProcs(),
 		},
 		Flags: map[string]interface{}{
-			"all":           flagAll,
-			"name":          flagName,
-			"output_dir":    flagOutDir,
-			"set_version":   flagSetVersion,
-			"tool_version":  appVersion,
-			"strategy":      flagStrategy,
-			"amd64_level":   flagAMD64Level,
-			"arm64_level":   flagARM64Level,
-			"arm_level":     flagARMLevel,
-			"mips_level":    flagMIPSLevel,
-			"ppc64_level":   flagPPC64Level,
-			"riscv_level":   flagRISCVLevel,
-			"buildmode":     flagBuildMode,
-			"tags":          flagTags,
-			"ldflags":       flagLDFlags,
-			"build_flags":   flagBuildFlags,
-			"verbose":       flagVerbose,
-			"skip_cleanup":  flagSkipCleanup,
-			"stop_on_error": flagStopOnError,
-			"parallel":      flagParallel,
-			"clean_cache":   flagCleanCache,
-			"compress":      flagCompress,
-			"checksums":     flagChecksums,
+			"all":                 flagAll,
+			"darwin_universal":    flagUniversal,
+			"name":                flagName,
+			"output_dir":          flagOutDir,
+			"staging_dir":         flagStagingDir,
+			"set_version":         flagSetVersion,
+			"channel":             flagChannel,
+			"nightly":             flagNightly,
+			"tool_version":        appVersion,
+			"strategy":            flagStrategy,
+			"amd64_level":         flagAMD64Level,
+			"arm64_level":         flagARM64Level,
+			"arm_level":           flagARMLevel,
+			"mips_level":          flagMIPSLevel,
+			"mips64_level":        flagMIPS64Level,
+			"386_level":           flagX86Level,
+			"target_group":        flagTargetGroup,
+			"targets":             flagTargets,
+			"config":              flagConfig,
+			"color":               flagColor,
+			"ascii":               flagASCII,
+			"summary_columns":     flagSummaryCols,
+			"sha_display":         flagSHADisplay,
+			"summary":             flagSummary,
+			"report":              flagReport,
+			"notify":              len(p.settings.Notify) > 0,
+			"otel_endpoint":       flagOTel,
+			"pushgateway":         flagPushgateway,
+			"wait":                flagWait.String(),
+			"latest":              flagLatest,
+			"latest_bin":          flagLatestBin,
+			"host_bin":            flagHostBin,
+			"ppc64_level":         flagPPC64Level,
+			"riscv_level":         flagRISCVLevel,
+			"buildmode":           flagBuildMode,
+			"tags":                flagTags,
+			"ldflags":             flagLDFlags,
+			"gcflags":             flagGCFlags,
+			"asmflags":            flagASMFlags,
+			"cover":               flagCover,
+			"test_binaries":       flagTestBinaries,
+			"strip":               flagStrip,
+			"no_strip":            flagNoStrip,
+			"dwarf":               flagDWARF,
+			"embed_info":          flagEmbedInfo,
+			"embed_check":         flagEmbedCheck,
+			"path_check":          flagPathCheck,
+			"ref":                 flagRef,
+			"tag_check":           flagTagCheck,
+			"static_check":        flagStaticCheck,
+			"no_gitignore_update": flagNoGitignore,
+			"create_gitignore":    flagNewGitignore,
+			"build_flags":         flagBuildFlags,
+			"verbose":             flagVerbose,
+			"very_verbose":        flagVeryVerbose,
+			"skip_cleanup":        flagSkipCleanup,
+			"stop_on_error":       flagStopOnError,
+			"parallel":            flagParallel,
+			"max_memory":          flagMaxMemory,
+			"concurrency":         flagConcurrency,
+			"publish":             flagPublish,
+			"publish_dry_run":     flagPublishDryRun,
+			"publish_partial":     flagPublishPartial,
+			"sign":                flagSign,
+			"key":                 flagSignKey,
+			"clean_cache":         flagCleanCache,
+			"cache_stats":         flagCacheStats,
+			"cache_strategy":      flagCacheStrategy,
+			"cpu_limit":           flagCPULimit,
+			"low_priority":        flagLowPriority,
+			"max_output_size":     flagMaxOutput,
+			"generate":            flagGenerate,
+			"compress":            flagCompress,
+			"checksums":           flagChecksums,
+			"archive":             flagArchive,
+			"licenses":            flagLicenses,
+			"sidecar":             flagSidecar,
+			"xattrs":              flagXattrs,
+			"pool":                flagPool,
+			"torrent":             flagTorrent,
+			"ipfs":                flagIPFS,
+			"omit_host":           flagOmitHost,
+			"metadata_minimal":    flagMetadataMinimal,
+			"mod_verify":          flagModVerify,
+			"bench_gate":          flagBenchGate,
+			"deps":                flagDeps,
+			"deps_outdated":       flagDepsOutdated,
+			"sandbox":             flagSandbox,
+			"install_scripts":     flagInstallScripts,
 		},
 		Artifacts:    artifacts,
+		Results:      results,
+		Interrupted:  interrupted,
 		SuccessCount: successCount,
 		FailCount:    failCount,
+		SkipCount:    skipCount,
+		Cache:        cacheReport,
+		Distribution: distribution,
+		Environment:  environment,
+		ModVerify:    modVerify,
+		Bench:        bench.metadata(),
+		Memory:       p.memory.Report(),
+		RunID:        os.Getenv(runIDEnv),
+	}
+	// Everything below (metadata, reports, exports) sees the masked copy
+	redactor.Value(&metadata)
+	if flagMetadataMinimal {
+		redact.Strings(&metadata, hostPaths(p))
 	}
 
-	if err := writeBuildMetadata(versionDir, metadata); err != nil {
+	// A single-target job only writes its fragment; pbuild merge-meta does the rest
+	if fragmentTarget != "" {
+		if err := buildmeta.WriteFragment(versionDir, fragmentTarget, metadata); err != nil {
+			fmt.Printf("Warning: Failed to write build metadata: %v\n", err)
+		} else {
+			fmt.Printf("Build metadata fragment written to: %s\n\n", filepath.Join(versionDir, buildmeta.FragmentName(fragmentTarget)))
+		}
+		if versionDir != finalDir {
+			if err := transferStaged(versionDir, finalDir, false); err != nil {
+				return err
+			}
+		}
+		if interrupted {
+			return errors.New("build interrupted")
+		}
+		return policyErr(rows)
+	}
+
+	if err := buildmeta.Write(versionDir, metadata); err != nil {
 		fmt.Printf("Warning: Failed to write build metadata: %v\n", err)
 	} else {
-		fmt.Printf("Build metadata written to: %s/build-metadata.json\n\n", versionDir)
+		fmt.Printf("Build metadata written to: %s\n\n", filepath.Join(versionDir, buildmeta.FileName))
 	}
 
-	return nil
+	for _, format := range reportFormats {
+		reportPath, err := report.Write(versionDir, format, metadata)
+		if err != nil {
+			fmt.Printf("Warning: Failed to write %s report: %v\n", format, err)
+			continue
+		}
+		fmt.Printf("Build report written to: %s\n", reportPath)
+	}
+	if versionDir != finalDir {
+		fmt.Println()
+		if err := transferStaged(versionDir, finalDir, true); err != nil {
+			return err
+		}
+		versionDir = finalDir
+	}
+
+	// Skip network exports when shutting down on request
+	if interrupted {
+		return errors.New("build interrupted")
+	}
+
+	// Only a complete run becomes the channel's update, and never an instrumented one
+	updated := false
+	if failCount == 0 && successCount > 0 && !flagCover {
+		if err := writeUpdateManifest(p, outDir, metadata); err != nil {
+			fmt.Printf("Warning: Failed to write %s: %v\n", channel.ManifestName, err)
+		} else {
+			updated = true
+		}
+	}
+
+	// Metadata and reports go up last so they describe the finished run
+	var publishErr error
+	if preview != nil {
+		preview.addRunFiles(ctx, p, versionDir, outDir, updated)
+		preview.print(p, versionDir)
+	} else if len(dests) > 0 {
+		if publishErr = publishRunFiles(ctx, p, dests, versionDir); publishErr != nil {
+			fmt.Printf("Warning: Failed to publish: %v\n", publishErr)
+		} else if updated {
+			if publishErr = publishUpdateManifest(ctx, p, dests, outDir); publishErr != nil {
+				fmt.Printf("Warning: Failed to publish: %v\n", publishErr)
+			}
+		}
+		if publishErr != nil || publishIncomplete(rows) {
+			fmt.Printf("Upload the rest with: pbuild publish --resume %s\n", versionDir)
+		}
+	}
+
+	if flagCover && successCount > 0 {
+		printCoverHint()
+	}
+	if flagHostBin != "" {
+		dst := hostBinPath(p, versionDir)
+		if err := copyHostBinary(versionDir, dst, rows); err != nil {
+			fmt.Printf("Warning: Failed to copy host binary: %v\n", err)
+		} else {
+			fmt.Printf("Host binary copied to: %s\n", dst)
+		}
+	}
+	if failCount == 0 && successCount > 0 && !flagCover {
+		if flagLatest {
+			if err := updateLatest(outDir, versionDir); err != nil {
+				fmt.Printf("Warning: Failed to update latest pointer: %v\n", err)
+			}
+		}
+		if flagLatestBin {
+			dst := filepath.Join(outDir, hostBinName(artifactBase(p)))
+			if err := copyHostBinary(versionDir, dst, rows); err != nil {
+				fmt.Printf("Warning: Failed to copy host binary: %v\n", err)
+			} else {
+				fmt.Printf("Host binary copied to: %s\n", dst)
+			}
+		}
+		if flagNightly {
+			pruneNightlies(p, outDir)
+		}
+	}
+	if flagPool {
+		prunePool(outputDir(workDir))
+	}
+
+	otelEndpoint := flagOTel
+	if otelEndpoint == "" {
+		otelEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if otelEndpoint != "" {
+		if err := metrics.ExportOTLP(ctx, otelEndpoint, appVersion, metadata); err != nil {
+			fmt.Printf("Warning: Failed to export OpenTelemetry trace: %v\n", err)
+		}
+	}
+	if flagPushgateway != "" {
+		if err := metrics.PushPrometheus(ctx, flagPushgateway, metadata); err != nil {
+			fmt.Printf("Warning: Failed to push metrics: %v\n", err)
+		}
+	}
+
+	for _, n := range notifiers {
+		if err := n.Notify(ctx, metadata); err != nil {
+			fmt.Printf("Warning: Failed to send %s notification: %v\n", n.Name(), err)
+		} else if flagVerbose {
+			fmt.Printf("Sent %s notification\n", n.Name())
+		}
+	}
+
+	if publishErr != nil {
+		return publishErr
+	}
+	return policyErr(rows)
 }
@@ -0,0 +1,113 @@
+package memlimit
+
+import (
+	"context"
+	"sync"
+
+	"pbuild/runner"
+)
+
+// Headroom is how much more a build is assumed to take than its largest
+// toolchain process, for the compiles the go command runs beside it
+const Headroom = 2
+
+// Available returns the memory the run may still use: on Linux the
+// MemAvailable of /proc/meminfo, lowered by the cgroup limit of a container,
+// on macOS and Windows the free physical memory
+func Available() (int64, error) {
+	return available()
+}
+
+// Gate caps how many builds run at once so that their estimated memory fits
+// the budget. Until a first build reported its memory only one runs; each
+// build's largest process then sets the estimate, which only ever grows.
+type Gate struct {
+	budget int64
+	max    int
+	// OnLimit is called when the first build set the cap and whenever it
+	// changes, e.g. to report it
+	OnLimit func(limit int, perBuild int64)
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	active   int
+	peak     int64
+	measured bool
+}
+
+// NewGate returns a gate for builds sharing budget bytes, never allowing
+// more than max at once
+func NewGate(budget int64, max int) *Gate {
+	g := &Gate{budget: budget, max: max, limit: 1}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// Acquire waits until another build fits
+func (g *Gate) Acquire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.active >= g.limit {
+		g.cond.Wait()
+	}
+	g.active++
+}
+
+// Release ends a build and recomputes the cap from the largest process seen
+func (g *Gate) Release() {
+	g.mu.Lock()
+	g.active--
+	old, first := g.limit, !g.measured
+	g.measured = true
+	g.limit = g.max
+	if perBuild := g.peak * Headroom; perBuild > 0 {
+		g.limit = min(max(int(g.budget/perBuild), 1), g.max)
+	}
+	limit, perBuild := g.limit, g.peak*Headroom
+	g.cond.Broadcast()
+	g.mu.Unlock()
+	if (first || limit != old) && g.OnLimit != nil {
+		g.OnLimit(limit, perBuild)
+	}
+}
+
+// Observe records the peak resident memory of a process of a build
+func (g *Gate) Observe(peakRSS int64) {
+	g.mu.Lock()
+	g.peak = max(g.peak, peakRSS)
+	g.mu.Unlock()
+}
+
+// Runner passes commands on to next and observes their peak memory
+func (g *Gate) Runner(next runner.Runner) runner.Runner {
+	return runner.Func(func(ctx context.Context, cmd *runner.Cmd) error {
+		err := runner.Or(next).Run(ctx, cmd)
+		g.Observe(cmd.PeakRSS)
+		return err
+	})
+}
+
+// Report is what build-metadata.json records of the gate
+type Report struct {
+	Budget int64 `json:"budget"`
+	// PerBuild is the estimated memory of one build; 0 when the system did
+	// not report the memory of processes
+	PerBuild int64 `json:"per_build"`
+	// Parallel is the cap the estimate allowed
+	Parallel int `json:"parallel"`
+}
+
+// Report returns the gate's budget, estimate and cap; nil for a nil gate or
+// before any build ended
+func (g *Gate) Report() *Report {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.measured {
+		return nil
+	}
+	return &Report{Budget: g.budget, PerBuild: g.peak * Headroom, Parallel: g.limit}
+}
@@ -0,0 +1,19 @@
+package memlimit
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func available() (int64, error) {
+	// Free and inactive pages can both be reclaimed at once
+	free, err := unix.SysctlUint32("vm.page_free_count")
+	if err != nil {
+		return 0, err
+	}
+	inactive, _ := unix.SysctlUint32("vm.page_inactive_count")
+	pageSize, err := unix.SysctlUint32("hw.pagesize")
+	if err != nil {
+		return 0, err
+	}
+	return (int64(free) + int64(inactive)) * int64(pageSize), nil
+}
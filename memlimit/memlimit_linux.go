@@ -0,0 +1,93 @@
+package memlimit
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func available() (int64, error) {
+	avail, err := memAvailable()
+	if err != nil {
+		return 0, err
+	}
+	if free, ok := cgroupFree(); ok {
+		avail = min(avail, free)
+	}
+	return avail, nil
+}
+
+// memAvailable reads MemAvailable from /proc/meminfo
+func memAvailable() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// MemAvailable:   12345678 kB
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("no MemAvailable in /proc/meminfo")
+}
+
+// cgroupFree returns what the memory limit of pbuild's cgroup leaves, with
+// cgroup v2 or v1; false without a limit
+func cgroupFree() (int64, bool) {
+	if dir, ok := cgroupV2Dir(); ok {
+		if limit, ok := readBytes(filepath.Join(dir, "memory.max")); ok {
+			usage, _ := readBytes(filepath.Join(dir, "memory.current"))
+			return max(limit-usage, 0), true
+		}
+	}
+	const v1 = "/sys/fs/cgroup/memory"
+	limit, ok := readBytes(filepath.Join(v1, "memory.limit_in_bytes"))
+	// An unlimited v1 cgroup reports a number near the largest int64
+	if !ok || limit >= 1<<60 {
+		return 0, false
+	}
+	usage, _ := readBytes(filepath.Join(v1, "memory.usage_in_bytes"))
+	return max(limit-usage, 0), true
+}
+
+// cgroupV2Dir is the unified hierarchy directory of pbuild's cgroup
+func cgroupV2Dir() (string, bool) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join("/sys/fs/cgroup", path), true
+		}
+	}
+	return "", false
+}
+
+// readBytes reads a cgroup file holding a byte count; false for "max" or a
+// missing file
+func readBytes(path string) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package memlimit
+
+import "errors"
+
+func available() (int64, error) {
+	return 0, errors.New("the available memory is not known on this platform")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"pbuild/fsutil"
+	"pbuild/memlimit"
+	"pbuild/pipeline"
+)
+
+// newMemoryGate returns the gate of --parallel auto and --max-memory, nil
+// with neither. Its budget is --max-memory, else the memory available now.
+func newMemoryGate() *memlimit.Gate {
+	if _, auto := parallelFlag(); !auto && flagMaxMemory == "" {
+		return nil
+	}
+	var budget int64
+	if flagMaxMemory != "" {
+		budget, _ = fsutil.ParseSize(flagMaxMemory) // checked by validateSettings
+	} else {
+		var err error
+		if budget, err = memlimit.Available(); err != nil {
+			fmt.Printf("Warning: --parallel auto cannot tell the available memory (%v); building up to %d targets at once, set --max-memory to cap them\n", err, workerCount())
+			return nil
+		}
+	}
+	fmt.Printf("Memory budget: %s; the first build runs alone to measure its memory\n", fsutil.HumanSizeBytes(budget))
+	g := memlimit.NewGate(budget, workerCount())
+	g.OnLimit = func(limit int, perBuild int64) {
+		if perBuild == 0 {
+			fmt.Printf("Memory: this system does not report the memory of builds; parallel builds: %d\n", limit)
+			return
+		}
+		fmt.Printf("Memory: ~%s per build in a %s budget; parallel builds: %d\n", fsutil.HumanSizeBytes(perBuild), fsutil.HumanSizeBytes(budget), limit)
+		if perBuild > budget {
+			fmt.Println("Warning: One build may take more than the memory budget")
+		}
+	}
+	return g
+}
+
+// memoryGated wraps a stage running the go command so that it holds a place
+// in the memory gate while it runs; without a gate it returns s as is
+func memoryGated(p *project, s pipeline.Stage) pipeline.Stage {
+	if p.memory == nil {
+		return s
+	}
+	return memoryGatedStage{s, p.memory}
+}
+
+type memoryGatedStage struct {
+	pipeline.Stage
+	gate *memlimit.Gate
+}
+
+func (s memoryGatedStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	s.gate.Acquire()
+	defer s.gate.Release()
+	return s.Stage.Run(ctx, a)
+}
+
+// parallelWorkers is the build table value of --parallel
+func parallelWorkers(p *project) string {
+	if p.memory != nil {
+		return fmt.Sprintf("up to %d, by memory", workerCount())
+	}
+	return fmt.Sprintf("%d", workerCount())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/buildmeta"
+	"pbuild/report"
+)
+
+var flagMergeInto string
+
+// newMergeMetaCmd returns the `pbuild merge-meta` subcommand
+func newMergeMetaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "merge-meta DIR_OR_FRAGMENT...",
+		Short: "Combine the metadata fragments of pbuild build-one jobs into one build",
+		Long: "Reads build-metadata.<goos>-<goarch>.json fragments, given as files or found in\n" +
+			"the given directories, and writes the combined build-metadata.json (and\n" +
+			"--report files) into --into, by default the first directory given or the\n" +
+			"directory of the first fragment. It does not write checksum files or\n" +
+			"signatures, move the latest pointer or write the update manifest.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runMergeMeta(args)
+		},
+	}
+	cmd.Flags().StringVar(&flagMergeInto, "into", "", "version directory to write the merged metadata to")
+	cmd.Flags().StringVar(&flagReport, "report", "", "also write a build report: md, html (comma-separated)")
+	return cmd
+}
+
+// runMergeMeta merges the fragments found at paths
+func runMergeMeta(paths []string) error {
+	reportFormats := splitList(flagReport)
+	for _, f := range reportFormats {
+		if !slices.Contains(report.Formats, f) {
+			return fmt.Errorf("unsupported report format: %s (expected %s)", f, strings.Join(report.Formats, ", "))
+		}
+	}
+
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, buildmeta.FragmentPattern))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no metadata fragments in %s", path)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	var fragments []*buildmeta.BuildMetadata
+	for _, file := range files {
+		meta, err := buildmeta.Read(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		fragments = append(fragments, meta)
+	}
+	merged, err := buildmeta.Merge(fragments)
+	if err != nil {
+		return err
+	}
+
+	into := flagMergeInto
+	if into == "" {
+		into = versionDirOf(paths[0])
+	}
+	if err := os.MkdirAll(into, 0o755); err != nil {
+		return err
+	}
+	if err := buildmeta.Write(into, merged); err != nil {
+		return fmt.Errorf("failed to write build metadata: %v", err)
+	}
+	fmt.Printf("Merged %d fragments of %s %s: Success: %d  Failed: %d  Skipped: %d\n",
+		len(fragments), merged.ProjectName, merged.Version, merged.SuccessCount, merged.FailCount, merged.SkipCount)
+	fmt.Printf("Build metadata written to: %s\n", filepath.Join(into, buildmeta.FileName))
+
+	for _, format := range reportFormats {
+		reportPath, err := report.Write(into, format, merged)
+		if err != nil {
+			return fmt.Errorf("failed to write %s report: %v", format, err)
+		}
+		fmt.Printf("Build report written to: %s\n", reportPath)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package metadiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"pbuild/buildmeta"
+	"pbuild/deps"
+)
+
+// Change is a setting whose value differs between two builds; an empty side means unset
+type Change struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// DepChange is a compiled-in module that was added, removed or changed
+type DepChange struct {
+	Path       string
+	Old        string // empty when added
+	New        string // empty when removed
+	SumChanged bool   // same version, different go.sum hash
+}
+
+// TargetChange compares one target's artifact across builds
+type TargetChange struct {
+	Target    string
+	OldFile   string
+	NewFile   string
+	OldSize   int64
+	NewSize   int64
+	OldStatus string // ok, failed, skipped, or empty when not built
+	NewStatus string
+}
+
+// Diff is everything that changed between two builds
+type Diff struct {
+	OldVersion string
+	NewVersion string
+	Build      []Change
+	Config     []Change
+	Flags      []Change
+	// DepsCompared is false when either build has no deps.json
+	DepsCompared bool
+	Deps         []DepChange
+	Targets      []TargetChange
+}
+
+// Compare diffs two builds; snapshots may be nil for builds without deps.json
+func Compare(old, new *buildmeta.BuildMetadata, oldDeps, newDeps *deps.Snapshot) Diff {
+	d := Diff{OldVersion: old.Version, NewVersion: new.Version}
+
+	d.Build = compareMaps(buildInfo(old), buildInfo(new))
+	d.Config = compareMaps(flatten(old.BuildConfig), flatten(new.BuildConfig))
+	d.Flags = compareMaps(flatten(old.Flags), flatten(new.Flags))
+
+	if oldDeps != nil && newDeps != nil {
+		d.DepsCompared = true
+		d.Deps = compareDeps(linked(oldDeps), linked(newDeps))
+	}
+	d.Targets = compareTargets(old.Results, new.Results)
+	return d
+}
+
+// flatten renders the top-level JSON fields of v as strings
+func flatten(v any) map[string]string {
+	out := make(map[string]string)
+	data, err := json.Marshal(v)
+	if err != nil {
+		return out
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return out
+	}
+	for k, val := range fields {
+		out[k] = fmt.Sprint(val)
+	}
+	return out
+}
+
+// buildInfo describes the build host and environment, go env values as env.<KEY>
+func buildInfo(m *buildmeta.BuildMetadata) map[string]string {
+	info := map[string]string{
+		"project_name": m.ProjectName, "go_version": m.GoVersion, "build_host": m.BuildHost,
+		"build_user": m.BuildUser, "build_platform": m.BuildOS + "/" + m.BuildArch,
+	}
+	// Builds from before environment snapshots have nothing to compare
+	if m.Environment != nil {
+		for k, v := range m.Environment.Go {
+			info["env."+k] = v
+		}
+		info["env.PATH (sha256)"] = m.Environment.PathSHA256
+	}
+	return info
+}
+
+// compareMaps returns the keys whose values differ, sorted by name
+func compareMaps(old, new map[string]string) []Change {
+	keys := make(map[string]bool)
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+	var changes []Change
+	for k := range keys {
+		if old[k] != new[k] {
+			changes = append(changes, Change{Name: k, Old: old[k], New: new[k]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// linked returns the modules compiled into at least one target, by path
+func linked(snap *deps.Snapshot) map[string]deps.Module {
+	out := make(map[string]deps.Module)
+	for _, m := range snap.Modules {
+		if len(m.Targets) == 0 {
+			continue
+		}
+		if m.Replace != nil {
+			m.Version, m.Sum = m.Replace.Path+"@"+m.Replace.Version, m.Replace.Sum
+		}
+		out[m.Path] = m
+	}
+	return out
+}
+
+func compareDeps(old, new map[string]deps.Module) []DepChange {
+	var changes []DepChange
+	for path, o := range old {
+		n, ok := new[path]
+		switch {
+		case !ok:
+			changes = append(changes, DepChange{Path: path, Old: o.Version})
+		case o.Version != n.Version:
+			changes = append(changes, DepChange{Path: path, Old: o.Version, New: n.Version})
+		case o.Sum != n.Sum:
+			changes = append(changes, DepChange{Path: path, Old: o.Version, New: n.Version, SumChanged: true})
+		}
+	}
+	for path, n := range new {
+		if _, ok := old[path]; !ok {
+			changes = append(changes, DepChange{Path: path, New: n.Version})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// status summarizes a target result
+func status(r buildmeta.TargetResult) string {
+	switch {
+	case r.Skipped:
+		return "skipped"
+	case r.Success:
+		return "ok"
+	default:
+		return "failed"
+	}
+}
+
+func compareTargets(old, new []buildmeta.TargetResult) []TargetChange {
+	byTarget := make(map[string]*TargetChange)
+	var order []string
+	get := func(target string) *TargetChange {
+		tc, ok := byTarget[target]
+		if !ok {
+			tc = &TargetChange{Target: target}
+			byTarget[target] = tc
+			order = append(order, target)
+		}
+		return tc
+	}
+	for _, r := range old {
+		tc := get(r.Target)
+		tc.OldFile, tc.OldSize, tc.OldStatus = r.File, r.Size, status(r)
+	}
+	for _, r := range new {
+		tc := get(r.Target)
+		tc.NewFile, tc.NewSize, tc.NewStatus = r.File, r.Size, status(r)
+	}
+	sort.Strings(order)
+	changes := make([]TargetChange, 0, len(order))
+	for _, t := range order {
+		changes = append(changes, *byTarget[t])
+	}
+	return changes
+}
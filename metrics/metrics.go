@@ -0,0 +1,251 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"pbuild/buildmeta"
+)
+
+// timeout bounds each export request so a dead collector cannot hang the run
+const timeout = 15 * time.Second
+
+// targetTiming returns the start and end time of a target build
+func targetTiming(r buildmeta.TargetResult) (time.Time, time.Time) {
+	d, _ := time.ParseDuration(r.Duration)
+	return r.Started, r.Started.Add(d)
+}
+
+// buildTiming returns the start and end time of the whole run
+func buildTiming(m buildmeta.BuildMetadata) (time.Time, time.Time) {
+	d, _ := time.ParseDuration(m.BuildDuration)
+	return m.BuildTime.Add(-d), m.BuildTime
+}
+
+// OTLP/HTTP JSON encoding, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes"`
+	Status       otlpStatus      `json:"status"`
+}
+
+const (
+	spanKindInternal = 1
+	statusOK         = 1
+	statusError      = 2
+)
+
+func str(key, v string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &v}}
+}
+
+func integer(key string, v int64) otlpAttribute {
+	s := strconv.FormatInt(v, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+func boolean(key string, v bool) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{BoolValue: &v}}
+}
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ExportOTLP sends the run as one trace, with a root span for the build and a child span per target,
+// to an OTLP/HTTP collector such as http://localhost:4318
+func ExportOTLP(ctx context.Context, endpoint, toolVersion string, m buildmeta.BuildMetadata) error {
+	traceID := randomID(16)
+	rootID := randomID(8)
+
+	start, end := buildTiming(m)
+	rootStatus := otlpStatus{Code: statusOK}
+	if m.FailCount > 0 {
+		rootStatus = otlpStatus{Code: statusError, Message: fmt.Sprintf("%d targets failed", m.FailCount)}
+	}
+	spans := []otlpSpan{{
+		TraceID: traceID,
+		SpanID:  rootID,
+		Name:    "pbuild " + m.ProjectName,
+		Kind:    spanKindInternal,
+		Start:   nanos(start),
+		End:     nanos(end),
+		Attributes: []otlpAttribute{
+			str("pbuild.project", m.ProjectName),
+			str("pbuild.version", m.Version),
+			str("pbuild.go_version", m.GoVersion),
+			integer("pbuild.targets.success", int64(m.SuccessCount)),
+			integer("pbuild.targets.failed", int64(m.FailCount)),
+			integer("pbuild.targets.skipped", int64(m.SkipCount)),
+		},
+		Status: rootStatus,
+	}}
+
+	for _, r := range m.Results {
+		if r.Skipped {
+			continue
+		}
+		tStart, tEnd := targetTiming(r)
+		status := otlpStatus{Code: statusOK}
+		if !r.Success {
+			status = otlpStatus{Code: statusError, Message: firstLine(r.Error)}
+		}
+		spans = append(spans, otlpSpan{
+			TraceID:      traceID,
+			SpanID:       randomID(8),
+			ParentSpanID: rootID,
+			Name:         "build " + r.Target,
+			Kind:         spanKindInternal,
+			Start:        nanos(tStart),
+			End:          nanos(tEnd),
+			Attributes: []otlpAttribute{
+				str("pbuild.target", r.Target),
+				str("pbuild.file", r.File),
+				integer("pbuild.size_bytes", r.Size),
+				boolean("pbuild.success", r.Success),
+			},
+			Status: status,
+		})
+	}
+
+	payload := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttribute{str("service.name", "pbuild"), str("service.version", toolVersion)},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "pbuild", "version": toolVersion},
+				"spans": spans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return send(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/v1/traces", "application/json", body)
+}
+
+// PrometheusText renders the run in the Prometheus text exposition format
+func PrometheusText(m buildmeta.BuildMetadata) []byte {
+	var b bytes.Buffer
+	d, _ := time.ParseDuration(m.BuildDuration)
+
+	fmt.Fprintln(&b, "# HELP pbuild_build_duration_seconds Wall time of the whole pbuild run.")
+	fmt.Fprintln(&b, "# TYPE pbuild_build_duration_seconds gauge")
+	fmt.Fprintf(&b, "pbuild_build_duration_seconds %g\n", d.Seconds())
+
+	fmt.Fprintln(&b, "# HELP pbuild_build_timestamp_seconds Unix time the run finished.")
+	fmt.Fprintln(&b, "# TYPE pbuild_build_timestamp_seconds gauge")
+	fmt.Fprintf(&b, "pbuild_build_timestamp_seconds %d\n", m.BuildTime.Unix())
+
+	fmt.Fprintln(&b, "# HELP pbuild_build_targets Number of targets by result.")
+	fmt.Fprintln(&b, "# TYPE pbuild_build_targets gauge")
+	fmt.Fprintf(&b, "pbuild_build_targets{result=\"success\"} %d\n", m.SuccessCount)
+	fmt.Fprintf(&b, "pbuild_build_targets{result=\"failed\"} %d\n", m.FailCount)
+	fmt.Fprintf(&b, "pbuild_build_targets{result=\"skipped\"} %d\n", m.SkipCount)
+
+	// skipped targets were never built, so they have no per-target series
+	built := make([]buildmeta.TargetResult, 0, len(m.Results))
+	for _, r := range m.Results {
+		if !r.Skipped {
+			built = append(built, r)
+		}
+	}
+
+	fmt.Fprintln(&b, "# HELP pbuild_target_duration_seconds Build time per target.")
+	fmt.Fprintln(&b, "# TYPE pbuild_target_duration_seconds gauge")
+	for _, r := range built {
+		td, _ := time.ParseDuration(r.Duration)
+		fmt.Fprintf(&b, "pbuild_target_duration_seconds{target=%q} %g\n", r.Target, td.Seconds())
+	}
+
+	fmt.Fprintln(&b, "# HELP pbuild_target_size_bytes Artifact size per target.")
+	fmt.Fprintln(&b, "# TYPE pbuild_target_size_bytes gauge")
+	for _, r := range built {
+		fmt.Fprintf(&b, "pbuild_target_size_bytes{target=%q} %d\n", r.Target, r.Size)
+	}
+
+	fmt.Fprintln(&b, "# HELP pbuild_target_success Whether the target built (1) or failed (0).")
+	fmt.Fprintln(&b, "# TYPE pbuild_target_success gauge")
+	for _, r := range built {
+		ok := 0
+		if r.Success {
+			ok = 1
+		}
+		fmt.Fprintf(&b, "pbuild_target_success{target=%q} %d\n", r.Target, ok)
+	}
+	return b.Bytes()
+}
+
+// PushPrometheus pushes the run's metrics to a Prometheus Pushgateway, grouped by project
+func PushPrometheus(ctx context.Context, gateway string, m buildmeta.BuildMetadata) error {
+	target := fmt.Sprintf("%s/metrics/job/pbuild/project/%s", strings.TrimRight(gateway, "/"), url.PathEscape(m.ProjectName))
+	return send(ctx, http.MethodPut, target, "text/plain; version=0.0.4", PrometheusText(m))
+}
+
+// send performs the request and treats any non-2xx response as an error
+func send(ctx context.Context, method, target, contentType string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// hostPaths returns the --metadata-minimal replacement of the build host's
+// directories in a string: the module and repository become ".", the home
+// directory "~" and the temporary directory $TMPDIR
+func hostPaths(p *project) func(string) string {
+	dirs := map[string]string{os.TempDir(): "$TMPDIR", p.gitRoot: ".", p.workDir: "."}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs[home] = "~"
+	}
+	var keys []string
+	for dir := range dirs {
+		// An empty directory or a filesystem root would match everything
+		if dir != "" && filepath.Dir(dir) != dir {
+			keys = append(keys, dir)
+		}
+	}
+	if len(keys) == 0 {
+		return func(s string) string { return s }
+	}
+	// Longest first, so the module wins over the home directory it is in
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	quoted := make([]string, len(keys))
+	for i, dir := range keys {
+		quoted[i] = regexp.QuoteMeta(dir)
+	}
+	// Whole path elements only: /tmp/app-out is not below /tmp/app
+	re := regexp.MustCompile(`(` + strings.Join(quoted, "|") + `)([/\\\s"',;:=)]|$)`)
+	return func(s string) string {
+		return re.ReplaceAllStringFunc(s, func(m string) string {
+			sub := re.FindStringSubmatch(m)
+			return dirs[sub[1]] + sub[2]
+		})
+	}
+}
+
+// checkMinimalLDFlags warns about host paths in the ldflags, which go build
+// records in every binary where --metadata-minimal cannot strip them
+func checkMinimalLDFlags(p *project) {
+	if !flagMetadataMinimal {
+		return
+	}
+	if ld := newBuildConfig(p).LDFlags; hostPaths(p)(ld) != ld {
+		fmt.Println("Warning: --ldflags contain host paths, which go build records in every binary")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pbuild/deps"
+)
+
+// verifyModules runs go mod verify before building, as --mod-verify asks.
+// A mismatch is a warning, or fails the run with error; a check that cannot
+// run only warns, since go mod verify needs the module cache.
+func verifyModules(p *project) (*deps.Verification, error) {
+	if flagModVerify == "off" {
+		return nil, nil
+	}
+	if _, err := os.Stat(filepath.Join(p.workDir, "go.mod")); err != nil {
+		return nil, nil
+	}
+	v, err := deps.Verify(context.Background(), p.workDir)
+	if err != nil {
+		fmt.Printf("Warning: Module verification skipped: %v\n", err)
+		return nil, nil
+	}
+	if v.Verified {
+		if flagVerbose {
+			fmt.Println("All modules verified against go.sum")
+		}
+		return v, nil
+	}
+	msg := fmt.Sprintf("modules do not match go.sum:\n  %s", strings.Join(v.Problems, "\n  "))
+	if flagModVerify == "error" {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	fmt.Printf("Warning: %s\n", msg)
+	return v, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pbuild/archive"
+	"pbuild/licenses"
+	"pbuild/ui"
+)
+
+// writeNotices collects the licenses of the project's dependencies when
+// --licenses is set, writes THIRD_PARTY_NOTICES into versionDir and returns it
+// as an archive entry. Dependencies under a forbidden license fail the run.
+func writeNotices(p *project, versionDir string) (*archive.File, error) {
+	if !flagLicenses {
+		return nil, nil
+	}
+	modules, err := licenses.Collect(context.Background(), p.workDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect dependency licenses: %v", err)
+	}
+
+	if bad := licenses.Forbidden(modules, p.cfg.Licenses.Forbidden); len(bad) > 0 {
+		var lines []string
+		for _, m := range bad {
+			lines = append(lines, fmt.Sprintf("  %s %s %s (%s)", ui.Fail(), m.Path, m.Version, m.License))
+		}
+		return nil, fmt.Errorf("dependencies with forbidden licenses:\n%s", strings.Join(lines, "\n"))
+	}
+
+	data, err := licenses.Write(versionDir, p.name, modules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", licenses.NoticesFile, err)
+	}
+	unknown := 0
+	for _, m := range modules {
+		if m.License == licenses.Unknown {
+			unknown++
+		}
+	}
+	fmt.Printf("Collected licenses of %d dependencies into %s", len(modules), licenses.NoticesFile)
+	if unknown > 0 {
+		fmt.Printf(" (%d unidentified)", unknown)
+	}
+	fmt.Print("\n\n")
+	return &archive.File{Name: licenses.NoticesFile, Data: data, Mode: 0o644}, nil
+}
@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"pbuild/buildmeta"
+)
+
+// timeout bounds each notification request so a dead endpoint cannot hang the run
+const timeout = 15 * time.Second
+
+// Notifier delivers a finished build's metadata to an external service
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, metadata buildmeta.BuildMetadata) error
+}
+
+// Parse creates a notifier from a "kind:url" spec such as slack:https://hooks.slack.com/...
+func Parse(spec string) (Notifier, error) {
+	kind, url, ok := strings.Cut(spec, ":")
+	if !ok || !strings.HasPrefix(url, "http") {
+		return nil, fmt.Errorf("invalid notify spec %q (expected slack:<webhook-url> or webhook:<url>)", spec)
+	}
+	switch strings.ToLower(kind) {
+	case "slack":
+		return &slack{url: url}, nil
+	case "webhook":
+		return &webhook{url: url}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q (expected slack or webhook)", kind)
+	}
+}
+
+// webhook POSTs the full BuildMetadata as JSON
+type webhook struct{ url string }
+
+func (w *webhook) Name() string { return "webhook" }
+
+func (w *webhook) Notify(ctx context.Context, metadata buildmeta.BuildMetadata) error {
+	return post(ctx, w.url, metadata)
+}
+
+// slack posts a short summary message to an incoming webhook
+type slack struct{ url string }
+
+func (s *slack) Name() string { return "slack" }
+
+func (s *slack) Notify(ctx context.Context, metadata buildmeta.BuildMetadata) error {
+	return post(ctx, s.url, map[string]string{"text": Summary(metadata)})
+}
+
+// Summary renders a one-paragraph, human-readable build summary
+func Summary(metadata buildmeta.BuildMetadata) string {
+	var b strings.Builder
+	status := "succeeded"
+	if metadata.FailCount > 0 {
+		status = "failed"
+	}
+	counts := fmt.Sprintf("%d ok, %d failed", metadata.SuccessCount, metadata.FailCount)
+	if metadata.SkipCount > 0 {
+		counts += fmt.Sprintf(", %d skipped", metadata.SkipCount)
+	}
+	fmt.Fprintf(&b, "pbuild: %s %s %s (%s) in %s",
+		metadata.ProjectName, metadata.Version, status,
+		counts, metadata.BuildDuration)
+	if metadata.BuildHost != "" {
+		fmt.Fprintf(&b, " on %s", metadata.BuildHost)
+	}
+
+	var failed []string
+	for _, r := range metadata.Results {
+		if !r.Success && !r.Skipped {
+			failed = append(failed, r.Target)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "\nFailed targets: %s", strings.Join(failed, ", "))
+	}
+	return b.String()
+}
+
+// post sends payload as JSON and treats any non-2xx response as an error
+func post(ctx context.Context, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
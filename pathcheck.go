@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"pbuild/gobuild"
+	"pbuild/leakcheck"
+	"pbuild/pipeline"
+)
+
+// pathCheckStage warns when a binary contains absolute host paths: the module
+// source, the module cache, GOPATH, GOROOT or the home directory
+type pathCheckStage struct {
+	enabled bool
+	roots   []leakcheck.Root
+	allow   []string
+}
+
+// newPathCheckStage resolves --path-check; auto scans only builds without -trimpath
+func newPathCheckStage(p *project, config gobuild.BuildConfig) (*pathCheckStage, error) {
+	s := &pathCheckStage{allow: p.cfg.PathCheck.Allow}
+	switch flagPathCheck {
+	case "auto":
+		s.enabled = !config.Trimpath()
+	case "always":
+		s.enabled = true
+	case "never":
+	default:
+		return nil, fmt.Errorf("invalid --path-check %q (expected auto, always or never)", flagPathCheck)
+	}
+	if !s.enabled {
+		return s, nil
+	}
+
+	s.roots = []leakcheck.Root{{Kind: "the module source", Path: p.workDir}}
+	if p.gitRoot != p.workDir {
+		s.roots = append(s.roots, leakcheck.Root{Kind: "the repository", Path: p.gitRoot})
+	}
+	cmd := exec.Command("go", "env", "GOROOT", "GOMODCACHE", "GOPATH")
+	cmd.Dir = p.workDir
+	if out, err := cmd.Output(); err == nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		kinds := []string{"GOROOT", "the module cache", "GOPATH"}
+		for i, line := range lines {
+			if i >= len(kinds) {
+				break
+			}
+			for _, dir := range filepath.SplitList(strings.TrimSpace(line)) {
+				if dir != "" {
+					s.roots = append(s.roots, leakcheck.Root{Kind: kinds[i], Path: dir})
+				}
+			}
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		s.roots = append(s.roots, leakcheck.Root{Kind: "the home directory", Path: home})
+	}
+	return s, nil
+}
+
+func (s *pathCheckStage) Name() string  { return "pathcheck" }
+func (s *pathCheckStage) Enabled() bool { return s.enabled }
+
+func (s *pathCheckStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	findings, err := leakcheck.Scan(a.Temp, s.roots, s.allow)
+	if err != nil {
+		stageLog(a, "Path check failed: %v", err)
+		return nil
+	}
+	if len(findings) == 0 {
+		stageLog(a, "No host paths found")
+		return nil
+	}
+	fmt.Printf("  WARNING: binary contains %s\n", leakcheck.Summary(findings))
+	for _, f := range findings {
+		for i, leaked := range f.Paths {
+			if i == 3 {
+				stageLog(a, "  ... and %d more", len(f.Paths)-i)
+				break
+			}
+			stageLog(a, "  %s", leaked)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"pbuild/buildmeta"
+	"pbuild/targets"
+)
+
+// Artifact is the state of one target as it moves through the stages
+type Artifact struct {
+	Target targets.Target
+	Worker int
+	// Dir is the version directory; Name is the artifact's current file name in it
+	Dir  string
+	Name string
+	// Temp is where the artifact lives until a stage moves it to Path; empty afterwards
+	Temp   string
+	Result buildmeta.TargetResult
+}
+
+// Path returns the artifact's final location in the version directory
+func (a *Artifact) Path() string {
+	return filepath.Join(a.Dir, a.Name)
+}
+
+// File returns the file stages should read and transform: the temp file
+// before it is stored, the final file afterwards
+func (a *Artifact) File() string {
+	if a.Temp != "" {
+		return a.Temp
+	}
+	return a.Path()
+}
+
+// Stage is one step of the per-target pipeline. An error from Run fails the
+// target and stops the pipeline; stages report recoverable problems themselves.
+type Stage interface {
+	Name() string
+	Enabled() bool
+	Run(ctx context.Context, a *Artifact) error
+}
+
+// Hook observes stages; After receives the stage's error, if any
+type Hook struct {
+	Before func(stage string, a *Artifact)
+	After  func(stage string, a *Artifact, err error, elapsed time.Duration)
+}
+
+// Pipeline runs its stages in order for every artifact
+type Pipeline struct {
+	stages []Stage
+	limits map[string]chan struct{}
+	hooks  []Hook
+}
+
+// New returns a pipeline of the given stages
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages, limits: make(map[string]chan struct{})}
+}
+
+// Names lists the stage names in order
+func (p *Pipeline) Names() []string {
+	names := make([]string, 0, len(p.stages))
+	for _, s := range p.stages {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+// Limit caps how many artifacts may be in the named stage at once; n is at
+// least 1, which the caller checks along with its other settings
+func (p *Pipeline) Limit(stage string, n int) error {
+	for _, s := range p.stages {
+		if s.Name() == stage {
+			p.limits[stage] = make(chan struct{}, n)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown stage: %s", stage)
+}
+
+// Use registers a hook for every stage
+func (p *Pipeline) Use(h Hook) {
+	p.hooks = append(p.hooks, h)
+}
+
+// Run passes the artifact through every enabled stage, stopping at the first error
+func (p *Pipeline) Run(ctx context.Context, a *Artifact) error {
+	for _, s := range p.stages {
+		if !s.Enabled() {
+			continue
+		}
+		if err := p.runStage(ctx, s, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) runStage(ctx context.Context, s Stage, a *Artifact) error {
+	name := s.Name()
+	if sem, ok := p.limits[name]; ok {
+		sem <- struct{}{}
+		defer func() { <-sem }()
+	}
+	for _, h := range p.hooks {
+		if h.Before != nil {
+			h.Before(name, a)
+		}
+	}
+	start := time.Now()
+	err := s.Run(ctx, a)
+	for _, h := range p.hooks {
+		if h.After != nil {
+			h.After(name, a, err, time.Since(start))
+		}
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"pbuild/buildplan"
+	"pbuild/config"
+	"pbuild/gitmeta"
+	"pbuild/gobuild"
+	"pbuild/targets"
+)
+
+// rootCmd is the root command, whose flags the build plan records
+var rootCmd *cobra.Command
+
+// replaying is the plan of a `pbuild replay` run, nil otherwise
+var replaying *buildplan.Plan
+
+// unplannedFlags are not recorded in a plan: the plan's target list, version
+// and config replace them, and the rest does not affect a build
+var unplannedFlags = map[string]bool{
+	"help": true, "version": true, "config": true, "set-version": true,
+	"all": true, "target-group": true, "targets": true,
+}
+
+// planFlags returns every recordable flag with its effective value
+func planFlags(cmd *cobra.Command) map[string][]string {
+	flags := make(map[string][]string)
+	record := func(f *pflag.Flag) {
+		if unplannedFlags[f.Name] {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			flags[f.Name] = sv.GetSlice()
+			return
+		}
+		flags[f.Name] = []string{f.Value.String()}
+	}
+	cmd.Flags().VisitAll(record)
+	cmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if _, seen := flags[f.Name]; !seen {
+			record(f)
+		}
+	})
+	return flags
+}
+
+// newBuildPlan resolves the run into a plan: flags, config and the exact
+// environment and tags of every target
+func newBuildPlan(p *project, matrix []targets.Target, outNames map[targets.Target]string) buildplan.Plan {
+	bc := newBuildConfig(p)
+	// The plan gets its own copy of the config, so redacting it leaves the run's alone
+	var cfg config.Config
+	if data, err := json.Marshal(p.cfg); err == nil {
+		_ = json.Unmarshal(data, &cfg)
+	}
+	plan := buildplan.Plan{
+		Schema:        buildplan.Schema,
+		PBuildVersion: appVersion,
+		Created:       time.Now(),
+		Project:       p.name,
+		Version:       p.version,
+		Channel:       p.channel,
+		Config:        &cfg,
+		BuildConfig:   bc,
+	}
+	if rootCmd != nil {
+		plan.Flags = planFlags(rootCmd)
+	}
+	if rev, err := gitmeta.ResolveHEAD(p.gitRoot); err == nil {
+		plan.Commit = rev
+	}
+	// The per-target GOCACHE is a host path that does not change the output
+	envConfig := bc
+	envConfig.GoCacheRoot = ""
+	planTarget := func(t targets.Target) buildplan.Target {
+		tags, _ := gobuild.ResolveTags(bc.Strategy, bc.Tags, t)
+		return buildplan.Target{Target: t.String(), Output: outNames[t], Env: gobuild.TargetEnv(p.workDir, t, envConfig), Tags: tags}
+	}
+	for _, t := range matrix {
+		pt := planTarget(t)
+		if t.OS == "darwin" && t.Arch == targets.DarwinUniversal {
+			pt.Env, pt.Tags = nil, nil
+			for _, arch := range []string{"amd64", "arm64"} {
+				slice := planTarget(targets.Target{OS: "darwin", Arch: arch})
+				slice.Output = ""
+				pt.Slices = append(pt.Slices, slice)
+			}
+		}
+		plan.Targets = append(plan.Targets, pt)
+	}
+	return plan
+}
+
+// plannedEnv returns the environment the plan recorded per target, universal
+// darwin slices included, which a replay builds with
+func plannedEnv(plan *buildplan.Plan) map[targets.Target][]string {
+	env := make(map[targets.Target][]string)
+	add := func(pt buildplan.Target) {
+		if t, err := targets.Parse(pt.Target); err == nil && pt.Env != nil {
+			env[t] = pt.Env
+		}
+	}
+	for _, pt := range plan.Targets {
+		add(pt)
+		for _, slice := range pt.Slices {
+			add(slice)
+		}
+	}
+	return env
+}
+
+// checkReplay warns about targets whose environment this pbuild would resolve
+// differently from the plan being replayed, which it builds with, and about
+// tags that differ
+func checkReplay(p *project, want, got buildplan.Plan) {
+	today := newBuildConfig(p)
+	today.Env, today.GoCacheRoot = nil, ""
+	planned := make(map[string]buildplan.Target)
+	for _, t := range want.Targets {
+		planned[t.Target] = t
+	}
+	for _, t := range got.Targets {
+		w, ok := planned[t.Target]
+		if !ok {
+			continue
+		}
+		if target, err := targets.Parse(t.Target); err == nil && w.Env != nil {
+			if env := gobuild.TargetEnv(p.workDir, target, today); !slices.Equal(w.Env, env) {
+				fmt.Printf("Warning: %s builds with the plan's %s; this pbuild would use %s\n", t.Target, strings.Join(w.Env, " "), strings.Join(env, " "))
+			}
+		}
+		if !slices.Equal(w.Tags, t.Tags) {
+			fmt.Printf("Warning: %s builds with tags %s, the plan recorded %s\n", t.Target, strings.Join(t.Tags, ","), strings.Join(w.Tags, ","))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"pbuild/buildmeta"
+)
+
+// DefaultTimeout bounds a plugin run when its config sets none
+const DefaultTimeout = 10 * time.Minute
+
+// Context is the JSON document a plugin reads from stdin
+type Context struct {
+	Stage   string                 `json:"stage"`
+	After   string                 `json:"after"`
+	Project string                 `json:"project"`
+	Version string                 `json:"version"`
+	Target  string                 `json:"target"`
+	OS      string                 `json:"os"`
+	Arch    string                 `json:"arch"`
+	WorkDir string                 `json:"work_dir"`
+	Dir     string                 `json:"dir"`
+	File    string                 `json:"file"`
+	Path    string                 `json:"path"`
+	Archive string                 `json:"archive,omitempty"`
+	Result  buildmeta.TargetResult `json:"result"`
+}
+
+// Run executes command in workDir with the context on stdin and returns its
+// combined output. The artifact path is also exported as PBUILD_ARTIFACT.
+func Run(ctx context.Context, command []string, workDir string, timeout time.Duration, pc Context) ([]byte, error) {
+	if len(command) == 0 {
+		return nil, errors.New("no command configured")
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	input, err := json.Marshal(pc)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = workDir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"PBUILD_STAGE="+pc.Stage,
+		"PBUILD_TARGET="+pc.Target,
+		"PBUILD_VERSION="+pc.Version,
+		"PBUILD_ARTIFACT="+pc.Path,
+	)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return out, fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return out, fmt.Errorf("%v: %s", err, msg)
+		}
+		return out, err
+	}
+	return out, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"pbuild/config"
+	"pbuild/pipeline"
+	"pbuild/plugin"
+)
+
+// pluginStage runs an external command from plugins: in pbuild.yaml
+type pluginStage struct {
+	p    *project
+	spec config.Plugin
+}
+
+func (s *pluginStage) Name() string  { return s.spec.Name }
+func (s *pluginStage) Enabled() bool { return true }
+
+func (s *pluginStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	if len(s.spec.Targets) > 0 {
+		if ok, _ := matchTargets(s.spec.Targets, a.Target); !ok {
+			return nil
+		}
+	}
+	pc := plugin.Context{
+		Stage:   s.spec.Name,
+		After:   s.spec.After,
+		Project: s.p.name,
+		Version: s.p.version,
+		Target:  a.Target.String(),
+		OS:      a.Target.OS,
+		Arch:    a.Target.Arch,
+		WorkDir: s.p.workDir,
+		Dir:     a.Dir,
+		File:    a.Name,
+		Path:    a.File(),
+		Archive: a.Result.Archive,
+		Result:  a.Result,
+	}
+	out, err := plugin.Run(ctx, s.spec.Command, s.p.workDir, s.spec.Timeout, pc)
+	if err != nil {
+		if s.spec.Optional {
+			fmt.Printf("  WARNING: plugin %s: %v\n", s.spec.Name, err)
+			return nil
+		}
+		return fmt.Errorf("plugin %s failed: %v", s.spec.Name, err)
+	}
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		stageLog(a, "%s: %s", s.spec.Name, msg)
+	}
+	return nil
+}
+
+// withPlugins validates the configured plugins and slots each one in after
+// its stage, keeping the config order among plugins on the same stage
+func withPlugins(p *project, builtin []pipeline.Stage) ([]pipeline.Stage, error) {
+	names := make([]string, 0, len(builtin))
+	for _, s := range builtin {
+		names = append(names, s.Name())
+	}
+	last := names[len(names)-1]
+
+	after := make(map[string][]pipeline.Stage)
+	for i, spec := range p.cfg.Plugins {
+		if spec.Name == "" || len(spec.Command) == 0 {
+			return nil, fmt.Errorf("plugins[%d]: name and command are required", i)
+		}
+		if slices.Contains(names, spec.Name) {
+			return nil, fmt.Errorf("plugins[%d]: stage name %q is already taken", i, spec.Name)
+		}
+		if spec.After == "" {
+			spec.After = last
+		}
+		if !slices.Contains(names, spec.After) {
+			return nil, fmt.Errorf("plugins[%d]: unknown stage %q (expected %s)", i, spec.After, strings.Join(names, ", "))
+		}
+		for _, pattern := range spec.Targets {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("plugins[%d]: invalid target pattern %q: %v", i, pattern, err)
+			}
+		}
+		names = append(names, spec.Name)
+		after[spec.After] = append(after[spec.After], &pluginStage{p: p, spec: spec})
+	}
+
+	var stages []pipeline.Stage
+	for _, s := range builtin {
+		stages = append(stages, s)
+		stages = append(stages, after[s.Name()]...)
+	}
+	return stages, nil
+}
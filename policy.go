@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"pbuild/config"
+	"pbuild/fsutil"
+	"pbuild/pipeline"
+	"pbuild/targets"
+)
+
+// policyViolation is the ErrorKind of targets failed by the content policy
+const policyViolation = "policy-violation"
+
+// policyRule is a content rule from pbuild.yaml with its size limit parsed
+type policyRule struct {
+	config.PolicyRule
+	maxSize int64
+}
+
+// policyStage fails targets whose binary breaks a policy rule of pbuild.yaml
+type policyStage struct {
+	rules   []policyRule
+	version string
+}
+
+// newPolicyStage validates the policy rules of pbuild.yaml
+func newPolicyStage(p *project) (*policyStage, error) {
+	s := &policyStage{version: p.version}
+	for i, rule := range p.cfg.Policy {
+		r := policyRule{PolicyRule: rule}
+		if rule.MaxSize != "" {
+			size, err := fsutil.ParseSize(rule.MaxSize)
+			if err != nil {
+				return nil, fmt.Errorf("policy rule %d in %s: invalid max_size: %v", i+1, config.FileName, err)
+			}
+			r.maxSize = size
+		}
+		if _, err := matchTargets(rule.Targets, targets.Target{}); err != nil {
+			return nil, fmt.Errorf("policy rule %d in %s: %v", i+1, config.FileName, err)
+		}
+		for _, f := range rule.Forbidden {
+			if f == "" {
+				return nil, fmt.Errorf("policy rule %d in %s: empty forbidden string", i+1, config.FileName)
+			}
+		}
+		s.rules = append(s.rules, r)
+	}
+	return s, nil
+}
+
+func (s *policyStage) Name() string  { return "policy" }
+func (s *policyStage) Enabled() bool { return len(s.rules) > 0 }
+
+func (s *policyStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	data, err := os.ReadFile(a.Temp)
+	if err != nil {
+		return fmt.Errorf("policy check failed: %v", err)
+	}
+	var violations []string
+	for _, rule := range s.rules {
+		if len(rule.Targets) > 0 {
+			if ok, _ := matchTargets(rule.Targets, a.Target); !ok {
+				continue
+			}
+		}
+		found := s.check(rule, data)
+		if rule.Reason != "" {
+			for i := range found {
+				found[i] += " (" + rule.Reason + ")"
+			}
+		}
+		violations = append(violations, found...)
+	}
+	if len(violations) > 0 {
+		a.Result.ErrorKind = policyViolation
+		return fmt.Errorf("policy violation: %s", strings.Join(violations, "; "))
+	}
+	stageLog(a, "Policy check passed")
+	return nil
+}
+
+// check returns the ways the binary data breaks the rule
+func (s *policyStage) check(rule policyRule, data []byte) []string {
+	var violations []string
+	if size := int64(len(data)); rule.maxSize > 0 && size > rule.maxSize {
+		violations = append(violations, fmt.Sprintf("binary is %s, over the %s limit",
+			fsutil.HumanSizeBytes(size), fsutil.HumanSizeBytes(rule.maxSize)))
+	}
+	for _, f := range rule.Forbidden {
+		if bytes.Contains(data, []byte(f)) {
+			violations = append(violations, fmt.Sprintf("contains forbidden string %q", f))
+		}
+	}
+	if rule.RequireVersion && !bytes.Contains(data, []byte(s.version)) {
+		violations = append(violations, fmt.Sprintf("version %s is not embedded", s.version))
+	}
+	return violations
+}
+
+// policyErr fails the run when a target broke the content policy
+func policyErr(rows []summaryRow) error {
+	var violating []string
+	for _, r := range rows {
+		if r.ErrorKind == policyViolation {
+			violating = append(violating, r.Target)
+		}
+	}
+	if len(violating) == 0 {
+		return nil
+	}
+	return fmt.Errorf("content policy violated by %s", strings.Join(violating, ", "))
+}
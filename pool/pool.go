@@ -0,0 +1,193 @@
+package pool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"pbuild/fsutil"
+)
+
+// Dir is the pool's directory inside the output directory
+const Dir = ".pool"
+
+// writeBits are cleared on pool entries, which keep their other mode bits so
+// binaries stay executable
+const writeBits = 0o222
+
+// Pool stores every artifact once, named by its SHA-256; version directories
+// hold links to the stored copies
+type Pool struct {
+	dir string
+}
+
+// New returns the pool of an output directory
+func New(outDir string) *Pool {
+	return &Pool{dir: filepath.Join(outDir, Dir)}
+}
+
+// path returns where the pool keeps content with the given hex SHA-256
+func (p *Pool) path(digest string) string {
+	return filepath.Join(p.dir, "sha256", digest[:2], digest)
+}
+
+// Link stores file in the pool unless the pool holds its content already, in
+// which case file is replaced by a link to the stored copy. Files are
+// hardlinked, or symlinked where hardlinks fail (e.g. across filesystems),
+// and made read-only, as every version sharing an entry would see an edit.
+// An entry is only reused once its SHA-256 matches. reused reports whether
+// an identical file was stored before.
+func (p *Pool) Link(file, digest string) (reused bool, err error) {
+	if len(digest) < 2 {
+		return false, fmt.Errorf("invalid digest %q", digest)
+	}
+	entry := p.path(digest)
+	if err := os.MkdirAll(filepath.Dir(entry), 0o755); err != nil {
+		return false, err
+	}
+
+	stored, err := os.Stat(entry)
+	if errors.Is(err, fs.ErrNotExist) {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		if err := os.Chmod(file, fi.Mode().Perm()&^writeBits); err != nil {
+			return false, err
+		}
+		if err := os.Link(file, entry); err == nil {
+			return false, nil
+		}
+		// No hardlinks: the pool takes the file and the version directory a symlink
+		if err := os.Rename(file, entry); err != nil {
+			return false, err
+		}
+		return false, p.symlink(entry, file)
+	}
+	if err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(stored, fi) {
+		return true, nil
+	}
+	if stored.Size() != fi.Size() {
+		return false, fmt.Errorf("pool entry %s has %d bytes, %s %d; the pool is corrupt", entry, stored.Size(), file, fi.Size())
+	}
+	sum, err := fileSHA256(entry)
+	if err != nil {
+		return false, err
+	}
+	if sum != digest {
+		return false, fmt.Errorf("pool entry %s has SHA-256 %s; the pool is corrupt", entry, sum)
+	}
+	if stored.Mode().Perm()&writeBits != 0 {
+		if err := os.Chmod(entry, stored.Mode().Perm()&^writeBits); err != nil {
+			return false, err
+		}
+	}
+
+	tmp := fsutil.TempPath(file)
+	_ = os.Remove(tmp)
+	if err := os.Link(entry, tmp); err != nil {
+		return true, p.symlink(entry, file)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return true, err
+	}
+	return true, nil
+}
+
+// symlink replaces file with a relative symlink to entry
+func (p *Pool) symlink(entry, file string) error {
+	rel, err := filepath.Rel(filepath.Dir(file), entry)
+	if err != nil {
+		return err
+	}
+	return fsutil.ReplaceSymlink(rel, file)
+}
+
+// Prune removes the entries no file below root refers to any more, either as
+// a hardlink or a symlink, and returns how many it removed and their size
+func (p *Pool) Prune(root string) (int, int64, error) {
+	// Files by size, so each entry is only compared with same-sized candidates
+	linked := make(map[int64][]fs.FileInfo)
+	symlinked := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir() && path == p.dir:
+			return filepath.SkipDir
+		case d.Type()&fs.ModeSymlink != 0:
+			if target, err := filepath.EvalSymlinks(path); err == nil {
+				symlinked[target] = true
+			}
+		case d.Type().IsRegular():
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			linked[fi.Size()] = append(linked[fi.Size()], fi)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	removed, freed := 0, int64(0)
+	err = filepath.WalkDir(p.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil && symlinked[resolved] {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		for _, other := range linked[fi.Size()] {
+			if os.SameFile(fi, other) {
+				return nil
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		freed += fi.Size()
+		return nil
+	})
+	return removed, freed, err
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,64 @@
+package pool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBinary writes an executable with the given content and returns its path
+// and SHA-256
+func writeBinary(t *testing.T, dir, name, content string) (string, string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return path, hex.EncodeToString(sum[:])
+}
+
+func TestLink(t *testing.T) {
+	out := t.TempDir()
+	p := New(out)
+
+	first, digest := writeBinary(t, filepath.Join(out, "1.0.0"), "app", "binary")
+	reused, err := p.Link(first, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused {
+		t.Error("first link reported a reused entry")
+	}
+
+	second, _ := writeBinary(t, filepath.Join(out, "1.0.1"), "app", "binary")
+	reused, err = p.Link(second, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reused {
+		t.Error("identical binary did not reuse the entry")
+	}
+
+	for _, path := range []string{first, second, p.path(digest)} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0o555 {
+			t.Errorf("%s: mode %o, want 555", path, perm)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "binary" {
+			t.Errorf("%s holds %q", path, data)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package priority
+
+// niceness is the nice value of a low-priority run on unix
+const niceness = 10
+
+// Lower drops the scheduling priority of the current process, which the go
+// build and every other subprocess started afterwards inherit: nice 10 on
+// unix, plus the idle I/O class on Linux; below-normal priority on Windows
+func Lower() error {
+	return lower()
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package priority
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ioprio_set arguments, see ioprio_set(2)
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lower sets nice and the idle I/O class (ionice -c3) on every thread:
+// Linux keeps both per thread, and a subprocess inherits them from whichever
+// thread forks it. Threads the runtime starts later inherit them in turn.
+func lower() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, niceness); err != nil {
+			return fmt.Errorf("setpriority: %v", err)
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift); errno != 0 {
+			return fmt.Errorf("ioprio_set: %v", errno)
+		}
+	}
+	return nil
+}
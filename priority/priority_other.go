@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package priority
+
+import "errors"
+
+func lower() error {
+	return errors.New("process priorities are not supported on this platform")
+}
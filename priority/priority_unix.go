@@ -0,0 +1,15 @@
+//go:build unix && !linux
+
+package priority
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func lower() error {
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, niceness); err != nil {
+		return fmt.Errorf("setpriority: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package priority
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// belowNormalPriorityClass is inherited by child processes, unlike background mode
+const belowNormalPriorityClass = 0x00004000
+
+var procSetPriorityClass = syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+
+func lower() error {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+	if r, _, err := procSetPriorityClass.Call(uintptr(process), belowNormalPriorityClass); r == 0 {
+		return fmt.Errorf("SetPriorityClass: %v", err)
+	}
+	return nil
+}
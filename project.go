@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"pbuild/appver"
+	"pbuild/channel"
+	"pbuild/config"
+	"pbuild/creds"
+	"pbuild/fsutil"
+	"pbuild/gitmeta"
+	"pbuild/gobuild"
+	"pbuild/ignore"
+	"pbuild/memlimit"
+	"pbuild/redact"
+	"pbuild/sandbox"
+	"pbuild/toolprobe"
+	"pbuild/versionpkg"
+)
+
+// project describes the Go project being built
+type project struct {
+	workDir string // module root, or the target directory outside a module
+	gitRoot string
+	name    string
+	version string
+	// srcVersion is the version declared in the source, "" when there is none,
+	// and versionVar the symbol -X stamps the built version into
+	srcVersion string
+	versionVar string
+	// versionPkg is the import path of the pbuild gen version-pkg package, if any
+	versionPkg string
+	channel    string
+	cfg        *config.Config
+	// settings are the config's settings with the build flags applied
+	settings buildSettings
+	creds    *creds.Store
+	// sandbox restricts the go commands of a --sandbox run
+	sandbox *sandbox.Policy
+	// memory caps the parallel builds of --parallel auto and --max-memory
+	memory *memlimit.Gate
+	// probes answers questions about the toolchain from the probe cache
+	probes *toolprobe.Prober
+}
+
+// resolveProject locates the module and git roots and derives the project name, version and config
+func resolveProject(targetDir string) (*project, error) {
+	abs, err := filepath.Abs(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// roots
+	workDir := abs
+	if modRoot, err := fsutil.FindModuleRoot(abs); err == nil {
+		workDir = modRoot
+	}
+	gitRoot := workDir
+	if gr, err := fsutil.FindGitRoot(workDir); err == nil {
+		gitRoot = gr
+	}
+
+	// name
+	projectName := flagName
+	if projectName == "" {
+		if m, err := fsutil.InferModulePath(workDir); err == nil && m != "" {
+			parts := strings.Split(m, "/")
+			projectName = parts[len(parts)-1]
+		} else {
+			projectName = filepath.Base(workDir)
+		}
+	}
+
+	cfg, err := loadConfig(workDir, flagConfig)
+	if err != nil {
+		return nil, err
+	}
+	if replaying != nil {
+		// A replay uses the config the plan recorded, not today's pbuild.yaml
+		cfg = replaying.Config
+	}
+
+	srcVersion, versionVar, err := sourceVersion(workDir, gitRoot, cfg)
+	if err != nil {
+		return nil, err
+	}
+	pkgDir := cfg.VersionPkg
+	if pkgDir == "" {
+		pkgDir = versionpkg.DefaultDir
+	}
+	versionPkg, err := versionpkg.Find(workDir, pkgDir)
+	if err != nil {
+		return nil, fmt.Errorf("version_pkg in %s: %v", config.FileName, err)
+	}
+
+	// version
+	ch := flagChannel
+	if flagNightly {
+		if ch != channel.Stable && ch != channel.Nightly {
+			return nil, fmt.Errorf("--nightly builds the nightly channel, not %s", ch)
+		}
+		ch = channel.Nightly
+	}
+	if err := channel.Validate(ch); err != nil {
+		return nil, err
+	}
+	versionTag := flagSetVersion
+	if versionTag != "" && channel.Prerelease(ch) && !strings.Contains(versionTag, "-"+ch+".") {
+		// A prerelease channel never builds a stable-looking version
+		versionTag += channel.Suffix(ch, versionTag, filepath.Join(outputDir(workDir), channel.Dir(ch)), time.Now())
+	}
+	if versionTag == "" {
+		rev, _ := gitmeta.ResolveHEAD(gitRoot)
+		if rev == "" {
+			rev = "unknown"
+		}
+		dirty, _ := gitmeta.HeuristicDirty(gitRoot, projectIgnore(workDir, gitRoot).Match)
+		if dirty {
+			rev += "-dirty"
+		}
+		var base string
+		if flagNightly {
+			// Nightlies are identified by date and commit alone
+			base = time.Now().UTC().Format("20060102")
+		} else {
+			base = srcVersion
+			if base == "" {
+				base = appVersion
+			}
+			base += channel.Suffix(ch, base, filepath.Join(outputDir(workDir), channel.Dir(ch)), time.Now())
+		}
+		versionTag = fmt.Sprintf("%s-%s", base, rev)
+	}
+	// Instrumented builds never share a version directory with the release
+	if flagCover {
+		versionTag += coverSuffix
+	}
+
+	return &project{workDir: workDir, gitRoot: gitRoot, name: projectName, version: versionTag, srcVersion: srcVersion, versionVar: versionVar,
+		versionPkg: versionPkg, channel: ch, cfg: cfg, settings: resolveSettings(cfg), creds: creds.New(cfg.Credentials), probes: newToolProber(workDir)}, nil
+}
+
+// sourceVersion returns the version declared in the source and the -X symbol
+// that sets it: version_source from pbuild.yaml, or else the first appVersion
+// found in the module, or else a VERSION file or package manifest in the
+// module or repository root
+func sourceVersion(workDir, gitRoot string, cfg *config.Config) (string, string, error) {
+	src := cfg.VersionSource
+	if src.File == "" {
+		if src.Var != "" {
+			return "", "", fmt.Errorf("version_source in %s needs a file", config.FileName)
+		}
+		version, _ := appver.ExtractAppVersion(workDir)
+		if version == "" {
+			version, _ = appver.FindManifest(workDir, gitRoot)
+		}
+		return version, "main.appVersion", nil
+	}
+	file := filepath.Join(workDir, filepath.FromSlash(src.File))
+	if !strings.HasSuffix(file, ".go") {
+		if src.Var != "" {
+			return "", "", fmt.Errorf("version_source in %s: var only applies to a .go file, not %s", config.FileName, src.File)
+		}
+		version, err := appver.FromManifest(file)
+		if err != nil {
+			return "", "", fmt.Errorf("version_source in %s: %v", config.FileName, err)
+		}
+		return version, "main.appVersion", nil
+	}
+	name := src.Var
+	if name == "" {
+		name = "appVersion"
+	}
+	version, pkg, err := appver.FromFile(file, name)
+	if err != nil {
+		return "", "", fmt.Errorf("version_source in %s: %v", config.FileName, err)
+	}
+	if pkg == "main" {
+		return version, "main." + name, nil
+	}
+	// Other packages are set by import path
+	importPath, err := fsutil.InferModulePath(workDir)
+	if err != nil {
+		return "", "", fmt.Errorf("version_source in %s: %v", config.FileName, err)
+	}
+	if dir := path.Dir(filepath.ToSlash(src.File)); dir != "." {
+		importPath += "/" + dir
+	}
+	return version, importPath + "." + name, nil
+}
+
+// projectIgnore matches the files that never make a build dirty: those in
+// the repository's .gitignore files, .pbuildignore at the repository and
+// module root and pbuild's own output directory. Paths are relative to gitRoot.
+func projectIgnore(workDir, gitRoot string) *ignore.Matcher {
+	m, err := ignore.Load(gitRoot)
+	if err != nil {
+		m = &ignore.Matcher{}
+	}
+	if rel, err := filepath.Rel(gitRoot, workDir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		_ = m.AddFile(filepath.Join(workDir, ignore.FileName), rel)
+	}
+	if rel, err := filepath.Rel(gitRoot, outputDir(workDir)); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		m.Add("/"+filepath.ToSlash(rel)+"/", "")
+	}
+	return m
+}
+
+// loadConfig loads the config like config.Load and masks its secrets in
+// everything pbuild prints or writes from then on
+func loadConfig(dir, path string) (*config.Config, error) {
+	cfg, err := config.Load(dir, path)
+	if err != nil {
+		return nil, err
+	}
+	if redactor, err = redact.New(cfg.Redact.Patterns, cfg.Redact.Env); err != nil {
+		return nil, err
+	}
+	gobuild.Redact = redactor.String
+	for _, secret := range cfg.Secrets() {
+		redactor.Add(secret)
+	}
+	for _, w := range cfg.Warnings() {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	return cfg, nil
+}
+
+// newBuildConfig assembles the go build configuration from the flags and pbuild.yaml
+func newBuildConfig(p *project) gobuild.BuildConfig {
+	// Tags from pbuild.yaml come first so --tags can remove or extend them
+	tags := strings.Join(p.settings.Tags, ",")
+
+	buildMode := getBuildMode(flagBuildMode)
+	config := gobuild.BuildConfig{
+		Strategy:    getBuildStrategy(flagStrategy, buildMode),
+		AMD64Level:  flagAMD64Level,
+		ARM64Level:  flagARM64Level,
+		ARMLevel:    flagARMLevel,
+		MIPSLevel:   flagMIPSLevel,
+		MIPS64Level: flagMIPS64Level,
+		X86Level:    flagX86Level,
+		PPC64Level:  flagPPC64Level,
+		RISCVLevel:  flagRISCVLevel,
+		BuildMode:   buildMode,
+		Tags:        tags,
+		LDFlags:     flagLDFlags,
+		GCFlags:     flagGCFlags,
+		ASMFlags:    flagASMFlags,
+		Cover:       flagCover,
+		BuildFlags:  flagBuildFlags,
+		Verbose:     flagVerbose,
+		CleanCache:  flagCleanCache,
+		Procs:       buildProcs(),
+		GoCacheRoot: goCacheRoot(),
+		Sandbox:     p.sandbox,
+	}
+	if replaying != nil {
+		config.Env = plannedEnv(replaying)
+	}
+
+	// Set default ldflags if not provided
+	if config.LDFlags == "" {
+		config.LDFlags = stripLDFlags() + " -X " + p.versionVar + "=" + p.version
+		if p.versionPkg != "" {
+			commit, _ := gitmeta.ResolveHEAD(p.gitRoot)
+			date := time.Now().UTC().Format(time.RFC3339)
+			config.LDFlags += " " + versionpkg.LDFlags(p.versionPkg, p.version, commit, date)
+		}
+	} else if flagStrip || flagNoStrip || flagDWARF {
+		// The linker takes the last -s/-w, so these win over the custom ldflags
+		config.LDFlags += " " + stripLDFlags()
+	}
+	// Source paths in the binaries would defeat --metadata-minimal
+	if flagMetadataMinimal && !config.Trimpath() {
+		config.BuildFlags = "-trimpath " + config.BuildFlags
+	}
+	return config
+}
+
+// stripLDFlags returns the -s/-w linker flags of --strip, --no-strip and
+// --dwarf; -s drops DWARF as well unless -w=0 follows
+func stripLDFlags() string {
+	switch {
+	case flagNoStrip:
+		return "-s=0 -w=0"
+	case flagDWARF:
+		return "-s -w=0"
+	default:
+		return "-s -w"
+	}
+}
+
+// debugInfoRows is the build table row of --no-strip and --dwarf, when set
+func debugInfoRows() [][]any {
+	switch {
+	case flagNoStrip:
+		return [][]any{{"Debug Info", "symbols, DWARF"}}
+	case flagDWARF:
+		return [][]any{{"Debug Info", "DWARF"}}
+	}
+	return nil
+}
+
+// workerCount is the number of parallel builds: --parallel, every CPU with
+// auto, capped by --cpu-limit
+func workerCount() int {
+	n, _ := parallelFlag()
+	n = max(n, 1) // 0 = sequential
+	if flagCPULimit > 0 {
+		n = min(n, flagCPULimit)
+	}
+	return n
+}
+
+// parallelFlag parses --parallel: a number, or auto to leave it to the memory gate
+func parallelFlag() (int, bool) {
+	if flagParallel == "auto" {
+		return runtime.NumCPU(), true
+	}
+	n, _ := strconv.Atoi(flagParallel) // checked by validateSettings
+	return n, false
+}
+
+// buildProcs shares --cpu-limit among the parallel builds; 0 without a limit
+func buildProcs() int {
+	if flagCPULimit <= 0 {
+		return 0
+	}
+	return max(flagCPULimit/workerCount(), 1)
+}
+
+// resourceRows are the behavior table rows of --cpu-limit and --low-priority, when set
+func resourceRows() [][]any {
+	var rows [][]any
+	if flagCPULimit > 0 {
+		rows = append(rows, []any{"CPU Limit", fmt.Sprintf("%d (%d per build)", flagCPULimit, buildProcs())})
+	}
+	if flagLowPriority {
+		rows = append(rows, []any{"Low Priority", "true"})
+	}
+	return rows
+}
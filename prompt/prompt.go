@@ -0,0 +1,106 @@
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Prompter asks questions one line at a time. Once the input ends, every
+// question takes its default, so a wizard can also be driven by a pipe.
+type Prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	eof bool
+}
+
+// New returns a prompter reading answers from in and writing questions to out
+func New(in io.Reader, out io.Writer) *Prompter {
+	return &Prompter{in: bufio.NewReader(in), out: out}
+}
+
+// line reads the next answer; ok is false at the end of the input
+func (p *Prompter) line() (answer string, ok bool) {
+	if p.eof {
+		fmt.Fprintln(p.out)
+		return "", false
+	}
+	s, err := p.in.ReadString('\n')
+	if err != nil {
+		p.eof = true
+		if s == "" {
+			fmt.Fprintln(p.out)
+			return "", false
+		}
+	}
+	return strings.TrimSpace(s), true
+}
+
+// Ask returns the answer to question, or def for an empty one
+func (p *Prompter) Ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	if answer, _ := p.line(); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// Validate asks until check accepts the answer; once the input has ended it
+// returns whatever Ask did
+func (p *Prompter) Validate(question, def string, check func(string) error) string {
+	for {
+		answer := p.Ask(question, def)
+		err := check(answer)
+		if err == nil || p.eof {
+			return answer
+		}
+		fmt.Fprintf(p.out, "  %v\n", err)
+	}
+}
+
+// Confirm asks a yes/no question
+func (p *Prompter) Confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, hint)
+		answer, _ := p.line()
+		switch strings.ToLower(answer) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Fprintln(p.out, "  Please answer y or n")
+	}
+}
+
+// Choose lists the options numbered from 1 and returns the index of the one
+// picked by number; def is the index taken for an empty answer
+func (p *Prompter) Choose(question string, options []string, def int) int {
+	fmt.Fprintln(p.out, question)
+	for i, o := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, o)
+	}
+	for {
+		fmt.Fprintf(p.out, "Choice [%d]: ", def+1)
+		answer, _ := p.line()
+		if answer == "" {
+			return def
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return n - 1
+		}
+		fmt.Fprintf(p.out, "  Please enter a number from 1 to %d\n", len(options))
+	}
+}
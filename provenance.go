@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"pbuild/buildmeta"
+	"pbuild/gobuild"
+	"pbuild/targets"
+	"pbuild/xattr"
+)
+
+// artifactMeta collects the provenance of one built artifact
+func artifactMeta(p *project, t targets.Target, result buildmeta.TargetResult, config gobuild.BuildConfig) buildmeta.ArtifactMeta {
+	var hostname string
+	if !flagOmitHost {
+		hostname, _ = os.Hostname()
+	}
+	tags, _ := gobuild.ResolveTags(config.Strategy, config.Tags, t)
+	return buildmeta.ArtifactMeta{
+		ProjectName: p.name,
+		Version:     p.version,
+		Target:      t.String(),
+		File:        result.File,
+		Size:        result.Size,
+		SHA256:      result.SHA256,
+		SHA512:      result.SHA512,
+		BuildTime:   time.Now(),
+		GoVersion:   runtime.Version(),
+		BuildHost:   hostname,
+		Tags:        tags,
+		BuildConfig: config,
+	}
+}
+
+// writeProvenance attaches meta to the artifact as a sidecar file and/or extended attributes
+func writeProvenance(artifactPath string, meta buildmeta.ArtifactMeta, sidecar, xattrs bool) error {
+	if sidecar {
+		if err := buildmeta.WriteSidecar(artifactPath, meta); err != nil {
+			return err
+		}
+	}
+	if !xattrs {
+		return nil
+	}
+	attrs := map[string]string{
+		"project":    meta.ProjectName,
+		"version":    meta.Version,
+		"target":     meta.Target,
+		"go_version": meta.GoVersion,
+		"build_time": meta.BuildTime.UTC().Format(time.RFC3339),
+	}
+	if meta.SHA256 != "" {
+		attrs["sha256"] = meta.SHA256
+	}
+	if sidecar {
+		attrs["sidecar"] = filepath.Base(artifactPath) + buildmeta.SidecarSuffix
+	}
+	return xattr.SetAll(artifactPath, attrs)
+}
@@ -0,0 +1,181 @@
+package publish
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+
+	"pbuild/config"
+)
+
+const (
+	// azureScope is the token audience of Azure Storage
+	azureScope = "https://storage.azure.com/.default"
+	// azureAPIVersion supports bearer auth and single-request blobs up to 5000 MiB
+	azureAPIVersion = "2021-08-06"
+)
+
+// azureBackend uploads block blobs. A SAS token from AZURE_STORAGE_SAS_TOKEN is
+// used when set; otherwise DefaultAzureCredential walks the SDK chain:
+// environment service principal, workload identity, managed identity, Azure CLI.
+type azureBackend struct {
+	base  string // https://<account>.blob.core.windows.net/<container>
+	sas   string
+	creds azcore.TokenCredential
+}
+
+func newAzure(c config.Publish) (*azureBackend, error) {
+	account, container := c.Account, c.Container
+	if container == "" || (account == "" && c.URL == "") {
+		return nil, errors.New("azure: account (or url) and container are required")
+	}
+	endpoint := fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	// url overrides the endpoint, e.g. for sovereign clouds or Azurite
+	if c.URL != "" {
+		endpoint = strings.TrimSuffix(c.URL, "/")
+	}
+	a := &azureBackend{
+		base: endpoint + "/" + url.PathEscape(container),
+		sas:  strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"),
+	}
+	if a.sas == "" {
+		creds, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("azure: no credentials found: %v", err)
+		}
+		a.creds = creds
+	}
+	return a, nil
+}
+
+func (a *azureBackend) Name() string { return "azure" }
+
+// URL returns the blob URL of a remote path, without the SAS token
+func (a *azureBackend) URL(remote string) string {
+	return a.base + "/" + (&url.URL{Path: remote}).EscapedPath()
+}
+
+// request builds a blob request authorized by the SAS token or an access token
+func (a *azureBackend) request(ctx context.Context, method, remote string, body io.Reader) (*http.Request, error) {
+	target := a.URL(remote)
+	if a.sas != "" {
+		target += "?" + a.sas
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	if a.creds != nil {
+		tok, err := a.creds.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureScope}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get an access token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tok.Token)
+	}
+	return req, nil
+}
+
+func (a *azureBackend) Upload(ctx context.Context, localPath, remote string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := a.request(ctx, http.MethodPut, remote, f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return "", err
+	}
+	return a.URL(remote), nil
+}
+
+func (a *azureBackend) Delete(ctx context.Context, remote string) error {
+	req, err := a.request(ctx, http.MethodDelete, remote, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if missing(resp) {
+		return nil
+	}
+	return checkStatus(resp)
+}
+
+// Stat reads the Content-MD5 Azure records for blobs uploaded in one request
+// and otherwise downloads the blob to hash it
+func (a *azureBackend) Stat(ctx context.Context, remote string) (*Remote, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	req, err := a.request(ctx, http.MethodHead, remote, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if missing(resp) {
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	if header := resp.Header.Get("Content-MD5"); header != "" {
+		if sum, err := base64.StdEncoding.DecodeString(header); err == nil {
+			return &Remote{MD5: hex.EncodeToString(sum)}, nil
+		}
+	}
+
+	if req, err = a.request(ctx, http.MethodGet, remote, nil); err != nil {
+		return nil, err
+	}
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	sum, err := hashBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{SHA256: sum}, nil
+}
@@ -0,0 +1,177 @@
+package publish
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"pbuild/config"
+)
+
+// gcsScope is the OAuth scope needed to write objects
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsBackend uploads objects with the Cloud Storage JSON API. Credentials come
+// from Application Default Credentials: GOOGLE_APPLICATION_CREDENTIALS, the
+// gcloud ADC file, or the GCE/GKE/Cloud Run metadata server.
+type gcsBackend struct {
+	bucket   string
+	endpoint string
+	tokens   oauth2.TokenSource // nil against an emulator
+}
+
+func newGCS(c config.Publish) (*gcsBackend, error) {
+	bucket := c.Bucket
+	if bucket == "" {
+		return nil, errors.New("gcs: bucket is required")
+	}
+	g := &gcsBackend{bucket: bucket, endpoint: "https://storage.googleapis.com"}
+	// STORAGE_EMULATOR_HOST is honored like the Google SDKs do, without auth
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		g.endpoint = strings.TrimSuffix(host, "/")
+		return g, nil
+	}
+	ts, err := google.DefaultTokenSource(context.Background(), gcsScope)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: no Google credentials found: %v", err)
+	}
+	g.tokens = ts
+	return g, nil
+}
+
+func (g *gcsBackend) Name() string { return "gcs" }
+
+// URL returns the public-style URL of an object
+func (g *gcsBackend) URL(object string) string {
+	return g.endpoint + "/" + g.bucket + "/" + (&url.URL{Path: object}).EscapedPath()
+}
+
+func (g *gcsBackend) Upload(ctx context.Context, localPath, remote string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{"uploadType": {"media"}, "name": {remote}}
+	target := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", g.endpoint, url.PathEscape(g.bucket), q.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if err := g.authorize(req); err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return "", err
+	}
+	return g.URL(remote), nil
+}
+
+// authorize adds the access token; requests to an emulator go without one
+func (g *gcsBackend) authorize(req *http.Request) error {
+	if g.tokens == nil {
+		return nil
+	}
+	tok, err := g.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("failed to get an access token: %v", err)
+	}
+	tok.SetAuthHeader(req)
+	return nil
+}
+
+// Stat reads the MD5 Cloud Storage records for every object uploaded in one request
+func (g *gcsBackend) Stat(ctx context.Context, remote string) (*Remote, error) {
+	target := fmt.Sprintf("%s/storage/v1/b/%s/o/%s", g.endpoint, url.PathEscape(g.bucket), url.PathEscape(remote))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if missing(resp) {
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	var object struct {
+		MD5Hash string `json:"md5Hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return nil, fmt.Errorf("failed to parse object metadata: %v", err)
+	}
+	sum, err := base64.StdEncoding.DecodeString(object.MD5Hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid md5Hash %q: %v", object.MD5Hash, err)
+	}
+	return &Remote{MD5: hex.EncodeToString(sum)}, nil
+}
+
+func (g *gcsBackend) Delete(ctx context.Context, remote string) error {
+	target := fmt.Sprintf("%s/storage/v1/b/%s/o/%s", g.endpoint, url.PathEscape(g.bucket), url.PathEscape(remote))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, target, nil)
+	if err != nil {
+		return err
+	}
+	if err := g.authorize(req); err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if missing(resp) {
+		return nil
+	}
+	return checkStatus(resp)
+}
+
+// checkStatus turns a non-2xx response into an error carrying the start of its body
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if s := strings.TrimSpace(string(msg)); s != "" {
+		return fmt.Errorf("unexpected response status %s: %s", resp.Status, s)
+	}
+	return fmt.Errorf("unexpected response status %s", resp.Status)
+}
@@ -0,0 +1,240 @@
+package publish
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"pbuild/config"
+)
+
+// uploadTimeout bounds a single PUT; release binaries are large, so this is generous
+const uploadTimeout = 10 * time.Minute
+
+// httpBackend PUTs files below a base URL, which covers Artifactory, Nexus raw
+// repositories and WebDAV servers
+type httpBackend struct {
+	base     *url.URL
+	username string
+	password string
+	token    string
+	headers  map[string]string
+	mkcol    bool
+}
+
+func newHTTP(c config.Publish) (*httpBackend, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid publish url %q (expected http(s)://host/path)", c.URL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	h := &httpBackend{
+		base:     u,
+		username: c.Username,
+		password: c.Password,
+		token:    c.Token,
+		headers:  c.Headers,
+		mkcol:    c.MkCol,
+	}
+	if h.token != "" && h.username != "" {
+		return nil, errors.New("publish: set either username/password or token, not both")
+	}
+	return h, nil
+}
+
+func (h *httpBackend) Name() string { return "http" }
+
+// URL joins the base URL and a slash-separated remote path
+func (h *httpBackend) URL(remote string) string {
+	u := *h.base
+	u.Path += "/" + remote
+	return u.String()
+}
+
+func (h *httpBackend) do(ctx context.Context, method, target string, body io.Reader, size int64, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	switch {
+	case h.token != "":
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	case h.username != "":
+		req.SetBasicAuth(h.username, h.password)
+	}
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// makeCollections creates the parent directories of remote on WebDAV servers,
+// which unlike Artifactory do not create them on PUT
+func (h *httpBackend) makeCollections(ctx context.Context, remote string) error {
+	parts := strings.Split(remote, "/")
+	for i := 1; i < len(parts); i++ {
+		resp, err := h.do(ctx, "MKCOL", h.URL(strings.Join(parts[:i], "/"))+"/", nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// 405 means the collection already exists
+		if resp.StatusCode != http.StatusMethodNotAllowed && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+			return fmt.Errorf("MKCOL %s: unexpected response status %s", strings.Join(parts[:i], "/"), resp.Status)
+		}
+	}
+	return nil
+}
+
+// fileDigests returns the hex SHA-1 and SHA-256 of a file; Artifactory
+// verifies uploads against the checksum headers
+func fileDigests(localPath string) (string, string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	s1, s256 := sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(s1, s256), f); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(s1.Sum(nil)), hex.EncodeToString(s256.Sum(nil)), nil
+}
+
+// localDigests returns the hex SHA-256 and MD5 of a file, the digests
+// backends report for stored files
+func localDigests(localPath string) (string, string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	s256, m5 := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(s256, m5), f); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(s256.Sum(nil)), hex.EncodeToString(m5.Sum(nil)), nil
+}
+
+// hashBody returns the hex SHA-256 of a response body
+func hashBody(resp *http.Response) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// missing reports whether a response says the file does not exist
+func missing(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone
+}
+
+// Stat takes the digest from Artifactory's X-Checksum-Sha256 header and
+// otherwise downloads the file to hash it
+func (h *httpBackend) Stat(ctx context.Context, remote string) (*Remote, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	target := h.URL(remote)
+	resp, err := h.do(ctx, http.MethodHead, target, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if missing(resp) {
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	if sum := resp.Header.Get("X-Checksum-Sha256"); sum != "" {
+		return &Remote{SHA256: sum}, nil
+	}
+
+	resp, err = h.do(ctx, http.MethodGet, target, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if missing(resp) {
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	sum, err := hashBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{SHA256: sum}, nil
+}
+
+func (h *httpBackend) Delete(ctx context.Context, remote string) error {
+	resp, err := h.do(ctx, http.MethodDelete, h.URL(remote), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if missing(resp) {
+		return nil
+	}
+	return checkStatus(resp)
+}
+
+func (h *httpBackend) Upload(ctx context.Context, localPath, remote string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	if h.mkcol {
+		if err := h.makeCollections(ctx, remote); err != nil {
+			return "", err
+		}
+	}
+	sha1Sum, sha256Sum, err := fileDigests(localPath)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	target := h.URL(remote)
+	resp, err := h.do(ctx, http.MethodPut, target, f, fi.Size(), map[string]string{
+		"X-Checksum-Sha1":   sha1Sum,
+		"X-Checksum-Sha256": sha256Sum,
+		// Lets the server reject bad credentials before the body is sent
+		"Expect": "100-continue",
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return "", err
+	}
+	return target, nil
+}
@@ -0,0 +1,178 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"pbuild/config"
+)
+
+// DefaultPath places every file under <project>/<version>/ at the destination,
+// below <channel>/ for prerelease channels
+const DefaultPath = "{{.ChannelDir}}{{.Project}}/{{.Version}}/{{.File}}"
+
+// Backend stores one local file at a remote path and returns its URL
+type Backend interface {
+	Name() string
+	Upload(ctx context.Context, localPath, remotePath string) (string, error)
+	// URL is where Upload puts remotePath
+	URL(remotePath string) string
+	// Stat returns the digest of the file at remotePath, or nil when there is none
+	Stat(ctx context.Context, remotePath string) (*Remote, error)
+	// Delete removes the file at remotePath; a missing file is not an error
+	Delete(ctx context.Context, remotePath string) error
+}
+
+// Remote is the digest a backend reports for a stored file. Backends that
+// record neither digest download the file to hash it.
+type Remote struct {
+	SHA256 string // hex
+	MD5    string // hex
+}
+
+// PathData is the template context of a destination's path
+type PathData struct {
+	Project string
+	Version string
+	// Channel is the release channel; ChannelDir is "" for stable and "<channel>/" otherwise
+	Channel    string
+	ChannelDir string
+	Prerelease bool
+	Target     string // empty for run-level files such as build-metadata.json
+	OS         string
+	Arch       string
+	File       string // slash-separated path relative to the version directory
+}
+
+// Destination is a configured backend with its path template
+type Destination struct {
+	Backend
+	path *template.Template
+}
+
+// Expander resolves ${VAR} and ${cred:NAME} references in a setting
+type Expander func(string) (string, error)
+
+// New validates a publish entry from pbuild.yaml. Credentials and URLs may
+// reference environment variables and credentials, which expand resolves.
+func New(c config.Publish, expand Expander) (*Destination, error) {
+	c, err := expandConfig(c, expand)
+	if err != nil {
+		return nil, err
+	}
+	tmplText := c.Path
+	if tmplText == "" {
+		tmplText = DefaultPath
+	}
+	tmpl, err := template.New("publish path").Option("missingkey=error").Parse(tmplText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid publish path template: %v", err)
+	}
+
+	var backend Backend
+	switch strings.ToLower(c.Type) {
+	case "http", "artifactory", "webdav":
+		backend, err = newHTTP(c)
+	case "gcs":
+		backend, err = newGCS(c)
+	case "azure":
+		backend, err = newAzure(c)
+	default:
+		return nil, fmt.Errorf("unknown publish type %q (expected http, artifactory, webdav, gcs or azure)", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Destination{Backend: backend, path: tmpl}, nil
+}
+
+// expandConfig returns a copy of c with every backend setting expanded
+func expandConfig(c config.Publish, expand Expander) (config.Publish, error) {
+	var err error
+	for _, field := range []*string{&c.URL, &c.Bucket, &c.Account, &c.Container, &c.Username, &c.Password, &c.Token} {
+		if *field, err = expand(*field); err != nil {
+			return c, err
+		}
+	}
+	headers := make(map[string]string, len(c.Headers))
+	for k, v := range c.Headers {
+		if headers[k], err = expand(v); err != nil {
+			return c, err
+		}
+	}
+	c.Headers = headers
+	return c, nil
+}
+
+// RemotePath renders the destination's path for a file
+func (d *Destination) RemotePath(data PathData) (string, error) {
+	var b strings.Builder
+	if err := d.path.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render publish path: %v", err)
+	}
+	p := path.Clean("/" + b.String())[1:]
+	if p == "" || strings.HasSuffix(b.String(), "/") {
+		return "", fmt.Errorf("publish path %q for %s is not a file path", b.String(), data.File)
+	}
+	return p, nil
+}
+
+// FileURL returns the remote URL of a file without uploading it
+func (d *Destination) FileURL(data PathData) (string, error) {
+	remote, err := d.RemotePath(data)
+	if err != nil {
+		return "", err
+	}
+	return d.Backend.URL(remote), nil
+}
+
+// Publish uploads the file and returns its remote URL
+func (d *Destination) Publish(ctx context.Context, localPath string, data PathData) (string, error) {
+	remote, err := d.RemotePath(data)
+	if err != nil {
+		return "", err
+	}
+	url, err := d.Upload(ctx, localPath, remote)
+	if err != nil {
+		return "", fmt.Errorf("%s upload of %s failed: %v", d.Name(), data.File, err)
+	}
+	return url, nil
+}
+
+// Uploaded reports whether the destination already holds the file with the
+// same digest, and its URL; pbuild publish --resume skips such files
+func (d *Destination) Uploaded(ctx context.Context, localPath string, data PathData) (string, bool, error) {
+	remote, err := d.RemotePath(data)
+	if err != nil {
+		return "", false, err
+	}
+	r, err := d.Stat(ctx, remote)
+	if err != nil {
+		return "", false, fmt.Errorf("%s lookup of %s failed: %v", d.Name(), data.File, err)
+	}
+	if r == nil {
+		return "", false, nil
+	}
+	sha256Sum, md5Sum, err := localDigests(localPath)
+	if err != nil {
+		return "", false, err
+	}
+	same := (r.SHA256 != "" && strings.EqualFold(r.SHA256, sha256Sum)) ||
+		(r.SHA256 == "" && r.MD5 != "" && strings.EqualFold(r.MD5, md5Sum))
+	return d.URL(remote), same, nil
+}
+
+// Remove deletes the file from the destination and returns the URL it had
+func (d *Destination) Remove(ctx context.Context, data PathData) (string, error) {
+	remote, err := d.RemotePath(data)
+	if err != nil {
+		return "", err
+	}
+	if err := d.Delete(ctx, remote); err != nil {
+		return "", fmt.Errorf("%s delete of %s failed: %v", d.Name(), data.File, err)
+	}
+	return d.URL(remote), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/buildmeta"
+	"pbuild/channel"
+	"pbuild/publish"
+	"pbuild/targets"
+)
+
+var flagPublishResume bool
+
+// newPublishCmd returns the `pbuild publish` subcommand
+func newPublishCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "publish VERSION_DIR",
+		Short: "Upload a finished build to the publish: destinations",
+		Long: "Uploads the files of a version directory to the publish: destinations in\n" +
+			"pbuild.yaml of the module in the current directory, e.g. after --publish failed\n" +
+			"midway. With --resume, files the destination already holds with the same\n" +
+			"digest are skipped. Targets that failed only in the upload count as built once\n" +
+			"their files are up, and " + buildmeta.FileName + " is rewritten to say so.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPublish(cmd.Context(), versionDirOf(args[0]))
+		},
+	}
+	cmd.Flags().BoolVar(&flagPublishResume, "resume", false, "skip files already uploaded with the same digest")
+	return cmd
+}
+
+// publishCounts tallies the files of a pbuild publish run
+type publishCounts struct {
+	uploaded, skipped int
+}
+
+// runPublish uploads the release in versionDir
+func runPublish(ctx context.Context, versionDir string) error {
+	meta, err := buildmeta.Read(versionDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", versionDir, err)
+	}
+	p, err := resolveProject(".")
+	if err != nil {
+		return err
+	}
+	// Paths name the release that was built, not the current checkout
+	p.name, p.version = meta.ProjectName, meta.Version
+	if meta.Channel != "" {
+		p.channel = meta.Channel
+	}
+	flagPublish = true
+	dests, err := newDestinations(p)
+	if err != nil {
+		return err
+	}
+
+	var n publishCounts
+	for i := range meta.Results {
+		r := &meta.Results[i]
+		if !r.Success && r.ErrorKind != publishFailed {
+			continue
+		}
+		target, _ := targets.Parse(r.Target)
+		var urls []string
+		for _, d := range dests {
+			for _, name := range targetFiles(r.File, *r) {
+				local := filepath.Join(versionDir, name)
+				if _, err := os.Stat(local); err != nil {
+					continue
+				}
+				data := pathData(p, name)
+				data.Target, data.OS, data.Arch = r.Target, target.OS, target.Arch
+				url, err := publishFile(ctx, d, local, data, &n)
+				if err != nil {
+					return fmt.Errorf("%v (%d uploaded, %d already there; rerun with --resume)", err, n.uploaded, n.skipped)
+				}
+				urls = append(urls, url)
+			}
+		}
+		r.Published = urls
+		if !r.Success {
+			r.Success, r.Error, r.ErrorKind, r.Hint = true, "", "", ""
+			meta.SuccessCount++
+			meta.FailCount--
+			meta.Artifacts = append(meta.Artifacts, r.File)
+			fmt.Printf("%s: upload completed\n", r.Target)
+		}
+	}
+	if err := buildmeta.Write(versionDir, *meta); err != nil {
+		return fmt.Errorf("failed to write %s: %v", buildmeta.FileName, err)
+	}
+
+	for _, d := range dests {
+		for _, name := range runFiles(versionDir) {
+			if _, err := publishFile(ctx, d, filepath.Join(versionDir, filepath.FromSlash(name)), pathData(p, name), &n); err != nil {
+				return fmt.Errorf("%v (%d uploaded, %d already there; rerun with --resume)", err, n.uploaded, n.skipped)
+			}
+		}
+	}
+
+	// The run is complete now, so it becomes the channel's update like it
+	// would have in the original run, unless a newer build took its place
+	outDir := filepath.Dir(versionDir)
+	if meta.FailCount == 0 && meta.SuccessCount > 0 && !meta.Interrupted && !newerUpdate(outDir, meta) {
+		if err := writeUpdateManifest(p, outDir, *meta); err != nil {
+			fmt.Printf("Warning: Failed to write %s: %v\n", channel.ManifestName, err)
+		} else if err := publishUpdateManifest(ctx, p, dests, outDir); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Published %s %s: %d files uploaded, %d already there\n", meta.ProjectName, meta.Version, n.uploaded, n.skipped)
+	if meta.FailCount > 0 {
+		fmt.Printf("%d targets failed to build and were not published\n", meta.FailCount)
+	}
+	return nil
+}
+
+// publishFile uploads one file, or with --resume skips it when the destination
+// already holds the same digest
+func publishFile(ctx context.Context, d *publish.Destination, local string, data publish.PathData, n *publishCounts) (string, error) {
+	if flagPublishResume {
+		url, done, err := d.Uploaded(ctx, local, data)
+		if err != nil {
+			return "", err
+		}
+		if done {
+			n.skipped++
+			if flagVerbose {
+				fmt.Printf("Already published %s\n", url)
+			}
+			return url, nil
+		}
+	}
+	url, err := d.Publish(ctx, local, data)
+	if err != nil {
+		return "", err
+	}
+	n.uploaded++
+	if flagVerbose {
+		fmt.Printf("Published %s\n", url)
+	}
+	return url, nil
+}
+
+// newerUpdate reports whether the channel's update manifest in outDir already
+// points at a build newer than meta
+func newerUpdate(outDir string, meta *buildmeta.BuildMetadata) bool {
+	m, err := channel.ReadManifest(outDir)
+	return err == nil && m.BuildTime.After(meta.BuildTime)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"pbuild/benchgate"
+	"pbuild/buildmeta"
+	"pbuild/buildplan"
+	"pbuild/channel"
+	"pbuild/config"
+	"pbuild/deps"
+	"pbuild/installer"
+	"pbuild/licenses"
+	"pbuild/linkcheck"
+	"pbuild/pipeline"
+	"pbuild/publish"
+	"pbuild/report"
+	"pbuild/targets"
+	"pbuild/ui"
+)
+
+// newDestinations validates the publish: entries of pbuild.yaml when --publish
+// or --publish-dry-run is set
+func newDestinations(p *project) ([]*publish.Destination, error) {
+	if !flagPublish && !flagPublishDryRun {
+		return nil, nil
+	}
+	if len(p.cfg.Publish) == 0 {
+		return nil, fmt.Errorf("--publish needs at least one destination under publish: in %s", config.FileName)
+	}
+	var dests []*publish.Destination
+	for i, c := range p.cfg.Publish {
+		if len(c.Channels) > 0 && !slices.Contains(c.Channels, p.channel) {
+			continue
+		}
+		d, err := publish.New(c, p.creds.Expand)
+		if err != nil {
+			return nil, fmt.Errorf("publish[%d]: %v", i, err)
+		}
+		dests = append(dests, d)
+	}
+	if len(dests) == 0 {
+		return nil, fmt.Errorf("--publish: no destination under publish: in %s takes the %s channel", config.FileName, p.channel)
+	}
+	return dests, nil
+}
+
+// publishFailed is the ErrorKind of targets whose upload failed; pbuild
+// publish retries them
+const publishFailed = "publish-failed"
+
+// publishStage uploads a target's artifact, checksum, signatures, sidecar and archive
+type publishStage struct {
+	p     *project
+	dests []*publish.Destination
+	plan  *releasePlan // set with --publish-dry-run, which only plans the uploads
+}
+
+func (s *publishStage) Name() string  { return "publish" }
+func (s *publishStage) Enabled() bool { return len(s.dests) > 0 }
+
+func (s *publishStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	files := targetFiles(a.Name, a.Result)
+	for _, d := range s.dests {
+		for _, name := range files {
+			local := filepath.Join(a.Dir, name)
+			if _, err := os.Stat(local); err != nil {
+				continue
+			}
+			data := pathData(s.p, name)
+			data.Target, data.OS, data.Arch = a.Target.String(), a.Target.OS, a.Target.Arch
+			if s.plan != nil {
+				s.plan.add(ctx, d, local, data)
+				continue
+			}
+			url, err := d.Publish(ctx, local, data)
+			if err != nil {
+				a.Result.ErrorKind = publishFailed
+				return err
+			}
+			a.Result.Published = append(a.Result.Published, url)
+			stageLog(a, "Published %s", url)
+		}
+	}
+	return nil
+}
+
+// pipelineDests are the destinations the publish stage uploads to as each
+// target finishes: all of them with --publish-partial or --publish-dry-run,
+// none otherwise, as publishTargets uploads once every target built
+func pipelineDests(dests []*publish.Destination, plan *releasePlan) []*publish.Destination {
+	if flagPublishPartial || plan != nil {
+		return dests
+	}
+	return nil
+}
+
+// publishTargets uploads the files of every built target after a run without
+// failures; a failed upload fails its target like the publish stage does. It
+// returns the number of targets whose upload failed.
+func publishTargets(ctx context.Context, p *project, dests []*publish.Destination, rows []summaryRow, versionDir string) int {
+	stage := &publishStage{p: p, dests: dests}
+	failed := 0
+	for i := range rows {
+		r := &rows[i]
+		if !r.Success {
+			continue
+		}
+		t, err := targets.Parse(r.Target)
+		if err != nil {
+			continue
+		}
+		a := &pipeline.Artifact{Target: t, Dir: versionDir, Name: r.File, Result: r.TargetResult}
+		if err := stage.Run(ctx, a); err != nil {
+			fmt.Printf("Publishing %s failed: %v\n", r.Target, err)
+			a.Result.Success = false
+			a.Result.Error = err.Error()
+			r.status = ui.Fail()
+			failed++
+		}
+		r.TargetResult = a.Result
+	}
+	return failed
+}
+
+// publishIncomplete reports whether a target of the run failed in its upload
+func publishIncomplete(rows []summaryRow) bool {
+	for _, r := range rows {
+		if r.ErrorKind == publishFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// targetFiles lists what publishing uploads for a target: the artifact, its
+// checksum, sidecar, archive and signatures
+func targetFiles(name string, r buildmeta.TargetResult) []string {
+	files := []string{name, name + ".hash", name + buildmeta.SidecarSuffix}
+	if r.Archive != "" {
+		files = append(files, r.Archive, r.Archive+".hash")
+	}
+	return append(files, r.Signatures...)
+}
+
+// pathData is the path template context of a file of the project's release
+func pathData(p *project, file string) publish.PathData {
+	data := publish.PathData{Project: p.name, Version: p.version, Channel: p.channel, File: file}
+	if dir := channel.Dir(p.channel); dir != "" {
+		data.ChannelDir = dir + "/"
+	}
+	data.Prerelease = channel.Prerelease(p.channel)
+	return data
+}
+
+// runFiles lists the run-level files of a version directory, relative to it
+func runFiles(versionDir string) []string {
+	candidates := []string{buildmeta.FileName, buildplan.FileName, deps.FileName, deps.OutdatedFile, benchgate.ResultsFile, benchgate.ComparisonFile, linkcheck.ReportFile, licenses.NoticesFile, installer.ShellName, installer.PowerShellName}
+	for _, format := range report.Formats {
+		candidates = append(candidates, "build-report."+format)
+	}
+	torrents, _ := filepath.Glob(filepath.Join(versionDir, "*.torrent"))
+	for _, t := range torrents {
+		candidates = append(candidates, filepath.Base(t))
+	}
+	for _, dir := range []string{"completions", "man"} {
+		entries, _ := os.ReadDir(filepath.Join(versionDir, dir))
+		for _, e := range entries {
+			if !e.IsDir() {
+				candidates = append(candidates, dir+"/"+e.Name())
+			}
+		}
+	}
+	var files []string
+	for _, name := range candidates {
+		if _, err := os.Stat(filepath.Join(versionDir, filepath.FromSlash(name))); err == nil {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// publishRunFiles uploads metadata, reports and generated assets once the run is complete
+func publishRunFiles(ctx context.Context, p *project, dests []*publish.Destination, versionDir string) error {
+	for _, d := range dests {
+		for _, name := range runFiles(versionDir) {
+			url, err := d.Publish(ctx, filepath.Join(versionDir, filepath.FromSlash(name)), pathData(p, name))
+			if err != nil {
+				return err
+			}
+			if flagVerbose {
+				fmt.Printf("Published %s\n", url)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"pbuild/fsutil"
+	"pbuild/gitmeta"
+)
+
+// outputRoot replaces the module root as the base of a relative --output-dir;
+// a --ref build writes into the working tree, not its temporary worktree
+var outputRoot string
+
+// checkoutRef creates a clean worktree of --ref for the repository of
+// targetDir. It returns the directory in the worktree that matches targetDir
+// and a func that removes the worktree again.
+func checkoutRef(targetDir string) (string, func(), error) {
+	abs, err := filepath.Abs(targetDir)
+	if err != nil {
+		return "", nil, err
+	}
+	gitRoot, err := fsutil.FindGitRoot(abs)
+	if err != nil {
+		return "", nil, fmt.Errorf("--ref needs a git repository: %v", err)
+	}
+	rel, err := filepath.Rel(gitRoot, abs)
+	if err != nil {
+		return "", nil, err
+	}
+	workDir := abs
+	if modRoot, err := fsutil.FindModuleRoot(abs); err == nil {
+		workDir = modRoot
+	}
+
+	tmp, err := os.MkdirTemp("", "pbuild-ref-")
+	if err != nil {
+		return "", nil, err
+	}
+	commit, err := gitmeta.AddWorktree(context.Background(), gitRoot, flagRef, tmp)
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return "", nil, err
+	}
+	fmt.Printf("Building %s (%s) from a clean worktree in %s\n", flagRef, commit[:min(len(commit), 12)], tmp)
+	outputRoot = workDir
+
+	cleanup := func() {
+		if err := gitmeta.RemoveWorktree(gitRoot, tmp); err != nil {
+			fmt.Printf("Warning: Failed to remove the worktree: %v\n", err)
+		}
+		_ = os.RemoveAll(tmp)
+	}
+	return filepath.Join(tmp, rel), cleanup, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"pbuild/buildmeta"
+	"pbuild/channel"
+	"pbuild/fsutil"
+	"pbuild/publish"
+)
+
+// releasePlan collects what --publish-dry-run would upload; the publish
+// stage and the end of the run fill it instead of uploading
+type releasePlan struct {
+	mu    sync.Mutex
+	dests []*publish.Destination
+	files map[*publish.Destination][]plannedFile
+}
+
+// plannedFile is one upload of the plan
+type plannedFile struct {
+	target string // empty for run-level files
+	file   string
+	url    string
+	size   int64
+	// state is new, changed or unchanged against what the destination holds
+	state string
+	err   error
+}
+
+// newReleasePlan returns the plan of --publish-dry-run, or nil without it
+func newReleasePlan(dests []*publish.Destination) *releasePlan {
+	if !flagPublishDryRun {
+		return nil
+	}
+	return &releasePlan{dests: dests, files: make(map[*publish.Destination][]plannedFile)}
+}
+
+// add looks the file up at the destination without changing anything there
+func (rp *releasePlan) add(ctx context.Context, d *publish.Destination, local string, data publish.PathData) {
+	f := plannedFile{target: data.Target, file: data.File, state: "new"}
+	if fi, err := os.Stat(local); err == nil {
+		f.size = fi.Size()
+	}
+	if url, err := d.FileURL(data); err != nil {
+		f.err = err
+	} else {
+		f.url = url
+	}
+	switch url, same, err := d.Uploaded(ctx, local, data); {
+	case err != nil:
+		f.state, f.err = "unknown", err
+	case same:
+		f.state = "unchanged"
+	case url != "":
+		f.state = "changed"
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.files[d] = append(rp.files[d], f)
+}
+
+// addRunFiles plans the run-level files of versionDir and, for a complete
+// run, the channel's update manifest in outDir
+func (rp *releasePlan) addRunFiles(ctx context.Context, p *project, versionDir, outDir string, updated bool) {
+	for _, d := range rp.dests {
+		for _, name := range runFiles(versionDir) {
+			rp.add(ctx, d, filepath.Join(versionDir, filepath.FromSlash(name)), pathData(p, name))
+		}
+		if updated {
+			data := pathData(p, channel.ManifestName)
+			data.Version = ""
+			rp.add(ctx, d, filepath.Join(outDir, channel.ManifestName), data)
+		}
+	}
+}
+
+// print shows the plan per destination; the release is new when the
+// destination holds no metadata for the version yet
+func (rp *releasePlan) print(p *project, versionDir string) {
+	fmt.Printf("\nRelease plan (--publish-dry-run, nothing was uploaded)\n")
+	uploads := 0
+	for _, d := range rp.dests {
+		// Workers finish targets in any order; run-level files come last
+		files := rp.files[d]
+		slices.SortStableFunc(files, func(a, b plannedFile) int {
+			if (a.target == "") != (b.target == "") {
+				return cmp.Compare(b.target, a.target)
+			}
+			return cmp.Compare(a.target, b.target)
+		})
+		action := "creates"
+		for _, f := range files {
+			if f.target == "" && f.file == buildmeta.FileName && f.state != "new" {
+				action = "updates"
+			}
+		}
+		fmt.Printf("\n%s: %s release %s %s (%s channel)\n", d.Name(), action, p.name, p.version, p.channel)
+
+		width := 0
+		for _, f := range files {
+			width = max(width, len(f.file))
+		}
+		for _, f := range files {
+			target := f.target
+			if target == "" {
+				target = "-"
+			}
+			fmt.Printf("  %-9s  %-14s  %-*s  %10s  %s\n", f.state, target, width, f.file, fsutil.HumanSizeBytes(f.size), f.url)
+			if f.err != nil {
+				fmt.Printf("    %v\n", f.err)
+			}
+			if f.state != "unchanged" {
+				uploads++
+			}
+		}
+		if len(files) == 0 {
+			fmt.Println("  nothing to upload")
+		}
+	}
+	fmt.Printf("\n%d uploads planned. Upload with: pbuild publish --resume %s\n", uploads, versionDir)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"pbuild/buildplan"
+	"pbuild/config"
+	"pbuild/fsutil"
+	"pbuild/gitmeta"
+	"pbuild/redact"
+	"pbuild/targets"
+)
+
+// newReplayCmd returns the `pbuild replay` subcommand
+func newReplayCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "replay PLAN [TARGET_DIR]",
+		Short: "Repeat a run exactly from its buildplan.json",
+		Long: "Reads a buildplan.json (or the version directory holding one) and builds the\n" +
+			"same targets with the same flags, version and pbuild.yaml settings it records,\n" +
+			"regardless of today's defaults and config, and with each target's recorded\n" +
+			"environment. Flags given on the command line override the plan's. A plan\n" +
+			"whose pbuild.yaml settings were redacted cannot be replayed.",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			plan, err := buildplan.Read(args[0])
+			if err != nil {
+				return err
+			}
+			if err := applyPlan(cmd, plan); err != nil {
+				return err
+			}
+			targetDir := targetArg(args[1:])
+			if plan.Commit != "" {
+				if root, err := fsutil.FindGitRoot(targetDir); err == nil {
+					if head, _ := gitmeta.ResolveHEAD(root); head != "" && head != plan.Commit {
+						fmt.Printf("Warning: plan was made at commit %s, HEAD is %s\n", plan.Commit, head)
+					}
+				}
+			}
+			fmt.Printf("Replaying %s %s from %s\n", plan.Project, plan.Version, args[0])
+			replaying = plan
+			return run(targetDir)
+		},
+	}
+}
+
+// applyPlan sets the root command's flags to the plan's values, except those
+// given on the replay command line
+func applyPlan(cmd *cobra.Command, plan *buildplan.Plan) error {
+	lookup := func(name string) *pflag.Flag {
+		if f := cmd.Root().Flags().Lookup(name); f != nil {
+			return f
+		}
+		return cmd.Root().PersistentFlags().Lookup(name)
+	}
+	set := func(name string, values []string) error {
+		if cmd.Flags().Changed(name) {
+			return nil
+		}
+		f := lookup(name)
+		if f == nil {
+			fmt.Printf("Warning: ignoring --%s from the plan, which this pbuild does not know\n", name)
+			return nil
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			return sv.Replace(values)
+		}
+		if len(values) != 1 {
+			return fmt.Errorf("plan has %d values for --%s", len(values), name)
+		}
+		return f.Value.Set(values[0])
+	}
+
+	// A masked secret in the config would be built in as the mask itself
+	if data, err := json.Marshal(plan.Config); err == nil && strings.Contains(string(data), redact.Mask) {
+		return fmt.Errorf("the %s settings in the plan were redacted; a replay cannot build with them", config.FileName)
+	}
+	for name, values := range plan.Flags {
+		if !cmd.Flags().Changed(name) && strings.Contains(strings.Join(values, " "), redact.Mask) {
+			fmt.Printf("Warning: --%s was redacted in the plan; pass it again to build with the real value\n", name)
+		}
+		if err := set(name, values); err != nil {
+			return fmt.Errorf("invalid --%s in plan: %v", name, err)
+		}
+	}
+	// The universal darwin target follows from --darwin-universal
+	var list []string
+	for _, t := range plan.Targets {
+		if !strings.HasSuffix(t.Target, "/"+targets.DarwinUniversal) {
+			list = append(list, t.Target)
+		}
+	}
+	for name, value := range map[string]string{
+		"targets": strings.Join(list, ","), "all": "false", "target-group": "", "set-version": plan.Version,
+	} {
+		if err := set(name, []string{value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"pbuild/buildmeta"
+	"pbuild/fsutil"
+)
+
+// Formats lists the supported report formats
+var Formats = []string{"md", "html"}
+
+// funcs are the helpers available to both report templates
+var funcs = map[string]any{
+	"size": func(b int64) string {
+		if b == 0 {
+			return "n/a"
+		}
+		return fsutil.HumanSizeBytes(b)
+	},
+	"short": func(sum string) string {
+		if len(sum) > 12 {
+			return sum[:12]
+		}
+		return sum
+	},
+	"status": func(r buildmeta.TargetResult) string {
+		switch {
+		case r.Success:
+			return "success"
+		case r.Skipped:
+			return "skipped"
+		}
+		return "failed"
+	},
+	"trim": strings.TrimSpace,
+}
+
+const markdownTemplate = `# {{.ProjectName}} {{.Version}}
+
+Built {{.BuildTime.Format "2006-01-02 15:04:05 MST"}} in {{.BuildDuration}} with {{.GoVersion}} on {{.BuildOS}}/{{.BuildArch}}.
+
+**{{.SuccessCount}}** succeeded, **{{.FailCount}}** failed{{if .SkipCount}}, **{{.SkipCount}}** skipped{{end}}.
+
+## Configuration
+
+| Setting | Value |
+|---------|-------|
+| Strategy | {{.BuildConfig.Strategy}} |
+| Build mode | {{.BuildConfig.BuildMode}} |
+{{- if .BuildConfig.Tags}}
+| Tags | ` + "`{{.BuildConfig.Tags}}`" + ` |
+{{- end}}
+{{- if .BuildConfig.LDFlags}}
+| LDFlags | ` + "`{{.BuildConfig.LDFlags}}`" + ` |
+{{- end}}
+{{- if .BuildConfig.GCFlags}}
+| GC flags | {{range $i, $f := .BuildConfig.GCFlags}}{{if $i}} {{end}}` + "`{{$f}}`" + `{{end}} |
+{{- end}}
+{{- if .BuildConfig.ASMFlags}}
+| ASM flags | {{range $i, $f := .BuildConfig.ASMFlags}}{{if $i}} {{end}}` + "`{{$f}}`" + `{{end}} |
+{{- end}}
+{{- if .BuildConfig.BuildFlags}}
+| Build flags | ` + "`{{.BuildConfig.BuildFlags}}`" + ` |
+{{- end}}
+{{- if .BuildConfig.Cover}}
+| Coverage | instrumented with ` + "`-cover`" + `, counters written to ` + "`$GOCOVERDIR`" + ` |
+{{- end}}
+| GOAMD64 | {{.BuildConfig.AMD64Level}} |
+| GOARM64 | {{.BuildConfig.ARM64Level}} |
+| GOARM | {{.BuildConfig.ARMLevel}} |
+
+## Artifacts
+
+| Target | File | Size | SHA256 | Duration | Status |
+|--------|------|------|--------|----------|--------|
+{{- range .Results}}
+| {{.Target}} | {{if .Success}}[{{.File}}]({{.File}}){{else}}{{.File}}{{end}} | {{size .Size}} | {{if .SHA256}}` + "`{{short .SHA256}}`" + `{{if $.HasChecksums}} ([hash]({{.File}}.hash)){{end}}{{else}}n/a{{end}} | {{.Duration}} | {{status .}} |
+{{- end}}
+{{- if .FailCount}}
+
+## Failures
+{{range .Results}}{{if not (or .Success .Skipped)}}
+### {{.Target}}
+{{if .Hint}}
+Hint: {{.Hint}}
+{{end}}{{if .Log}}
+Full output: [{{.Log}}]({{.Log}})
+{{end}}
+` + "```" + `
+{{trim .Error}}
+` + "```" + `
+{{end}}{{end}}
+{{- end}}
+
+Full metadata: [build-metadata.json](build-metadata.json)
+`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.ProjectName}} {{.Version}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2rem; color: #24292f; }
+table { border-collapse: collapse; margin-bottom: 1.5rem; }
+th, td { border: 1px solid #d0d7de; padding: 0.35rem 0.7rem; text-align: left; }
+th { background: #f6f8fa; }
+code { font-family: ui-monospace, Menlo, Consolas, monospace; font-size: 0.9em; }
+.success { color: #1a7f37; }
+.failed { color: #cf222e; }
+.skipped { color: #6e7781; }
+</style>
+</head>
+<body>
+<h1>{{.ProjectName}} {{.Version}}</h1>
+<p>Built {{.BuildTime.Format "2006-01-02 15:04:05 MST"}} in {{.BuildDuration}} with {{.GoVersion}} on {{.BuildOS}}/{{.BuildArch}}.</p>
+<p><strong class="success">{{.SuccessCount}}</strong> succeeded, <strong class="failed">{{.FailCount}}</strong> failed{{if .SkipCount}}, <strong class="skipped">{{.SkipCount}}</strong> skipped{{end}}.</p>
+
+<h2>Configuration</h2>
+<table>
+<tr><th>Setting</th><th>Value</th></tr>
+<tr><td>Strategy</td><td>{{.BuildConfig.Strategy}}</td></tr>
+<tr><td>Build mode</td><td>{{.BuildConfig.BuildMode}}</td></tr>
+{{- if .BuildConfig.Tags}}
+<tr><td>Tags</td><td><code>{{.BuildConfig.Tags}}</code></td></tr>
+{{- end}}
+{{- if .BuildConfig.LDFlags}}
+<tr><td>LDFlags</td><td><code>{{.BuildConfig.LDFlags}}</code></td></tr>
+{{- end}}
+{{- if .BuildConfig.GCFlags}}
+<tr><td>GC flags</td><td>{{range $i, $f := .BuildConfig.GCFlags}}{{if $i}} {{end}}<code>{{$f}}</code>{{end}}</td></tr>
+{{- end}}
+{{- if .BuildConfig.ASMFlags}}
+<tr><td>ASM flags</td><td>{{range $i, $f := .BuildConfig.ASMFlags}}{{if $i}} {{end}}<code>{{$f}}</code>{{end}}</td></tr>
+{{- end}}
+{{- if .BuildConfig.BuildFlags}}
+<tr><td>Build flags</td><td><code>{{.BuildConfig.BuildFlags}}</code></td></tr>
+{{- end}}
+{{- if .BuildConfig.Cover}}
+<tr><td>Coverage</td><td>instrumented with <code>-cover</code>, counters written to <code>$GOCOVERDIR</code></td></tr>
+{{- end}}
+<tr><td>GOAMD64</td><td>{{.BuildConfig.AMD64Level}}</td></tr>
+<tr><td>GOARM64</td><td>{{.BuildConfig.ARM64Level}}</td></tr>
+<tr><td>GOARM</td><td>{{.BuildConfig.ARMLevel}}</td></tr>
+</table>
+
+<h2>Artifacts</h2>
+<table>
+<tr><th>Target</th><th>File</th><th>Size</th><th>SHA256</th><th>Duration</th><th>Status</th></tr>
+{{- range .Results}}
+<tr>
+<td>{{.Target}}</td>
+<td>{{if .Success}}<a href="{{.File}}">{{.File}}</a>{{else}}{{.File}}{{end}}</td>
+<td>{{size .Size}}</td>
+<td>{{if .SHA256}}<code title="{{.SHA256}}">{{short .SHA256}}</code>{{if $.HasChecksums}} (<a href="{{.File}}.hash">hash</a>){{end}}{{else}}n/a{{end}}</td>
+<td>{{.Duration}}</td>
+<td class="{{status .}}">{{status .}}</td>
+</tr>
+{{- end}}
+</table>
+{{- if .FailCount}}
+
+<h2>Failures</h2>
+{{- range .Results}}{{if not (or .Success .Skipped)}}
+<h3>{{.Target}}</h3>
+{{- if .Hint}}
+<p>Hint: {{.Hint}}</p>
+{{- end}}
+{{- if .Log}}
+<p>Full output: <a href="{{.Log}}">{{.Log}}</a></p>
+{{- end}}
+<pre>{{.Error}}</pre>
+{{- end}}{{end}}
+{{- end}}
+
+<p>Full metadata: <a href="build-metadata.json">build-metadata.json</a></p>
+</body>
+</html>
+`
+
+// reportData extends the metadata with values derived for the templates
+type reportData struct {
+	buildmeta.BuildMetadata
+	HasChecksums bool
+}
+
+// Write renders a report in the given format into versionDir and returns its path
+func Write(versionDir, format string, metadata buildmeta.BuildMetadata) (string, error) {
+	data := reportData{BuildMetadata: metadata}
+	if checksums, ok := metadata.Flags["checksums"].(bool); ok {
+		data.HasChecksums = checksums
+	}
+
+	var render func(io.Writer) error
+	switch format {
+	case "md":
+		tmpl, err := texttemplate.New("report").Funcs(funcs).Parse(markdownTemplate)
+		if err != nil {
+			return "", err
+		}
+		render = func(w io.Writer) error { return tmpl.Execute(w, data) }
+	case "html":
+		tmpl, err := htmltemplate.New("report").Funcs(funcs).Parse(htmlTemplate)
+		if err != nil {
+			return "", err
+		}
+		render = func(w io.Writer) error { return tmpl.Execute(w, data) }
+	default:
+		return "", fmt.Errorf("unsupported report format: %s", format)
+	}
+
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return "", fmt.Errorf("failed to render %s report: %v", format, err)
+	}
+	reportPath := filepath.Join(versionDir, "build-report."+format)
+	return reportPath, fsutil.WriteFileAtomic(reportPath, buf.Bytes(), 0644)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/gobuild"
+	"pbuild/targets"
+)
+
+// newRunCmd returns the `pbuild run` subcommand
+func newRunCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "run [TARGET_DIR] [-- ARGS...]",
+		Short: "Build the host binary with version injection into a temp dir and execute it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pre, progArgs := args, []string(nil)
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				pre, progArgs = args[:dash], args[dash:]
+			}
+			if len(pre) > 1 {
+				return fmt.Errorf("expected at most one TARGET_DIR before --, got %d", len(pre))
+			}
+
+			code, err := runHost(targetArg(pre), progArgs)
+			if err != nil {
+				return err
+			}
+			// Pass the program's exit status through unchanged
+			if code != 0 {
+				os.Exit(code)
+			}
+			return nil
+		},
+	}
+}
+
+// runHost builds the host target into a temp dir, runs it and returns its exit code
+func runHost(targetDir string, progArgs []string) (int, error) {
+	p, err := resolveProject(targetDir)
+	if err != nil {
+		return 0, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "pbuild-run-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	host := targets.Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	binPath := filepath.Join(tmpDir, targets.OutputName(p.name, host))
+	if flagVerbose {
+		fmt.Printf("Building %s %s for %s -> %s\n", p.name, p.version, host, binPath)
+	}
+	if err := gobuild.BuildWithConfig(context.Background(), p.workDir, host, binPath, newBuildConfig(p)); err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(binPath, progArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// The terminal delivers Ctrl-C to the child too; keep pbuild alive so the temp dir is cleaned up
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
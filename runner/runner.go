@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"pbuild/sandbox"
+)
+
+// Cmd is an external command to run, like exec.Cmd without the process
+type Cmd struct {
+	// Name is the program, e.g. go, looked up in PATH
+	Name string
+	Args []string
+	Dir  string
+	// Env is the full environment; nil inherits pbuild's
+	Env []string
+	// Stdin is the standard input; nil reads from the null device
+	Stdin io.Reader
+	// Sandbox restricts the command; nil runs it unrestricted
+	Sandbox *sandbox.Policy
+	// Stdout and Stderr receive the output; nil discards it, and one writer
+	// for both interleaves them as a terminal would
+	Stdout io.Writer
+	Stderr io.Writer
+	// PeakRSS is set by Exec once the command ended: the resident memory in
+	// bytes of its largest process, children included; 0 where the system
+	// does not report it
+	PeakRSS int64
+}
+
+// String is the command line, for messages
+func (c *Cmd) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Runner runs commands. Packages that start tools take one, so they can be
+// exercised without the tools installed and their commands intercepted or
+// recorded.
+type Runner interface {
+	// Run runs cmd to completion; a non-zero exit is an error
+	Run(ctx context.Context, cmd *Cmd) error
+}
+
+// Func adapts a function to a Runner
+type Func func(ctx context.Context, cmd *Cmd) error
+
+func (f Func) Run(ctx context.Context, cmd *Cmd) error { return f(ctx, cmd) }
+
+// Exec runs commands as processes, under their sandbox policy
+type Exec struct{}
+
+func (Exec) Run(ctx context.Context, cmd *Cmd) error {
+	c := sandbox.Command(ctx, cmd.Sandbox, cmd.Name, cmd.Args...)
+	c.Dir, c.Env, c.Stdin, c.Stdout, c.Stderr = cmd.Dir, cmd.Env, cmd.Stdin, cmd.Stdout, cmd.Stderr
+	err := c.Run()
+	if c.ProcessState != nil {
+		cmd.PeakRSS = peakRSS(c.ProcessState)
+	}
+	return err
+}
+
+// Or returns r, or Exec when r is nil
+func Or(r Runner) Runner {
+	if r == nil {
+		return Exec{}
+	}
+	return r
+}
+
+// Output runs cmd with r and returns its standard output; the error of a
+// failed command ends with its standard error
+func Output(ctx context.Context, r Runner, cmd *Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	c := *cmd
+	c.Stdout, c.Stderr = &stdout, &stderr
+	err := Or(r).Run(ctx, &c)
+	cmd.PeakRSS = c.PeakRSS
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.Bytes(), fmt.Errorf("%v: %s", err, msg)
+		}
+		return stdout.Bytes(), err
+	}
+	return stdout.Bytes(), nil
+}
+
+// CombinedOutput runs cmd with r and returns its standard output and error,
+// interleaved
+func CombinedOutput(ctx context.Context, r Runner, cmd *Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	c := *cmd
+	c.Stdout, c.Stderr = &out, &out
+	err := Or(r).Run(ctx, &c)
+	cmd.PeakRSS = c.PeakRSS
+	return out.Bytes(), err
+}
+
+// Recorder passes commands on to Next (Exec when nil) and keeps a copy of
+// each, e.g. to log or replay what a build ran
+type Recorder struct {
+	Next Runner
+
+	mu   sync.Mutex
+	cmds []Cmd
+}
+
+func (r *Recorder) Run(ctx context.Context, cmd *Cmd) error {
+	r.mu.Lock()
+	c := *cmd
+	c.Args = append([]string(nil), cmd.Args...)
+	c.Stdin, c.Stdout, c.Stderr = nil, nil, nil
+	r.cmds = append(r.cmds, c)
+	r.mu.Unlock()
+	return Or(r.Next).Run(ctx, cmd)
+}
+
+// Commands returns the commands run so far, in order
+func (r *Recorder) Commands() []Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Cmd(nil), r.cmds...)
+}
@@ -0,0 +1,14 @@
+package runner
+
+import (
+	"os"
+	"syscall"
+)
+
+// peakRSS is the ru_maxrss of the ended process, which macOS reports in bytes
+func peakRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return ru.Maxrss
+	}
+	return 0
+}
@@ -0,0 +1,14 @@
+package runner
+
+import (
+	"os"
+	"syscall"
+)
+
+// peakRSS is the ru_maxrss of the ended process, which Linux reports in KiB
+func peakRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return int64(ru.Maxrss) * 1024
+	}
+	return 0
+}
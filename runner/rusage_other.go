@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package runner
+
+import "os"
+
+func peakRSS(ps *os.ProcessState) int64 {
+	return 0
+}
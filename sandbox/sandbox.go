@@ -0,0 +1,93 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// helperArg is the first argument of pbuild started as the sandbox helper
+const helperArg = "__sandbox"
+
+// Policy says what a sandboxed command may do. Everything outside Write is
+// read-only for it.
+type Policy struct {
+	// Write lists the directories the command may create, change and remove files in
+	Write []string `json:"write"`
+	// Temp becomes TMPDIR and GOTMPDIR of the command and is writable, so
+	// the shared temporary directory can stay read-only
+	Temp string `json:"temp,omitempty"`
+	// Network allows internet sockets; without it only unix sockets can be opened
+	Network bool `json:"network"`
+}
+
+// With returns a copy of p that may also write to dirs; a nil p stays nil
+func (p *Policy) With(dirs ...string) *Policy {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.Write = append(append([]string{}, p.Write...), dirs...)
+	return &c
+}
+
+// Command returns a command that runs name with args under p: pbuild starts
+// itself as the helper, which restricts its own process and then executes
+// name, so the command and everything it starts inherit the restrictions.
+// A nil p runs the command as is.
+func Command(ctx context.Context, p *Policy, name string, args ...string) *exec.Cmd {
+	if p == nil {
+		return exec.CommandContext(ctx, name, args...)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Err = fmt.Errorf("sandbox: %v", err)
+		return cmd
+	}
+	policy, _ := json.Marshal(p)
+	return exec.CommandContext(ctx, exe, append([]string{helperArg, string(policy), name}, args...)...)
+}
+
+// Enter is called first thing in main. When pbuild runs as the sandbox helper
+// it restricts the process and executes the wrapped command, and never
+// returns; otherwise it does nothing.
+func Enter() {
+	if len(os.Args) < 4 || os.Args[1] != helperArg {
+		return
+	}
+	var p Policy
+	if err := json.Unmarshal([]byte(os.Args[2]), &p); err != nil {
+		fail(fmt.Errorf("invalid policy: %v", err))
+	}
+	path, err := exec.LookPath(os.Args[3])
+	if err != nil {
+		fail(err)
+	}
+	if p.Temp != "" {
+		os.Setenv("TMPDIR", p.Temp)
+		os.Setenv("GOTMPDIR", p.Temp)
+		p.Write = append(p.Write, p.Temp)
+	}
+	for _, dir := range p.Write {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			fail(err)
+		}
+	}
+	// The restrictions apply to this thread, which then becomes the command
+	runtime.LockOSThread()
+	if err := restrict(p); err != nil {
+		fail(err)
+	}
+	fail(syscall.Exec(path, os.Args[3:], os.Environ()))
+}
+
+// fail ends the helper; the output becomes part of the command's output
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "pbuild sandbox: %v\n", err)
+	os.Exit(126)
+}
@@ -0,0 +1,170 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Filesystem access landlock knows, by ABI version
+const (
+	accessFSv1 = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	accessRead = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+)
+
+// auditArch is the seccomp architecture of each GOARCH the network filter
+// supports; the filter reads the socket family from the low half of the
+// first argument, so only little-endian ones are listed
+var auditArch = map[string]uint32{
+	"amd64":   unix.AUDIT_ARCH_X86_64,
+	"arm64":   unix.AUDIT_ARCH_AARCH64,
+	"arm":     unix.AUDIT_ARCH_ARM,
+	"riscv64": unix.AUDIT_ARCH_RISCV64,
+	"loong64": unix.AUDIT_ARCH_LOONGARCH64,
+	"ppc64le": unix.AUDIT_ARCH_PPC64LE,
+}
+
+// Supported reports why commands cannot be sandboxed on this system
+func Supported() error {
+	if _, err := abiVersion(); err != nil {
+		return err
+	}
+	if _, ok := auditArch[runtime.GOARCH]; !ok {
+		return fmt.Errorf("the sandbox's network filter does not support %s", runtime.GOARCH)
+	}
+	return nil
+}
+
+// abiVersion returns the landlock ABI of the running kernel
+func abiVersion() (int, error) {
+	v, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, fmt.Errorf("landlock is not available: %v (needs linux 5.13 with landlock enabled)", errno)
+	}
+	return int(v), nil
+}
+
+// restrict confines the calling thread to p: landlock makes the filesystem
+// read-only outside p.Write, and without p.Network a seccomp filter refuses
+// internet sockets
+func restrict(p Policy) error {
+	abi, err := abiVersion()
+	if err != nil {
+		return err
+	}
+	handled := uint64(accessFSv1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		handled |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	if abi >= 4 && !p.Network {
+		attr.Access_net = unix.LANDLOCK_ACCESS_NET_BIND_TCP | unix.LANDLOCK_ACCESS_NET_CONNECT_TCP
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create the landlock ruleset: %v", errno)
+	}
+	ruleset := int(fd)
+	defer unix.Close(ruleset)
+
+	if err := allow(ruleset, "/", accessRead); err != nil {
+		return err
+	}
+	// /dev/null and the terminal stay writable
+	dev := uint64(accessRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE)
+	dev |= handled & (unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV)
+	if err := allow(ruleset, "/dev", dev); err != nil {
+		return err
+	}
+	for _, dir := range p.Write {
+		if err := allow(ruleset, dir, handled); err != nil {
+			return err
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %v", err)
+	}
+	if !p.Network {
+		if err := denyNetwork(); err != nil {
+			return err
+		}
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce the landlock ruleset: %v", errno)
+	}
+	return nil
+}
+
+// allow grants access below dir; a missing dir needs no rule
+func allow(ruleset int, dir string, access uint64) error {
+	fd, err := unix.Open(dir, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", dir, err)
+	}
+	defer unix.Close(fd)
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to allow %s: %v", dir, errno)
+	}
+	return nil
+}
+
+// denyNetwork installs a seccomp filter that fails socket(2) for AF_INET and
+// AF_INET6 with EACCES, and every syscall of a foreign architecture with EPERM
+func denyNetwork() error {
+	arch, ok := auditArch[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("the network filter does not support %s", runtime.GOARCH)
+	}
+	const (
+		archOffset = 4  // seccomp_data.arch
+		nrOffset   = 0  // seccomp_data.nr
+		arg0Offset = 16 // seccomp_data.args[0], low half on little-endian
+	)
+	load := func(off uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: off}
+	}
+	jeq := func(k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: k, Jt: jt, Jf: jf}
+	}
+	ret := func(k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: k}
+	}
+	filter := []unix.SockFilter{
+		load(archOffset),
+		jeq(arch, 1, 0),
+		ret(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)),
+		load(nrOffset),
+		jeq(uint32(unix.SYS_SOCKET), 0, 3),
+		load(arg0Offset),
+		jeq(unix.AF_INET, 2, 0),
+		jeq(unix.AF_INET6, 1, 0),
+		ret(unix.SECCOMP_RET_ALLOW),
+		ret(unix.SECCOMP_RET_ERRNO | uint32(unix.EACCES)),
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("failed to install the seccomp filter: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Supported reports why commands cannot be sandboxed on this system
+func Supported() error {
+	return fmt.Errorf("the sandbox needs linux (landlock), not %s", runtime.GOOS)
+}
+
+// restrict is not available outside linux
+func restrict(p Policy) error {
+	return Supported()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"pbuild/sandbox"
+)
+
+// sandboxPolicy returns what the go commands of a --sandbox run may do: write
+// to the output directory, the Go build cache and a temporary directory of
+// their own, and reach the network only when the module's dependencies
+// cannot be resolved offline (GOPROXY=off or a vendor directory). Module
+// downloads need the module cache, so it is writable whenever the network is.
+// The caller removes Temp after the run.
+func sandboxPolicy(p *project) (*sandbox.Policy, error) {
+	cmd := exec.Command("go", "env", "-json", "GOCACHE", "GOMODCACHE", "GOTMPDIR", "GOPROXY", "GOFLAGS")
+	cmd.Dir = p.workDir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go env failed: %v", err)
+	}
+	var env map[string]string
+	if err := json.Unmarshal(out, &env); err != nil {
+		return nil, fmt.Errorf("failed to parse go env output: %v", err)
+	}
+
+	_, vendorErr := os.Stat(filepath.Join(p.workDir, "vendor", "modules.txt"))
+	offline := env["GOPROXY"] == "off" || strings.Contains(env["GOFLAGS"], "-mod=vendor") || vendorErr == nil
+
+	policy := &sandbox.Policy{Write: []string{outputDir(p.workDir)}, Network: !offline}
+	if flagStagingDir != "" {
+		policy.Write = append(policy.Write, stagingDir(p.workDir))
+	}
+	if dir := env["GOCACHE"]; dir != "" && dir != "off" {
+		policy.Write = append(policy.Write, dir)
+	}
+	if dir := goCacheRoot(); dir != "" {
+		policy.Write = append(policy.Write, dir)
+	}
+	if policy.Network && env["GOMODCACHE"] != "" {
+		policy.Write = append(policy.Write, env["GOMODCACHE"])
+	}
+	if policy.Temp, err = os.MkdirTemp(env["GOTMPDIR"], "pbuild-sandbox-"); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// printSandbox describes the sandbox of the run
+func printSandbox(policy *sandbox.Policy) {
+	network := "off"
+	if policy.Network {
+		network = "on (set GOPROXY=off or vendor the module to turn it off)"
+	}
+	fmt.Printf("Sandbox: source read-only, writable: %s; network %s\n\n", strings.Join(append(slices.Clone(policy.Write), policy.Temp), ", "), network)
+}
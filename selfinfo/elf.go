@@ -0,0 +1,114 @@
+package selfinfo
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"os"
+)
+
+// IsELF reports whether the file starts with the ELF magic
+func IsELF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := f.Read(magic); err != nil {
+		return false
+	}
+	return string(magic) == elf.ELFMAG
+}
+
+// AddSection appends a non-loaded section holding data to an ELF file. The
+// section name table and header table are rewritten at the end of the file;
+// segments and everything the loader maps stay untouched.
+func AddSection(path, name string, data []byte) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := elf.NewFile(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if f.Section(name) != nil {
+		return fmt.Errorf("%s already has a %s section", path, name)
+	}
+
+	var shoff uint64
+	var shentsize, shnum, shstrndx int
+	bo := f.ByteOrder
+	is64 := f.Class == elf.ELFCLASS64
+	if is64 {
+		shoff = bo.Uint64(raw[0x28:])
+		shentsize, shnum, shstrndx = int(bo.Uint16(raw[0x3a:])), int(bo.Uint16(raw[0x3c:])), int(bo.Uint16(raw[0x3e:]))
+	} else {
+		shoff = uint64(bo.Uint32(raw[0x20:]))
+		shentsize, shnum, shstrndx = int(bo.Uint16(raw[0x2e:])), int(bo.Uint16(raw[0x30:])), int(bo.Uint16(raw[0x32:]))
+	}
+	if shnum == 0 || shnum >= int(elf.SHN_LORESERVE)-1 || shstrndx == int(elf.SHN_UNDEF) || shstrndx >= shnum {
+		return fmt.Errorf("%s: unsupported section header layout", path)
+	}
+	headers := bytes.Clone(raw[shoff : shoff+uint64(shnum*shentsize)])
+	strtab, err := f.Sections[shstrndx].Data()
+	if err != nil {
+		return err
+	}
+
+	out := bytes.NewBuffer(raw)
+	// Section data, then the extended name table, then the 8-byte aligned headers
+	dataOff := uint64(out.Len())
+	out.Write(data)
+	strOff := uint64(out.Len())
+	nameOff := uint64(len(strtab))
+	out.Write(strtab)
+	out.WriteString(name + "\x00")
+	strSize := uint64(out.Len()) - strOff
+	for out.Len()%8 != 0 {
+		out.WriteByte(0)
+	}
+	newShoff := uint64(out.Len())
+
+	// Point the name table's header at the extended copy
+	str := headers[shstrndx*shentsize:]
+	if is64 {
+		bo.PutUint64(str[24:], strOff)
+		bo.PutUint64(str[32:], strSize)
+	} else {
+		bo.PutUint32(str[16:], uint32(strOff))
+		bo.PutUint32(str[20:], uint32(strSize))
+	}
+	out.Write(headers)
+
+	sh := make([]byte, shentsize)
+	bo.PutUint32(sh[0:], uint32(nameOff))
+	bo.PutUint32(sh[4:], uint32(elf.SHT_PROGBITS))
+	if is64 {
+		bo.PutUint64(sh[24:], dataOff)
+		bo.PutUint64(sh[32:], uint64(len(data)))
+		bo.PutUint64(sh[48:], 1)
+	} else {
+		bo.PutUint32(sh[16:], uint32(dataOff))
+		bo.PutUint32(sh[20:], uint32(len(data)))
+		bo.PutUint32(sh[32:], 1)
+	}
+	out.Write(sh)
+
+	b := out.Bytes()
+	if is64 {
+		bo.PutUint64(b[0x28:], newShoff)
+		bo.PutUint16(b[0x3c:], uint16(shnum+1))
+	} else {
+		bo.PutUint32(b[0x20:], uint32(newShoff))
+		bo.PutUint16(b[0x30:], uint16(shnum+1))
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, fi.Mode().Perm())
+}
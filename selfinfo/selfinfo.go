@@ -0,0 +1,93 @@
+package selfinfo
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultVar is the string variable the info is linked into with -X; the
+// program declares it as `var pbuildInfo string` in package main
+const DefaultVar = "main.pbuildInfo"
+
+// Section is the ELF section the info is written to
+const Section = ".pbuild_info"
+
+// marker starts every embedded blob, so it can be found in any binary format
+const marker = `{"pbuild_info":`
+
+// Info describes the build a binary came from
+type Info struct {
+	Schema    int       `json:"pbuild_info"` // always 1; keep first, it is the marker
+	Project   string    `json:"project"`
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	BuildTime time.Time `json:"build_time"`
+	Target    string    `json:"target"`
+	PBuild    string    `json:"pbuild_version"`
+}
+
+// ErrNotFound is returned by Extract for binaries without embedded info
+var ErrNotFound = errors.New("no embedded pbuild info")
+
+// Marshal encodes the info as compact JSON, safe to single-quote in -ldflags
+func Marshal(info Info) []byte {
+	info.Schema = 1
+	data, _ := json.Marshal(info)
+	return bytes.ReplaceAll(data, []byte("'"), []byte(`\u0027`))
+}
+
+// LDFlag returns the linker flag setting variable (importpath.name) to the info
+func LDFlag(variable string, info Info) string {
+	return fmt.Sprintf("-X '%s=%s'", variable, Marshal(info))
+}
+
+// Extract reads the embedded info of a binary: the ELF section when present,
+// else the variable's value found by its marker
+func Extract(path string) (*Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if f, err := elf.NewFile(bytes.NewReader(data)); err == nil {
+		if s := f.Section(Section); s != nil {
+			if b, err := s.Data(); err == nil {
+				return decode(b)
+			}
+		}
+	}
+	// The marker may also occur as a plain string, e.g. in pbuild itself
+	for rest := data; ; {
+		i := bytes.Index(rest, []byte(marker))
+		if i < 0 {
+			return nil, ErrNotFound
+		}
+		if info, err := decode(rest[i:]); err == nil {
+			return info, nil
+		}
+		rest = rest[i+len(marker):]
+	}
+}
+
+// decode parses the JSON object at the start of data, ignoring what follows
+func decode(data []byte) (*Info, error) {
+	var info Info
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&info); err != nil {
+		return nil, fmt.Errorf("corrupt embedded pbuild info: %v", err)
+	}
+	if info.Schema != 1 {
+		return nil, fmt.Errorf("unsupported embedded pbuild info version %d", info.Schema)
+	}
+	return &info, nil
+}
+
+// ValidVar reports whether v looks like an importpath.name for -X
+func ValidVar(v string) bool {
+	i := strings.LastIndex(v, ".")
+	return i > 0 && i < len(v)-1 && !strings.ContainsAny(v, " '\"=")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/cache"
+	"pbuild/fsutil"
+	"pbuild/server"
+)
+
+var (
+	flagListen        string
+	flagToken         string
+	flagWebhookSecret string
+	flagWorkspace     string
+	flagOutputRoot    string
+	flagWebhookArgs   []string
+	flagWebhookRepos  []string
+	flagCloneSSH      bool
+)
+
+// newServeCmd returns the `pbuild serve` subcommand
+func newServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve [TARGET_DIR]",
+		Short: "Run pbuild as a build service with an HTTP API",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serve(targetArg(args))
+		},
+	}
+	cmd.Flags().StringVar(&flagListen, "listen", "127.0.0.1:8080", "address to serve the API on")
+	cmd.Flags().StringVar(&flagToken, "token", "", "bearer token API requests must send (default: $PBUILD_TOKEN; required)")
+	cmd.Flags().StringVar(&flagOutDir, "output-dir", "builds", "directory for build artifacts")
+
+	// Webhook flags
+	cmd.Flags().StringVar(&flagWebhookSecret, "webhook-secret", "", "enable GitHub/GitLab webhooks with this secret (default: $PBUILD_WEBHOOK_SECRET)")
+	cmd.Flags().StringVar(&flagWorkspace, "workspace", "", "directory for repository clones (default: <user cache dir>/pbuild/workspace)")
+	cmd.Flags().StringVar(&flagOutputRoot, "output-root", "", "artifact root for webhook builds, one directory per repository (default: <user cache dir>/pbuild/builds)")
+	cmd.Flags().StringArrayVar(&flagWebhookArgs, "webhook-args", []string{"--all"}, "pbuild flag for tag builds in --flag=value form (repeatable)")
+	cmd.Flags().StringArrayVar(&flagWebhookRepos, "webhook-repo", nil, "only build this owner/name repository (repeatable; default: any with a valid signature)")
+	cmd.Flags().BoolVar(&flagCloneSSH, "clone-ssh", false, "clone webhook repositories over SSH instead of HTTPS")
+	return cmd
+}
+
+// webhookConfig resolves the webhook settings, defaulting directories to the user cache dir
+func webhookConfig() (server.Webhooks, error) {
+	wh := server.Webhooks{
+		Secret:     flagWebhookSecret,
+		Workspace:  flagWorkspace,
+		OutputRoot: flagOutputRoot,
+		Args:       flagWebhookArgs,
+		Repos:      flagWebhookRepos,
+		SSH:        flagCloneSSH,
+	}
+	if wh.Secret == "" {
+		wh.Secret = os.Getenv("PBUILD_WEBHOOK_SECRET")
+	}
+	if wh.Workspace == "" || wh.OutputRoot == "" {
+		dir, err := cache.Dir()
+		if err != nil {
+			return wh, fmt.Errorf("set --workspace and --output-root: %v", err)
+		}
+		if wh.Workspace == "" {
+			wh.Workspace = filepath.Join(dir, cache.Workspace)
+		}
+		if wh.OutputRoot == "" {
+			wh.OutputRoot = filepath.Join(dir, cache.Builds)
+		}
+	}
+	for _, dir := range []*string{&wh.Workspace, &wh.OutputRoot} {
+		expanded, err := fsutil.ExpandHome(*dir)
+		if err != nil {
+			return wh, err
+		}
+		if *dir, err = filepath.Abs(expanded); err != nil {
+			return wh, err
+		}
+	}
+	return wh, nil
+}
+
+// serve runs the build API until interrupted
+func serve(targetDir string) error {
+	p, err := resolveProject(targetDir)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the pbuild binary: %v", err)
+	}
+	token := flagToken
+	if token == "" {
+		token = os.Getenv("PBUILD_TOKEN")
+	}
+	// A build runs the project's generators, cgo and tests, so an open API runs code
+	if token == "" {
+		return errors.New("pbuild serve needs a bearer token: set --token or $PBUILD_TOKEN")
+	}
+
+	srv := server.New(exe, p.workDir, flagOutDir, token)
+	srv.Webhooks, err = webhookConfig()
+	if err != nil {
+		return err
+	}
+	for _, a := range srv.Webhooks.Args {
+		if !strings.HasPrefix(a, "-") {
+			return fmt.Errorf("--webhook-args %q: use --flag=value form", a)
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go srv.Run(ctx)
+
+	httpSrv := &http.Server{Addr: flagListen, Handler: srv.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpSrv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving builds of %s on http://%s\n", p.name, flagListen)
+	if srv.Webhooks.Secret != "" {
+		fmt.Printf("Webhooks: POST /webhooks/github, /webhooks/gitlab -> %s\n", srv.Webhooks.OutputRoot)
+	}
+	if err := httpSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,464 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pbuild/buildmeta"
+	"pbuild/gitmeta"
+)
+
+// Job states
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+)
+
+// maxJobs is how many finished jobs are kept in memory for status queries
+const maxJobs = 100
+
+// ErrQueueFull is returned when too many builds are waiting
+var ErrQueueFull = errors.New("build queue is full")
+
+// AllowedFlags are the pbuild flags an API request may pass. They select what
+// is built, never where it goes, what it runs or what it is configured from.
+var AllowedFlags = []string{
+	"all", "targets", "target-group", "channel", "nightly", "compress",
+	"checksums", "stop-on-error", "parallel", "report", "cache-stats", "verbose",
+}
+
+// valueFlags are the AllowedFlags that take a value, which must be passed as
+// --flag=value: a bare --flag would take the next argument as its value
+var valueFlags = []string{"targets", "target-group", "channel", "compress", "parallel", "report"}
+
+// Job is one pbuild run executed by the server
+type Job struct {
+	ID       int       `json:"id"`
+	Dir      string    `json:"dir"`
+	Args     []string  `json:"args"`
+	Status   string    `json:"status"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	Repo     string    `json:"repo,omitempty"`
+	Tag      string    `json:"tag,omitempty"`
+
+	source  *Source // repository to check out before building, for webhook jobs
+	outDir  string
+	mu      sync.Mutex
+	lines   []string
+	changed chan struct{} // closed and replaced whenever lines or status change
+}
+
+// snapshot returns a copy of the job's public fields that is safe to encode
+func (j *Job) snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return Job{
+		ID: j.ID, Dir: j.Dir, Args: j.Args, Status: j.Status, Created: j.Created,
+		Started: j.Started, Finished: j.Finished, ExitCode: j.ExitCode, Error: j.Error,
+		Repo: j.Repo, Tag: j.Tag,
+	}
+}
+
+// update applies fn under the job lock and wakes log followers
+func (j *Job) update(fn func()) {
+	j.mu.Lock()
+	fn()
+	close(j.changed)
+	j.changed = make(chan struct{})
+	j.mu.Unlock()
+}
+
+// logsFrom returns the log lines after index n, whether the job is done, and a
+// channel that is closed on the next change
+func (j *Job) logsFrom(n int) ([]string, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var lines []string
+	if n < len(j.lines) {
+		lines = append(lines, j.lines[n:]...)
+	}
+	done := j.Status != StatusQueued && j.Status != StatusRunning
+	return lines, done, j.changed
+}
+
+// Server queues builds and serves their status, logs and artifacts over HTTP
+type Server struct {
+	// Exe is the pbuild binary run for every job
+	Exe string
+	// Dir is the project built when a request does not name one
+	Dir string
+	// OutDir is the output directory, relative to a job's Dir unless absolute
+	OutDir string
+	// Token, when set, must be sent as "Authorization: Bearer <token>"
+	Token string
+	// Webhooks configures builds triggered by git push events
+	Webhooks Webhooks
+
+	mu     sync.Mutex
+	jobs   []*Job
+	nextID int
+	queue  chan *Job
+}
+
+// New returns a server building dir with exe
+func New(exe, dir, outDir, token string) *Server {
+	return &Server{Exe: exe, Dir: dir, OutDir: outDir, Token: token, queue: make(chan *Job, 64)}
+}
+
+// Run executes queued jobs one at a time until ctx is canceled
+func (s *Server) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.queue:
+			s.execute(ctx, job)
+		}
+	}
+}
+
+// Enqueue schedules a pbuild run of dir with args, which must all be
+// AllowedFlags, in --flag=value form for those taking a value, so a request
+// cannot name another directory or change what the build runs
+func (s *Server) Enqueue(dir string, args []string) (*Job, error) {
+	for _, a := range args {
+		name, _, hasValue := strings.Cut(strings.TrimPrefix(a, "--"), "=")
+		if !strings.HasPrefix(a, "--") || !slices.Contains(AllowedFlags, name) {
+			return nil, fmt.Errorf("unexpected argument %q: only --flag=value for %s is accepted", a, strings.Join(AllowedFlags, ", "))
+		}
+		if !hasValue && slices.Contains(valueFlags, name) {
+			return nil, fmt.Errorf("argument %q needs a value: pass it as --%s=value", a, name)
+		}
+	}
+
+	job := &Job{Dir: dir, Args: args, outDir: s.outDir(dir)}
+	if err := s.enqueue(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// enqueue assigns the job an id and queues it
+func (s *Server) enqueue(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	job.ID = s.nextID
+	job.Status = StatusQueued
+	job.Created = time.Now()
+	job.changed = make(chan struct{})
+	select {
+	case s.queue <- job:
+	default:
+		return ErrQueueFull
+	}
+	s.jobs = append(s.jobs, job)
+	if len(s.jobs) > maxJobs {
+		s.jobs = s.jobs[len(s.jobs)-maxJobs:]
+	}
+	return nil
+}
+
+// outDir resolves the output directory for a project directory
+func (s *Server) outDir(dir string) string {
+	if filepath.IsAbs(s.OutDir) {
+		return s.OutDir
+	}
+	return filepath.Join(dir, s.OutDir)
+}
+
+// execute runs one job as a pbuild child process, capturing its output line by line
+func (s *Server) execute(ctx context.Context, job *Job) {
+	job.update(func() {
+		job.Status = StatusRunning
+		job.Started = time.Now()
+	})
+
+	pr, pw := io.Pipe()
+	scanned := make(chan struct{})
+	go func() {
+		defer close(scanned)
+		sc := bufio.NewScanner(pr)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			line := sc.Text()
+			job.update(func() { job.lines = append(job.lines, line) })
+		}
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+
+	var err error
+	if job.source != nil {
+		err = gitmeta.Checkout(ctx, job.source.CloneURL, "refs/tags/"+job.source.Tag, job.Dir, pw)
+	}
+	if err == nil {
+		args := append([]string{"--color", "never", "--output-dir", job.outDir}, job.Args...)
+		args = append(args, "--", job.Dir)
+		cmd := exec.CommandContext(ctx, s.Exe, args...)
+		// Let the child clean up half-written artifacts before it is killed
+		cmd.Cancel = func() error {
+			if err := cmd.Process.Signal(os.Interrupt); err != nil {
+				return cmd.Process.Kill()
+			}
+			return nil
+		}
+		cmd.WaitDelay = 30 * time.Second
+		cmd.Stdout = pw
+		cmd.Stderr = pw
+		err = cmd.Run()
+	}
+	_ = pw.Close()
+	<-scanned
+
+	job.update(func() {
+		job.Finished = time.Now()
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			job.Status = StatusSucceeded
+		case ctx.Err() != nil:
+			job.Status = StatusCanceled
+			job.Error = "server shutting down"
+		case errors.As(err, &exitErr):
+			job.Status = StatusFailed
+			job.ExitCode = exitErr.ExitCode()
+		default:
+			job.Status = StatusFailed
+			job.ExitCode = -1
+			job.Error = err.Error()
+		}
+	})
+}
+
+// job looks up a job by id
+func (s *Server) job(id int) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// Handler returns the HTTP API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("POST /api/builds", s.handleTrigger)
+	mux.HandleFunc("GET /api/builds", s.handleList)
+	mux.HandleFunc("GET /api/builds/{id}", s.handleStatus)
+	mux.HandleFunc("GET /api/builds/{id}/logs", s.handleLogs)
+	mux.HandleFunc("GET /api/artifacts", s.handleArtifacts)
+	mux.Handle("GET /artifacts/", http.StripPrefix("/artifacts/", http.FileServer(http.Dir(s.outDir(s.Dir)))))
+	mux.HandleFunc("POST /webhooks/github", s.handleWebhook(parseGitHub))
+	mux.HandleFunc("POST /webhooks/gitlab", s.handleWebhook(parseGitLab))
+	if s.Webhooks.OutputRoot != "" {
+		mux.Handle("GET /repos/", http.StripPrefix("/repos/", http.FileServer(http.Dir(s.Webhooks.OutputRoot))))
+	}
+	return s.authorize(mux)
+}
+
+// authorize rejects requests without the bearer token; pbuild serve does not
+// start without one
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Webhooks authenticate with their own secret
+		public := r.URL.Path == "/api/health" || strings.HasPrefix(r.URL.Path, "/webhooks/")
+		if !public {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if s.Token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(s.Token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// triggerRequest is the body of POST /api/builds
+type triggerRequest struct {
+	Args []string `json:"args"`
+}
+
+func (s *Server) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	// A form a browser posts across sites can be neither JSON nor from our origin
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("the request body must be application/json"))
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin request from %s", origin))
+			return
+		}
+	}
+	var req triggerRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+	}
+	job, err := s.Enqueue(s.Dir, req.Args)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrQueueFull) {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/builds/%d", job.ID))
+	writeJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for i := len(s.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, s.jobs[i].snapshot())
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// lookup resolves the {id} path value to a job, writing a 404 when it is unknown
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) *Job {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err == nil {
+		if job := s.job(id); job != nil {
+			return job
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("no build %q", r.PathValue("id")))
+	return nil
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if job := s.lookup(w, r); job != nil {
+		writeJSON(w, http.StatusOK, job.snapshot())
+	}
+}
+
+// handleLogs streams a job's output as server-sent events, replaying what was
+// already logged and following until the job finishes
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent := 0
+	for {
+		lines, done, changed := job.logsFrom(sent)
+		for _, line := range lines {
+			fmt.Fprintf(w, "data: %s\n\n", line)
+		}
+		sent += len(lines)
+		if done {
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", job.snapshot().Status)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// release summarizes one version directory for GET /api/artifacts
+type release struct {
+	Version      string    `json:"version"`
+	BuildTime    time.Time `json:"build_time"`
+	SuccessCount int       `json:"success_count"`
+	FailCount    int       `json:"fail_count"`
+	Artifacts    []string  `json:"artifacts"`
+	Yanked       bool      `json:"yanked,omitempty"`
+}
+
+func (s *Server) handleArtifacts(w http.ResponseWriter, r *http.Request) {
+	outDir := s.outDir(s.Dir)
+	if repo := r.URL.Query().Get("repo"); repo != "" {
+		if !validRepoName(repo) || s.Webhooks.OutputRoot == "" {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no builds for repository %q", repo))
+			return
+		}
+		outDir = filepath.Join(s.Webhooks.OutputRoot, filepath.FromSlash(repo))
+	}
+	entries, err := os.ReadDir(outDir)
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	releases := []release{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		m, err := buildmeta.Read(filepath.Join(outDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		releases = append(releases, release{
+			Version:      e.Name(),
+			BuildTime:    m.BuildTime,
+			SuccessCount: m.SuccessCount,
+			FailCount:    m.FailCount,
+			Artifacts:    m.Artifacts,
+			Yanked:       m.Yanked != nil,
+		})
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].BuildTime.After(releases[j].BuildTime) })
+	writeJSON(w, http.StatusOK, releases)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Webhooks configures builds of tags pushed to GitHub or GitLab repositories
+type Webhooks struct {
+	// Secret validates deliveries; webhooks are disabled while it is empty
+	Secret string
+	// Workspace holds one clone per repository
+	Workspace string
+	// OutputRoot receives the artifacts, one directory per repository
+	OutputRoot string
+	// Args are the pbuild flags used for every tag build
+	Args []string
+	// Repos, when set, restricts builds to these owner/name repositories
+	Repos []string
+	// SSH clones over SSH instead of HTTPS
+	SSH bool
+}
+
+// Source is a repository and tag to check out for a webhook build
+type Source struct {
+	Repo     string // owner/name, nested groups allowed
+	CloneURL string
+	Tag      string
+}
+
+// pushEvent is the part of a push delivery pbuild needs, common to both providers
+type pushEvent struct {
+	Ref      string
+	After    string
+	Deleted  bool
+	Repo     string
+	HTTPURL  string
+	SSHURL   string
+	Relevant bool // false for pings and events other than pushes
+}
+
+// errUnauthorized marks deliveries whose secret or signature does not match
+var errUnauthorized = errors.New("invalid webhook secret or signature")
+
+var (
+	repoName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(/[A-Za-z0-9][A-Za-z0-9._-]*)+$`)
+	tagName  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+/-]*$`)
+)
+
+// validRepoName reports whether name is safe to use as a relative path
+func validRepoName(name string) bool {
+	return repoName.MatchString(name) && !strings.Contains(name, "..")
+}
+
+// parseGitHub validates X-Hub-Signature-256 and decodes a GitHub push event
+func parseGitHub(r *http.Request, body []byte, secret string) (*pushEvent, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get("X-Hub-Signature-256")), []byte(want)) {
+		return nil, errUnauthorized
+	}
+	if r.Header.Get("X-GitHub-Event") != "push" {
+		return &pushEvent{}, nil
+	}
+
+	var payload struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Deleted    bool   `json:"deleted"`
+		Repository struct {
+			FullName string `json:"full_name"`
+			CloneURL string `json:"clone_url"`
+			SSHURL   string `json:"ssh_url"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %v", err)
+	}
+	return &pushEvent{
+		Ref: payload.Ref, After: payload.After, Deleted: payload.Deleted,
+		Repo: payload.Repository.FullName, HTTPURL: payload.Repository.CloneURL, SSHURL: payload.Repository.SSHURL,
+		Relevant: true,
+	}, nil
+}
+
+// parseGitLab validates X-Gitlab-Token and decodes a GitLab push or tag push event
+func parseGitLab(r *http.Request, body []byte, secret string) (*pushEvent, error) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+		return nil, errUnauthorized
+	}
+	if event := r.Header.Get("X-Gitlab-Event"); event != "Tag Push Hook" && event != "Push Hook" {
+		return &pushEvent{}, nil
+	}
+
+	var payload struct {
+		Ref     string `json:"ref"`
+		After   string `json:"after"`
+		Project struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+			HTTPURL           string `json:"git_http_url"`
+			SSHURL            string `json:"git_ssh_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %v", err)
+	}
+	return &pushEvent{
+		Ref: payload.Ref, After: payload.After, Deleted: strings.Trim(payload.After, "0") == "",
+		Repo: payload.Project.PathWithNamespace, HTTPURL: payload.Project.HTTPURL, SSHURL: payload.Project.SSHURL,
+		Relevant: true,
+	}, nil
+}
+
+// handleWebhook queues a build for every new tag pushed to an allowed repository
+func (s *Server) handleWebhook(parse func(*http.Request, []byte, string) (*pushEvent, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Webhooks.Secret == "" {
+			writeError(w, http.StatusForbidden, errors.New("webhooks are disabled; start pbuild serve with --webhook-secret"))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 10<<20))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		event, err := parse(r, body, s.Webhooks.Secret)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errUnauthorized) {
+				status = http.StatusUnauthorized
+			}
+			writeError(w, status, err)
+			return
+		}
+
+		ignore := func(reason string) {
+			writeJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": reason})
+		}
+		tag, isTag := strings.CutPrefix(event.Ref, "refs/tags/")
+		switch {
+		case !event.Relevant:
+			ignore("not a push event")
+			return
+		case !isTag:
+			ignore("not a tag push")
+			return
+		case event.Deleted:
+			ignore("tag deleted")
+			return
+		case !tagName.MatchString(tag) || strings.Contains(tag, ".."):
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported tag name %q", tag))
+			return
+		case !validRepoName(event.Repo):
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported repository name %q", event.Repo))
+			return
+		case len(s.Webhooks.Repos) > 0 && !contains(s.Webhooks.Repos, event.Repo):
+			writeError(w, http.StatusForbidden, fmt.Errorf("repository %s is not allowed", event.Repo))
+			return
+		}
+
+		cloneURL := event.HTTPURL
+		if s.Webhooks.SSH {
+			cloneURL = event.SSHURL
+		}
+		if cloneURL == "" {
+			writeError(w, http.StatusBadRequest, errors.New("push payload has no clone URL"))
+			return
+		}
+
+		repoPath := filepath.FromSlash(event.Repo)
+		job := &Job{
+			Dir:    filepath.Join(s.Webhooks.Workspace, repoPath),
+			Args:   append(append([]string{}, s.Webhooks.Args...), "--set-version="+tag),
+			Repo:   event.Repo,
+			Tag:    tag,
+			source: &Source{Repo: event.Repo, CloneURL: cloneURL, Tag: tag},
+			outDir: filepath.Join(s.Webhooks.OutputRoot, repoPath),
+		}
+		if err := s.enqueue(job); err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/builds/%d", job.ID))
+		writeJSON(w, http.StatusAccepted, job.snapshot())
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
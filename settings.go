@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+
+	"pbuild/config"
+)
+
+// buildSettings are the pbuild.yaml settings build flags override, as a run
+// uses them: the flag when given, else the config, else pbuild's default.
+// Targets and stage limits have resolvers of their own, resolveMatrix and
+// parseConcurrency.
+type buildSettings struct {
+	// Compress, Sign and ArchiveFormat are empty when turned off
+	Compress      string
+	Sign          string
+	ArchiveFormat string
+	BenchGate     string
+	// Tags and Notify are the config's, followed by the flags'
+	Tags            []string
+	Notify          []string
+	Generate        bool
+	NightlyKeep     int
+	GitignoreUpdate bool
+	GitignoreCreate bool
+}
+
+// resolveSettings merges the build flags over cfg
+func resolveSettings(cfg *config.Config) buildSettings {
+	s := buildSettings{
+		Compress:        flagCompress,
+		Sign:            flagSign,
+		ArchiveFormat:   flagArchive,
+		BenchGate:       flagBenchGate,
+		Tags:            append(slices.Clone(cfg.Tags), splitList(flagTags)...),
+		Notify:          append(slices.Clone(cfg.Notify), flagNotify...),
+		Generate:        flagGenerate || cfg.GoGenerate,
+		NightlyKeep:     flagKeepNightly,
+		GitignoreUpdate: !flagNoGitignore && (cfg.Gitignore.Update == nil || *cfg.Gitignore.Update),
+		GitignoreCreate: flagNewGitignore || cfg.Gitignore.Create,
+	}
+	if s.Compress == "" {
+		s.Compress = cfg.Compress
+	}
+	if s.Sign == "" {
+		s.Sign = cfg.Sign
+	}
+	if s.ArchiveFormat == "" {
+		s.ArchiveFormat = cfg.Archive.Format
+	}
+	if s.BenchGate == "" {
+		s.BenchGate = cfg.Bench.Gate
+	}
+	if s.BenchGate == "" {
+		s.BenchGate = "off"
+	}
+	// none turns a configured value off
+	for _, v := range []*string{&s.Compress, &s.Sign, &s.ArchiveFormat} {
+		if *v == "none" {
+			*v = ""
+		}
+	}
+	if s.NightlyKeep <= 0 {
+		s.NightlyKeep = cfg.Nightly.Keep
+	}
+	if s.NightlyKeep <= 0 {
+		s.NightlyKeep = defaultKeepNightlies
+	}
+	return s
+}
@@ -0,0 +1,168 @@
+package sign
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+
+	"pbuild/fsutil"
+)
+
+// minisignSigner writes prehashed minisign signatures, readable by minisign ≥ 0.8
+// and rsign
+type minisignSigner struct {
+	keyID [8]byte
+	key   ed25519.PrivateKey
+}
+
+// newMinisign decrypts a minisign secret key (minisign -G), given as a file or its contents
+func newMinisign(key, password string) (*minisignSigner, error) {
+	raw, err := readKeyFile(key)
+	if err != nil {
+		return nil, err
+	}
+	name := keyName(key)
+	// sig alg, kdf alg, checksum alg, salt, opslimit, memlimit, key id, secret key, checksum
+	if len(raw) != 2+2+2+32+8+8+8+64+32 {
+		return nil, fmt.Errorf("%s is not a minisign secret key", name)
+	}
+	if string(raw[:2]) != "Ed" || string(raw[4:6]) != "B2" {
+		return nil, fmt.Errorf("%s: unsupported minisign key algorithm %q", name, raw[:6])
+	}
+	salt := raw[6:38]
+	opsLimit := binary.LittleEndian.Uint64(raw[38:46])
+	memLimit := binary.LittleEndian.Uint64(raw[46:54])
+	keynum := raw[54:]
+
+	switch kdf := string(raw[2:4]); kdf {
+	case "Sc":
+		if password == "" {
+			return nil, fmt.Errorf("%s is encrypted; set %s", name, PasswordEnv)
+		}
+		n, r, p := scryptParams(opsLimit, memLimit)
+		stream, err := scrypt.Key([]byte(password), salt, n, r, p, len(keynum))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	case "\x00\x00":
+		// Unencrypted key (minisign -G -W)
+	default:
+		return nil, fmt.Errorf("%s: unsupported minisign key derivation %q", name, kdf)
+	}
+
+	s := &minisignSigner{key: ed25519.PrivateKey(bytes.Clone(keynum[8:72]))}
+	copy(s.keyID[:], keynum[:8])
+	sum := blake2b.Sum256(append(append([]byte("Ed"), keynum[:8]...), keynum[8:72]...))
+	if subtle.ConstantTimeCompare(sum[:], keynum[72:]) != 1 {
+		return nil, fmt.Errorf("%s: wrong password or corrupt minisign key", name)
+	}
+	return s, nil
+}
+
+// scryptParams derives scrypt's N, r and p from libsodium's opslimit and
+// memlimit the way crypto_pwhash_scryptsalsa208sha256 does
+func scryptParams(opsLimit, memLimit uint64) (int, int, int) {
+	const r = 8
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+	maxN := memLimit / (r * 128)
+	if opsLimit < memLimit/32 {
+		maxN = opsLimit / (r * 4)
+	}
+	nLog2 := uint(1)
+	for ; nLog2 < 63; nLog2++ {
+		if uint64(1)<<nLog2 > maxN/2 {
+			break
+		}
+	}
+	p := 1
+	if opsLimit >= memLimit/32 {
+		maxRP := (opsLimit / 4) / (uint64(1) << nLog2)
+		if maxRP > 0x3fffffff {
+			maxRP = 0x3fffffff
+		}
+		p = int(maxRP / r)
+	}
+	return 1 << nLog2, r, p
+}
+
+func (s *minisignSigner) Name() string { return "minisign" }
+func (s *minisignSigner) Ext() string  { return ".minisig" }
+
+func (s *minisignSigner) Sign(ctx context.Context, path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, _ := blake2b.New512(nil)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	sig := ed25519.Sign(s.key, h.Sum(nil))
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filepath.Base(path))
+	global := ed25519.Sign(s.key, append(bytes.Clone(sig), trusted...))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "untrusted comment: signature from minisign secret key\n")
+	fmt.Fprintf(&b, "%s\n", base64.StdEncoding.EncodeToString(append(append([]byte("ED"), s.keyID[:]...), sig...)))
+	fmt.Fprintf(&b, "trusted comment: %s\n", trusted)
+	fmt.Fprintf(&b, "%s\n", base64.StdEncoding.EncodeToString(global))
+	if err := fsutil.WriteFileAtomic(path+s.Ext(), []byte(b.String()), 0644); err != nil {
+		return nil, err
+	}
+	return []string{path + s.Ext()}, nil
+}
+
+// readKeyFile decodes the base64 line that follows the untrusted comment of a
+// minisign or signify key file
+func readKeyFile(keyFile string) ([]byte, error) {
+	data := []byte(keyFile)
+	if !isInline(keyFile) {
+		var err error
+		if data, err = os.ReadFile(keyFile); err != nil {
+			return nil, fmt.Errorf("failed to read signing key: %v", err)
+		}
+	}
+	name := keyName(keyFile)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return nil, fmt.Errorf("%s is not a minisign or signify key file", name)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return raw, nil
+}
+
+// isInline reports whether key is the text of a key file rather than its path,
+// e.g. when it comes from an environment variable or keychain
+func isInline(key string) bool {
+	return strings.HasPrefix(strings.TrimSpace(key), "untrusted comment:")
+}
+
+// keyName names a key in messages without printing inline key material
+func keyName(key string) string {
+	if isInline(key) {
+		return "signing key"
+	}
+	return key
+}
@@ -0,0 +1,145 @@
+package sign
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"pbuild/fsutil"
+	"pbuild/runner"
+)
+
+// PasswordEnv holds the passphrase of an encrypted signing key; it is the
+// first source of the sign_password credential
+const PasswordEnv = "PBUILD_SIGN_PASSWORD"
+
+// Runner runs the signing tools; nil runs those in PATH
+var Runner runner.Runner
+
+// Methods lists the supported signing methods
+var Methods = []string{"minisign", "signify", "gpg", "cosign"}
+
+// Signer writes a detached signature next to a file
+type Signer interface {
+	Name() string
+	// Ext is the suffix appended to the signed file's name
+	Ext() string
+	// Sign returns the paths of the files it wrote
+	Sign(ctx context.Context, path string) ([]string, error)
+}
+
+// New returns the signer for a method. key is a secret key file or its contents
+// for minisign and signify, a key id for gpg (empty: the default key) and a
+// key file, PEM key or KMS URI for cosign (empty: keyless signing). password
+// decrypts the key.
+func New(method, key, password string) (Signer, error) {
+	switch strings.ToLower(method) {
+	case "minisign":
+		if key == "" {
+			return nil, fmt.Errorf("minisign signing needs --key <minisign.key>")
+		}
+		return newMinisign(key, password)
+	case "signify":
+		if key == "" {
+			return nil, fmt.Errorf("signify signing needs --key <key.sec>")
+		}
+		return newSignify(key, password)
+	case "gpg":
+		if strings.HasPrefix(strings.TrimSpace(key), "-----BEGIN") {
+			return nil, fmt.Errorf("gpg signing needs a key id; import the key with gpg --import first")
+		}
+		return newGPG(key, password)
+	case "cosign":
+		if _, err := exec.LookPath("cosign"); err != nil {
+			return nil, fmt.Errorf("cosign not found in PATH")
+		}
+		return &cosignSigner{key: key, password: password}, nil
+	default:
+		return nil, fmt.Errorf("unknown signing method %q (expected %s)", method, strings.Join(Methods, ", "))
+	}
+}
+
+// run executes a signing tool, folding its output into the error
+func run(ctx context.Context, cmd *runner.Cmd) error {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := runner.Or(Runner).Run(ctx, cmd); err != nil {
+		return fmt.Errorf("%s failed: %v\n%s", cmd.Name, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// gpgSigner writes ASCII-armored detached signatures with GnuPG
+type gpgSigner struct {
+	binary   string
+	key      string
+	password string
+}
+
+func newGPG(key, password string) (*gpgSigner, error) {
+	for _, name := range []string{"gpg", "gpg2"} {
+		if bin, err := exec.LookPath(name); err == nil {
+			return &gpgSigner{binary: bin, key: key, password: password}, nil
+		}
+	}
+	return nil, fmt.Errorf("gpg not found in PATH")
+}
+
+func (s *gpgSigner) Name() string { return "gpg" }
+func (s *gpgSigner) Ext() string  { return ".asc" }
+
+func (s *gpgSigner) Sign(ctx context.Context, path string) ([]string, error) {
+	args := []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", fsutil.LongPath(path + s.Ext())}
+	if s.key != "" {
+		args = append(args, "--local-user", s.key)
+	}
+	if s.password != "" {
+		args = append(args, "--pinentry-mode", "loopback", "--passphrase-fd", "0")
+	}
+	cmd := &runner.Cmd{Name: s.binary, Args: append(args, fsutil.LongPath(path))}
+	if s.password != "" {
+		cmd.Stdin = strings.NewReader(s.password + "\n")
+	}
+	if err := run(ctx, cmd); err != nil {
+		return nil, err
+	}
+	return []string{path + s.Ext()}, nil
+}
+
+// cosignKeyEnv passes an inline PEM key to cosign as --key env://PBUILD_COSIGN_KEY
+const cosignKeyEnv = "PBUILD_COSIGN_KEY"
+
+// cosignSigner signs blobs with Sigstore cosign
+type cosignSigner struct {
+	key      string
+	password string
+}
+
+func (s *cosignSigner) Name() string { return "cosign" }
+func (s *cosignSigner) Ext() string  { return ".sig" }
+
+func (s *cosignSigner) Sign(ctx context.Context, path string) ([]string, error) {
+	files := []string{path + s.Ext()}
+	args := []string{"sign-blob", "--yes", "--output-signature", fsutil.LongPath(files[0])}
+	env := append(os.Environ(), "COSIGN_PASSWORD="+s.password)
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(s.key), "-----BEGIN"):
+		// A PEM key from a credential goes through the environment, not a temp file
+		args = append(args, "--key", "env://"+cosignKeyEnv)
+		env = append(env, cosignKeyEnv+"="+s.key)
+	case s.key != "":
+		args = append(args, "--key", s.key)
+	default:
+		// Keyless signatures are only verifiable with the Fulcio certificate
+		files = append(files, path+".pem")
+		args = append(args, "--output-certificate", fsutil.LongPath(files[1]))
+	}
+	if err := run(ctx, &runner.Cmd{Name: "cosign", Args: append(args, fsutil.LongPath(path)), Env: env}); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,141 @@
+package sign
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/blowfish"
+
+	"pbuild/fsutil"
+)
+
+// signifySigner writes OpenBSD signify signatures
+type signifySigner struct {
+	keyNum  [8]byte
+	key     ed25519.PrivateKey
+	pubName string
+}
+
+// newSignify decrypts a signify secret key (signify -G), given as a file or its contents
+func newSignify(key, password string) (*signifySigner, error) {
+	raw, err := readKeyFile(key)
+	if err != nil {
+		return nil, err
+	}
+	name := keyName(key)
+	// pk alg, kdf alg, kdf rounds, salt, checksum, key number, secret key
+	if len(raw) != 2+2+4+16+8+8+64 {
+		return nil, fmt.Errorf("%s is not a signify secret key", name)
+	}
+	if string(raw[:2]) != "Ed" || string(raw[2:4]) != "BK" {
+		return nil, fmt.Errorf("%s: unsupported signify key algorithm %q", name, raw[:4])
+	}
+	rounds := binary.BigEndian.Uint32(raw[4:8])
+	salt, checksum := raw[8:24], raw[24:32]
+	seckey := raw[40:]
+
+	if rounds > 0 {
+		if password == "" {
+			return nil, fmt.Errorf("%s is encrypted; set %s", name, PasswordEnv)
+		}
+		stream := bcryptPBKDF([]byte(password), salt, int(rounds), len(seckey))
+		for i := range seckey {
+			seckey[i] ^= stream[i]
+		}
+	}
+	sum := sha512.Sum512(seckey)
+	if subtle.ConstantTimeCompare(sum[:8], checksum) != 1 {
+		return nil, fmt.Errorf("%s: wrong password or corrupt signify key", name)
+	}
+
+	s := &signifySigner{key: ed25519.PrivateKey(bytes.Clone(seckey))}
+	copy(s.keyNum[:], raw[32:40])
+	// signify -V -p expects the public key named after the secret key
+	s.pubName = "signify.pub"
+	if !isInline(key) {
+		s.pubName = strings.TrimSuffix(filepath.Base(key), ".sec") + ".pub"
+	}
+	return s, nil
+}
+
+func (s *signifySigner) Name() string { return "signify" }
+func (s *signifySigner) Ext() string  { return ".sig" }
+
+func (s *signifySigner) Sign(ctx context.Context, path string) ([]string, error) {
+	msg, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(s.key, msg)
+	var b strings.Builder
+	fmt.Fprintf(&b, "untrusted comment: verify with %s\n", s.pubName)
+	fmt.Fprintf(&b, "%s\n", base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), s.keyNum[:]...), sig...)))
+	if err := fsutil.WriteFileAtomic(path+s.Ext(), []byte(b.String()), 0644); err != nil {
+		return nil, err
+	}
+	return []string{path + s.Ext()}, nil
+}
+
+// bcryptPBKDF is the bcrypt-based PBKDF2 variant OpenBSD uses to encrypt
+// signify and OpenSSH keys
+func bcryptPBKDF(password, salt []byte, rounds, keyLen int) []byte {
+	const blockSize = 32
+	numBlocks := (keyLen + blockSize - 1) / blockSize
+	key := make([]byte, numBlocks*blockSize)
+
+	shaPass := sha512.Sum512(password)
+	h := sha512.New()
+	cnt, tmp := make([]byte, 4), make([]byte, blockSize)
+	for block := 1; block <= numBlocks; block++ {
+		h.Reset()
+		h.Write(salt)
+		binary.BigEndian.PutUint32(cnt, uint32(block))
+		h.Write(cnt)
+		bcryptHash(tmp, shaPass[:], h.Sum(nil))
+
+		out := bytes.Clone(tmp)
+		for i := 2; i <= rounds; i++ {
+			shaSalt := sha512.Sum512(tmp)
+			bcryptHash(tmp, shaPass[:], shaSalt[:])
+			for j := range out {
+				out[j] ^= tmp[j]
+			}
+		}
+		// Output bytes are interleaved across blocks
+		for i, v := range out {
+			key[i*numBlocks+(block-1)] = v
+		}
+	}
+	return key[:keyLen]
+}
+
+// bcryptHash encrypts the bcrypt_pbkdf magic with an expensively keyed Blowfish
+func bcryptHash(out, shaPass, shaSalt []byte) {
+	c, err := blowfish.NewSaltedCipher(shaPass, shaSalt)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 64; i++ {
+		blowfish.ExpandKey(shaSalt, c)
+		blowfish.ExpandKey(shaPass, c)
+	}
+	copy(out, "OxychromaticBlowfishSwatDynamite")
+	for i := 0; i < 32; i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(out[i:i+8], out[i:i+8])
+		}
+	}
+	// Blowfish works on big-endian words, bcrypt_pbkdf emits little-endian ones
+	for i := 0; i < 32; i += 4 {
+		out[i+3], out[i+2], out[i+1], out[i] = out[i], out[i+1], out[i+2], out[i+3]
+	}
+}
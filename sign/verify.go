@@ -0,0 +1,198 @@
+package sign
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+
+	"pbuild/fsutil"
+	"pbuild/runner"
+)
+
+// Keys are the trust anchors a release is verified against
+type Keys struct {
+	Minisign string // minisign public key file
+	Signify  string // signify public key file
+	GPG      string // exported public key file; empty: the user's keyring
+	Cosign   string // cosign public key file or KMS URI
+	// Keyless cosign signatures are checked against the signer's certificate
+	Identity string // --certificate-identity-regexp
+	Issuer   string // --certificate-oidc-issuer
+}
+
+// Verify checks a detached signature of path and returns the method that made
+// it. The method follows from the extension; .sig is signify when it carries
+// an untrusted comment and cosign otherwise.
+func Verify(ctx context.Context, keys Keys, path, sigPath string) (string, error) {
+	switch filepath.Ext(sigPath) {
+	case ".minisig":
+		return "minisign", verifyMinisign(keys.Minisign, path, sigPath)
+	case ".asc":
+		return "gpg", verifyGPG(ctx, keys.GPG, path, sigPath)
+	case ".sig":
+		data, err := os.ReadFile(sigPath)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(string(data), "untrusted comment:") {
+			return "signify", verifySignify(keys.Signify, path, data)
+		}
+		return "cosign", verifyCosign(ctx, keys, path, sigPath)
+	default:
+		return "", fmt.Errorf("unknown signature type %s", filepath.Base(sigPath))
+	}
+}
+
+// readPublicKey returns a minisign or signify public key with its key id
+func readPublicKey(keyFile, method string) ([]byte, ed25519.PublicKey, error) {
+	if keyFile == "" {
+		return nil, nil, fmt.Errorf("no %s public key given", method)
+	}
+	raw, err := readKeyFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, nil, fmt.Errorf("%s is not a %s public key", keyFile, method)
+	}
+	return raw[2:10], ed25519.PublicKey(raw[10:]), nil
+}
+
+func verifyMinisign(keyFile, path, sigPath string) error {
+	keyID, pub, err := readPublicKey(keyFile, "minisign")
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(sigPath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		return fmt.Errorf("%s is not a minisign signature", filepath.Base(sigPath))
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("%s is not a minisign signature", filepath.Base(sigPath))
+	}
+	trusted, ok := strings.CutPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if !ok {
+		return fmt.Errorf("%s has no trusted comment", filepath.Base(sigPath))
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(sigPath), err)
+	}
+	if string(sig[2:10]) != string(keyID) {
+		return fmt.Errorf("signed by a different key than %s", keyFile)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var msg []byte
+	switch string(sig[:2]) {
+	case "ED":
+		h, _ := blake2b.New512(nil)
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		msg = h.Sum(nil)
+	case "Ed":
+		// Legacy signature over the whole file
+		if msg, err = io.ReadAll(f); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(pub, msg, sig[10:]) {
+		return fmt.Errorf("signature does not match")
+	}
+	if !ed25519.Verify(pub, append(bytes.Clone(sig[10:]), trusted...), global) {
+		return fmt.Errorf("trusted comment was tampered with")
+	}
+	return nil
+}
+
+func verifySignify(keyFile, path string, data []byte) error {
+	keyNum, pub, err := readPublicKey(keyFile, "signify")
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var sig []byte
+	if len(lines) >= 2 {
+		sig, _ = base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	}
+	if len(sig) != 2+8+ed25519.SignatureSize || string(sig[:2]) != "Ed" {
+		return fmt.Errorf("not a signify signature")
+	}
+	if string(sig[2:10]) != string(keyNum) {
+		return fmt.Errorf("signed by a different key than %s", keyFile)
+	}
+	msg, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, msg, sig[10:]) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// verifyGPG runs gpg --verify, against a throwaway keyring holding only
+// keyFile when one is given
+func verifyGPG(ctx context.Context, keyFile, path, sigPath string) error {
+	g, err := newGPG("", "")
+	if err != nil {
+		return err
+	}
+	env := os.Environ()
+	if keyFile != "" {
+		home, err := os.MkdirTemp("", "pbuild-gpg-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(home)
+		env = append(env, "GNUPGHOME="+home)
+		if err := run(ctx, &runner.Cmd{Name: g.binary, Args: []string{"--batch", "--import", keyFile}, Env: env}); err != nil {
+			return err
+		}
+	}
+	return run(ctx, &runner.Cmd{Name: g.binary, Args: []string{"--batch", "--verify", fsutil.LongPath(sigPath), fsutil.LongPath(path)}, Env: env})
+}
+
+// verifyCosign runs cosign verify-blob with a key, or keyless against the
+// certificate written next to the signature
+func verifyCosign(ctx context.Context, keys Keys, path, sigPath string) error {
+	if _, err := exec.LookPath("cosign"); err != nil {
+		return fmt.Errorf("cosign not found in PATH")
+	}
+	args := []string{"verify-blob", "--signature", fsutil.LongPath(sigPath)}
+	cert := strings.TrimSuffix(sigPath, ".sig") + ".pem"
+	switch {
+	case keys.Cosign != "":
+		args = append(args, "--key", keys.Cosign)
+	case keys.Identity != "" && keys.Issuer != "":
+		if _, err := os.Stat(cert); err != nil {
+			return fmt.Errorf("keyless signature without certificate %s", filepath.Base(cert))
+		}
+		args = append(args, "--certificate", fsutil.LongPath(cert),
+			"--certificate-identity-regexp", keys.Identity, "--certificate-oidc-issuer", keys.Issuer)
+	default:
+		return fmt.Errorf("no cosign key or certificate identity and issuer given")
+	}
+	return run(ctx, &runner.Cmd{Name: "cosign", Args: append(args, fsutil.LongPath(path))})
+}
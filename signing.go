@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"pbuild/creds"
+	"pbuild/pipeline"
+	"pbuild/sign"
+)
+
+// newSigner validates --sign and --key and loads the signing key up front, so a
+// wrong passphrase fails the run before anything is built. --key takes
+// precedence over the sign_key credential; the passphrase is the sign_password credential.
+func newSigner(p *project) (sign.Signer, error) {
+	if flagSign == "" {
+		if flagSignKey != "" {
+			return nil, fmt.Errorf("--key needs --sign")
+		}
+		return nil, nil
+	}
+	if !flagChecksums {
+		return nil, fmt.Errorf("--sign signs the checksum files and needs --checksums")
+	}
+	key := flagSignKey
+	if key == "" {
+		v, source, err := p.creds.Get(creds.SignKey)
+		switch {
+		case err == nil:
+			key = v
+			if flagVerbose {
+				fmt.Printf("Signing key from %s\n", source)
+			}
+		case !errors.Is(err, creds.ErrNotFound):
+			return nil, err
+		}
+	}
+	password, err := p.creds.Optional(creds.SignPassword)
+	if err != nil {
+		return nil, err
+	}
+	return sign.New(flagSign, key, password)
+}
+
+// signStage writes a detached signature next to the artifact's and archive's .hash files
+type signStage struct {
+	signer sign.Signer
+}
+
+func (s *signStage) Name() string  { return "sign" }
+func (s *signStage) Enabled() bool { return s.signer != nil }
+
+func (s *signStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	files := []string{a.Name + ".hash"}
+	if a.Result.Archive != "" {
+		files = append(files, a.Result.Archive+".hash")
+	}
+	for _, name := range files {
+		written, err := s.signer.Sign(ctx, filepath.Join(a.Dir, name))
+		if err != nil {
+			return fmt.Errorf("%s signing of %s failed: %v", s.signer.Name(), name, err)
+		}
+		for _, path := range written {
+			a.Result.Signatures = append(a.Result.Signatures, filepath.Base(path))
+		}
+		stageLog(a, "Signed %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"go/build/constraint"
+	"path"
+	"slices"
+	"strings"
+
+	"pbuild/config"
+	"pbuild/gobuild"
+	"pbuild/targets"
+)
+
+// skipReason returns why a skip rule excludes the target, or "" when it is built
+func skipReason(rules []config.SkipRule, t targets.Target, buildConfig gobuild.BuildConfig) (string, error) {
+	tags, err := gobuild.ResolveTags(buildConfig.Strategy, buildConfig.Tags, t)
+	if err != nil {
+		return "", err
+	}
+	cgo := buildConfig.Strategy == gobuild.FlexibleCGO
+
+	for i, rule := range rules {
+		matched, err := skipRuleMatches(rule, t, tags, buildConfig.BuildMode, cgo)
+		if err != nil {
+			return "", fmt.Errorf("skip rule %d in %s: %v", i+1, config.FileName, err)
+		}
+		if !matched {
+			continue
+		}
+		if rule.Reason != "" {
+			return rule.Reason, nil
+		}
+		return fmt.Sprintf("matched skip rule %d", i+1), nil
+	}
+	return "", nil
+}
+
+// skipRuleMatches reports whether every condition of the rule holds for the target
+func skipRuleMatches(rule config.SkipRule, t targets.Target, tags []string, buildMode string, cgo bool) (bool, error) {
+	if len(rule.Targets) > 0 {
+		if matched, err := matchTargets(rule.Targets, t); err != nil || !matched {
+			return false, err
+		}
+	}
+
+	if rule.When != "" {
+		expr, err := constraint.Parse("//go:build " + rule.When)
+		if err != nil {
+			return false, fmt.Errorf("invalid condition %q: %v", rule.When, err)
+		}
+		if !expr.Eval(func(tag string) bool { return t.Satisfies(tag, cgo) }) {
+			return false, nil
+		}
+	}
+
+	for _, tag := range rule.Tags {
+		if !slices.Contains(tags, tag) {
+			return false, nil
+		}
+	}
+
+	if rule.BuildMode != "" && rule.BuildMode != buildMode {
+		return false, nil
+	}
+	return true, nil
+}
+
+// matchTargets reports whether the target matches any GOOS/GOARCH pattern
+func matchTargets(patterns []string, t targets.Target) (bool, error) {
+	matched := false
+	for _, pattern := range patterns {
+		ok, err := path.Match(strings.ToLower(pattern), t.String())
+		if err != nil {
+			return false, fmt.Errorf("invalid target pattern %q: %v", pattern, err)
+		}
+		matched = matched || ok
+	}
+	return matched, nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/pipeline"
+	"pbuild/pool"
+	"pbuild/publish"
+	"pbuild/redact"
+	"pbuild/selfinfo"
+	"pbuild/sign"
+	"pbuild/targets"
+)
+
+// stageNames lists the per-target stages in the order they run
+var stageNames = []string{"compile", "tests", "header", "pathcheck", "static", "policy", "compress", "store", "checksum", "pool", "provenance", "archive", "sign", "publish"}
+
+// stageLog prints a verbose-only progress line for an artifact
+func stageLog(a *pipeline.Artifact, format string, args ...any) {
+	if flagVerbose {
+		fmt.Printf("[Worker %d]   %s\n", a.Worker, fmt.Sprintf(format, args...))
+	}
+}
+
+// compileStage builds the target into a temp file so the version directory
+// only ever holds complete artifacts
+type compileStage struct {
+	workDir string
+	config  gobuild.BuildConfig
+	embed   *infoEmbedder
+	// cacheStats predicts each build's cache hits and misses before it runs
+	cacheStats bool
+	// transcript keeps the go build -x log of every build, not only failed ones
+	transcript bool
+}
+
+func (s *compileStage) Name() string  { return "compile" }
+func (s *compileStage) Enabled() bool { return true }
+
+func (s *compileStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	a.Temp = fsutil.TempPath(a.Path())
+	config := s.config
+	var info selfinfo.Info
+	if s.embed != nil {
+		info = s.embed.info(a)
+		config.LDFlags += " " + selfinfo.LDFlag(s.embed.variable, info)
+	}
+	if s.cacheStats {
+		s.probeCache(ctx, a, config)
+	}
+	var transcript bytes.Buffer
+	if s.transcript {
+		config.Transcript = &transcript
+	}
+	var err error
+	if a.Target.OS == "darwin" && a.Target.Arch == targets.DarwinUniversal {
+		err = buildDarwinUniversal(ctx, s.workDir, a.Temp, config)
+	} else {
+		err = gobuild.BuildWithConfig(ctx, s.workDir, a.Target, a.Temp, config)
+	}
+	if err != nil {
+		return err
+	}
+	if s.transcript {
+		if a.Result.Log = writeLog(a.Dir, a.Target, transcript.Bytes()); a.Result.Log != "" {
+			stageLog(a, "Toolchain transcript written to %s", filepath.Join(a.Dir, a.Result.Log))
+		}
+	}
+	if s.embed != nil {
+		if err := s.embed.finish(a, info); err != nil {
+			return err
+		}
+	}
+	_ = os.Chmod(a.Temp, 0o755)
+	return nil
+}
+
+// probeCache records the cache hits and misses the build of a will have;
+// a universal binary counts both of its slices
+func (s *compileStage) probeCache(ctx context.Context, a *pipeline.Artifact, config gobuild.BuildConfig) {
+	builds := []targets.Target{a.Target}
+	if a.Target.OS == "darwin" && a.Target.Arch == targets.DarwinUniversal {
+		builds = []targets.Target{{OS: "darwin", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}}
+	}
+	var total gobuild.CacheStats
+	for _, t := range builds {
+		stats, err := gobuild.ProbeCache(ctx, s.workDir, t, config)
+		if err != nil {
+			stageLog(a, "Cache probe failed: %v", err)
+			return
+		}
+		total.Hits += stats.Hits
+		total.Misses += stats.Misses
+	}
+	a.Result.Cache = &total
+	stageLog(a, "Build cache: %d hits, %d misses", total.Hits, total.Misses)
+}
+
+// testDir holds the test binaries of --test-binaries inside the version directory
+const testDir = "tests"
+
+// testStage compiles the tests of the --test-binaries packages for the target
+// into tests/<os>-<arch>/<package dir>, each next to a copy of its testdata,
+// so they run on the device as they do in the package directory
+type testStage struct {
+	workDir string
+	config  gobuild.BuildConfig
+	pattern string
+}
+
+func (s *testStage) Name() string  { return "tests" }
+func (s *testStage) Enabled() bool { return s.pattern != "" }
+
+func (s *testStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	if a.Target.Arch == targets.DarwinUniversal {
+		stageLog(a, "No test binaries for a universal binary; build darwin/amd64 and darwin/arm64 for them")
+		return nil
+	}
+	pkgs, err := gobuild.TestPackages(ctx, s.workDir, a.Target, s.pattern, s.config)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(a.Dir, testDir, strings.ReplaceAll(a.Target.String(), "/", "-"))
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		rel, err := filepath.Rel(s.workDir, pkg.Dir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(pkg.Dir)
+		}
+		name := path.Base(pkg.ImportPath) + ".test"
+		if a.Target.OS == "windows" {
+			name += ".exe"
+		}
+		out := filepath.Join(dir, rel, name)
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			return err
+		}
+		if err := gobuild.BuildTest(ctx, s.workDir, a.Target, pkg.ImportPath, out, s.config); err != nil {
+			_ = os.RemoveAll(dir)
+			return err
+		}
+		// testdata holds the fixtures and the fuzz corpus (testdata/fuzz)
+		if fi, err := os.Stat(filepath.Join(pkg.Dir, "testdata")); err == nil && fi.IsDir() {
+			if err := fsutil.CopyDir(filepath.Join(pkg.Dir, "testdata"), filepath.Join(filepath.Dir(out), "testdata")); err != nil {
+				_ = os.RemoveAll(dir)
+				return fmt.Errorf("failed to copy the testdata of %s: %v", pkg.ImportPath, err)
+			}
+		}
+		relOut, _ := filepath.Rel(a.Dir, out)
+		a.Result.Tests = append(a.Result.Tests, filepath.ToSlash(relOut))
+	}
+	stageLog(a, "Built %d test binaries into %s", len(a.Result.Tests), dir)
+	return nil
+}
+
+// compressStage compresses the binary, keeping the raw one when compression fails
+type compressStage struct {
+	method string
+}
+
+func (s *compressStage) Name() string  { return "compress" }
+func (s *compressStage) Enabled() bool { return s.method != "" }
+
+func (s *compressStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	name := a.Name + compressionExt(s.method)
+	tmp := fsutil.TempPath(filepath.Join(a.Dir, name))
+	if err := compressFile(a.Temp, tmp, s.method); err != nil {
+		_ = os.Remove(tmp)
+		stageLog(a, "Compression failed: %v", err)
+		return nil
+	}
+	// Remove original file after successful compression
+	_ = os.Remove(a.Temp)
+	a.Temp, a.Name = tmp, name
+	stageLog(a, "Compressed to %s", a.Path())
+	return nil
+}
+
+// storeStage moves the finished file into the version directory
+type storeStage struct{}
+
+func (storeStage) Name() string  { return "store" }
+func (storeStage) Enabled() bool { return true }
+
+func (storeStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	if err := os.Rename(a.Temp, a.Path()); err != nil {
+		return err
+	}
+	a.Temp = ""
+	a.Result.File = a.Name
+	a.Result.Success = true
+	if sz, err := fsutil.FileSize(a.Path()); err == nil {
+		a.Result.Size = sz
+	}
+	return nil
+}
+
+// checksumStage writes the .hash file and records the digests
+type checksumStage struct {
+	enabled bool
+}
+
+func (s *checksumStage) Name() string  { return "checksum" }
+func (s *checksumStage) Enabled() bool { return s.enabled }
+
+func (s *checksumStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	sha256Sum, sha512Sum, err := generateChecksums(a.Path())
+	if err != nil {
+		stageLog(a, "Checksum generation failed: %v", err)
+		return nil
+	}
+	if err := writeChecksumFile(a.Path(), sha256Sum, sha512Sum); err != nil {
+		stageLog(a, "Failed to write checksum file: %v", err)
+	}
+	a.Result.SHA256 = sha256Sum
+	a.Result.SHA512 = sha512Sum
+	return nil
+}
+
+// poolStage keeps the artifact in the content-addressed pool of --pool, so an
+// unchanged binary is stored once however many versions contain it
+type poolStage struct {
+	pool *pool.Pool
+}
+
+func (s *poolStage) Name() string  { return "pool" }
+func (s *poolStage) Enabled() bool { return s.pool != nil }
+
+func (s *poolStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	digest := a.Result.SHA256
+	if digest == "" {
+		sum, _, err := generateChecksums(a.Path())
+		if err != nil {
+			stageLog(a, "Pooling skipped: %v", err)
+			return nil
+		}
+		digest = sum
+	}
+	reused, err := s.pool.Link(a.Path(), digest)
+	if err != nil {
+		stageLog(a, "Pooling failed: %v", err)
+		return nil
+	}
+	a.Result.Deduplicated = reused
+	if reused {
+		stageLog(a, "Identical to an earlier build; linked from the pool")
+	}
+	return nil
+}
+
+// provenanceStage attaches provenance so a copied artifact still identifies its build
+type provenanceStage struct {
+	p       *project
+	config  gobuild.BuildConfig
+	sidecar bool
+	xattrs  bool
+}
+
+func (s *provenanceStage) Name() string  { return "provenance" }
+func (s *provenanceStage) Enabled() bool { return s.sidecar || s.xattrs }
+
+func (s *provenanceStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	meta := artifactMeta(s.p, a.Target, a.Result, s.config)
+	redactor.Value(&meta)
+	if flagMetadataMinimal {
+		redact.Strings(&meta, hostPaths(s.p))
+	}
+	if err := writeProvenance(a.Path(), meta, s.sidecar, s.xattrs); err != nil {
+		fmt.Printf("  WARNING: %v\n", err)
+	}
+	return nil
+}
+
+// archiveStage bundles the artifact with the configured extra files
+type archiveStage struct {
+	p         *project
+	plan      *archivePlan
+	checksums bool
+}
+
+func (s *archiveStage) Name() string  { return "archive" }
+func (s *archiveStage) Enabled() bool { return s.plan != nil }
+
+func (s *archiveStage) Run(ctx context.Context, a *pipeline.Artifact) error {
+	name, err := s.plan.build(s.p, a.Target, a.Path(), a.Dir)
+	if err != nil {
+		fmt.Printf("  WARNING: archive failed: %v\n", err)
+		return nil
+	}
+	a.Result.Archive = name
+	if s.checksums {
+		archivePath := filepath.Join(a.Dir, name)
+		if sha256Sum, sha512Sum, err := generateChecksums(archivePath); err == nil {
+			_ = writeChecksumFile(archivePath, sha256Sum, sha512Sum)
+		}
+	}
+	stageLog(a, "Archived to %s", name)
+	return nil
+}
+
+// newPipeline assembles the per-target stages from the run's flags and
+// applies the concurrency caps from pbuild.yaml and --concurrency; plugins
+// from pbuild.yaml join as extra stages
+func newPipeline(p *project, archives *archivePlan, signer sign.Signer, dests []*publish.Destination, plan *releasePlan) (*pipeline.Pipeline, error) {
+	config := newBuildConfig(p)
+	if p.memory != nil {
+		config.Runner = p.memory.Runner(config.Runner)
+	}
+	embed, err := newInfoEmbedder(p)
+	if err != nil {
+		return nil, err
+	}
+	pathCheck, err := newPathCheckStage(p, config)
+	if err != nil {
+		return nil, err
+	}
+	staticCheck, err := newStaticCheckStage(config)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := newPolicyStage(p)
+	if err != nil {
+		return nil, err
+	}
+	stages, err := withPlugins(p, []pipeline.Stage{
+		memoryGated(p, &compileStage{workDir: p.workDir, config: config, embed: embed, cacheStats: flagCacheStats, transcript: flagVeryVerbose}),
+		memoryGated(p, &testStage{workDir: p.workDir, config: config, pattern: flagTestBinaries}),
+		headerStage{},
+		pathCheck,
+		staticCheck,
+		policy,
+		&compressStage{method: flagCompress},
+		storeStage{},
+		&checksumStage{enabled: flagChecksums},
+		&poolStage{pool: artifactPool(p)},
+		&provenanceStage{p: p, config: config, sidecar: flagSidecar, xattrs: flagXattrs},
+		&archiveStage{p: p, plan: archives, checksums: flagChecksums},
+		&signStage{signer: signer},
+		&publishStage{p: p, dests: pipelineDests(dests, plan), plan: plan},
+	})
+	if err != nil {
+		return nil, err
+	}
+	pl := pipeline.New(stages...)
+
+	limits, errs := parseConcurrency(p.cfg)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	for stage, n := range limits {
+		if err := pl.Limit(stage, n); err != nil {
+			return nil, err
+		}
+	}
+
+	// Report success as soon as the artifact is in place, before post-processing
+	pl.Use(pipeline.Hook{
+		After: func(stage string, a *pipeline.Artifact, err error, elapsed time.Duration) {
+			if flagVerbose && err == nil {
+				stageLog(a, "%s done in %s", stage, elapsed.Round(time.Millisecond))
+			}
+			if stage == "store" && err == nil {
+				if flagVerbose {
+					fmt.Printf("[Worker %d]   SUCCESS\n\n", a.Worker)
+				} else {
+					fmt.Printf("  SUCCESS\n\n")
+				}
+			}
+		},
+	})
+	return pl, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pbuild/config"
+	"pbuild/fsutil"
+	"pbuild/pipeline"
+	"pbuild/targets"
+)
+
+const testBinary = "\x7fELF not really a binary\n"
+
+// compiledArtifact returns an artifact as the compile stage leaves it: the
+// binary in a temp file of the version directory dir
+func compiledArtifact(t *testing.T, dir string, target targets.Target) *pipeline.Artifact {
+	t.Helper()
+	a := &pipeline.Artifact{Target: target, Dir: dir, Name: "app"}
+	a.Temp = fsutil.TempPath(a.Path())
+	if err := os.WriteFile(a.Temp, []byte(testBinary), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// runStages runs the stages on a, failing the test on the first error
+func runStages(t *testing.T, a *pipeline.Artifact, stages ...pipeline.Stage) {
+	t.Helper()
+	for _, s := range stages {
+		if err := s.Run(context.Background(), a); err != nil {
+			t.Fatalf("%s stage: %v", s.Name(), err)
+		}
+	}
+}
+
+func TestCompressStage(t *testing.T) {
+	dir := t.TempDir()
+	a := compiledArtifact(t, dir, targets.Target{OS: "linux", Arch: "amd64"})
+	raw := a.Temp
+	runStages(t, a, &compressStage{method: "gzip"}, storeStage{})
+
+	if a.Name != "app.gz" || a.Result.File != "app.gz" || !a.Result.Success {
+		t.Fatalf("artifact after compress and store: name %q, result %+v", a.Name, a.Result)
+	}
+	if _, err := os.Stat(raw); !os.IsNotExist(err) {
+		t.Errorf("uncompressed temp file left behind: %v", err)
+	}
+	f, err := os.Open(filepath.Join(dir, "app.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testBinary {
+		t.Errorf("decompressed %q, want %q", data, testBinary)
+	}
+}
+
+func TestCompressStageFailure(t *testing.T) {
+	dir := t.TempDir()
+	a := compiledArtifact(t, dir, targets.Target{OS: "linux", Arch: "amd64"})
+	raw := a.Temp
+	runStages(t, a, &compressStage{method: "lz4"})
+	if a.Name != "app" || a.Temp != raw {
+		t.Errorf("failed compression replaced the binary: name %q, temp %q", a.Name, a.Temp)
+	}
+}
+
+func TestChecksumStage(t *testing.T) {
+	dir := t.TempDir()
+	a := compiledArtifact(t, dir, targets.Target{OS: "linux", Arch: "amd64"})
+	runStages(t, a, storeStage{}, &checksumStage{enabled: true})
+
+	sum := sha256.Sum256([]byte(testBinary))
+	want := hex.EncodeToString(sum[:])
+	if a.Result.SHA256 != want || a.Result.SHA512 == "" {
+		t.Errorf("result digests %q, %q; want SHA256 %q", a.Result.SHA256, a.Result.SHA512, want)
+	}
+	hash, err := os.ReadFile(filepath.Join(dir, "app.hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(hash), "SHA256 (app) = "+want+"\nSHA512 (app) = ") {
+		t.Errorf("app.hash = %q", hash)
+	}
+}
+
+func TestArchiveStage(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	work, dir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(work, "README.md"), []byte("# app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := &project{workDir: work, name: "app", version: "1.0.0-abc1234", cfg: &config.Config{}}
+	p.cfg.Archive.Files = []config.ArchiveFile{{Src: "README.md", CRLF: true}}
+	p.settings.ArchiveFormat = "auto"
+	plan, err := newArchivePlan(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := compiledArtifact(t, dir, targets.Target{OS: "windows", Arch: "amd64"})
+	runStages(t, a, storeStage{}, &archiveStage{p: p, plan: plan, checksums: true})
+
+	const name = "app_1.0.0-abc1234_windows_amd64.zip"
+	if a.Result.Archive != name {
+		t.Fatalf("archive %q, want %s", a.Result.Archive, name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, name+".hash")); err != nil {
+		t.Errorf("archive checksum: %v", err)
+	}
+	zr, err := zip.OpenReader(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name] = string(data)
+	}
+	if entries["app"] != testBinary || entries["README.md"] != "# app\r\n" || len(entries) != 2 {
+		t.Errorf("archive entries %q", entries)
+	}
+}
@@ -0,0 +1,179 @@
+package staging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pbuild/fsutil"
+)
+
+// Attempts is how often a file is copied before the transfer gives up
+const Attempts = 3
+
+// Result counts what a transfer copied
+type Result struct {
+	Files int
+	Bytes int64
+	// Retries are the copies that failed or did not match and were redone
+	Retries int
+}
+
+// Transfer copies the directory src to dst, typically on a network share.
+// Each file is written next to its place, read back and compared with the
+// SHA-256 of the original, then renamed into place; a copy that fails or
+// does not match is retried with a growing pause. With replace the files go
+// to a sibling directory that then replaces dst, so readers see either the
+// old directory or the new one; otherwise they are added to dst, for the
+// single-target jobs that share it.
+func Transfer(src, dst string, replace bool) (Result, error) {
+	var res Result
+	into := dst
+	if replace {
+		into = dst + ".partial"
+		if err := os.RemoveAll(into); err != nil {
+			return res, err
+		}
+	}
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(into, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return copySymlink(path, target)
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("cannot stage %s: not a regular file, directory or symlink", rel)
+		}
+		n, retries, err := copyWithRetries(path, target)
+		res.Retries += retries
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %v", rel, err)
+		}
+		res.Files++
+		res.Bytes += n
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	if !replace {
+		return res, nil
+	}
+
+	// Swap the directories; the old one is only removed once the new one is in place
+	old := dst + ".old"
+	_ = os.RemoveAll(old)
+	if _, err := os.Stat(dst); err == nil {
+		if err := os.Rename(dst, old); err != nil {
+			return res, err
+		}
+	}
+	if err := os.Rename(into, dst); err != nil {
+		_ = os.Rename(old, dst)
+		return res, err
+	}
+	_ = os.RemoveAll(old)
+	return res, nil
+}
+
+// copySymlink recreates the symlink src at dst with the same target
+func copySymlink(src, dst string) error {
+	link, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(dst)
+	return os.Symlink(link, dst)
+}
+
+// copyWithRetries copies src to dst until a verified copy succeeds or the
+// attempts run out, returning its size and the number of retries
+func copyWithRetries(src, dst string) (int64, int, error) {
+	var err error
+	for attempt := 0; attempt < Attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		var n int64
+		if n, err = copyVerified(src, dst); err == nil {
+			return n, attempt, nil
+		}
+	}
+	return 0, Attempts - 1, err
+}
+
+// copyVerified copies src to a temporary file next to dst, flushes it to
+// storage, checks its SHA-256 against src's by reading it back, and renames
+// it into place
+func copyVerified(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := fsutil.TempPath(dst)
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(out, io.TeeReader(in, h))
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	want := h.Sum(nil)
+	got, err := fileSum(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if !bytes.Equal(got, want) {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("copy does not match: SHA-256 %x, expected %x", got, want)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, nil
+}
+
+func fileSum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"pbuild/fsutil"
+	"pbuild/staging"
+)
+
+// stagingDir returns the resolved --staging-dir, relative paths taken from
+// workDir like the output directory's
+func stagingDir(workDir string) string {
+	if filepath.IsAbs(flagStagingDir) {
+		return flagStagingDir
+	}
+	return filepath.Join(workDir, flagStagingDir)
+}
+
+// transferStaged copies the version directory built in staging to its place
+// in the output directory, verified file by file, and removes the staged
+// copy; the whole directory is replaced unless a single-target job adds its
+// files. On failure the staged build is left for another try.
+func transferStaged(stagedDir, versionDir string, replace bool) error {
+	fmt.Printf("Copying %s to %s\n", stagedDir, versionDir)
+	res, err := staging.Transfer(stagedDir, versionDir, replace)
+	if err != nil {
+		return fmt.Errorf("failed to copy the build to %s: %v (the build is kept in %s)", versionDir, err, stagedDir)
+	}
+	if res.Retries > 0 {
+		fmt.Printf("Warning: %d copies to %s failed or did not match and were redone\n", res.Retries, versionDir)
+	}
+	fmt.Printf("Version directory copied to: %s (%d files, %s, SHA-256 verified)\n", versionDir, res.Files, fsutil.HumanSizeBytes(res.Bytes))
+	if err := os.RemoveAll(stagedDir); err != nil {
+		fmt.Printf("Warning: Failed to remove the staged build: %v\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/renderer"
+	"github.com/olekukonko/tablewriter/tw"
+
+	"pbuild/buildmeta"
+	"pbuild/fsutil"
+	"pbuild/ui"
+)
+
+// summaryRow is one line of the final artifacts table
+type summaryRow struct {
+	buildmeta.TargetResult
+	status string
+}
+
+// summaryColumnOrder lists the available summary columns in their default order
+var summaryColumnOrder = []string{"file", "target", "size", "sha256", "status"}
+
+// summaryColumnTitles maps summary column names to table headers
+var summaryColumnTitles = map[string]string{
+	"file":   "File",
+	"target": "Target",
+	"size":   "Size",
+	"sha256": "SHA256",
+	"status": "Status",
+}
+
+// summaryModes lists the layouts of --summary
+var summaryModes = []string{"table", "group-by-os", "compact"}
+
+// shortSHALength is the number of hex digits shown with --sha-display short
+const shortSHALength = 12
+
+// parseSummaryColumns validates --summary, --summary-columns and --sha-display,
+// and drops the SHA column for --sha-display none
+func parseSummaryColumns(mode, columns, shaDisplay string) ([]string, error) {
+	if !slices.Contains(summaryModes, mode) {
+		return nil, fmt.Errorf("invalid --summary %q (expected %s)", mode, strings.Join(summaryModes, ", "))
+	}
+	switch shaDisplay {
+	case "short", "full", "none":
+	default:
+		return nil, fmt.Errorf("invalid --sha-display %q (expected short, full or none)", shaDisplay)
+	}
+
+	selected := splitList(strings.ToLower(columns))
+	if len(selected) == 0 {
+		selected = summaryColumnOrder
+	}
+
+	var out []string
+	for _, c := range selected {
+		if _, ok := summaryColumnTitles[c]; !ok {
+			return nil, fmt.Errorf("unknown summary column %q (available: %s)", c, strings.Join(summaryColumnOrder, ", "))
+		}
+		if c == "sha256" && shaDisplay == "none" {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// formatSHA shortens a digest according to --sha-display
+func formatSHA(sum, mode string) string {
+	if mode == "short" && len(sum) > shortSHALength {
+		return sum[:shortSHALength]
+	}
+	return sum
+}
+
+// cell returns the value of a summary column for a row
+func (r summaryRow) cell(column, shaDisplay string) string {
+	switch column {
+	case "file":
+		return r.File
+	case "target":
+		return r.Target
+	case "size":
+		if !r.Success {
+			return "n/a"
+		}
+		return fmt.Sprintf("%s (%d)", fsutil.HumanSizeBytes(r.Size), r.Size)
+	case "sha256":
+		if r.SHA256 == "" {
+			return "n/a"
+		}
+		return formatSHA(r.SHA256, shaDisplay)
+	case "status":
+		return r.status
+	}
+	return ""
+}
+
+// newGridTable returns a table that renders the inner grid only, no outer frame
+func newGridTable(w io.Writer) *tablewriter.Table {
+	return tablewriter.NewTable(
+		w,
+		tablewriter.WithRenderer(renderer.NewBlueprint(tw.Rendition{
+			Borders:  tw.BorderNone,
+			Symbols:  ui.TableSymbols(),
+			Settings: tw.Settings{Separators: tw.Separators{BetweenColumns: tw.On, BetweenRows: tw.On}},
+		})),
+	)
+}
+
+// renderSummary prints the artifacts in the --summary layout: one table,
+// a table per GOOS with subtotals, or one line per GOOS
+func renderSummary(rows []summaryRow, columns []string, shaDisplay, mode string) {
+	switch mode {
+	case "group-by-os":
+		for i, g := range groupByOS(rows) {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s: %s\n", g.goos, g.subtotal())
+			renderTable(g.rows, columns, shaDisplay)
+		}
+	case "compact":
+		tbl := newGridTable(os.Stdout)
+		tbl.Header([]string{"GOOS", "Total", "Success", "Failed", "Skipped", "Size"})
+		var data [][]any
+		for _, g := range groupByOS(rows) {
+			data = append(data, []any{g.goos, len(g.rows), g.successCount, g.failCount, g.skipCount, fsutil.HumanSizeBytes(g.size)})
+		}
+		_ = tbl.Bulk(data)
+		_ = tbl.Render()
+	default:
+		renderTable(rows, columns, shaDisplay)
+	}
+}
+
+// renderTable prints the artifacts table with the selected columns
+func renderTable(rows []summaryRow, columns []string, shaDisplay string) {
+	tbl := newGridTable(os.Stdout)
+
+	header := make([]string, 0, len(columns))
+	for _, c := range columns {
+		header = append(header, summaryColumnTitles[c])
+	}
+	tbl.Header(header)
+
+	data := make([][]any, 0, len(rows))
+	for _, r := range rows {
+		line := make([]any, 0, len(columns))
+		for _, c := range columns {
+			line = append(line, r.cell(c, shaDisplay))
+		}
+		data = append(data, line)
+	}
+	_ = tbl.Bulk(data)
+	_ = tbl.Render()
+}
+
+// osGroup holds the summary rows of one GOOS and their subtotals
+type osGroup struct {
+	goos                               string
+	rows                               []summaryRow
+	successCount, failCount, skipCount int
+	size                               int64
+}
+
+// groupByOS splits the rows by GOOS, sorted by name
+func groupByOS(rows []summaryRow) []*osGroup {
+	byOS := make(map[string]*osGroup)
+	var groups []*osGroup
+	for _, r := range rows {
+		goos, _, _ := strings.Cut(r.Target, "/")
+		g := byOS[goos]
+		if g == nil {
+			g = &osGroup{goos: goos}
+			byOS[goos] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, r)
+		switch {
+		case r.Success:
+			g.successCount++
+			g.size += r.Size
+		case r.Skipped:
+			g.skipCount++
+		default:
+			g.failCount++
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].goos < groups[j].goos })
+	return groups
+}
+
+// subtotal summarizes the group, e.g. "4 targets, 3 ok, 1 failed, 12.3 MiB"
+func (g *osGroup) subtotal() string {
+	total := fmt.Sprintf("%d targets", len(g.rows))
+	if len(g.rows) == 1 {
+		total = "1 target"
+	}
+	parts := []string{total, fmt.Sprintf("%d ok", g.successCount)}
+	if g.failCount > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", g.failCount))
+	}
+	if g.skipCount > 0 {
+		parts = append(parts, fmt.Sprintf("%d skipped", g.skipCount))
+	}
+	return strings.Join(append(parts, fsutil.HumanSizeBytes(g.size)), ", ")
+}
+
+// printFailureLogs lists where the full output of each failed target was saved
+func printFailureLogs(rows []summaryRow, versionDir string) {
+	printed := false
+	for _, r := range rows {
+		// --very-verbose keeps the logs of successful builds too
+		if r.Log == "" || r.Success {
+			continue
+		}
+		if !printed {
+			fmt.Println("\nFailure logs:")
+			printed = true
+		}
+		fmt.Printf("  %s %s: %s\n", ui.Fail(), r.Target, filepath.Join(versionDir, filepath.FromSlash(r.Log)))
+	}
+}
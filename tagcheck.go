@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"pbuild/gitmeta"
+)
+
+// checkVersionTag compares the version in the source with the version tags
+// of HEAD, catching a tag made before the version variable was bumped.
+// Tags of a module in a subdirectory carry its path, e.g. tools/v1.2.0.
+func checkVersionTag(p *project) error {
+	switch flagTagCheck {
+	case "off":
+		return nil
+	case "warn", "error":
+	default:
+		return fmt.Errorf("invalid --tag-check %q (expected warn, error or off)", flagTagCheck)
+	}
+	version := p.srcVersion
+	if version == "" {
+		return nil
+	}
+	tags, err := gitmeta.TagsAt(p.gitRoot)
+	if err != nil {
+		return nil
+	}
+	prefix := ""
+	if rel, err := filepath.Rel(p.gitRoot, p.workDir); err == nil && rel != "." {
+		prefix = filepath.ToSlash(rel) + "/"
+	}
+
+	var mismatched []string
+	for _, tag := range tags {
+		v, ok := tagVersion(tag, prefix)
+		if !ok {
+			continue
+		}
+		if v == strings.TrimPrefix(version, "v") {
+			return nil
+		}
+		mismatched = append(mismatched, tag)
+	}
+	if len(mismatched) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("HEAD is tagged %s, but the source says version %s; was the version bumped before tagging?",
+		strings.Join(mismatched, ", "), version)
+	if flagTagCheck == "error" {
+		return errors.New(msg)
+	}
+	fmt.Printf("Warning: %s\n", msg)
+	return nil
+}
+
+// tagVersion returns the version of a tag like v1.2.0 or <prefix>v1.2.0;
+// ok is false for tags that do not name a version
+func tagVersion(tag, prefix string) (string, bool) {
+	if prefix != "" {
+		var found bool
+		if tag, found = strings.CutPrefix(tag, prefix); !found {
+			return "", false
+		}
+	}
+	v := strings.TrimPrefix(tag, "v")
+	if v == "" || v[0] < '0' || v[0] > '9' {
+		return "", false
+	}
+	return v, true
+}
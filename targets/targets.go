@@ -1,9 +1,46 @@
 package targets
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 type Target struct{ OS, Arch string }
 
+// DarwinUniversal is the pseudo-architecture of a merged darwin/amd64 + darwin/arm64 binary
+const DarwinUniversal = "universal"
+
+// String returns the target in GOOS/GOARCH form
+func (t Target) String() string {
+	return t.OS + "/" + t.Arch
+}
+
+// unixOS lists the GOOS values that satisfy the "unix" build constraint
+var unixOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true, "hurd": true,
+	"illumos": true, "ios": true, "linux": true, "netbsd": true, "openbsd": true, "solaris": true,
+}
+
+// Satisfies evaluates a build constraint tag for the target the way the go tool does
+func (t Target) Satisfies(tag string, cgo bool) bool {
+	switch {
+	case tag == t.OS || tag == t.Arch:
+		return true
+	case tag == "unix":
+		return unixOS[t.OS]
+	case tag == "cgo":
+		return cgo
+	case tag == "linux":
+		return t.OS == "android"
+	case tag == "darwin":
+		return t.OS == "ios"
+	case tag == "solaris":
+		return t.OS == "illumos"
+	}
+	return false
+}
+
 func Default() []Target {
 	return []Target{
 		{"linux", "amd64"},
@@ -24,13 +61,299 @@ func Default() []Target {
 	}
 }
 
-func OutputName(project string, t Target) string {
-	ext := ""
-	if t.OS == "windows" {
-		ext = ".exe"
+// Exotic returns opt-in targets for less common architectures
+func Exotic() []Target {
+	return []Target{
+		{"linux", "386"},
+		{"linux", "arm"},
+		{"linux", "loong64"},
+		{"linux", "mips"},
+		{"linux", "mipsle"},
+		{"linux", "mips64"},
+		{"linux", "mips64le"},
+		{"linux", "ppc64"},
+		{"linux", "ppc64le"},
+		{"linux", "s390x"},
+		{"windows", "386"},
+		{"freebsd", "386"},
+		{"freebsd", "arm"},
+		{"openbsd", "ppc64"},
+		{"netbsd", "arm"},
+	}
+}
+
+// Desktop returns targets for desktop operating systems
+func Desktop() []Target {
+	return []Target{
+		{"linux", "amd64"},
+		{"linux", "arm64"},
+		{"windows", "amd64"},
+		{"windows", "arm64"},
+		{"darwin", "amd64"},
+		{"darwin", "arm64"},
+	}
+}
+
+// Server returns targets commonly deployed on servers
+func Server() []Target {
+	return []Target{
+		{"linux", "amd64"},
+		{"linux", "arm64"},
+		{"linux", "riscv64"},
+		{"linux", "ppc64le"},
+		{"linux", "s390x"},
+		{"freebsd", "amd64"},
+		{"freebsd", "arm64"},
+	}
+}
+
+// BSD returns targets for the BSD family
+func BSD() []Target {
+	return []Target{
+		{"freebsd", "amd64"},
+		{"freebsd", "arm64"},
+		{"freebsd", "riscv64"},
+		{"openbsd", "amd64"},
+		{"openbsd", "arm64"},
+		{"openbsd", "riscv64"},
+		{"netbsd", "amd64"},
+		{"netbsd", "arm64"},
+		{"dragonfly", "amd64"},
+	}
+}
+
+// Mobile returns Android and iOS targets, which link externally and need
+// CGO with an NDK or Xcode toolchain (--strategy flexible)
+func Mobile() []Target {
+	return []Target{
+		{"android", "arm64"},
+		{"android", "amd64"},
+		{"ios", "arm64"},
+	}
+}
+
+// WASM returns the WebAssembly targets
+func WASM() []Target {
+	return []Target{
+		{"js", "wasm"},
+		{"wasip1", "wasm"},
+	}
+}
+
+// All returns the union of every other built-in group
+func All() []Target {
+	var all []Target
+	seen := make(map[Target]bool)
+	for _, name := range GroupNames() {
+		if name == "all" {
+			continue
+		}
+		for _, t := range groups[name]() {
+			if !seen[t] {
+				seen[t] = true
+				all = append(all, t)
+			}
+		}
+	}
+	return all
+}
+
+// groups maps target group names to their target lists
+var groups map[string]func() []Target
+
+func init() {
+	groups = map[string]func() []Target{
+		"default": Default,
+		"desktop": Desktop,
+		"server":  Server,
+		"bsd":     BSD,
+		"mobile":  Mobile,
+		"wasm":    WASM,
+		"exotic":  Exotic,
+		"all":     All,
+	}
+}
+
+// Group returns the targets of a named group
+func Group(name string) ([]Target, error) {
+	g, ok := groups[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown target group %q (available: %s)", name, strings.Join(GroupNames(), ", "))
+	}
+	return g(), nil
+}
+
+// ParseList parses a list of GOOS/GOARCH strings
+func ParseList(list []string) ([]Target, error) {
+	out := make([]Target, 0, len(list))
+	for _, s := range list {
+		t, err := Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// GroupNames returns the sorted names of all target groups
+func GroupNames() []string {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// supported lists the GOOS/GOARCH pairs accepted by the Go toolchain (go tool dist list)
+var supported = map[Target]bool{
+	{"aix", "ppc64"}:       true,
+	{"android", "386"}:     true,
+	{"android", "amd64"}:   true,
+	{"android", "arm"}:     true,
+	{"android", "arm64"}:   true,
+	{"darwin", "amd64"}:    true,
+	{"darwin", "arm64"}:    true,
+	{"dragonfly", "amd64"}: true,
+	{"freebsd", "386"}:     true,
+	{"freebsd", "amd64"}:   true,
+	{"freebsd", "arm"}:     true,
+	{"freebsd", "arm64"}:   true,
+	{"freebsd", "riscv64"}: true,
+	{"illumos", "amd64"}:   true,
+	{"ios", "amd64"}:       true,
+	{"ios", "arm64"}:       true,
+	{"js", "wasm"}:         true,
+	{"linux", "386"}:       true,
+	{"linux", "amd64"}:     true,
+	{"linux", "arm"}:       true,
+	{"linux", "arm64"}:     true,
+	{"linux", "loong64"}:   true,
+	{"linux", "mips"}:      true,
+	{"linux", "mips64"}:    true,
+	{"linux", "mips64le"}:  true,
+	{"linux", "mipsle"}:    true,
+	{"linux", "ppc64"}:     true,
+	{"linux", "ppc64le"}:   true,
+	{"linux", "riscv64"}:   true,
+	{"linux", "s390x"}:     true,
+	{"netbsd", "386"}:      true,
+	{"netbsd", "amd64"}:    true,
+	{"netbsd", "arm"}:      true,
+	{"netbsd", "arm64"}:    true,
+	{"openbsd", "386"}:     true,
+	{"openbsd", "amd64"}:   true,
+	{"openbsd", "arm"}:     true,
+	{"openbsd", "arm64"}:   true,
+	{"openbsd", "ppc64"}:   true,
+	{"openbsd", "riscv64"}: true,
+	{"plan9", "386"}:       true,
+	{"plan9", "amd64"}:     true,
+	{"plan9", "arm"}:       true,
+	{"solaris", "amd64"}:   true,
+	{"wasip1", "wasm"}:     true,
+	{"windows", "386"}:     true,
+	{"windows", "amd64"}:   true,
+	{"windows", "arm64"}:   true,
+}
+
+// Supported reports whether the target is a valid GOOS/GOARCH pair
+func Supported(t Target) bool {
+	if t.OS == "darwin" && t.Arch == DarwinUniversal {
+		return true
+	}
+	return supported[t]
+}
+
+// buildModeTargets lists where the go tool accepts the build modes that are
+// not available everywhere, as in Go's internal/platform
+var buildModeTargets = map[string][]string{
+	"c-archive": {"aix/*", "darwin/*", "ios/*", "windows/*", "freebsd/amd64",
+		"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64", "linux/ppc64le", "linux/riscv64", "linux/s390x"},
+	"c-shared": {"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64", "linux/ppc64le", "linux/riscv64", "linux/s390x",
+		"android/386", "android/amd64", "android/arm", "android/arm64", "freebsd/amd64", "darwin/amd64", "darwin/arm64",
+		"windows/386", "windows/amd64", "windows/arm64", "wasip1/wasm"},
+	"pie": {"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64", "linux/ppc64le", "linux/riscv64", "linux/s390x",
+		"android/386", "android/amd64", "android/arm", "android/arm64", "freebsd/amd64", "darwin/amd64", "darwin/arm64",
+		"ios/amd64", "ios/arm64", "aix/ppc64", "openbsd/arm64", "windows/386", "windows/amd64", "windows/arm", "windows/arm64"},
+}
+
+// BuildModeSupported reports whether go build accepts -buildmode=mode for the
+// target; exe is available everywhere
+func BuildModeSupported(mode string, t Target) bool {
+	list, ok := buildModeTargets[mode]
+	if !ok {
+		return true
+	}
+	for _, s := range list {
+		if s == t.String() || s == t.OS+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses a GOOS/GOARCH string and validates it against the supported set
+func Parse(s string) (Target, error) {
+	osName, arch, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok || osName == "" || arch == "" {
+		return Target{}, fmt.Errorf("invalid target %q, expected GOOS/GOARCH", s)
+	}
+	t := Target{OS: strings.ToLower(osName), Arch: strings.ToLower(arch)}
+	if !Supported(t) {
+		return Target{}, fmt.Errorf("unsupported target %s", t)
 	}
+	return t, nil
+}
+
+func OutputName(project string, t Target) string {
+	ext := outputExt(t)
 	if (t.OS == "windows" && t.Arch == "amd64") || (t.OS == "linux" && t.Arch == "amd64") {
 		return project + ext
 	}
+	return qualifiedName(project, t, ext)
+}
+
+// outputExt is the file extension the target's binaries need
+func outputExt(t Target) string {
+	if t.OS == "windows" {
+		return ".exe"
+	} else if t.Arch == "wasm" {
+		return ".wasm"
+	}
+	return ""
+}
+
+// qualifiedName is the <project>-<arch>-<os><ext> form, unique per target
+func qualifiedName(project string, t Target, ext string) string {
 	return fmt.Sprintf("%s-%s-%s%s", project, t.Arch, t.OS, ext)
 }
+
+// OutputNames assigns an output file name to every target of the matrix.
+// Targets whose OutputName collides with another's (compared case-insensitively,
+// as macOS and Windows filesystems do) fall back to the qualified
+// <project>-<arch>-<os> form; a collision that remains is an error.
+func OutputNames(project string, matrix []Target) (map[Target]string, error) {
+	names := make(map[Target]string, len(matrix))
+	count := make(map[string]int)
+	for _, t := range matrix {
+		names[t] = OutputName(project, t)
+		count[strings.ToLower(names[t])]++
+	}
+	for _, t := range matrix {
+		if count[strings.ToLower(names[t])] > 1 {
+			names[t] = qualifiedName(project, t, outputExt(t))
+		}
+	}
+
+	owner := make(map[string]Target, len(matrix))
+	for _, t := range matrix {
+		key := strings.ToLower(names[t])
+		if other, ok := owner[key]; ok {
+			return nil, fmt.Errorf("targets %s and %s both produce %s", other, t, names[t])
+		}
+		owner[key] = t
+	}
+	return names, nil
+}
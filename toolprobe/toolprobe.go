@@ -0,0 +1,308 @@
+package toolprobe
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"pbuild/fsutil"
+	"pbuild/runner"
+	"pbuild/targets"
+)
+
+// StateFile is the probe cache below pbuild's cache directory
+const StateFile = "toolchains.json"
+
+// Dist is one entry of go tool dist list -json -broken
+type Dist struct {
+	GOOS         string `json:"GOOS"`
+	GOARCH       string `json:"GOARCH"`
+	CgoSupported bool   `json:"CgoSupported"`
+	FirstClass   bool   `json:"FirstClass"`
+	// Broken ports still build but are not maintained
+	Broken bool `json:"Broken,omitempty"`
+}
+
+// Toolchain is what was probed of one Go toolchain version
+type Toolchain struct {
+	Version string `json:"version"`
+	Dists   []Dist `json:"dists"`
+	// BuildModes maps a build mode and a GOOS/GOARCH to whether go build
+	// accepts it, filled in as pbuild asks
+	BuildModes map[string]map[string]bool `json:"build_modes,omitempty"`
+	Probed     time.Time                  `json:"probed"`
+}
+
+// Supports reports whether the toolchain lists the target; darwin/universal
+// is supported with both of its halves
+func (tc *Toolchain) Supports(t targets.Target) bool {
+	if t.OS == "darwin" && t.Arch == targets.DarwinUniversal {
+		return tc.Supports(targets.Target{OS: "darwin", Arch: "amd64"}) && tc.Supports(targets.Target{OS: "darwin", Arch: "arm64"})
+	}
+	_, ok := tc.dist(t)
+	return ok
+}
+
+// Broken reports whether the toolchain marks the target as a broken port
+func (tc *Toolchain) Broken(t targets.Target) bool {
+	d, ok := tc.dist(t)
+	return ok && d.Broken
+}
+
+func (tc *Toolchain) dist(t targets.Target) (Dist, bool) {
+	for _, d := range tc.Dists {
+		if d.GOOS == t.OS && d.GOARCH == t.Arch {
+			return d, true
+		}
+	}
+	return Dist{}, false
+}
+
+// Zig is the zig compiler found in PATH
+type Zig struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	// Binary identifies the executable, so an upgrade is probed again
+	Binary string `json:"binary"`
+}
+
+// state is the content of StateFile
+type state struct {
+	// Commands maps the fingerprint of a go command, its module's go and
+	// toolchain lines included, to the version it reported
+	Commands   map[string]string     `json:"commands"`
+	Toolchains map[string]*Toolchain `json:"toolchains"`
+	Zig        *Zig                  `json:"zig,omitempty"`
+}
+
+// Prober answers questions about the toolchain from the state file and only
+// runs the go command, or zig, for what it has not recorded yet. The go
+// command is identified without running it, by its executable and what
+// selects the toolchain it runs: GOTOOLCHAIN, GOROOT and the module's go.mod.
+type Prober struct {
+	// Dir is the module directory the go command runs in
+	Dir string
+	// Path is the state file; empty keeps the results for this run only
+	Path string
+	// Refresh probes everything again, replacing what the file recorded of
+	// this toolchain
+	Refresh bool
+	Runner  runner.Runner
+
+	mu    sync.Mutex
+	st    *state
+	tc    *Toolchain
+	dirty bool
+}
+
+// New returns a prober for the module in dir caching in path
+func New(path, dir string) *Prober {
+	return &Prober{Dir: dir, Path: path}
+}
+
+func (p *Prober) load() *state {
+	if p.st != nil {
+		return p.st
+	}
+	p.st = &state{}
+	if data, err := os.ReadFile(p.Path); err == nil {
+		_ = json.Unmarshal(data, p.st) // a corrupt file is probed again
+	}
+	if p.st.Commands == nil {
+		p.st.Commands = make(map[string]string)
+	}
+	if p.st.Toolchains == nil {
+		p.st.Toolchains = make(map[string]*Toolchain)
+	}
+	return p.st
+}
+
+// Toolchain returns the version and target list of the go command
+func (p *Prober) Toolchain(ctx context.Context) (*Toolchain, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.toolchain(ctx)
+}
+
+func (p *Prober) toolchain(ctx context.Context) (*Toolchain, error) {
+	if p.tc != nil {
+		return p.tc, nil
+	}
+	st := p.load()
+	fp, err := p.goFingerprint()
+	if err != nil {
+		return nil, err
+	}
+	if tc := st.Toolchains[st.Commands[fp]]; tc != nil && !p.Refresh {
+		p.tc = tc
+		return tc, nil
+	}
+
+	out, err := runner.Output(ctx, p.Runner, &runner.Cmd{Name: "go", Args: []string{"env", "GOVERSION"}, Dir: p.Dir})
+	if err != nil {
+		return nil, fmt.Errorf("go env GOVERSION failed: %v", err)
+	}
+	version := strings.TrimSpace(string(out))
+	tc := st.Toolchains[version]
+	if tc == nil || p.Refresh {
+		out, err = runner.Output(ctx, p.Runner, &runner.Cmd{Name: "go", Args: []string{"tool", "dist", "list", "-json", "-broken"}, Dir: p.Dir})
+		if err != nil {
+			return nil, fmt.Errorf("go tool dist list failed: %v", err)
+		}
+		tc = &Toolchain{Version: version, Probed: time.Now().UTC()}
+		if err := json.Unmarshal(out, &tc.Dists); err != nil {
+			return nil, fmt.Errorf("failed to parse go tool dist list output: %v", err)
+		}
+		st.Toolchains[version] = tc
+	}
+	st.Commands[fp] = version
+	p.tc, p.dirty = tc, true
+	return tc, nil
+}
+
+// BuildModeSupported reports whether go build accepts -buildmode=mode for
+// the target. The go command refuses an unsupported mode before it loads any
+// package, so go build -n of a missing package answers without building.
+func (p *Prober) BuildModeSupported(ctx context.Context, mode string, t targets.Target) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tc, err := p.toolchain(ctx)
+	if err != nil {
+		return false, err
+	}
+	if ok, found := tc.BuildModes[mode][t.String()]; found {
+		return ok, nil
+	}
+	env := append(os.Environ(), "GOOS="+t.OS, "GOARCH="+t.Arch, "GOFLAGS=")
+	out, _ := runner.CombinedOutput(ctx, p.Runner, &runner.Cmd{
+		Name: "go", Args: []string{"build", "-n", "-buildmode=" + mode, "./.pbuild-probe"}, Dir: p.Dir, Env: env,
+	})
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	ok := !strings.Contains(string(out), "-buildmode="+mode+" not supported on")
+	if tc.BuildModes == nil {
+		tc.BuildModes = make(map[string]map[string]bool)
+	}
+	if tc.BuildModes[mode] == nil {
+		tc.BuildModes[mode] = make(map[string]bool)
+	}
+	tc.BuildModes[mode][t.String()] = ok
+	p.dirty = true
+	return ok, nil
+}
+
+// Zig returns the zig compiler in PATH and its version; false without one
+func (p *Prober) Zig(ctx context.Context) (*Zig, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := p.load()
+	path, err := exec.LookPath("zig")
+	if err != nil {
+		return nil, false
+	}
+	id, err := binaryID(path)
+	if err != nil {
+		return nil, false
+	}
+	if st.Zig != nil && st.Zig.Path == path && st.Zig.Binary == id && !p.Refresh {
+		return st.Zig, true
+	}
+	out, _ := runner.Output(ctx, p.Runner, &runner.Cmd{Name: path, Args: []string{"version"}})
+	st.Zig = &Zig{Path: path, Version: strings.TrimSpace(string(out)), Binary: id}
+	p.dirty = true
+	return st.Zig, true
+}
+
+// Save writes the state file when something new was probed
+func (p *Prober) Save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.dirty || p.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.Path), 0o755); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(p.Path, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	p.dirty = false
+	return nil
+}
+
+// goFingerprint identifies the toolchain the go command in PATH runs in
+// Dir, with the go env -w settings that can select another one
+func (p *Prober) goFingerprint() (string, error) {
+	path, err := exec.LookPath("go")
+	if err != nil {
+		return "", err
+	}
+	id, err := binaryID(path)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\nGOTOOLCHAIN=%s\nGOROOT=%s\n", path, id, os.Getenv("GOTOOLCHAIN"), os.Getenv("GOROOT"))
+	if env := goEnvFile(); env != "" {
+		envID, _ := binaryID(env)
+		fmt.Fprintf(h, "GOENV=%s %s\n", env, envID)
+	}
+	for _, line := range goModLines(p.Dir) {
+		fmt.Fprintln(h, line)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// goEnvFile is the file go env -w writes to
+func goEnvFile() string {
+	if env := os.Getenv("GOENV"); env != "" {
+		return env
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go", "env")
+}
+
+// binaryID is the size and modification time of a file
+func binaryID(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", fi.Size(), fi.ModTime().UnixNano()), nil
+}
+
+// goModLines are the go and toolchain directives of the go.mod in dir, which
+// let the go command switch toolchains
+func goModLines(dir string) []string {
+	f, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "go ") || strings.HasPrefix(line, "toolchain ") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"pbuild/cache"
+	"pbuild/targets"
+	"pbuild/toolprobe"
+)
+
+// newToolProber returns the toolchain prober for the module in workDir,
+// caching its results in pbuild's cache directory
+func newToolProber(workDir string) *toolprobe.Prober {
+	path := ""
+	if dir, err := cache.Dir(); err == nil {
+		path = filepath.Join(dir, cache.Probes, toolprobe.StateFile)
+	}
+	return toolprobe.New(path, workDir)
+}
+
+// saveProbes keeps what was probed for the next run; failing to only costs
+// that run the probes again
+func saveProbes(pr *toolprobe.Prober) {
+	if err := pr.Save(); err != nil && flagVerbose {
+		fmt.Printf("Warning: Failed to cache the toolchain probes: %v\n", err)
+	}
+}
+
+// checkToolchainTargets checks the matrix against the targets of the go
+// command's toolchain, which can be older or newer than pbuild's list. A
+// missing go command is left to the build to report.
+func checkToolchainTargets(p *project, matrix []targets.Target) error {
+	tc, err := p.probes.Toolchain(context.Background())
+	if err != nil {
+		return nil
+	}
+	defer saveProbes(p.probes)
+	var missing []string
+	for _, t := range matrix {
+		if !tc.Supports(t) {
+			missing = append(missing, t.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s does not support %s (see go tool dist list)", tc.Version, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// buildModeSupported asks the toolchain whether it accepts -buildmode=mode
+// for the target, falling back to pbuild's table when it cannot be asked
+func buildModeSupported(p *project, mode string, t targets.Target) bool {
+	if mode == "exe" || mode == "default" {
+		return true
+	}
+	ok, err := p.probes.BuildModeSupported(context.Background(), mode, t)
+	if err != nil {
+		return targets.BuildModeSupported(mode, t)
+	}
+	return ok
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pbuild/cache"
+	"pbuild/tools"
+)
+
+// installTools installs the pinned tools: of pbuild.yaml and puts their bin
+// directories first on PATH, so go generate and plugins run those versions
+func installTools(p *project) error {
+	if len(p.cfg.Tools) == 0 {
+		return nil
+	}
+	dir, err := cache.Dir()
+	if err != nil {
+		return fmt.Errorf("no directory for tools: %v", err)
+	}
+	root := filepath.Join(dir, cache.Tools)
+
+	var bins []string
+	for _, spec := range p.cfg.Tools {
+		t, err := tools.Parse(spec)
+		if err != nil {
+			return err
+		}
+		bin, installed, err := tools.Install(context.Background(), root, t)
+		if err != nil {
+			return err
+		}
+		if installed {
+			fmt.Printf("Installed %s\n", t)
+		} else if flagVerbose {
+			fmt.Printf("Using %s from %s\n", t, bin)
+		}
+		bins = append(bins, bin)
+	}
+	return os.Setenv("PATH", strings.Join(append(bins, os.Getenv("PATH")), string(os.PathListSeparator)))
+}
@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Tool is a Go command pinned to a version, installed with go install pkg@version
+type Tool struct {
+	Package string
+	Version string
+}
+
+// majorSuffix matches the /vN element of a major version import path
+var majorSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// Parse reads a tools: entry, e.g. golang.org/x/tools/cmd/stringer@v0.24.0.
+// The version is required and may not be latest, which is the point of pinning.
+func Parse(spec string) (Tool, error) {
+	pkg, version, ok := strings.Cut(strings.TrimSpace(spec), "@")
+	if !ok || pkg == "" || version == "" {
+		return Tool{}, fmt.Errorf("tool %q: expected package@version", spec)
+	}
+	if version == "latest" || version == "upgrade" || version == "patch" {
+		return Tool{}, fmt.Errorf("tool %q: pin a version instead of %s", spec, version)
+	}
+	return Tool{Package: pkg, Version: version}, nil
+}
+
+func (t Tool) String() string { return t.Package + "@" + t.Version }
+
+// Name is the binary go install produces: the last element of the package
+// path, skipping a major version suffix like /v2
+func (t Tool) Name() string {
+	name := path.Base(t.Package)
+	if dir := path.Dir(t.Package); majorSuffix.MatchString(name) && dir != "." {
+		name = path.Base(dir)
+	}
+	return name
+}
+
+// Dir is the tool's own bin directory below root
+func (t Tool) Dir(root string) string {
+	return filepath.Join(root, strings.ReplaceAll(t.Package, "/", "_")+"@"+t.Version)
+}
+
+// Install makes sure the tool is in its bin directory below root and returns
+// that directory; installed reports whether go install had to run. A pinned
+// version never changes, so an existing binary is reused.
+func Install(ctx context.Context, root string, t Tool) (dir string, installed bool, err error) {
+	dir = t.Dir(root)
+	exe := t.Name()
+	if runtime.GOOS == "windows" {
+		exe += ".exe"
+	}
+	if _, err := os.Stat(filepath.Join(dir, exe)); err == nil {
+		// Mark it used, so pbuild cache trim keeps it
+		now := time.Now()
+		_ = os.Chtimes(dir, now, now)
+		return dir, false, nil
+	}
+
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", false, err
+	}
+	tmp, err := os.MkdirTemp(root, ".install-")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.RemoveAll(tmp)
+
+	cmd := exec.CommandContext(ctx, "go", "install", t.String())
+	cmd.Dir = root
+	// Tools run on this machine, whatever GOOS/GOARCH the environment asks
+	// for, and GOFLAGS like -mod=vendor do not apply outside the module
+	cmd.Env = append(os.Environ(), "GOBIN="+tmp, "GOOS="+runtime.GOOS, "GOARCH="+runtime.GOARCH, "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", false, fmt.Errorf("go install %s failed: %v\n%s", t, err, out)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, exe)); err != nil {
+		return "", false, fmt.Errorf("go install %s did not produce %s", t, exe)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		// Another run installed the same version first
+		if _, statErr := os.Stat(filepath.Join(dir, exe)); statErr == nil {
+			return dir, false, nil
+		}
+		return "", false, err
+	}
+	return dir, true, nil
+}
@@ -0,0 +1,217 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options are the optional parts of a torrent
+type Options struct {
+	// Trackers are announce URLs; the first is the primary tracker
+	Trackers []string
+	// WebSeeds are BEP 19 URLs the torrent's directory is served below; the
+	// torrent name and the file path are appended to them
+	WebSeeds  []string
+	CreatedBy string
+	// PieceLength is chosen from the total size when zero
+	PieceLength int64
+}
+
+// Torrent is an encoded .torrent file
+type Torrent struct {
+	Data []byte
+	// InfoHash is the hex SHA-1 of the info dictionary
+	InfoHash string
+	Name     string
+	Trackers []string
+}
+
+// Magnet returns the magnet link of the torrent
+func (t *Torrent) Magnet() string {
+	link := "magnet:?xt=urn:btih:" + t.InfoHash + "&dn=" + url.QueryEscape(t.Name)
+	for _, tr := range t.Trackers {
+		link += "&tr=" + url.QueryEscape(tr)
+	}
+	return link
+}
+
+// Create builds a multi-file torrent named name of files, which are
+// slash-separated paths relative to dir
+func Create(dir, name string, files []string, opts Options) (*Torrent, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files to share")
+	}
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+
+	var total int64
+	for _, f := range files {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return nil, err
+		}
+		total += info.Size()
+	}
+	pieceLength := opts.PieceLength
+	if pieceLength <= 0 {
+		pieceLength = PieceLength(total)
+	}
+
+	h := newHasher(pieceLength)
+	var entries []any
+	for _, f := range files {
+		size, err := h.addFile(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return nil, err
+		}
+		var path []any
+		for _, part := range strings.Split(f, "/") {
+			path = append(path, part)
+		}
+		entries = append(entries, map[string]any{"length": size, "path": path})
+	}
+
+	info := map[string]any{
+		"name":         name,
+		"piece length": pieceLength,
+		"pieces":       h.pieces(),
+		"files":        entries,
+	}
+	infoData := encode(info)
+	sum := sha1.Sum(infoData)
+
+	meta := map[string]any{
+		"info":          rawValue(infoData),
+		"creation date": time.Now().Unix(),
+	}
+	if opts.CreatedBy != "" {
+		meta["created by"] = opts.CreatedBy
+	}
+	if len(opts.Trackers) > 0 {
+		meta["announce"] = opts.Trackers[0]
+		var tiers []any
+		for _, tr := range opts.Trackers {
+			tiers = append(tiers, []any{tr})
+		}
+		meta["announce-list"] = tiers
+	}
+	if len(opts.WebSeeds) > 0 {
+		var seeds []any
+		for _, s := range opts.WebSeeds {
+			if !strings.HasSuffix(s, "/") {
+				s += "/"
+			}
+			seeds = append(seeds, s)
+		}
+		meta["url-list"] = seeds
+	}
+	return &Torrent{Data: encode(meta), InfoHash: hex.EncodeToString(sum[:]), Name: name, Trackers: opts.Trackers}, nil
+}
+
+// PieceLength picks a power of two between 16KiB and 16MiB that splits total
+// into roughly 1500 pieces
+func PieceLength(total int64) int64 {
+	length := int64(16 << 10)
+	for length < 16<<20 && total/length > 1500 {
+		length *= 2
+	}
+	return length
+}
+
+// hasher hashes the concatenated files in fixed-size pieces
+type hasher struct {
+	length int64
+	buf    []byte
+	sums   bytes.Buffer
+}
+
+func newHasher(length int64) *hasher {
+	return &hasher{length: length, buf: make([]byte, 0, length)}
+}
+
+func (h *hasher) addFile(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var size int64
+	for {
+		n, err := f.Read(h.buf[len(h.buf):cap(h.buf)])
+		h.buf = h.buf[:len(h.buf)+n]
+		size += int64(n)
+		if len(h.buf) == cap(h.buf) {
+			h.flush()
+		}
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	}
+}
+
+func (h *hasher) flush() {
+	sum := sha1.Sum(h.buf)
+	h.sums.Write(sum[:])
+	h.buf = h.buf[:0]
+}
+
+// pieces returns the concatenated piece hashes, including the last short piece
+func (h *hasher) pieces() string {
+	if len(h.buf) > 0 {
+		h.flush()
+	}
+	return h.sums.String()
+}
+
+// rawValue is an already encoded value
+type rawValue []byte
+
+// encode bencodes strings, integers, lists and dictionaries with sorted keys
+func encode(v any) []byte {
+	var b bytes.Buffer
+	encodeTo(&b, v)
+	return b.Bytes()
+}
+
+func encodeTo(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case rawValue:
+		b.Write(v)
+	case string:
+		fmt.Fprintf(b, "%d:%s", len(v), v)
+	case int64:
+		fmt.Fprintf(b, "i%de", v)
+	case []any:
+		b.WriteByte('l')
+		for _, item := range v {
+			encodeTo(b, item)
+		}
+		b.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('d')
+		for _, k := range keys {
+			encodeTo(b, k)
+			encodeTo(b, v[k])
+		}
+		b.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("bencode: unsupported type %T", v))
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package ui
+
+import "os"
+
+// enableVT reports whether the terminal f writes to handles ANSI escapes,
+// which every terminal outside the Windows console does
+func enableVT(f *os.File) bool {
+	return true
+}
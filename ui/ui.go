@@ -0,0 +1,122 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"github.com/olekukonko/tablewriter/tw"
+)
+
+// ColorMode controls when ANSI colors are written
+type ColorMode int
+
+const (
+	// ColorAuto enables colors only on a terminal and when NO_COLOR is unset
+	ColorAuto ColorMode = iota
+	// ColorAlways forces colors even when output is redirected
+	ColorAlways
+	// ColorNever disables colors
+	ColorNever
+)
+
+// ParseColorMode converts a --color value to a ColorMode
+func ParseColorMode(s string) (ColorMode, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return ColorAuto, nil
+	case "always":
+		return ColorAlways, nil
+	case "never":
+		return ColorNever, nil
+	default:
+		return ColorAuto, fmt.Errorf("invalid color mode %q (expected auto, always or never)", s)
+	}
+}
+
+var colorEnabled bool
+
+// SetColorMode resolves the mode against NO_COLOR and the stdout type. On
+// Windows it enables escape processing of the console first; a console too old
+// for it gets no colors in auto mode.
+func SetColorMode(mode ColorMode) {
+	switch mode {
+	case ColorAlways:
+		enableVT(os.Stdout)
+		colorEnabled = true
+	case ColorNever:
+		colorEnabled = false
+	default:
+		// https://no-color.org: any non-empty NO_COLOR value disables colors
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			colorEnabled = false
+			return
+		}
+		fd := os.Stdout.Fd()
+		// mintty and other Cygwin terminals are pipes that understand escapes
+		colorEnabled = isatty.IsCygwinTerminal(fd) || (isatty.IsTerminal(fd) && enableVT(os.Stdout))
+	}
+}
+
+// ColorEnabled reports whether ANSI colors are written
+func ColorEnabled() bool {
+	return colorEnabled
+}
+
+func paint(code, s string) string {
+	if !colorEnabled {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// Green wraps s in green when colors are enabled
+func Green(s string) string {
+	return paint("32", s)
+}
+
+// Red wraps s in red when colors are enabled
+func Red(s string) string {
+	return paint("31", s)
+}
+
+// Glyphs are the status marks and table lines of an output style
+type Glyphs struct {
+	OK, Fail, Skip string
+	Table          tw.BorderStyle
+}
+
+var (
+	// UnicodeGlyphs is the default style
+	UnicodeGlyphs = Glyphs{OK: "✓", Fail: "✗", Skip: "-", Table: tw.StyleLight}
+	// ASCIIGlyphs is for terminals and CI log viewers that mangle UTF-8
+	ASCIIGlyphs = Glyphs{OK: "OK", Fail: "FAIL", Skip: "-", Table: tw.StyleASCII}
+)
+
+var glyphs = UnicodeGlyphs
+
+// SetGlyphs selects the output style
+func SetGlyphs(g Glyphs) {
+	glyphs = g
+}
+
+// OK is the success mark, in green when colors are enabled
+func OK() string {
+	return Green(glyphs.OK)
+}
+
+// Fail is the failure mark, in red when colors are enabled
+func Fail() string {
+	return Red(glyphs.Fail)
+}
+
+// Skip is the mark of skipped entries
+func Skip() string {
+	return glyphs.Skip
+}
+
+// TableSymbols are the border and separator characters of tables
+func TableSymbols() tw.Symbols {
+	return tw.NewSymbols(glyphs.Table)
+}
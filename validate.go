@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"pbuild/config"
+	"pbuild/fsutil"
+	"pbuild/gobuild"
+	"pbuild/notify"
+	"pbuild/report"
+	"pbuild/sandbox"
+	"pbuild/selfinfo"
+	"pbuild/sign"
+)
+
+// Values accepted by the enumerated flags
+var (
+	strategies      = []string{"flexible", "purego", "traditional"}
+	buildModes      = []string{"auto", "pie", "exe", "c-archive", "c-shared"}
+	amd64Levels     = []string{"v1", "v2", "v3", "v4"}
+	arm64Levels     = []string{"v8.0", "v8.1", "v8.2", "v8.3", "v8.4", "v8.5", "v8.6", "v8.7", "v8.8", "v8.9", "v9.0", "v9.1", "v9.2", "v9.3", "v9.4", "v9.5"}
+	armLevels       = []string{"5", "6", "7"}
+	mipsLevels      = []string{"hardfloat", "softfloat"}
+	x86Levels       = []string{"sse2", "softfloat"}
+	ppc64Levels     = []string{"power8", "power9", "power10"}
+	riscvLevels     = []string{"rva20u64", "rva22u64"}
+	compressions    = []string{"zstd", "gzip", "none"}
+	checkModes      = []string{"auto", "always", "never"}
+	gateModes       = []string{"warn", "error", "off"}
+	cacheStrategies = []string{"shared", "per-target"}
+)
+
+// The schema of pbuild.yaml offers the values the flags accept
+func init() {
+	config.Values["compress"] = compressions
+	config.Values["sign"] = append(slices.Clone(sign.Methods), "none")
+}
+
+// validateSettings checks every flag and pbuild.yaml value a run uses before
+// anything is built, and reports all problems at once instead of the first
+// one, or a failure per target halfway through the run
+func validateSettings(p *project) error {
+	var problems []string
+	// The same problem can surface through two checks, e.g. a broken group
+	check := func(err error) {
+		if err != nil && !slices.Contains(problems, err.Error()) {
+			problems = append(problems, err.Error())
+		}
+	}
+	choice := func(name, value string, allowed []string) {
+		if !slices.Contains(allowed, value) {
+			check(fmt.Errorf("invalid %s %q (expected %s)", name, value, strings.Join(allowed, ", ")))
+		}
+	}
+	// A flag left empty takes its pbuild.yaml default
+	defaulted := func(flag, value, key, cfgValue string, allowed []string) {
+		switch {
+		case value != "":
+			choice(flag, value, allowed)
+		case cfgValue != "":
+			choice(key+" in "+config.FileName, cfgValue, allowed)
+		}
+	}
+
+	choice("--strategy", strings.ToLower(flagStrategy), strategies)
+	choice("--buildmode", flagBuildMode, buildModes)
+	choice("--amd64-level", flagAMD64Level, amd64Levels)
+	choice("--arm64-level", flagARM64Level, arm64Levels)
+	choice("--arm-level", flagARMLevel, armLevels)
+	choice("--mips-level", flagMIPSLevel, mipsLevels)
+	choice("--mips64-level", flagMIPS64Level, mipsLevels)
+	choice("--386-level", flagX86Level, x86Levels)
+	choice("--ppc64-level", flagPPC64Level, ppc64Levels)
+	choice("--riscv-level", flagRISCVLevel, riscvLevels)
+	choice("--path-check", flagPathCheck, checkModes)
+	choice("--static-check", flagStaticCheck, checkModes)
+	choice("--tag-check", flagTagCheck, gateModes)
+	choice("--mod-verify", flagModVerify, gateModes)
+	choice("--cache-strategy", flagCacheStrategy, cacheStrategies)
+	defaulted("--compress", flagCompress, "compress", p.cfg.Compress, compressions)
+	defaulted("--sign", flagSign, "sign", p.cfg.Sign, append(slices.Clone(sign.Methods), "none"))
+	defaulted("--bench-gate", flagBenchGate, "bench.gate", p.cfg.Bench.Gate, gateModes)
+	if p.cfg.Bench.Count < 0 || p.cfg.Bench.Threshold < 0 {
+		check(fmt.Errorf("invalid bench in %s: count and threshold cannot be negative", config.FileName))
+	}
+
+	_, err := parseSummaryColumns(flagSummary, flagSummaryCols, flagSHADisplay)
+	check(err)
+	for _, f := range splitList(flagReport) {
+		choice("--report format", f, report.Formats)
+	}
+	switch flagEmbedInfo {
+	case "", "var", "section":
+	default:
+		if !selfinfo.ValidVar(flagEmbedInfo) {
+			check(fmt.Errorf("invalid --embed-info %q (expected var, section or an importpath.name variable)", flagEmbedInfo))
+		}
+	}
+	if flagMaxOutput != "" {
+		if _, err := fsutil.ParseSize(flagMaxOutput); err != nil {
+			check(fmt.Errorf("invalid --max-output-size: %v", err))
+		}
+	}
+	if flagStagingDir != "" {
+		staged, out := stagingDir(p.workDir), outputDir(p.workDir)
+		if rel, err := filepath.Rel(out, staged); err == nil && !strings.HasPrefix(rel, "..") {
+			check(fmt.Errorf("--staging-dir %s is inside the output directory %s", flagStagingDir, out))
+		}
+		if flagPool {
+			check(fmt.Errorf("--staging-dir and --pool cannot be combined: binaries copied from staging cannot be hardlinked into the pool"))
+		}
+		if flagSkipCleanup {
+			check(fmt.Errorf("--staging-dir and --skip-cleanup cannot be combined: the staged version directory replaces the existing one, dropping the files --skip-cleanup keeps"))
+		}
+		if flagXattrs {
+			check(fmt.Errorf("--staging-dir and --xattrs cannot be combined: copying from staging drops extended attributes"))
+		}
+	}
+	if flagCover && (flagPublish || flagPublishDryRun) {
+		check(fmt.Errorf("--cover cannot be combined with --publish: instrumented binaries are for testing, not release"))
+	}
+	if flagPool && flagXattrs {
+		check(fmt.Errorf("--pool and --xattrs cannot be combined: the versions sharing a pooled binary would share its extended attributes"))
+	}
+	if flagSandbox {
+		if err := sandbox.Supported(); err != nil {
+			check(fmt.Errorf("--sandbox is not available: %v", err))
+		}
+	}
+	if n, err := strconv.Atoi(flagParallel); flagParallel != "auto" && (err != nil || n < 0) {
+		check(fmt.Errorf("invalid --parallel %q (expected 0 or more, or auto)", flagParallel))
+	}
+	if flagMaxMemory != "" {
+		if n, err := fsutil.ParseSize(flagMaxMemory); err != nil || n <= 0 {
+			check(fmt.Errorf("invalid --max-memory %q (expected a size, e.g. 8GiB)", flagMaxMemory))
+		}
+	}
+	if flagCPULimit < 0 {
+		check(fmt.Errorf("invalid --cpu-limit %d (expected 0 or more)", flagCPULimit))
+	}
+	_, errs := parseConcurrency(p.cfg)
+	for _, err := range errs {
+		check(err)
+	}
+	check(gobuild.ValidateTags(newBuildConfig(p).Tags))
+
+	for _, spec := range p.settings.Notify {
+		_, err := notify.Parse(spec)
+		check(err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.cfg.TargetGroups)) {
+		_, err := resolveGroup(p.cfg, name)
+		check(err)
+	}
+	if matrix, err := resolveMatrix(p.cfg); err != nil {
+		check(err)
+	} else {
+		check(checkToolchainTargets(p, matrix))
+	}
+	_, err = newArchivePlan(p)
+	check(err)
+
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s", problems[0])
+	}
+	return fmt.Errorf("%d invalid settings:\n  %s", len(problems), strings.Join(problems, "\n  "))
+}
+
+// parseConcurrency returns the per-stage limits of --concurrency over the
+// concurrency: settings of pbuild.yaml, and every problem with them: entries
+// that are not stage=N, stages other than the built-in and plugin ones, and
+// limits below 1
+func parseConcurrency(cfg *config.Config) (map[string]int, []error) {
+	stages := slices.Clone(stageNames)
+	for _, pl := range cfg.Plugins {
+		stages = append(stages, pl.Name)
+	}
+	limits := map[string]int{}
+	maps.Copy(limits, cfg.Concurrency)
+	var errs []error
+	for _, entry := range splitList(flagConcurrency) {
+		stage, value, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil {
+			errs = append(errs, fmt.Errorf("invalid --concurrency entry %q (expected stage=N)", entry))
+			continue
+		}
+		limits[strings.TrimSpace(stage)] = n
+	}
+	for _, stage := range slices.Sorted(maps.Keys(limits)) {
+		if !slices.Contains(stages, stage) {
+			errs = append(errs, fmt.Errorf("unknown stage: %s (stages: %s)", stage, strings.Join(stages, ", ")))
+		} else if n := limits[stage]; n < 1 {
+			errs = append(errs, fmt.Errorf("concurrency limit for %s must be at least 1, got %d", stage, n))
+		}
+	}
+	return limits, errs
+}
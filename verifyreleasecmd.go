@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/buildmeta"
+	"pbuild/fsutil"
+	"pbuild/sign"
+	"pbuild/ui"
+)
+
+var (
+	flagVerifyKeys        sign.Keys
+	flagVerifyDownloadDir string
+	flagVerifyRequireSig  bool
+)
+
+// newVerifyReleaseCmd returns the `pbuild verify-release` subcommand
+func newVerifyReleaseCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify-release URL|VERSION_DIR",
+		Short: "Check the digests and signatures of a release pbuild produced",
+		Long: "Reads build-metadata.json of a version directory, or downloads it and the files\n" +
+			"it lists from a URL (e.g. a --publish destination), then checks every artifact\n" +
+			"against its recorded SHA256/SHA512 and .hash file and verifies the signatures\n" +
+			"of the .hash files against the given keys.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVerifyRelease(cmd.Context(), args[0])
+		},
+	}
+	cmd.Flags().StringVar(&flagVerifyKeys.Minisign, "minisign-key", "", "minisign public key file")
+	cmd.Flags().StringVar(&flagVerifyKeys.Signify, "signify-key", "", "signify public key file")
+	cmd.Flags().StringVar(&flagVerifyKeys.GPG, "gpg-key", "", "exported gpg public key (default: your keyring)")
+	cmd.Flags().StringVar(&flagVerifyKeys.Cosign, "cosign-key", "", "cosign public key file or KMS URI")
+	cmd.Flags().StringVar(&flagVerifyKeys.Identity, "certificate-identity", "", "keyless cosign: signer identity (regexp), e.g. a workflow URL")
+	cmd.Flags().StringVar(&flagVerifyKeys.Issuer, "certificate-oidc-issuer", "", "keyless cosign: OIDC issuer, e.g. https://token.actions.githubusercontent.com")
+	cmd.Flags().StringVar(&flagVerifyDownloadDir, "download-dir", "", "keep downloaded files in this directory (default: a temp dir, removed afterwards)")
+	cmd.Flags().BoolVar(&flagVerifyRequireSig, "require-signature", false, "fail artifacts whose checksum files are not signed")
+	return cmd
+}
+
+// runVerifyRelease verifies every successful target of a release
+func runVerifyRelease(ctx context.Context, src string) error {
+	dir, meta, cleanup, err := locateRelease(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	fmt.Printf("Verifying %s %s from %s\n\n", meta.ProjectName, meta.Version, src)
+	total, failed := 0, 0
+	for _, r := range meta.Results {
+		if !r.Success {
+			continue
+		}
+		total++
+		checks, err := verifyResult(ctx, dir, r)
+		if err != nil {
+			failed++
+			fmt.Printf("  %s %-16s %s: %v\n", ui.Fail(), r.Target, r.File, err)
+			continue
+		}
+		fmt.Printf("  %s %-16s %s (%s)\n", ui.OK(), r.Target, r.File, strings.Join(checks, ", "))
+	}
+	if total == 0 {
+		return fmt.Errorf("%s lists no successful artifacts", buildmeta.FileName)
+	}
+	if failed > 0 {
+		return fmt.Errorf("verification failed for %d of %d artifacts", failed, total)
+	}
+	fmt.Printf("\nAll %d artifacts verified\n", total)
+	return nil
+}
+
+// verifyResult checks one target's artifact, archive and signatures and
+// returns the checks that passed
+func verifyResult(ctx context.Context, dir string, r buildmeta.TargetResult) ([]string, error) {
+	file := filepath.Join(dir, r.File)
+	sha256Sum, sha512Sum, err := generateChecksums(file)
+	if err != nil {
+		return nil, err
+	}
+	if r.SHA256 != "" && sha256Sum != r.SHA256 {
+		return nil, fmt.Errorf("SHA256 %s does not match %s", sha256Sum, buildmeta.FileName)
+	}
+	if r.SHA512 != "" && sha512Sum != r.SHA512 {
+		return nil, fmt.Errorf("SHA512 does not match %s", buildmeta.FileName)
+	}
+	checks := []string{"sha256", "sha512"}
+
+	hashed := []string{r.File}
+	if r.Archive != "" {
+		hashed = append(hashed, r.Archive)
+	}
+	for _, name := range hashed {
+		if err := verifyChecksumFile(filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(file + ".hash"); err == nil {
+		checks = append(checks, ".hash")
+	}
+
+	// The signatures are looked up by the name of the .hash file they cover,
+	// not taken from the unsigned metadata, so one target's cannot stand in
+	// for another's. With a key every signature of its method must be there.
+	exts, keyed := signatureExts(flagVerifyKeys)
+	required := keyed || flagVerifyRequireSig
+	for _, name := range hashed {
+		sumFile := filepath.Join(dir, name+".hash")
+		if _, err := os.Stat(sumFile); err != nil {
+			if required {
+				return nil, fmt.Errorf("%s.hash, which the signatures cover, is missing", name)
+			}
+			continue
+		}
+		verified := 0
+		for _, ext := range exts {
+			sig := sumFile + ext
+			if _, err := os.Stat(sig); err != nil {
+				if keyed {
+					return nil, fmt.Errorf("%s is missing", filepath.Base(sig))
+				}
+				continue
+			}
+			method, err := sign.Verify(ctx, flagVerifyKeys, sumFile, sig)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", filepath.Base(sig), err)
+			}
+			verified++
+			if !slices.Contains(checks, method) {
+				checks = append(checks, method)
+			}
+		}
+		if required && verified == 0 {
+			return nil, fmt.Errorf("%s.hash is not signed", name)
+		}
+	}
+	return checks, nil
+}
+
+// signatureExts returns the signature suffixes to look for: those of the
+// methods given a key, and whether there were any; otherwise every method's
+func signatureExts(keys sign.Keys) ([]string, bool) {
+	var exts []string
+	add := func(set bool, ext string) {
+		if set && !slices.Contains(exts, ext) {
+			exts = append(exts, ext)
+		}
+	}
+	add(keys.Minisign != "", ".minisig")
+	add(keys.Signify != "", ".sig")
+	add(keys.GPG != "", ".asc")
+	add(keys.Cosign != "" || keys.Identity != "", ".sig")
+	if len(exts) > 0 {
+		return exts, true
+	}
+	return []string{".minisig", ".asc", ".sig"}, false
+}
+
+// verifyChecksumFile compares a file with the digests in its .hash file, when present
+func verifyChecksumFile(file string) error {
+	f, err := os.Open(file + ".hash")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sha256Sum, sha512Sum, err := generateChecksums(file)
+	if err != nil {
+		return err
+	}
+	want := map[string]string{"SHA256": sha256Sum, "SHA512": sha512Sum}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// SHA256 (name) = digest
+		algo, rest, ok := strings.Cut(sc.Text(), " (")
+		name, digest, ok2 := strings.Cut(rest, ") = ")
+		if !ok || !ok2 {
+			continue
+		}
+		if name != filepath.Base(file) {
+			return fmt.Errorf("%s.hash lists %s", filepath.Base(file), name)
+		}
+		if sum, known := want[algo]; known && sum != strings.TrimSpace(digest) {
+			return fmt.Errorf("%s of %s does not match its .hash file", algo, filepath.Base(file))
+		}
+	}
+	return sc.Err()
+}
+
+// locateRelease returns the directory holding the release's files. A URL is
+// downloaded: its build-metadata.json first, then every file it lists.
+func locateRelease(ctx context.Context, src string) (string, *buildmeta.BuildMetadata, func(), error) {
+	noop := func() {}
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		meta, err := buildmeta.Read(src)
+		if err != nil {
+			return "", nil, noop, fmt.Errorf("failed to read %s: %v", src, err)
+		}
+		return versionDirOf(src), meta, noop, nil
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(src, "/"+buildmeta.FileName))
+	if err != nil {
+		return "", nil, noop, fmt.Errorf("invalid release URL: %v", err)
+	}
+	dir, cleanup := flagVerifyDownloadDir, noop
+	if dir == "" {
+		if dir, err = os.MkdirTemp("", "pbuild-verify-"); err != nil {
+			return "", nil, noop, err
+		}
+		cleanup = func() { _ = os.RemoveAll(dir) }
+	} else if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", nil, noop, err
+	}
+
+	if err := download(ctx, base, buildmeta.FileName, dir, true); err != nil {
+		cleanup()
+		return "", nil, noop, err
+	}
+	meta, err := buildmeta.Read(dir)
+	if err != nil {
+		cleanup()
+		return "", nil, noop, err
+	}
+	for _, r := range meta.Results {
+		if !r.Success {
+			continue
+		}
+		// Artifacts must exist; .hash files and their signatures are optional
+		// here and required by verifyResult when a key is given
+		required := []string{r.File}
+		if r.Archive != "" {
+			required = append(required, r.Archive)
+		}
+		var optional []string
+		for _, name := range required {
+			sum := name + ".hash"
+			optional = append(optional, sum, sum+".minisig", sum+".asc", sum+".sig", sum+".pem")
+		}
+		for _, name := range append(required, optional...) {
+			if err := download(ctx, base, name, dir, slices.Contains(required, name)); err != nil {
+				cleanup()
+				return "", nil, noop, err
+			}
+		}
+	}
+	return dir, meta, cleanup, nil
+}
+
+// download fetches base/name into dir. A missing file is only an error when required.
+func download(ctx context.Context, base *url.URL, name, dir string, required bool) error {
+	if name != filepath.Base(name) {
+		return fmt.Errorf("refusing to download %q outside the release directory", name)
+	}
+	u := *base
+	u.Path = path.Join(u.Path, name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && !required {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", u.Redacted(), resp.Status)
+	}
+
+	dest := filepath.Join(dir, name)
+	tmp := fsutil.TempPath(dest)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to download %s: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
@@ -0,0 +1,177 @@
+package versionpkg
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"pbuild/fsutil"
+)
+
+// DefaultDir is where pbuild gen version-pkg puts the package, relative to the module root
+const DefaultDir = "internal/buildinfo"
+
+// FileName is the generated source file
+const FileName = "buildinfo.go"
+
+// marker starts the generated file; builds only stamp packages that have it
+const marker = "// Generated by pbuild gen version-pkg."
+
+// Vars are the variables the build stamps with -X
+var Vars = []string{"Version", "Commit", "Date"}
+
+// Write generates the package in dir below the module root; an existing file
+// is only replaced with force
+func Write(moduleRoot, dir string, force bool) (string, error) {
+	if err := checkDir(dir); err != nil {
+		return "", err
+	}
+	pkg := path.Base(path.Clean(dir))
+	if !token.IsIdentifier(pkg) {
+		return "", fmt.Errorf("%s is not a valid package name; the directory names the package", pkg)
+	}
+	file := filepath.Join(moduleRoot, filepath.FromSlash(dir), FileName)
+	if _, err := os.Stat(file); err == nil && !force {
+		return "", fmt.Errorf("%s already exists; use --force to regenerate it", file)
+	}
+	var b bytes.Buffer
+	if err := source.Execute(&b, struct{ Marker, Package string }{marker, pkg}); err != nil {
+		return "", err
+	}
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("failed to format %s: %v", FileName, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return "", err
+	}
+	return file, fsutil.WriteFileAtomic(file, src, 0644)
+}
+
+// Find returns the import path of the generated package in dir, or "" when
+// the module has none there
+func Find(moduleRoot, dir string) (string, error) {
+	if err := checkDir(dir); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(moduleRoot, filepath.FromSlash(dir), FileName))
+	if err != nil || !bytes.HasPrefix(data, []byte(marker)) {
+		return "", nil
+	}
+	modPath, err := fsutil.InferModulePath(moduleRoot)
+	if err != nil {
+		return "", err
+	}
+	return modPath + "/" + path.Clean(dir), nil
+}
+
+// LDFlags returns the -X flags that stamp the package's variables; empty
+// values keep the package's defaults
+func LDFlags(importPath, version, commit, date string) string {
+	var flags []string
+	for i, value := range []string{version, commit, date} {
+		if value != "" {
+			flags = append(flags, "-X "+importPath+"."+Vars[i]+"="+value)
+		}
+	}
+	return strings.Join(flags, " ")
+}
+
+// checkDir accepts slash-separated directories inside the module
+func checkDir(dir string) error {
+	clean := path.Clean(dir)
+	if dir == "" || path.IsAbs(clean) || clean == "." || strings.HasPrefix(clean, "../") || clean == ".." {
+		return fmt.Errorf("invalid version package directory %q (expected a path inside the module, e.g. %s)", dir, DefaultDir)
+	}
+	return nil
+}
+
+var source = template.Must(template.New(FileName).Parse(`{{.Marker}}
+
+// Package {{.Package}} reports the version, commit and build date pbuild stamps
+// into the binary at link time.
+package {{.Package}}
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Constants, so that pbuild does not take them for the project version
+const (
+	devVersion   = "dev"
+	develVersion = "(devel)"
+)
+
+// Set with -X by pbuild; empty in other builds
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info is the build info of the running binary
+type Info struct {
+	Version   string ` + "`json:\"version\"`" + `
+	Commit    string ` + "`json:\"commit,omitempty\"`" + `
+	Date      string ` + "`json:\"date,omitempty\"`" + `
+	GoVersion string ` + "`json:\"go_version\"`" + `
+}
+
+// Get returns the stamped build info, falling back to the module version and
+// VCS details the Go toolchain records when the binary was not built by pbuild
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if main := bi.Main.Version; info.Version == "" && main != develVersion {
+			info.Version = main
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.Date == "":
+				info.Date = s.Value
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = devVersion
+	}
+	return info
+}
+
+// String returns e.g. "1.2.0 (commit 1a2b3c4d5e6f, built 2024-05-01T10:00:00Z)"
+func (i Info) String() string {
+	s := i.Version
+	commit := i.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	switch {
+	case commit != "" && i.Date != "":
+		s += " (commit " + commit + ", built " + i.Date + ")"
+	case commit != "":
+		s += " (commit " + commit + ")"
+	case i.Date != "":
+		s += " (built " + i.Date + ")"
+	}
+	return s
+}
+
+// Handler serves the build info as JSON, e.g. http.Handle("/version", {{.Package}}.Handler())
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Get())
+	})
+}
+`))
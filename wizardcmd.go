@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"pbuild/config"
+	"pbuild/creds"
+	"pbuild/fsutil"
+	"pbuild/prompt"
+	"pbuild/sign"
+	"pbuild/targets"
+)
+
+// newWizardCmd returns the `pbuild wizard` subcommand
+func newWizardCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "wizard [TARGET_DIR]",
+		Short: "Answer a few questions to write pbuild.yaml and run the first build",
+		Long: "Asks which targets to build, how to compress and sign the binaries and where\n" +
+			"to publish them, writes the answers to pbuild.yaml in the module root and\n" +
+			"offers to run the first build. Piped answers work too; once the input ends\n" +
+			"every remaining question takes its default.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWizard(targetArg(args), prompt.New(cmd.InOrStdin(), cmd.OutOrStdout()))
+		},
+	}
+}
+
+// wizardAnswers are what pbuild wizard writes to pbuild.yaml
+type wizardAnswers struct {
+	targets  []string
+	compress string
+	sign     string
+	signKey  string
+	publish  map[string]string
+}
+
+// runWizard asks the questions and writes the config of the module in targetDir
+func runWizard(targetDir string, pr *prompt.Prompter) error {
+	abs, err := filepath.Abs(targetDir)
+	if err != nil {
+		return err
+	}
+	root, err := fsutil.FindModuleRoot(abs)
+	if err != nil {
+		return fmt.Errorf("no go.mod found in or above %s", abs)
+	}
+	path := flagConfig
+	if path == "" {
+		path = filepath.Join(root, config.FileName)
+	}
+	// Groups added by an existing file are offered as targets
+	cfg, err := loadConfig(root, flagConfig)
+	if err != nil {
+		cfg = &config.Config{}
+	}
+	if _, err := os.Stat(path); err == nil {
+		if !pr.Confirm(path+" exists. Replace it with the wizard's answers?", false) {
+			return fmt.Errorf("kept %s; nothing written", path)
+		}
+	}
+
+	var a wizardAnswers
+	if a.targets, err = wizardTargets(pr, cfg); err != nil {
+		return err
+	}
+
+	methods := []string{"none", "zstd", "gzip"}
+	a.compress = methods[pr.Choose("\nCompress the binaries?", methods, 0)]
+
+	signing := append([]string{"none"}, sign.Methods...)
+	a.sign = signing[pr.Choose("\nSign the checksum files?", signing, 0)]
+	switch a.sign {
+	case "none":
+	case "gpg":
+		fmt.Println("gpg signs with its default key; pass --key or set " + creds.EnvName(creds.SignKey) + " for another one")
+	default:
+		a.signKey = pr.Ask("Secret key file (empty: --key or "+creds.EnvName(creds.SignKey)+")", "")
+	}
+	if a.sign != "none" {
+		fmt.Println("An encrypted key reads its passphrase from " + sign.PasswordEnv)
+	}
+
+	a.publish = wizardPublish(pr)
+
+	out, err := renderWizardConfig(a)
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(path, out, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("\nWrote %s\n", path)
+	if a.publish != nil {
+		fmt.Println("Uploads run with `pbuild --publish`")
+	}
+
+	if !pr.Confirm("\nRun the first build now?", true) {
+		return nil
+	}
+	return run(targetDir)
+}
+
+// wizardTargets asks for the host only, a target group or a custom list
+func wizardTargets(pr *prompt.Prompter, cfg *config.Config) ([]string, error) {
+	options := []string{"this machine only (" + runtime.GOOS + "/" + runtime.GOARCH + ")"}
+	var added []string
+	for name := range cfg.TargetGroups {
+		if _, err := targets.Group(name); err != nil {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	names := append(targets.GroupNames(), added...)
+	for _, name := range names {
+		group, err := resolveGroup(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, fmt.Sprintf("target group %s (%d targets)", name, len(group)))
+	}
+	options = append(options, "a custom list")
+
+	switch i := pr.Choose("Which targets should a plain `pbuild` build?", options, 0); {
+	case i == 0:
+		return nil, nil
+	case i <= len(names):
+		return []string{names[i-1]}, nil
+	}
+	list := pr.Validate("Targets as GOOS/GOARCH (comma-separated)", runtime.GOOS+"/"+runtime.GOARCH, func(s string) error {
+		_, err := targets.ParseList(splitList(s))
+		return err
+	})
+	return splitList(list), nil
+}
+
+// wizardPublish asks for an upload destination; nil means none
+func wizardPublish(pr *prompt.Prompter) map[string]string {
+	options := []string{"none", "HTTP PUT (Artifactory, WebDAV)", "Google Cloud Storage", "Azure Blob Storage"}
+	required := func(s string) error {
+		if s == "" {
+			return fmt.Errorf("a value is required")
+		}
+		return nil
+	}
+	switch pr.Choose("\nPublish the artifacts?", options, 0) {
+	case 1:
+		dest := map[string]string{"type": "http"}
+		dest["url"] = pr.Validate("Base URL", "", required)
+		if env := pr.Ask("Environment variable holding a bearer token (empty: none)", ""); env != "" {
+			dest["token"] = "${" + env + "}"
+		}
+		return dest
+	case 2:
+		return map[string]string{"type": "gcs", "bucket": pr.Validate("Bucket", "", required)}
+	case 3:
+		return map[string]string{
+			"type":      "azure",
+			"account":   pr.Validate("Storage account", "", required),
+			"container": pr.Validate("Container", "", required),
+		}
+	}
+	return nil
+}
+
+// renderWizardConfig writes the answers as a commented pbuild.yaml
+func renderWizardConfig(a wizardAnswers) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("# yaml-language-server: $schema=" + config.SchemaURL + "\n")
+	b.WriteString("# Written by pbuild wizard; the README lists every option\n\n")
+	if len(a.targets) == 0 {
+		b.WriteString("# Built without target flags (GOOS/GOARCH or target groups); default the host\n# targets: [linux/amd64, darwin/arm64]\n")
+	} else {
+		b.WriteString("# Built without target flags (GOOS/GOARCH or target groups)\ntargets:\n")
+		for _, t := range a.targets {
+			fmt.Fprintf(&b, "  - %s\n", yamlScalar(t))
+		}
+	}
+	if a.compress != "none" {
+		fmt.Fprintf(&b, "\n# Default of --compress\ncompress: %s\n", a.compress)
+	}
+	if a.sign != "none" {
+		fmt.Fprintf(&b, "\n# Default of --sign\nsign: %s\n", a.sign)
+	}
+	if a.signKey != "" {
+		fmt.Fprintf(&b, "\ncredentials:\n  %s:\n    file: %s\n", creds.SignKey, yamlScalar(a.signKey))
+	}
+	if a.publish != nil {
+		b.WriteString("\n# Uploaded with --publish\npublish:\n")
+		prefix := "  - "
+		for _, key := range []string{"type", "url", "token", "bucket", "account", "container"} {
+			if v, ok := a.publish[key]; ok {
+				fmt.Fprintf(&b, "%s%s: %s\n", prefix, key, yamlScalar(v))
+				prefix = "    "
+			}
+		}
+	}
+
+	// Catch anything the quoting missed before the file is written
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(b.String()), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to render %s: %v", config.FileName, err)
+	}
+	if problems := config.Validate(config.FileName, []byte(b.String())); len(problems) > 0 {
+		return nil, fmt.Errorf("failed to render %s: %s", config.FileName, problems[0])
+	}
+	return []byte(b.String()), nil
+}
+
+// yamlScalar quotes s where YAML needs it
+func yamlScalar(s string) string {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("%q", s)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
@@ -0,0 +1,28 @@
+package xattr
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Prefix namespaces every attribute pbuild writes
+const Prefix = "user.pbuild."
+
+// ErrUnsupported is returned on platforms without extended attribute support
+var ErrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// SetAll writes attrs onto path as user.pbuild.<key>, stopping at the first failure
+func SetAll(path string, attrs map[string]string) error {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := set(path, Prefix+k, []byte(attrs[k])); err != nil {
+			return fmt.Errorf("failed to set %s%s on %s: %v", Prefix, k, path, err)
+		}
+	}
+	return nil
+}
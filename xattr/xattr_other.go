@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package xattr
+
+func set(path, name string, value []byte) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,10 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package xattr
+
+import "golang.org/x/sys/unix"
+
+// set writes one extended attribute, replacing any existing value
+func set(path, name string, value []byte) error {
+	return unix.Setxattr(path, name, value, 0)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbuild/buildmeta"
+	"pbuild/channel"
+	"pbuild/prompt"
+	"pbuild/publish"
+	"pbuild/targets"
+)
+
+var (
+	flagYankReason      string
+	flagYankDeleteLocal bool
+	flagYankYes         bool
+)
+
+// newYankCmd returns the `pbuild yank` subcommand
+func newYankCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "yank VERSION [TARGET_DIR]",
+		Short: "Withdraw a release: delete its uploads and repoint latest and the update manifest",
+		Long: "Deletes every file of a release from the publish: destinations in pbuild.yaml,\n" +
+			"marks its build-metadata.json as yanked and points the latest link and the\n" +
+			"channel's update manifest back at the newest complete release that is left.\n" +
+			"The version directory is looked up in every channel; --delete-local removes it.",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runYank(cmd.Context(), args[0], targetArg(args[1:]), prompt.New(cmd.InOrStdin(), cmd.OutOrStdout()))
+		},
+	}
+	cmd.Flags().StringVar(&flagOutDir, "output-dir", "builds", "directory for build artifacts")
+	cmd.Flags().StringVar(&flagYankReason, "reason", "", "why the release was withdrawn, recorded in build-metadata.json")
+	cmd.Flags().BoolVar(&flagYankDeleteLocal, "delete-local", false, "also remove the version directory")
+	cmd.Flags().BoolVarP(&flagYankYes, "yes", "y", false, "do not ask for confirmation")
+	return cmd
+}
+
+// runYank withdraws version of the module in targetDir
+func runYank(ctx context.Context, version, targetDir string, pr *prompt.Prompter) error {
+	p, err := resolveProject(targetDir)
+	if err != nil {
+		return err
+	}
+	outDir, err := findRelease(outputDir(p.workDir), version)
+	if err != nil {
+		return err
+	}
+	versionDir := filepath.Join(outDir, version)
+	meta, err := buildmeta.Read(versionDir)
+	if err != nil {
+		return fmt.Errorf("failed to read release %s: %v", version, err)
+	}
+	p.name, p.version = meta.ProjectName, meta.Version
+	if meta.Channel != "" {
+		p.channel = meta.Channel
+	}
+
+	var dests []*publish.Destination
+	if len(p.cfg.Publish) > 0 {
+		flagPublish = true
+		if dests, err = newDestinations(p); err != nil {
+			return err
+		}
+	}
+	var files []publish.PathData
+	for _, r := range meta.Results {
+		target, _ := targets.Parse(r.Target)
+		for _, name := range targetFiles(r.File, r) {
+			data := pathData(p, name)
+			data.Target, data.OS, data.Arch = r.Target, target.OS, target.Arch
+			files = append(files, data)
+		}
+	}
+	for _, name := range runFiles(versionDir) {
+		files = append(files, pathData(p, name))
+	}
+
+	question := fmt.Sprintf("Yank %s %s (%s channel): delete up to %d files from %d destinations", p.name, p.version, p.channel, len(files), len(dests))
+	if flagYankDeleteLocal {
+		question += " and remove " + versionDir
+	}
+	if !flagYankYes && !pr.Confirm(question+"?", false) {
+		return fmt.Errorf("yank of %s cancelled", version)
+	}
+
+	for _, d := range dests {
+		for _, data := range files {
+			url, err := d.Remove(ctx, data)
+			if err != nil {
+				return err
+			}
+			if flagVerbose {
+				fmt.Printf("Deleted %s\n", url)
+			}
+		}
+	}
+
+	// Marked first, so the release no longer counts when latest is repointed
+	meta.Yanked = &buildmeta.Yank{Time: time.Now().UTC(), Reason: flagYankReason}
+	if err := buildmeta.Write(versionDir, *meta); err != nil {
+		return fmt.Errorf("failed to write %s: %v", buildmeta.FileName, err)
+	}
+	if err := repointRelease(ctx, p, dests, outDir, version); err != nil {
+		return err
+	}
+	if flagYankDeleteLocal {
+		if err := os.RemoveAll(versionDir); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", versionDir)
+		prunePool(outputDir(p.workDir))
+	}
+	fmt.Printf("Yanked %s %s from %d destinations\n", p.name, p.version, len(dests))
+	return nil
+}
+
+// findRelease returns the channel directory below outDir that holds version
+func findRelease(outDir, version string) (string, error) {
+	var found []string
+	for _, ch := range channel.Names {
+		dir := filepath.Join(outDir, channel.Dir(ch))
+		if _, err := os.Stat(filepath.Join(dir, version, buildmeta.FileName)); err == nil {
+			found = append(found, dir)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no release %s in %s", version, outDir)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("release %s exists in %d channels; remove the one to keep out of %s first", version, len(found), outDir)
+}
+
+// repointRelease points the latest link and the update manifest that named
+// the yanked version at the newest complete release left in outDir, or
+// removes them when there is none
+func repointRelease(ctx context.Context, p *project, dests []*publish.Destination, outDir, yanked string) error {
+	newest, meta := newestRelease(outDir)
+
+	link := filepath.Join(outDir, "latest")
+	if linked, err := buildmeta.Read(link); err == nil && linked.Version == yanked {
+		if newest != "" {
+			if err := updateLatest(outDir, filepath.Join(outDir, newest)); err != nil {
+				return fmt.Errorf("failed to update latest pointer: %v", err)
+			}
+			fmt.Printf("latest now points at %s\n", newest)
+		} else if err := os.RemoveAll(link); err != nil {
+			return err
+		}
+	}
+
+	m, err := channel.ReadManifest(outDir)
+	if err != nil || m.Version != yanked {
+		return nil
+	}
+	if newest == "" {
+		if err := os.Remove(filepath.Join(outDir, channel.ManifestName)); err != nil {
+			return err
+		}
+		data := pathData(p, channel.ManifestName)
+		data.Version = ""
+		for _, d := range dests {
+			if _, err := d.Remove(ctx, data); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Removed %s: no release of the %s channel is left\n", channel.ManifestName, p.channel)
+		return nil
+	}
+	if err := writeUpdateManifest(p, outDir, *meta); err != nil {
+		return fmt.Errorf("failed to write %s: %v", channel.ManifestName, err)
+	}
+	if err := publishUpdateManifest(ctx, p, dests, outDir); err != nil {
+		return err
+	}
+	fmt.Printf("%s now points at %s\n", channel.ManifestName, newest)
+	return nil
+}
+
+// newestRelease returns the newest complete, not yanked release in outDir
+func newestRelease(outDir string) (string, *buildmeta.BuildMetadata) {
+	entries, _ := os.ReadDir(outDir)
+	var newest string
+	var meta *buildmeta.BuildMetadata
+	for _, e := range entries {
+		// latest is a copy where symlinks are not available
+		if !e.IsDir() || e.Name() == "latest" {
+			continue
+		}
+		m, err := buildmeta.Read(filepath.Join(outDir, e.Name()))
+		if err != nil || m.Yanked != nil || m.FailCount > 0 || m.SuccessCount == 0 || m.Interrupted {
+			continue
+		}
+		if meta == nil || m.BuildTime.After(meta.BuildTime) {
+			newest, meta = e.Name(), m
+		}
+	}
+	return newest, meta
+}